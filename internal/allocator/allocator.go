@@ -1,8 +1,9 @@
 package allocator
 
 import (
+	"github.com/ganqierwu/rain/internal/filesection"
 	"github.com/ganqierwu/rain/internal/metainfo"
-	"github.com/ganqierwu/rain/internal/storage"
+	"github.com/ganqierwu/rain/storage"
 )
 
 // Allocator allocates files on the disk.
@@ -62,6 +63,11 @@ func (a *Allocator) Run(info *metainfo.Info, sto storage.Storage, progressC chan
 	var allocatedSize int64
 	a.Files = make([]File, len(info.Files))
 	for i, f := range info.Files {
+		if NotOnDisk(f) {
+			a.Files[i] = File{Storage: filesection.Zeros{}, Name: f.Path}
+			allocatedSize += f.Length
+			continue
+		}
 		var sf storage.File
 		var exists bool
 		sf, exists, a.Error = sto.Open(f.Path, f.Length)
@@ -79,6 +85,12 @@ func (a *Allocator) Run(info *metainfo.Info, sto storage.Storage, progressC chan
 	}
 }
 
+// NotOnDisk returns true if the file is not saved to disk.
+// Padding files are read as zeros and symbolic links are created after download is completed.
+func NotOnDisk(f metainfo.File) bool {
+	return f.Padding || f.SymlinkPath != ""
+}
+
 func (a *Allocator) sendProgress(progressC chan Progress, size int64) {
 	select {
 	case progressC <- Progress{AllocatedSize: size}:
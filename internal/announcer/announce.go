@@ -16,6 +16,9 @@ func announce(
 	responseC chan *tracker.AnnounceResponse,
 	errC chan error,
 ) {
+	if e == tracker.EventNone && torrent.PartialSeed {
+		e = tracker.EventPaused
+	}
 	annReq := tracker.AnnounceRequest{
 		Torrent: torrent,
 		Event:   e,
@@ -1,12 +1,15 @@
 package blocklist
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"encoding/binary"
 	"errors"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 
 	"github.com/ganqierwu/rain/internal/blocklist/stree"
@@ -19,6 +22,7 @@ type Blocklist struct {
 	Logger Logger
 
 	tree  stree.Stree
+	allow stree.Stree
 	m     sync.RWMutex
 	count int
 }
@@ -37,7 +41,7 @@ func (b *Blocklist) Len() int {
 	return b.count
 }
 
-// Blocked returns true if ip is in Blocklist.
+// Blocked returns true if ip is in Blocklist and not in the allow-list.
 func (b *Blocklist) Blocked(ip net.IP) bool {
 	b.m.RLock()
 	defer b.m.RUnlock()
@@ -47,39 +51,86 @@ func (b *Blocklist) Blocked(ip net.IP) bool {
 		return false
 	}
 
-	val := binary.BigEndian.Uint32(ip)
-	return b.tree.Contains(stree.ValueType(val))
+	val := stree.ValueType(binary.BigEndian.Uint32(ip))
+	return b.tree.Contains(val) && !b.allow.Contains(val)
 }
 
+// Max size of decompressed rules read by Reload.
+const maxReloadSize = 1 << 30
+
 // Reload the segment tree by reading new rules from a io.Reader.
+// The allow-list is not changed.
 func (b *Blocklist) Reload(r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	ranges, err := Parse(data, maxReloadSize, b.Logger)
+	if err != nil {
+		return 0, err
+	}
+
 	b.m.Lock()
 	defer b.m.Unlock()
+	b.tree = buildTree(ranges)
+	b.count = len(ranges)
+	return len(ranges), nil
+}
 
-	tree, n, err := load(r, b.Logger)
-	if err != nil {
-		return n, err
-	}
+// Set replaces the rules in the Blocklist.
+// IPs in allow ranges are not blocked even if they are in one of the blocked ranges.
+func (b *Blocklist) Set(ranges, allow []Range) {
+	tree := buildTree(ranges)
+	allowTree := buildTree(allow)
 
-	b.tree = *tree
-	b.count = n
-	return n, nil
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.tree = tree
+	b.allow = allowTree
+	b.count = len(ranges)
 }
 
-func load(r io.Reader, logger Logger) (*stree.Stree, int, error) {
+func buildTree(ranges []Range) stree.Stree {
 	var tree stree.Stree
-	var n int
+	for _, r := range ranges {
+		tree.AddRange(stree.ValueType(r.First), stree.ValueType(r.Last))
+	}
+	tree.Build()
+	return tree
+}
+
+// Range of IPv4 addresses. First and Last addresses are included in the range.
+type Range struct {
+	First, Last uint32
+}
+
+// Parse returns the IP ranges in data.
+// Data may be compressed with gzip or zip, it is detected by looking at the first bytes.
+// Each line may be in one of the following formats:
+//
+//	1.2.3.0/24                                  CIDR
+//	1.2.3.4                                     single IP
+//	1.2.3.0-1.2.3.255                           IP range
+//	Some description:1.2.3.0-1.2.3.255          PeerGuardian P2P
+//	001.002.003.000 - 001.002.003.255 , 100 , Some description    eMule DAT
+//
+// Empty lines and lines starting with '#' or "//" are ignored.
+// Lines in DAT format with an access level greater than 127 are not blocked.
+// An error is returned if the decompressed data is larger than maxSize bytes.
+func Parse(data []byte, maxSize int64, logger Logger) ([]Range, error) {
+	data, err := decompress(data, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	var ranges []Range
 	var hasError bool
-	scanner := bufio.NewScanner(r)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
 	for scanner.Scan() {
 		l := bytes.TrimSpace(scanner.Bytes())
-		if len(l) == 0 {
-			continue
-		}
-		if l[0] == '#' {
+		if len(l) == 0 || l[0] == '#' || bytes.HasPrefix(l, []byte("//")) {
 			continue
 		}
-		r, err := parseCIDR(l)
+		r, ok, err := parseLine(l)
 		if err != nil {
 			hasError = true
 			if logger != nil {
@@ -87,26 +138,118 @@ func load(r io.Reader, logger Logger) (*stree.Stree, int, error) {
 			}
 			continue
 		}
-		tree.AddRange(stree.ValueType(r.first), stree.ValueType(r.last))
-		n++
+		if ok {
+			ranges = append(ranges, r)
+		}
 	}
 	if err := scanner.Err(); err != nil {
-		return nil, 0, err
+		return nil, err
 	}
-	if n == 0 && hasError {
+	if len(ranges) == 0 && hasError {
 		// Probably we couln't decode the stream correctly.
 		// At least one line must be correct before we consider the load operation as successful.
-		return nil, 0, errors.New("no valid rules")
+		return nil, errors.New("no valid rules")
 	}
-	tree.Build()
-	return &tree, n, nil
+	return ranges, nil
 }
 
-type ipRange struct {
-	first, last uint32
+// ParseRange parses a single IP, a CIDR or an IP range in "first-last" form.
+func ParseRange(s string) (Range, error) {
+	r, _, err := parseLine([]byte(s))
+	return r, err
 }
 
-func parseCIDR(b []byte) (r ipRange, err error) {
+// ErrTooBig is returned from Parse if the decompressed data is larger than the allowed size.
+var ErrTooBig = errors.New("decompressed blocklist too big")
+
+func decompress(data []byte, maxSize int64) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		var buf bytes.Buffer
+		err = copyLimited(&buf, gr, maxSize)
+		return buf.Bytes(), err
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = copyLimited(&buf, rc, maxSize-int64(buf.Len()))
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), nil
+	default:
+		if int64(len(data)) > maxSize {
+			return nil, ErrTooBig
+		}
+		return data, nil
+	}
+}
+
+// copyLimited copies from r to w until EOF. Returns ErrTooBig if r has more than n bytes.
+func copyLimited(w io.Writer, r io.Reader, n int64) error {
+	if n < 0 {
+		return ErrTooBig
+	}
+	m, err := io.Copy(w, io.LimitReader(r, n+1))
+	if err != nil {
+		return err
+	}
+	if m > n {
+		return ErrTooBig
+	}
+	return nil
+}
+
+// parseLine parses a line in one of the formats accepted by Parse.
+// ok is false if the line is valid but must not be blocked.
+func parseLine(l []byte) (r Range, ok bool, err error) {
+	if bytes.IndexByte(l, '/') >= 0 {
+		r, err = parseCIDR(l)
+		return r, err == nil, err
+	}
+	// DAT format: "first - last , level , description"
+	if fields := bytes.SplitN(l, []byte(","), 3); len(fields) >= 2 {
+		r, err = parseIPRange(fields[0])
+		if err != nil {
+			return
+		}
+		level, err2 := strconv.Atoi(string(bytes.TrimSpace(fields[1])))
+		if err2 != nil {
+			return r, false, err2
+		}
+		return r, level <= 127, nil
+	}
+	// P2P format: "description:first-last"
+	if i := bytes.LastIndexByte(l, ':'); i >= 0 {
+		l = l[i+1:]
+	}
+	if bytes.IndexByte(l, '-') >= 0 {
+		r, err = parseIPRange(l)
+		return r, err == nil, err
+	}
+	ip, err := parseIPv4(l)
+	return Range{First: ip, Last: ip}, err == nil, err
+}
+
+func parseCIDR(b []byte) (r Range, err error) {
 	_, ipnet, err := net.ParseCIDR(string(b))
 	if err != nil {
 		return
@@ -119,7 +262,44 @@ func parseCIDR(b []byte) (r ipRange, err error) {
 		err = errNotIPv4Address
 		return
 	}
-	r.first = binary.BigEndian.Uint32(ipnet.IP)
-	r.last = r.first | ^binary.BigEndian.Uint32(ipnet.Mask)
+	r.First = binary.BigEndian.Uint32(ipnet.IP)
+	r.Last = r.First | ^binary.BigEndian.Uint32(ipnet.Mask)
+	return
+}
+
+func parseIPRange(b []byte) (r Range, err error) {
+	i := bytes.IndexByte(b, '-')
+	if i < 0 {
+		err = errors.New("invalid IP range")
+		return
+	}
+	r.First, err = parseIPv4(b[:i])
+	if err != nil {
+		return
+	}
+	r.Last, err = parseIPv4(b[i+1:])
+	if err != nil {
+		return
+	}
+	if r.First > r.Last {
+		err = errors.New("invalid IP range")
+	}
 	return
 }
+
+// parseIPv4 parses an IPv4 address. Unlike net.ParseIP, it accepts leading zeros that are used in DAT files.
+func parseIPv4(b []byte) (uint32, error) {
+	parts := bytes.Split(bytes.TrimSpace(b), []byte("."))
+	if len(parts) != 4 {
+		return 0, errNotIPv4Address
+	}
+	var ip uint32
+	for _, p := range parts {
+		n, err := strconv.ParseUint(string(p), 10, 8)
+		if err != nil {
+			return 0, errNotIPv4Address
+		}
+		ip = ip<<8 | uint32(n)
+	}
+	return ip, nil
+}
@@ -1,7 +1,9 @@
 package blocklist
 
 import (
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"net"
 	"os"
 	"path/filepath"
@@ -16,8 +18,8 @@ func TestParseCIDR(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	assert.Equal(t, uint32(256), r.first)
-	assert.Equal(t, uint32(511), r.last)
+	assert.Equal(t, uint32(256), r.First)
+	assert.Equal(t, uint32(511), r.Last)
 }
 
 func TestContains(t *testing.T) {
@@ -49,3 +51,69 @@ func TestEmptyList(t *testing.T) {
 	assert.False(t, b.Blocked(net.ParseIP("0.0.0.0")))
 	assert.False(t, b.Blocked(net.ParseIP("176.240.195.107")))
 }
+
+func TestFormats(t *testing.T) {
+	data := []byte(`# comment
+// comment
+1.0.0.0/24
+2.0.0.1
+3.0.0.1-3.0.0.5
+Some Org: with colon:4.0.0.1-4.0.0.5
+005.000.000.001 - 005.000.000.005 , 000 , blocked
+006.000.000.001 - 006.000.000.005 , 200 , allowed
+`)
+	ranges, err := Parse(data, 1<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, ranges, 5)
+	b := New()
+	b.Set(ranges, nil)
+	for _, ip := range []string{"1.0.0.255", "2.0.0.1", "3.0.0.5", "4.0.0.3", "5.0.0.1"} {
+		assert.True(t, b.Blocked(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"1.0.1.0", "2.0.0.2", "3.0.0.6", "6.0.0.3"} {
+		assert.False(t, b.Blocked(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestCompressed(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write([]byte("1.2.3.4\n"))
+	gw.Close()
+
+	var zb bytes.Buffer
+	zw := zip.NewWriter(&zb)
+	w, _ := zw.Create("list.p2p")
+	_, _ = w.Write([]byte("x:1.2.3.4-1.2.3.5\n"))
+	zw.Close()
+
+	for _, data := range [][]byte{gz.Bytes(), zb.Bytes()} {
+		ranges, err := Parse(data, 1<<20, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, ranges, 1)
+		assert.Equal(t, uint32(0x01020304), ranges[0].First)
+
+		_, err = Parse(data, 4, nil)
+		assert.Equal(t, ErrTooBig, err)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	block, err := ParseRange("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow, err := ParseRange("10.1.0.0-10.1.255.255")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := New()
+	b.Set([]Range{block}, []Range{allow})
+	assert.Equal(t, 1, b.Len())
+	assert.True(t, b.Blocked(net.ParseIP("10.0.0.1")))
+	assert.False(t, b.Blocked(net.ParseIP("10.1.2.3")))
+}
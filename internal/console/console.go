@@ -21,6 +21,7 @@ const (
 	sessionStats
 	addTorrent
 	help
+	dialogPage
 )
 
 const (
@@ -30,6 +31,24 @@ const (
 	trackers
 	peers
 	webseeds
+	files
+	pieces
+)
+
+// file priorities in increasing order
+var filePriorities = []string{"Skip", "Low", "Normal", "High"}
+
+// how long the result of an action is shown in the title bar
+const messageTimeout = 10 * time.Second
+
+// colors of pieces in pieces tab
+const (
+	colorHave        = "\x1b[32m"
+	colorDownloading = "\x1b[33m"
+	colorAvailable   = "\x1b[34m"
+	colorMissing     = "\x1b[31m"
+	colorReset       = "\x1b[0m"
+	colorMarked      = "\x1b[36m"
 )
 
 // Console is for drawing a text user interface for a remote Session.
@@ -56,6 +75,24 @@ type Console struct {
 	selectedPage int
 	// distance Y from 0,0
 	tabAdjust int
+	// index of selected file in files tab
+	selectedFile int
+
+	// text in the filter bar
+	filterText string
+	// whether filter bar is open
+	filtering bool
+	// index of the column in sortColumns that torrents are sorted by
+	sortColumn int
+	sortDesc   bool
+	// ids of torrents that are marked for batch actions
+	marked map[string]struct{}
+
+	// currently open dialog window
+	dialog *dialog
+	// result of the last action that is shown in the title bar
+	message     string
+	messageTime time.Time
 
 	// fields to hold responsed from rpc requests
 	torrents     []Torrent
@@ -64,6 +101,8 @@ type Console struct {
 	trackers     []rpctypes.Tracker
 	peers        []rpctypes.Peer
 	webseeds     []rpctypes.Webseed
+	files        []rpctypes.File
+	pieces       rpctypes.Pieces
 
 	// whether details tab is currently updating state
 	updatingDetails bool
@@ -83,7 +122,8 @@ type Console struct {
 
 type Torrent struct {
 	rpctypes.Torrent
-	Stats *rpctypes.Stats
+	Stats    *rpctypes.Stats
+	Trackers []rpctypes.Tracker
 }
 
 // New returns a new Console object that uses a RPC client to get information from a torrent.Session.
@@ -94,6 +134,7 @@ func New(clt *rainrpc.Client, columns []string) *Console {
 		needStats:       columnsNeedStats(columns),
 		updateTorrentsC: make(chan struct{}, 1),
 		updateDetailsC:  make(chan struct{}, 1),
+		marked:          make(map[string]struct{}),
 	}
 }
 
@@ -149,12 +190,32 @@ func (c *Console) keybindings(g *gocui.Gui) {
 	_ = g.SetKeybinding("torrents", 'a', gocui.ModAlt, c.switchSessionStats)
 	_ = g.SetKeybinding("torrents", '?', gocui.ModNone, c.switchHelp)
 
+	// Filter, sort and selection
+	_ = g.SetKeybinding("torrents", '/', gocui.ModNone, c.openFilter)
+	_ = g.SetKeybinding("filter", gocui.KeyEnter, gocui.ModNone, c.closeFilter)
+	_ = g.SetKeybinding("filter", gocui.KeyEsc, gocui.ModNone, c.clearFilter)
+	_ = g.SetKeybinding("filter", gocui.KeyCtrlQ, gocui.ModNone, c.clearFilter)
+	_ = g.SetKeybinding("torrents", 'o', gocui.ModNone, c.nextSortColumn)
+	_ = g.SetKeybinding("torrents", 'O', gocui.ModNone, c.reverseSort)
+	_ = g.SetKeybinding("torrents", gocui.KeySpace, gocui.ModNone, c.toggleMark)
+	_ = g.SetKeybinding("torrents", 'm', gocui.ModNone, c.markAll)
+	_ = g.SetKeybinding("torrents", 'M', gocui.ModNone, c.clearMarks)
+
 	// Tabs
 	_ = g.SetKeybinding("torrents", 'g', gocui.ModAlt, c.switchGeneral)
 	_ = g.SetKeybinding("torrents", 's', gocui.ModAlt, c.switchStats)
 	_ = g.SetKeybinding("torrents", 't', gocui.ModAlt, c.switchTrackers)
 	_ = g.SetKeybinding("torrents", 'p', gocui.ModAlt, c.switchPeers)
 	_ = g.SetKeybinding("torrents", 'w', gocui.ModAlt, c.switchWebseeds)
+	_ = g.SetKeybinding("torrents", 'f', gocui.ModAlt, c.switchFiles)
+	_ = g.SetKeybinding("torrents", 'i', gocui.ModAlt, c.switchPieces)
+
+	// Files tab keys
+	_ = g.SetKeybinding("torrents", ']', gocui.ModNone, c.fileDown)
+	_ = g.SetKeybinding("torrents", '[', gocui.ModNone, c.fileUp)
+	_ = g.SetKeybinding("torrents", '+', gocui.ModNone, c.increaseFilePriority)
+	_ = g.SetKeybinding("torrents", '-', gocui.ModNone, c.decreaseFilePriority)
+	_ = g.SetKeybinding("torrents", 'x', gocui.ModNone, c.toggleSkipFile)
 
 	// Torrent control
 	_ = g.SetKeybinding("torrents", gocui.KeyCtrlS, gocui.ModNone, c.startTorrent)
@@ -164,6 +225,19 @@ func (c *Console) keybindings(g *gocui.Gui) {
 	_ = g.SetKeybinding("torrents", gocui.KeyCtrlV, gocui.ModNone, c.verify)
 	_ = g.SetKeybinding("torrents", gocui.KeyCtrlA, gocui.ModNone, c.switchAddTorrent)
 	_ = g.SetKeybinding("add-torrent", gocui.KeyEnter, gocui.ModNone, c.addTorrentHandleEnter)
+
+	// dialogs
+	_ = g.SetKeybinding("torrents", 'A', gocui.ModNone, c.openAddTorrentDialog)
+	_ = g.SetKeybinding("torrents", 'l', gocui.ModNone, c.openTorrentSpeedLimitDialog)
+	_ = g.SetKeybinding("torrents", 'L', gocui.ModNone, c.openGlobalSpeedLimitDialog)
+	_ = g.SetKeybinding("torrents", 'T', gocui.ModNone, c.openAddTrackerDialog)
+	_ = g.SetKeybinding("torrents", 'P', gocui.ModNone, c.openAddPeerDialog)
+	_ = g.SetKeybinding("torrents", 'c', gocui.ModNone, c.openMagnetDialog)
+	_ = g.SetKeybinding("torrents", 'V', gocui.ModNone, c.openMoveDialog)
+	_ = g.SetKeybinding("torrents", 'C', gocui.ModNone, c.openConfigDialog)
+	_ = g.SetKeybinding("dialog", gocui.KeyEnter, gocui.ModNone, c.dialogEnter)
+	_ = g.SetKeybinding("dialog", gocui.KeyCtrlS, gocui.ModNone, c.submitDialog)
+	_ = g.SetKeybinding("dialog", gocui.KeyCtrlQ, gocui.ModNone, c.quitDialog)
 }
 
 func (c *Console) startUpdatingTorrents(g *gocui.Gui) {
@@ -223,6 +297,10 @@ func (c *Console) layout(g *gocui.Gui) error {
 		_ = g.DeleteView("add-torrent")
 		g.Cursor = false
 	}
+	if c.selectedPage != dialogPage {
+		_ = g.DeleteView("dialog-info")
+		_ = g.DeleteView("dialog")
+	}
 	switch c.selectedPage {
 	case torrents:
 		err = c.drawTorrents(g)
@@ -233,7 +311,18 @@ func (c *Console) layout(g *gocui.Gui) error {
 		if err != nil {
 			return err
 		}
-		_, err = g.SetCurrentView("torrents")
+		if c.filtering {
+			err = c.drawFilter(g)
+			if err != nil {
+				return err
+			}
+			g.Cursor = true
+			_, err = g.SetCurrentView("filter")
+		} else {
+			_ = g.DeleteView("filter")
+			g.Cursor = false
+			_, err = g.SetCurrentView("torrents")
+		}
 	case sessionStats:
 		err = c.drawSessionStats(g)
 		if err != nil {
@@ -253,6 +342,13 @@ func (c *Console) layout(g *gocui.Gui) error {
 		}
 		g.Cursor = true
 		_, err = g.SetCurrentView("add-torrent")
+	case dialogPage:
+		err = c.drawDialog(g)
+		if err != nil {
+			return err
+		}
+		g.Cursor = true
+		_, err = g.SetCurrentView("dialog")
 	}
 	return err
 }
@@ -260,11 +356,49 @@ func (c *Console) layout(g *gocui.Gui) error {
 func (c *Console) drawTitle(g *gocui.Gui) error {
 	maxX, maxY := g.Size()
 	v, err := g.SetView("title", -1, 0, maxX, maxY)
+	if err != nil && err != gocui.ErrUnknownView {
+		return err
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	title := "Rain by put.io [" + c.client.Addr() + "] (Press '?' for help)"
+	direction := "asc"
+	if c.sortDesc {
+		direction = "desc"
+	}
+	title += " [Sort: " + sortColumns[c.sortColumn] + " " + direction + "]"
+	if c.filterText != "" {
+		title += " [Filter: " + c.filterText + "]"
+	}
+	if len(c.marked) > 0 {
+		title += fmt.Sprintf(" [Marked: %d]", len(c.marked))
+	}
+	if c.message != "" && time.Since(c.messageTime) < messageTimeout {
+		title += " [" + c.message + "]"
+	}
+	v.Title = title
+	return nil
+}
+
+func (c *Console) drawFilter(g *gocui.Gui) error {
+	maxX, maxY := g.Size()
+	v, err := g.SetView("filter", 5, maxY/2-1, maxX-6, maxY/2+1)
 	if err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
-		v.Title = "Rain by put.io [" + c.client.Addr() + "] (Press '?' for help)"
+		v.Frame = true
+		v.Title = "Filter (name, status:, tracker:, id:) Enter to close, ctrl-q to clear"
+		v.Editable = true
+		v.Editor = gocui.EditorFunc(func(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modifier) {
+			gocui.DefaultEditor.Edit(v, key, ch, mod)
+			c.m.Lock()
+			c.filterText = strings.TrimSpace(v.Buffer())
+			c.m.Unlock()
+			c.triggerUpdateTorrents()
+		})
+		fmt.Fprint(v, c.filterText)
+		_ = v.SetCursor(len(c.filterText), 0)
 	}
 	return nil
 }
@@ -289,6 +423,12 @@ func (c *Console) drawHelp(g *gocui.Gui) error {
 	fmt.Fprintln(v, "    g|home  Go to top")
 	fmt.Fprintln(v, "     G|end  Go to bottom")
 	fmt.Fprintln(v, "     alt+a  show session stats page")
+	fmt.Fprintln(v, "         /  filter torrents by name, status:, tracker:, id:")
+	fmt.Fprintln(v, "         o  sort by next column")
+	fmt.Fprintln(v, "         O  reverse sort direction")
+	fmt.Fprintln(v, "     space  mark/unmark torrent for batch actions")
+	fmt.Fprintln(v, "         m  mark all shown torrents")
+	fmt.Fprintln(v, "         M  clear marks")
 
 	fmt.Fprintln(v, "")
 
@@ -297,15 +437,32 @@ func (c *Console) drawHelp(g *gocui.Gui) error {
 	fmt.Fprintln(v, "     alt+t  switch to Trackers tab")
 	fmt.Fprintln(v, "     alt+p  switch to Peers tab")
 	fmt.Fprintln(v, "     alt+w  switch to Webseeds tab")
+	fmt.Fprintln(v, "     alt+f  switch to Files tab")
+	fmt.Fprintln(v, "     alt+i  switch to Pieces tab")
 
 	fmt.Fprintln(v, "")
 
+	fmt.Fprintln(v, "       ]|[  select next/previous file in Files tab")
+	fmt.Fprintln(v, "       +|-  increase/decrease priority of selected file")
+	fmt.Fprintln(v, "         x  skip selected file or download it again")
+
+	fmt.Fprintln(v, "")
+
+	fmt.Fprintln(v, "Actions below apply to marked torrents, or the selected torrent if none is marked.")
 	fmt.Fprintln(v, "    ctrl+s  Start torrent")
 	fmt.Fprintln(v, "ctrl+alt+s  Stop torrent")
 	fmt.Fprintln(v, "    ctrl+R  Remove torrent")
 	fmt.Fprintln(v, "ctrl+alt+a  Announce torrent")
 	fmt.Fprintln(v, "    ctrl+v  Verify torrent")
 	fmt.Fprintln(v, "    ctrl+a  Add new torrent")
+	fmt.Fprintln(v, "         A  Add new torrent with options")
+	fmt.Fprintln(v, "         l  set speed limit of torrent")
+	fmt.Fprintln(v, "         L  set global speed limit")
+	fmt.Fprintln(v, "         T  add tracker to torrent")
+	fmt.Fprintln(v, "         P  add peer to torrent")
+	fmt.Fprintln(v, "         c  copy magnet link of selected torrent")
+	fmt.Fprintln(v, "         V  move torrent to another server")
+	fmt.Fprintln(v, "         C  view/edit session config")
 
 	return nil
 }
@@ -491,7 +648,11 @@ func (c *Console) drawTorrents(g *gocui.Gui) error {
 
 		selectedIDrow := -1
 		for i, t := range c.torrents {
-			fmt.Fprint(v, getRow(c.columns, t, i))
+			row := getRow(c.columns, t, i)
+			if _, ok := c.marked[t.ID]; ok {
+				row = colorMarked + strings.TrimSuffix(row, "\n") + colorReset + "\n"
+			}
+			fmt.Fprint(v, row)
 
 			if t.ID == c.selectedID {
 				selectedIDrow = i
@@ -501,12 +662,12 @@ func (c *Console) drawTorrents(g *gocui.Gui) error {
 		_, cy := v.Cursor()
 		_, oy := v.Origin()
 		selectedRow := cy + oy
-		if selectedRow < len(c.torrents) {
-			if c.torrents[selectedRow].ID != c.selectedID && selectedIDrow != -1 {
-				_ = v.SetCursor(0, selectedIDrow)
-			} else {
-				c.setSelectedID(c.torrents[selectedRow].ID)
-			}
+		switch {
+		case selectedIDrow != -1 && (selectedRow >= len(c.torrents) || c.torrents[selectedRow].ID != c.selectedID):
+			// Order of rows may change after sorting or filtering.
+			return c.switchRow(v, selectedIDrow)
+		case selectedRow < len(c.torrents):
+			c.setSelectedID(c.torrents[selectedRow].ID)
 		}
 	}
 	return nil
@@ -538,6 +699,10 @@ func (c *Console) drawDetails(g *gocui.Gui) error {
 			v.Title = "Peers"
 		case webseeds:
 			v.Title = "WebSeeds"
+		case files:
+			v.Title = "Files"
+		case pieces:
+			v.Title = "Pieces"
 		}
 		if c.selectedID == "" {
 			return nil
@@ -586,8 +751,8 @@ func (c *Console) drawDetails(g *gocui.Gui) error {
 				fmt.Fprintf(v, "    Last announce: %s, Next announce: %s\n", t.LastAnnounce.Time.Format(time.RFC3339), nextAnnounce)
 			}
 		case peers:
-			format := "%2s %21s %7s %8s %6s %s\n"
-			fmt.Fprintf(v, format, "#", "Addr", "Flags", "Download", "Upload", "Client")
+			format := "%2s %21s %7s %8s %6s %5s %s\n"
+			fmt.Fprintf(v, format, "#", "Addr", "Flags", "Download", "Upload", "Queue", "Client")
 			for i, p := range c.peers {
 				num := fmt.Sprintf("%d", i+1)
 				var dl string
@@ -598,7 +763,7 @@ func (c *Console) drawDetails(g *gocui.Gui) error {
 				if p.UploadSpeed > 0 {
 					ul = fmt.Sprintf("%d", p.UploadSpeed/1024)
 				}
-				fmt.Fprintf(v, format, num, p.Addr, flags(p), dl, ul, p.Client)
+				fmt.Fprintf(v, format, num, p.Addr, flags(p), dl, ul, fmt.Sprintf("%d", p.RequestQueue), p.Client)
 			}
 		case webseeds:
 			format := "%2s %40s %8s %s\n"
@@ -615,11 +780,114 @@ func (c *Console) drawDetails(g *gocui.Gui) error {
 				}
 				fmt.Fprintf(v, format, num, p.URL, dl, errstr)
 			}
+		case files:
+			format := "%1s%3s %-8s %10s %4s %s\n"
+			fmt.Fprintf(v, format, "", "#", "Priority", "Size", "Done", "Path")
+			// Scroll down until selected file is visible.
+			_, height := v.Size()
+			var first int
+			if height > 1 && c.selectedFile >= height-1 {
+				first = c.selectedFile - height + 2
+			}
+			for i := first; i < len(c.files); i++ {
+				f := c.files[i]
+				var cursor string
+				if i == c.selectedFile {
+					cursor = ">"
+				}
+				num := fmt.Sprintf("%d", i+1)
+				var progress int64 = 100
+				if f.Length > 0 {
+					progress = f.Completed * 100 / f.Length
+				}
+				done := fmt.Sprintf("%d%%", progress)
+				fmt.Fprintf(v, format, cursor, num, f.Priority, formatSize(f.Length), done, f.Path)
+			}
+		case pieces:
+			width, height := v.Size()
+			drawPieces(v, &c.pieces, width, height-1)
 		}
 	}
 	return nil
 }
 
+const (
+	pieceMissing = iota
+	pieceAvailable
+	pieceDownloading
+	pieceHave
+)
+
+// drawPieces draws a map of pieces that fits in a area of width x height characters.
+// If there are more pieces than the characters in the area, each character represents a range of pieces.
+func drawPieces(v io.Writer, p *rpctypes.Pieces, width, height int) {
+	if p.Total == 0 {
+		fmt.Fprintln(v, "no metadata")
+		return
+	}
+	state := make([]int, p.Total)
+	for i := range state {
+		switch {
+		case len(p.Have) > i/8 && p.Have[i/8]&(0x80>>uint(i%8)) != 0:
+			state[i] = pieceHave
+		case len(p.Availability) > i && p.Availability[i] > 0:
+			state[i] = pieceAvailable
+		}
+	}
+	for _, l := range [][]uint32{p.Downloading, p.Writing, p.Verifying} {
+		for _, i := range l {
+			if i < p.Total && state[i] != pieceHave {
+				state[i] = pieceDownloading
+			}
+		}
+	}
+	var counts [4]int
+	for _, s := range state {
+		counts[s]++
+	}
+	fmt.Fprintf(v, "%s█%s have: %d  %s█%s downloading: %d  %s█%s available: %d  %s█%s missing: %d\n",
+		colorHave, colorReset, counts[pieceHave],
+		colorDownloading, colorReset, counts[pieceDownloading],
+		colorAvailable, colorReset, counts[pieceAvailable],
+		colorMissing, colorReset, counts[pieceMissing])
+	if width < 1 || height < 1 {
+		return
+	}
+	cells := width * height
+	perCell := (len(state) + cells - 1) / cells
+	colors := [4]string{colorMissing, colorAvailable, colorDownloading, colorHave}
+	var sb strings.Builder
+	for begin, col := 0, 0; begin < len(state); begin, col = begin+perCell, col+1 {
+		end := begin + perCell
+		if end > len(state) {
+			end = len(state)
+		}
+		// A cell is shown as have only if all pieces in it are downloaded, otherwise the most advanced missing state is shown.
+		cell := pieceMissing
+		all := true
+		for _, s := range state[begin:end] {
+			if s == pieceHave {
+				continue
+			}
+			all = false
+			if s > cell {
+				cell = s
+			}
+		}
+		if all {
+			cell = pieceHave
+		}
+		if col == width {
+			sb.WriteString("\n")
+			col = 0
+		}
+		sb.WriteString(colors[cell])
+		sb.WriteString("█")
+	}
+	sb.WriteString(colorReset)
+	fmt.Fprintln(v, sb.String())
+}
+
 func (c *Console) updateTorrentsAndDetailsLoop(g *gocui.Gui, stop chan struct{}) {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
@@ -658,42 +926,57 @@ func (c *Console) updateSessionStatsLoop(g *gocui.Gui, stop chan struct{}) {
 func (c *Console) updateTorrents(g *gocui.Gui) {
 	rpcTorrents, err := c.client.ListTorrents()
 
-	sort.Slice(rpcTorrents, func(i, j int) bool {
-		a, b := rpcTorrents[i], rpcTorrents[j]
-		if a.AddedAt.Equal(b.AddedAt.Time) {
-			return a.ID < b.ID
-		}
-		return a.AddedAt.Time.Before(b.AddedAt.Time)
-	})
-
 	torrents := make([]Torrent, 0, len(rpcTorrents))
 	for _, t := range rpcTorrents {
 		torrents = append(torrents, Torrent{Torrent: t})
 	}
 
-	// Get torrent stats in parallel
-	if c.needStats {
-		inside := c.rowsInsideView(g)
-		var wg sync.WaitGroup
-		for _, i := range inside {
-			if i < len(torrents) {
-				t := &torrents[i]
-				wg.Add(1)
-				go func(t *Torrent) {
-					t.Stats, _ = c.client.GetTorrentStats(t.ID)
-					wg.Done()
-				}(t)
-			}
-		}
-		wg.Wait()
+	c.m.Lock()
+	f := parseFilter(c.filterText)
+	sortColumn := sortColumns[c.sortColumn]
+	sortDesc := c.sortDesc
+	c.m.Unlock()
+
+	// Filtering and sorting by some fields require getting details of all torrents.
+	all := make([]int, len(torrents))
+	for i := range all {
+		all[i] = i
+	}
+	if f.needsTrackers() {
+		c.getTorrentDetails(torrents, all, func(t *Torrent) {
+			t.Trackers, _ = c.client.GetTorrentTrackers(t.ID)
+		})
+	}
+	statsReceived := f.needsStats() || sortNeedsStats(sortColumn)
+	if statsReceived {
+		c.getTorrentDetails(torrents, all, c.getTorrentStats)
+	}
+
+	torrents = filterTorrents(torrents, f)
+	sortTorrents(torrents, sortColumn, sortDesc)
+
+	// Get torrent stats of visible rows
+	if c.needStats && !statsReceived {
+		c.getTorrentDetails(torrents, c.rowsInsideView(g), c.getTorrentStats)
 	}
 
 	c.m.Lock()
 	c.torrents = torrents
 	c.errTorrents = err
-	if len(c.torrents) == 0 {
+	// Forget marks of torrents that are removed or filtered out.
+	shown := make(map[string]struct{}, len(torrents))
+	for _, t := range torrents {
+		shown[t.ID] = struct{}{}
+	}
+	for id := range c.marked {
+		if _, ok := shown[id]; !ok {
+			delete(c.marked, id)
+		}
+	}
+	if _, ok := shown[c.selectedID]; !ok {
 		c.setSelectedID("")
-	} else if c.selectedID == "" {
+	}
+	if len(c.torrents) > 0 && c.selectedID == "" {
 		c.setSelectedID(c.torrents[0].ID)
 	}
 	c.m.Unlock()
@@ -701,6 +984,33 @@ func (c *Console) updateTorrents(g *gocui.Gui) {
 	g.Update(c.drawTorrents)
 }
 
+// getTorrentDetails calls fn in parallel for torrents at indexes.
+func (c *Console) getTorrentDetails(torrents []Torrent, indexes []int, fn func(t *Torrent)) {
+	const maxParallel = 10
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for _, i := range indexes {
+		if i < len(torrents) {
+			t := &torrents[i]
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(t *Torrent) {
+				fn(t)
+				<-sem
+				wg.Done()
+			}(t)
+		}
+	}
+	wg.Wait()
+}
+
+func (c *Console) getTorrentStats(t *Torrent) {
+	stats, err := c.client.GetTorrentStats(t.ID)
+	if err == nil {
+		t.Stats = stats
+	}
+}
+
 func (c *Console) rowsInsideView(g *gocui.Gui) []int {
 	c.m.Lock()
 	defer c.m.Unlock()
@@ -768,6 +1078,24 @@ func (c *Console) updateDetails(g *gocui.Gui) {
 		c.webseeds = webseeds
 		c.errDetails = err
 		c.m.Unlock()
+	case files:
+		files, err := c.client.GetTorrentFiles(selectedID)
+		c.m.Lock()
+		c.files = files
+		if c.selectedFile >= len(files) {
+			c.selectedFile = len(files) - 1
+		}
+		if c.selectedFile < 0 {
+			c.selectedFile = 0
+		}
+		c.errDetails = err
+		c.m.Unlock()
+	case pieces:
+		pieces, err := c.client.GetTorrentPieces(selectedID)
+		c.m.Lock()
+		c.pieces = *pieces
+		c.errDetails = err
+		c.m.Unlock()
 	}
 
 	c.m.Lock()
@@ -929,73 +1257,100 @@ func (c *Console) goBottom(g *gocui.Gui, v *gocui.View) error {
 }
 
 func (c *Console) removeTorrent(g *gocui.Gui, v *gocui.View) error {
-	c.m.Lock()
-	id := c.selectedID
-	c.m.Unlock()
-
-	err := c.client.RemoveTorrent(id)
-	if err != nil {
-		return err
+	ids := c.targetIDs()
+	if len(ids) == 0 {
+		return nil
 	}
-	c.triggerUpdateTorrents()
+	c.openDialog(&dialog{
+		title:   fmt.Sprintf("Remove %d Torrent(s)", len(ids)),
+		help:    []string{"Downloaded data of the torrents is deleted from the disk."},
+		confirm: fmt.Sprintf("%d torrent(s) will be removed with their data.", len(ids)),
+		action: func(input string) (string, error) {
+			for _, id := range ids {
+				err := c.client.RemoveTorrent(id)
+				if err != nil {
+					return "", err
+				}
+			}
+			return fmt.Sprintf("%d torrent(s) removed", len(ids)), nil
+		},
+	})
 	return nil
 }
 
+// targetIDs returns the IDs of the torrents that actions apply to.
+// These are the marked torrents in the order they are shown, or the selected torrent if none is marked.
+func (c *Console) targetIDs() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if len(c.marked) == 0 {
+		if c.selectedID == "" {
+			return nil
+		}
+		return []string{c.selectedID}
+	}
+	ids := make([]string, 0, len(c.marked))
+	for _, t := range c.torrents {
+		if _, ok := c.marked[t.ID]; ok {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids
+}
+
 func (c *Console) setSelectedID(id string) {
 	changed := id != c.selectedID
 	c.selectedID = id
 	if changed {
+		c.selectedFile = 0
 		c.triggerUpdateDetails(true)
 	}
 }
 
 func (c *Console) startTorrent(g *gocui.Gui, v *gocui.View) error {
-	c.m.Lock()
-	id := c.selectedID
-	c.m.Unlock()
-
-	err := c.client.StartTorrent(id)
-	if err != nil {
-		return err
+	for _, id := range c.targetIDs() {
+		err := c.client.StartTorrent(id)
+		if err != nil {
+			// Returning the error from a key binding quits the console.
+			c.setMessage("error: " + err.Error())
+			break
+		}
 	}
 	c.triggerUpdateDetails(true)
 	return nil
 }
 
 func (c *Console) stopTorrent(g *gocui.Gui, v *gocui.View) error {
-	c.m.Lock()
-	id := c.selectedID
-	c.m.Unlock()
-
-	err := c.client.StopTorrent(id)
-	if err != nil {
-		return err
+	for _, id := range c.targetIDs() {
+		err := c.client.StopTorrent(id)
+		if err != nil {
+			c.setMessage("error: " + err.Error())
+			break
+		}
 	}
 	c.triggerUpdateDetails(true)
 	return nil
 }
 
 func (c *Console) announce(g *gocui.Gui, v *gocui.View) error {
-	c.m.Lock()
-	id := c.selectedID
-	c.m.Unlock()
-
-	err := c.client.AnnounceTorrent(id)
-	if err != nil {
-		return err
+	for _, id := range c.targetIDs() {
+		err := c.client.AnnounceTorrent(id)
+		if err != nil {
+			c.setMessage("error: " + err.Error())
+			break
+		}
 	}
 	c.triggerUpdateDetails(true)
 	return nil
 }
 
 func (c *Console) verify(g *gocui.Gui, v *gocui.View) error {
-	c.m.Lock()
-	id := c.selectedID
-	c.m.Unlock()
-
-	err := c.client.VerifyTorrent(id)
-	if err != nil {
-		return err
+	for _, id := range c.targetIDs() {
+		err := c.client.VerifyTorrent(id)
+		if err != nil {
+			c.setMessage("error: " + err.Error())
+			break
+		}
 	}
 	c.triggerUpdateDetails(true)
 	return nil
@@ -1059,6 +1414,172 @@ func (c *Console) switchWebseeds(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
+func (c *Console) switchFiles(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	c.selectedTab = files
+	c.m.Unlock()
+	c.triggerUpdateDetails(true)
+	return nil
+}
+
+func (c *Console) switchPieces(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	c.selectedTab = pieces
+	c.m.Unlock()
+	c.triggerUpdateDetails(true)
+	return nil
+}
+
+func (c *Console) fileDown(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.selectedTab != files {
+		return nil
+	}
+	if c.selectedFile < len(c.files)-1 {
+		c.selectedFile++
+		g.Update(c.drawDetails)
+	}
+	return nil
+}
+
+func (c *Console) fileUp(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.selectedTab != files {
+		return nil
+	}
+	if c.selectedFile > 0 {
+		c.selectedFile--
+		g.Update(c.drawDetails)
+	}
+	return nil
+}
+
+func (c *Console) increaseFilePriority(g *gocui.Gui, v *gocui.View) error {
+	return c.changeFilePriority(g, func(i int) int { return i + 1 })
+}
+
+func (c *Console) decreaseFilePriority(g *gocui.Gui, v *gocui.View) error {
+	return c.changeFilePriority(g, func(i int) int { return i - 1 })
+}
+
+func (c *Console) toggleSkipFile(g *gocui.Gui, v *gocui.View) error {
+	return c.changeFilePriority(g, func(i int) int {
+		if i == 0 {
+			return 2 // Normal
+		}
+		return 0 // Skip
+	})
+}
+
+// changeFilePriority sets the priority of the selected file.
+// f returns the index of the new priority in filePriorities for the index of current priority.
+func (c *Console) changeFilePriority(g *gocui.Gui, f func(int) int) error {
+	c.m.Lock()
+	if c.selectedTab != files || c.selectedFile >= len(c.files) {
+		c.m.Unlock()
+		return nil
+	}
+	id := c.selectedID
+	index := c.selectedFile
+	current := c.files[index].Priority
+	c.m.Unlock()
+
+	i := f(indexOf(filePriorities, current))
+	if i < 0 || i >= len(filePriorities) {
+		return nil
+	}
+	err := c.client.SetFilePriority(id, index, filePriorities[i])
+	if err != nil {
+		// Show the error until next refresh.
+		c.m.Lock()
+		c.errDetails = err
+		c.m.Unlock()
+		g.Update(c.drawDetails)
+		return nil
+	}
+	c.triggerUpdateDetails(false)
+	return nil
+}
+
+func indexOf(a []string, s string) int {
+	for i := range a {
+		if a[i] == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Console) openFilter(g *gocui.Gui, v *gocui.View) error {
+	c.filtering = true
+	return nil
+}
+
+func (c *Console) closeFilter(g *gocui.Gui, v *gocui.View) error {
+	c.filtering = false
+	return nil
+}
+
+func (c *Console) clearFilter(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	c.filterText = ""
+	c.m.Unlock()
+	c.filtering = false
+	c.triggerUpdateTorrents()
+	return nil
+}
+
+func (c *Console) nextSortColumn(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	c.sortColumn = (c.sortColumn + 1) % len(sortColumns)
+	c.m.Unlock()
+	c.triggerUpdateTorrents()
+	return nil
+}
+
+func (c *Console) reverseSort(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	c.sortDesc = !c.sortDesc
+	c.m.Unlock()
+	c.triggerUpdateTorrents()
+	return nil
+}
+
+func (c *Console) toggleMark(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.selectedID == "" {
+		return nil
+	}
+	if _, ok := c.marked[c.selectedID]; ok {
+		delete(c.marked, c.selectedID)
+	} else {
+		c.marked[c.selectedID] = struct{}{}
+	}
+	g.Update(c.drawTorrents)
+	return nil
+}
+
+func (c *Console) markAll(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, t := range c.torrents {
+		c.marked[t.ID] = struct{}{}
+	}
+	g.Update(c.drawTorrents)
+	return nil
+}
+
+func (c *Console) clearMarks(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.marked = make(map[string]struct{})
+	g.Update(c.drawTorrents)
+	return nil
+}
+
 func (c *Console) switchHelp(g *gocui.Gui, v *gocui.View) error {
 	c.selectedPage = help
 	return nil
@@ -1161,6 +1682,8 @@ func getProgress(stats *rpctypes.Stats) int {
 			progress = int(stats.Pieces.Checked * 100 / stats.Pieces.Total)
 		case "Allocating":
 			progress = int(stats.Bytes.Allocated * 100 / stats.Bytes.Total)
+		case "Moving":
+			progress = int(stats.Bytes.Moved * 100 / stats.Bytes.Total)
 		default:
 			progress = int(stats.Pieces.Have * 100 / stats.Pieces.Total)
 		}
@@ -1177,14 +1700,18 @@ func getRatio(stats *rpctypes.Stats) float64 {
 }
 
 func getSize(stats *rpctypes.Stats) string {
+	return formatSize(stats.Bytes.Total)
+}
+
+func formatSize(n int64) string {
 	var size string
 	switch {
-	case stats.Bytes.Total < 1<<10:
-		size = fmt.Sprintf("%d bytes", stats.Bytes.Total)
-	case stats.Bytes.Total < 1<<20:
-		size = fmt.Sprintf("%d KiB", stats.Bytes.Total/(1<<10))
+	case n < 1<<10:
+		size = fmt.Sprintf("%d bytes", n)
+	case n < 1<<20:
+		size = fmt.Sprintf("%d KiB", n/(1<<10))
 	default:
-		size = fmt.Sprintf("%d MiB", stats.Bytes.Total/(1<<20))
+		size = fmt.Sprintf("%d MiB", n/(1<<20))
 	}
 	return size
 }
@@ -1229,6 +1756,7 @@ func FormatSessionStats(s *rpctypes.SessionStats, v io.Writer) {
 	fmt.Fprintf(v, "BlocklistRules: %d, Updated: %s ago\n", s.BlockListRules, time.Duration(s.BlockListRecency)*time.Second)
 	fmt.Fprintf(v, "Reads: %d/s, %dKB/s, Active: %d, Pending: %d\n", s.ReadsPerSecond, s.SpeedRead/1024, s.ReadsActive, s.ReadsPending)
 	fmt.Fprintf(v, "Writes: %d/s, %dKB/s, Active: %d, Pending: %d\n", s.WritesPerSecond, s.SpeedWrite/1024, s.WritesActive, s.WritesPending)
+	fmt.Fprintf(v, "WriteQueue: %dKB, WriteLatency: %dms, Syncs: %d/s, SyncLatency: %dms\n", s.WriteQueueBytes/1024, s.WriteLatencyMS, s.SyncsPerSecond, s.SyncLatencyMS)
 	fmt.Fprintf(v, "ReadCache Objects: %d, Size: %dMB, Utilization: %d%%\n", s.ReadCacheObjects, s.ReadCacheSize/(1<<20), s.ReadCacheUtilization)
 	fmt.Fprintf(v, "WriteCache Objects: %d, Size: %dMB, PendingKeys: %d\n", s.WriteCacheObjects, s.WriteCacheSize/(1<<20), s.WriteCachePendingKeys)
 	fmt.Fprintf(v, "DownloadSpeed: %dKB/s, UploadSpeed: %dKB/s\n", s.SpeedDownload/1024, s.SpeedUpload/1024)
@@ -0,0 +1,394 @@
+package console
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganqierwu/rain/rainrpc"
+	"github.com/jroimartin/gocui"
+)
+
+// dialog is a modal window that asks for input and runs an action with it.
+type dialog struct {
+	title string
+	// Lines shown above the input.
+	help []string
+	// Initial text of the input.
+	input string
+	// If true, Enter inserts a new line and ctrl+s submits the input.
+	multiline bool
+	// If not empty, this question is asked before running the action.
+	confirm string
+	// Runs with the input text. Returns a message to show after success.
+	action func(input string) (string, error)
+
+	// Waiting for the user to submit again to confirm.
+	confirming bool
+	// Error from the last run of the action.
+	err error
+}
+
+func (c *Console) openDialog(d *dialog) {
+	c.dialog = d
+	c.selectedPage = dialogPage
+}
+
+func (c *Console) drawDialog(g *gocui.Gui) error {
+	d := c.dialog
+	maxX, maxY := g.Size()
+	infoHeight := len(d.help) + 3
+	v, err := g.SetView("dialog-info", 5, 2, maxX-6, 2+infoHeight)
+	if err != nil && err != gocui.ErrUnknownView {
+		return err
+	}
+	v.Frame = true
+	v.Wrap = true
+	if d.multiline {
+		v.Title = d.title + " (ctrl-s to submit, ctrl-q to close)"
+	} else {
+		v.Title = d.title + " (Enter to submit, ctrl-q to close)"
+	}
+	v.Clear()
+	for _, line := range d.help {
+		fmt.Fprintln(v, line)
+	}
+	switch {
+	case d.err != nil:
+		fmt.Fprintln(v, colorMissing+"error: "+d.err.Error()+colorReset)
+	case d.confirming:
+		fmt.Fprintln(v, colorDownloading+d.confirm+" Submit again to confirm."+colorReset)
+	}
+
+	bottom := 2 + infoHeight + 2
+	if d.multiline {
+		bottom = maxY - 3
+	}
+	v, err = g.SetView("dialog", 5, 2+infoHeight+1, maxX-6, bottom)
+	if err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Frame = true
+		v.Editable = true
+		fmt.Fprint(v, d.input)
+		if !d.multiline {
+			_ = v.SetCursor(len(d.input), 0)
+		}
+	}
+	return nil
+}
+
+func (c *Console) dialogEnter(g *gocui.Gui, v *gocui.View) error {
+	if c.dialog.multiline {
+		v.EditNewLine()
+		return nil
+	}
+	return c.submitDialog(g, v)
+}
+
+func (c *Console) submitDialog(g *gocui.Gui, v *gocui.View) error {
+	d := c.dialog
+	if d.confirm != "" && !d.confirming {
+		d.confirming = true
+		d.err = nil
+		return nil
+	}
+	input := v.Buffer()
+	if !d.multiline {
+		input = strings.TrimSpace(input)
+	}
+	msg, err := d.action(input)
+	if err != nil {
+		d.err = err
+		d.confirming = false
+		return nil
+	}
+	c.closeDialog()
+	c.setMessage(msg)
+	c.triggerUpdateTorrents()
+	c.triggerUpdateDetails(false)
+	return nil
+}
+
+func (c *Console) closeDialog() {
+	c.dialog = nil
+	c.selectedPage = torrents
+}
+
+func (c *Console) quitDialog(g *gocui.Gui, v *gocui.View) error {
+	c.closeDialog()
+	return nil
+}
+
+// setMessage shows a message in the title bar for a while.
+func (c *Console) setMessage(msg string) {
+	c.m.Lock()
+	c.message = msg
+	c.messageTime = time.Now()
+	c.m.Unlock()
+}
+
+func (c *Console) openAddTorrentDialog(g *gocui.Gui, v *gocui.View) error {
+	c.openDialog(&dialog{
+		title:     "Add Torrent",
+		help:      []string{"uri is a magnet link, URL or path of a torrent file. Leave id and dest empty for defaults."},
+		input:     "uri: \nid: \ndest: \nstopped: false\nstop-after-download: false\n",
+		multiline: true,
+		action: func(input string) (string, error) {
+			var uri string
+			var opt rainrpc.AddTorrentOptions
+			for _, line := range strings.Split(input, "\n") {
+				line = strings.TrimSpace(line)
+				if line == "" {
+					continue
+				}
+				key, value, ok := strings.Cut(line, ":")
+				if !ok {
+					return "", fmt.Errorf("invalid line: %q", line)
+				}
+				value = strings.TrimSpace(value)
+				var err error
+				switch key {
+				case "uri":
+					uri = value
+				case "id":
+					opt.ID = value
+				case "dest":
+					opt.DataDir = value
+				case "stopped":
+					opt.Stopped, err = strconv.ParseBool(value)
+				case "stop-after-download":
+					opt.StopAfterDownload, err = strconv.ParseBool(value)
+				default:
+					err = fmt.Errorf("unknown option: %q", key)
+				}
+				if err != nil {
+					return "", err
+				}
+			}
+			if uri == "" {
+				return "", errors.New("uri is required")
+			}
+			if isURI(uri) {
+				_, err := c.client.AddURI(uri, &opt)
+				if err != nil {
+					return "", err
+				}
+			} else {
+				f, err := os.Open(uri)
+				if err != nil {
+					return "", err
+				}
+				defer f.Close()
+				_, err = c.client.AddTorrent(f, &opt)
+				if err != nil {
+					return "", err
+				}
+			}
+			return "Torrent added", nil
+		},
+	})
+	return nil
+}
+
+func parseSpeedLimit(s string) (download, upload int64, err error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, 0, errors.New("enter download and upload limits separated by space")
+	}
+	download, err = strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return
+	}
+	upload, err = strconv.ParseInt(fields[1], 10, 64)
+	return
+}
+
+func (c *Console) openTorrentSpeedLimitDialog(g *gocui.Gui, v *gocui.View) error {
+	ids := c.targetIDs()
+	if len(ids) == 0 {
+		return nil
+	}
+	download, upload, err := c.client.GetSpeedLimit(ids[0])
+	if err != nil {
+		c.setMessage("error: " + err.Error())
+		return nil
+	}
+	c.openDialog(&dialog{
+		title: fmt.Sprintf("Speed Limit of %d Torrent(s)", len(ids)),
+		help:  []string{"Download and upload limits in KB/s separated by space. 0 means no limit."},
+		input: fmt.Sprintf("%d %d", download, upload),
+		action: func(input string) (string, error) {
+			download, upload, err := parseSpeedLimit(input)
+			if err != nil {
+				return "", err
+			}
+			for _, id := range ids {
+				err = c.client.SetSpeedLimit(id, download, upload)
+				if err != nil {
+					return "", err
+				}
+			}
+			return "Speed limit changed", nil
+		},
+	})
+	return nil
+}
+
+func (c *Console) openGlobalSpeedLimitDialog(g *gocui.Gui, v *gocui.View) error {
+	download, upload, err := c.client.GetSpeedLimit("")
+	if err != nil {
+		c.setMessage("error: " + err.Error())
+		return nil
+	}
+	c.openDialog(&dialog{
+		title: "Global Speed Limit",
+		help:  []string{"Download and upload limits in KB/s separated by space. 0 means no limit."},
+		input: fmt.Sprintf("%d %d", download, upload),
+		action: func(input string) (string, error) {
+			download, upload, err := parseSpeedLimit(input)
+			if err != nil {
+				return "", err
+			}
+			err = c.client.SetSpeedLimit("", download, upload)
+			if err != nil {
+				return "", err
+			}
+			return "Global speed limit changed", nil
+		},
+	})
+	return nil
+}
+
+func (c *Console) openAddTrackerDialog(g *gocui.Gui, v *gocui.View) error {
+	ids := c.targetIDs()
+	if len(ids) == 0 {
+		return nil
+	}
+	c.openDialog(&dialog{
+		title: fmt.Sprintf("Add Tracker to %d Torrent(s)", len(ids)),
+		help:  []string{"Tracker URL"},
+		action: func(input string) (string, error) {
+			for _, id := range ids {
+				err := c.client.AddTracker(id, input)
+				if err != nil {
+					return "", err
+				}
+			}
+			return "Tracker added", nil
+		},
+	})
+	return nil
+}
+
+func (c *Console) openAddPeerDialog(g *gocui.Gui, v *gocui.View) error {
+	ids := c.targetIDs()
+	if len(ids) == 0 {
+		return nil
+	}
+	c.openDialog(&dialog{
+		title: fmt.Sprintf("Add Peer to %d Torrent(s)", len(ids)),
+		help:  []string{"Peer address in host:port format"},
+		action: func(input string) (string, error) {
+			for _, id := range ids {
+				err := c.client.AddPeer(id, input)
+				if err != nil {
+					return "", err
+				}
+			}
+			return "Peer added", nil
+		},
+	})
+	return nil
+}
+
+func (c *Console) openMoveDialog(g *gocui.Gui, v *gocui.View) error {
+	ids := c.targetIDs()
+	if len(ids) == 0 {
+		return nil
+	}
+	var target string
+	c.openDialog(&dialog{
+		title:   fmt.Sprintf("Move %d Torrent(s) to Another Server", len(ids)),
+		help:    []string{"RPC address of the target server in host:port format"},
+		confirm: fmt.Sprintf("%d torrent(s) will be removed from this server after moving.", len(ids)),
+		action: func(input string) (string, error) {
+			if input == "" {
+				return "", errors.New("target is required")
+			}
+			target = input
+			for _, id := range ids {
+				err := c.client.MoveTorrent(id, target)
+				if err != nil {
+					return "", err
+				}
+			}
+			return fmt.Sprintf("%d torrent(s) moved to %s", len(ids), target), nil
+		},
+	})
+	return nil
+}
+
+func (c *Console) openMagnetDialog(g *gocui.Gui, v *gocui.View) error {
+	c.m.Lock()
+	id := c.selectedID
+	c.m.Unlock()
+	if id == "" {
+		return nil
+	}
+	magnet, err := c.client.GetMagnet(id)
+	if err != nil {
+		c.setMessage("error: " + err.Error())
+		return nil
+	}
+	copyToClipboard(magnet)
+	c.openDialog(&dialog{
+		title: "Magnet Link",
+		help:  []string{"Magnet link is copied to clipboard if the terminal supports it. You can also select it below."},
+		input: magnet,
+		action: func(input string) (string, error) {
+			return "", nil
+		},
+	})
+	return nil
+}
+
+// copyToClipboard sets the clipboard of the terminal with an OSC 52 escape sequence.
+func copyToClipboard(s string) {
+	_, _ = fmt.Fprintf(os.Stdout, "\x1b]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
+// configHelp lists the config fields that Session.SetConfig can change at runtime.
+var configHelp = []string{
+	"Editable while the session is running: speedlimitdownload, speedlimitupload,",
+	"maxpeerdial, maxpeeraccept, parallelmetadatadownloads, webseedmaxdownloads.",
+	"Other fields are read-only, changing them is rejected.",
+}
+
+func (c *Console) openConfigDialog(g *gocui.Gui, v *gocui.View) error {
+	cfg, err := c.client.GetSessionConfig()
+	if err != nil {
+		c.setMessage("error: " + err.Error())
+		return nil
+	}
+	c.openDialog(&dialog{
+		title:     "Session Config",
+		help:      configHelp,
+		input:     cfg,
+		multiline: true,
+		confirm:   "Changes will be applied to the running session.",
+		action: func(input string) (string, error) {
+			err := c.client.SetSessionConfig(input)
+			if err != nil {
+				return "", err
+			}
+			return "Config changed", nil
+		},
+	})
+	return nil
+}
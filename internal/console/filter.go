@@ -0,0 +1,194 @@
+package console
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/ganqierwu/rain/internal/rpctypes"
+)
+
+// Columns that torrents can be sorted by. Sorting by a column other than "Added" and "Name" requires stats.
+var sortColumns = []string{"Added", "Name", "Status", "Progress", "Speed", "Size", "Ratio", "ETA"}
+
+// filter is a parsed search query that is typed in the filter bar.
+// A query contains words that are separated by spaces. A torrent must match all of the words to be shown.
+// A word can be in "key:value" format for matching a specific field. Keys are:
+//
+//	name: substring of torrent name
+//	status: prefix of torrent status, e.g. "status:seed"
+//	tracker: substring of a tracker host
+//	id: prefix of torrent ID or info hash
+//
+// Words without a key are matched with the name of the torrent.
+// Matching is case-insensitive.
+type filter struct {
+	names    []string
+	statuses []string
+	trackers []string
+	ids      []string
+}
+
+func parseFilter(s string) filter {
+	var f filter
+	for _, word := range strings.Fields(strings.ToLower(s)) {
+		key, value, ok := strings.Cut(word, ":")
+		if !ok {
+			f.names = append(f.names, word)
+			continue
+		}
+		switch key {
+		case "name":
+			f.names = append(f.names, value)
+		case "status":
+			f.statuses = append(f.statuses, value)
+		case "tracker":
+			f.trackers = append(f.trackers, value)
+		case "id", "hash":
+			f.ids = append(f.ids, value)
+		default:
+			f.names = append(f.names, word)
+		}
+	}
+	return f
+}
+
+func (f filter) needsStats() bool {
+	return len(f.statuses) > 0
+}
+
+func (f filter) needsTrackers() bool {
+	return len(f.trackers) > 0
+}
+
+func (f filter) match(t *Torrent) bool {
+	name := strings.ToLower(t.Name)
+	for _, s := range f.names {
+		if !strings.Contains(name, s) {
+			return false
+		}
+	}
+	for _, s := range f.statuses {
+		if t.Stats == nil || !strings.HasPrefix(strings.ToLower(t.Stats.Status), s) {
+			return false
+		}
+	}
+	for _, s := range f.ids {
+		if !strings.HasPrefix(strings.ToLower(t.ID), s) && !strings.HasPrefix(strings.ToLower(t.InfoHash), s) {
+			return false
+		}
+	}
+	for _, s := range f.trackers {
+		if !matchTracker(t.Trackers, s) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchTracker(trackers []rpctypes.Tracker, s string) bool {
+	for _, tr := range trackers {
+		u, err := url.Parse(tr.URL)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(strings.ToLower(u.Hostname()), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func filterTorrents(torrents []Torrent, f filter) []Torrent {
+	ret := torrents[:0]
+	for i := range torrents {
+		if f.match(&torrents[i]) {
+			ret = append(ret, torrents[i])
+		}
+	}
+	return ret
+}
+
+func sortNeedsStats(column string) bool {
+	return column != "Added" && column != "Name"
+}
+
+// sortTorrents sorts torrents by column. Torrents without stats are placed at the end.
+// Torrents with equal values are sorted by added time.
+func sortTorrents(torrents []Torrent, column string, desc bool) {
+	less := func(a, b *Torrent) bool {
+		if a.AddedAt.Equal(b.AddedAt.Time) {
+			return a.ID < b.ID
+		}
+		return a.AddedAt.Time.Before(b.AddedAt.Time)
+	}
+	compare := func(a, b *Torrent) int {
+		switch column {
+		case "Name":
+			return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		case "Added":
+			return 0
+		}
+		if a.Stats == nil || b.Stats == nil {
+			return 0
+		}
+		switch column {
+		case "Status":
+			return strings.Compare(a.Stats.Status, b.Stats.Status)
+		case "Progress":
+			return compareInt(int64(getProgress(a.Stats)), int64(getProgress(b.Stats)))
+		case "Speed":
+			return compareInt(a.Stats.Speed.Download+a.Stats.Speed.Upload, b.Stats.Speed.Download+b.Stats.Speed.Upload)
+		case "Size":
+			return compareInt(a.Stats.Bytes.Total, b.Stats.Bytes.Total)
+		case "Ratio":
+			ra, rb := getRatio(a.Stats), getRatio(b.Stats)
+			switch {
+			case ra < rb:
+				return -1
+			case ra > rb:
+				return 1
+			}
+		case "ETA":
+			// Unknown ETA (-1) is placed after all known values.
+			ea, eb := int64(a.Stats.ETA), int64(b.Stats.ETA)
+			switch {
+			case ea == eb:
+				return 0
+			case ea == -1:
+				return 1
+			case eb == -1:
+				return -1
+			}
+			return compareInt(ea, eb)
+		}
+		return 0
+	}
+	sort.SliceStable(torrents, func(i, j int) bool {
+		a, b := &torrents[i], &torrents[j]
+		if sortNeedsStats(column) && (a.Stats == nil) != (b.Stats == nil) {
+			return b.Stats == nil
+		}
+		cmp := compare(a, b)
+		if desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp < 0
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+func compareInt[T ~int | ~int64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
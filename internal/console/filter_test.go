@@ -0,0 +1,61 @@
+package console
+
+import (
+	"testing"
+
+	"github.com/ganqierwu/rain/internal/rpctypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+	torrents := []Torrent{
+		{
+			Torrent:  rpctypes.Torrent{ID: "a1", Name: "Ubuntu ISO", InfoHash: "ff00"},
+			Stats:    &rpctypes.Stats{Status: "Seeding"},
+			Trackers: []rpctypes.Tracker{{URL: "udp://tracker.example.com:1337/announce"}},
+		},
+		{
+			Torrent: rpctypes.Torrent{ID: "b2", Name: "Debian ISO", InfoHash: "00ff"},
+			Stats:   &rpctypes.Stats{Status: "Downloading"},
+		},
+	}
+	ids := func(f string) []string {
+		l := append([]Torrent(nil), torrents...)
+		var ret []string
+		for _, t := range filterTorrents(l, parseFilter(f)) {
+			ret = append(ret, t.ID)
+		}
+		return ret
+	}
+	assert.Equal(t, []string{"a1", "b2"}, ids(""))
+	assert.Equal(t, []string{"a1", "b2"}, ids("iso"))
+	assert.Equal(t, []string{"b2"}, ids("deb iso"))
+	assert.Equal(t, []string{"a1"}, ids("status:seed"))
+	assert.Equal(t, []string{"a1"}, ids("tracker:example"))
+	assert.Equal(t, []string{"b2"}, ids("id:00"))
+	assert.Nil(t, ids("name:fedora"))
+}
+
+func TestSortTorrents(t *testing.T) {
+	torrents := []Torrent{
+		{Torrent: rpctypes.Torrent{ID: "a", Name: "b"}, Stats: &rpctypes.Stats{ETA: -1}},
+		{Torrent: rpctypes.Torrent{ID: "b", Name: "c"}},
+		{Torrent: rpctypes.Torrent{ID: "c", Name: "a"}, Stats: &rpctypes.Stats{ETA: 10}},
+	}
+	ids := func() []string {
+		var ret []string
+		for _, t := range torrents {
+			ret = append(ret, t.ID)
+		}
+		return ret
+	}
+	sortTorrents(torrents, "Name", false)
+	assert.Equal(t, []string{"c", "a", "b"}, ids())
+	sortTorrents(torrents, "Name", true)
+	assert.Equal(t, []string{"b", "a", "c"}, ids())
+	// Torrents without stats are placed at the end.
+	sortTorrents(torrents, "ETA", false)
+	assert.Equal(t, []string{"c", "a", "b"}, ids())
+	sortTorrents(torrents, "ETA", true)
+	assert.Equal(t, []string{"a", "c", "b"}, ids())
+}
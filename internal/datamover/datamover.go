@@ -0,0 +1,141 @@
+// Package datamover provides support for moving files of a torrent to another directory.
+package datamover
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var errClosed = errors.New("data mover is closed")
+
+// DataMover moves a file or a directory to another location.
+// The file is renamed if possible, otherwise it is copied to the new location and deleted from the old one.
+type DataMover struct {
+	// Error is nil if the data is moved successfully.
+	// In case of an error, partially copied files are removed and the data stays at the old location.
+	Error error
+
+	closeC chan struct{}
+	doneC  chan struct{}
+}
+
+// Progress about the move.
+type Progress struct {
+	MovedSize int64
+}
+
+// New returns a new DataMover.
+func New() *DataMover {
+	return &DataMover{
+		closeC: make(chan struct{}),
+		doneC:  make(chan struct{}),
+	}
+}
+
+// Close the DataMover.
+func (m *DataMover) Close() {
+	close(m.closeC)
+	<-m.doneC
+}
+
+// Run the DataMover. src is moved to dst. It is not an error if src does not exist.
+func (m *DataMover) Run(src, dst string, progressC chan Progress, resultC chan *DataMover) {
+	defer close(m.doneC)
+
+	defer func() {
+		select {
+		case resultC <- m:
+		case <-m.closeC:
+		}
+	}()
+
+	m.Error = m.move(src, dst, progressC)
+}
+
+func (m *DataMover) move(src, dst string, progressC chan Progress) error {
+	if _, err := os.Lstat(src); os.IsNotExist(err) {
+		return os.MkdirAll(filepath.Dir(dst), os.ModeDir|0o750)
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return errors.New("target already exists: " + dst)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0o750)
+	if err != nil {
+		return err
+	}
+	// Rename fails if the target is on a different filesystem.
+	if os.Rename(src, dst) == nil {
+		return nil
+	}
+	err = m.copyTree(src, dst, progressC)
+	if err != nil {
+		_ = os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+func (m *DataMover) copyTree(src, dst string, progressC chan Progress) error {
+	buf := make([]byte, 1<<20)
+	var moved int64
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, fi.Mode().Perm()|0o700)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		return m.copyFile(path, target, fi.Mode().Perm(), buf, &moved, progressC)
+	})
+}
+
+func (m *DataMover) copyFile(src, dst string, perm os.FileMode, buf []byte, moved *int64, progressC chan Progress) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for {
+		n, rerr := in.Read(buf)
+		if n > 0 {
+			if _, err = out.Write(buf[:n]); err != nil {
+				return err
+			}
+			*moved += int64(n)
+			select {
+			case progressC <- Progress{MovedSize: *moved}:
+			case <-m.closeC:
+				return errClosed
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	// Make sure data is on the new disk before the original is deleted.
+	err = out.Sync()
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
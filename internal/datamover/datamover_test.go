@@ -0,0 +1,56 @@
+package datamover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyTree(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "a"), []byte("hello"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	m := New()
+	progressC := make(chan Progress, 1)
+	go func() {
+		for range progressC {
+		}
+	}()
+	err := m.copyTree(src, dst, progressC)
+	close(progressC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dst, "sub", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("invalid content: %q", b)
+	}
+}
+
+func TestRunTargetExists(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	for _, p := range []string{src, dst} {
+		if err := os.WriteFile(p, []byte("x"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := New()
+	resultC := make(chan *DataMover, 1)
+	m.Run(src, dst, make(chan Progress), resultC)
+	if (<-resultC).Error == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,292 @@
+// Package datareuse copies the files of a torrent from other torrents that have the same content before the torrent is downloaded.
+// Torrents that are listed in "similar" key or share a collection with the torrent are searched first (BEP 38).
+//
+// A file matches if it has the same length and the same offset in a piece in both torrents with the same piece length,
+// and the hashes of the pieces that are fully inside the file are equal.
+// Reused files are not trusted; they are verified by the torrent after allocation.
+// Files are hard-linked only if all of their bytes are covered by equal hashes,
+// because pieces at file boundaries that fail verification are downloaded again into the shared file.
+package datareuse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ganqierwu/rain/internal/bitfield"
+	"github.com/ganqierwu/rain/internal/metainfo"
+)
+
+var errClosed = errors.New("data reuse is closed")
+
+// Source is another torrent that may contain the files of the torrent.
+type Source struct {
+	Info     *metainfo.Info
+	Bitfield *bitfield.Bitfield
+	// Dir is the directory on disk that the files of the torrent are saved under.
+	Dir string
+}
+
+// Match is a file of the torrent that is found in a Source.
+type Match struct {
+	// Index of the file in the torrent.
+	Index int
+	// Path of the file with the same content on disk.
+	Path string
+	// True if all bytes of the file are in pieces whose hashes are equal in both torrents.
+	Complete bool
+}
+
+// DataReuse copies or links the matching files into the data dir of the torrent.
+type DataReuse struct {
+	Matches []Match
+	// Number of bytes that are reused.
+	Reused int64
+	// Errors of the files that cannot be reused. Pieces of these files are downloaded as usual.
+	Errors []error
+
+	closeC chan struct{}
+	doneC  chan struct{}
+}
+
+// New returns a new DataReuse.
+func New() *DataReuse {
+	return &DataReuse{
+		closeC: make(chan struct{}),
+		doneC:  make(chan struct{}),
+	}
+}
+
+// Close the DataReuse.
+func (d *DataReuse) Close() {
+	close(d.closeC)
+	<-d.doneC
+}
+
+// Run the DataReuse. Sources are returned from getSources, which may block.
+// Files that already exist in dir are not overwritten.
+// If hardLink is true, complete matches are linked instead of copied if the source is on the same filesystem.
+func (d *DataReuse) Run(info *metainfo.Info, dir string, getSources func() []Source, hardLink bool, resultC chan *DataReuse) {
+	defer close(d.doneC)
+	defer func() {
+		select {
+		case resultC <- d:
+		case <-d.closeC:
+		}
+	}()
+
+	sourcesC := make(chan []Source, 1)
+	go func() { sourcesC <- getSources() }()
+	var sources []Source
+	select {
+	case sources = <-sourcesC:
+	case <-d.closeC:
+		return
+	}
+
+	for _, m := range FindMatches(info, sources) {
+		f := info.Files[m.Index]
+		dst := filepath.Join(dir, f.Path)
+		if _, err := os.Lstat(dst); !os.IsNotExist(err) {
+			continue
+		}
+		err := d.reuse(m.Path, dst, hardLink && m.Complete)
+		if err == errClosed {
+			return
+		}
+		if err != nil {
+			d.Errors = append(d.Errors, err)
+			continue
+		}
+		d.Matches = append(d.Matches, m)
+		d.Reused += f.Length
+	}
+}
+
+func (d *DataReuse) reuse(src, dst string, hardLink bool) error {
+	err := os.MkdirAll(filepath.Dir(dst), os.ModeDir|0o750)
+	if err != nil {
+		return err
+	}
+	if hardLink && os.Link(src, dst) == nil {
+		return nil
+	}
+	err = d.copyFile(src, dst)
+	if err != nil {
+		_ = os.Remove(dst)
+	}
+	return err
+}
+
+func (d *DataReuse) copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	buf := make([]byte, 1<<20)
+	for {
+		select {
+		case <-d.closeC:
+			return errClosed
+		default:
+		}
+		n, rerr := in.Read(buf)
+		if n > 0 {
+			_, err = out.Write(buf[:n])
+			if err != nil {
+				return err
+			}
+		}
+		if rerr == io.EOF {
+			return out.Close()
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// FindMatches returns the files of info that have the same content in sources.
+// Sources that are similar to info are preferred.
+func FindMatches(info *metainfo.Info, sources []Source) []Match {
+	sources = sortSources(info, sources)
+	offsets := fileOffsets(info)
+	var matches []Match
+	for i, f := range info.Files {
+		if f.Padding || f.SymlinkPath != "" {
+			continue
+		}
+		for _, src := range sources {
+			if path, complete, ok := findFile(info, offsets[i], f, src); ok {
+				matches = append(matches, Match{Index: i, Path: path, Complete: complete})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// sortSources returns the sources that are similar to info first.
+func sortSources(info *metainfo.Info, sources []Source) []Source {
+	var similar, others []Source
+	for _, src := range sources {
+		if src.Info == nil || src.Bitfield == nil || src.Dir == "" || src.Info.Hash == info.Hash {
+			continue
+		}
+		if isSimilar(info, src.Info) {
+			similar = append(similar, src)
+		} else {
+			others = append(others, src)
+		}
+	}
+	return append(similar, others...)
+}
+
+func isSimilar(a, b *metainfo.Info) bool {
+	for _, h := range a.Similar {
+		if h == b.Hash {
+			return true
+		}
+	}
+	for _, h := range b.Similar {
+		if h == a.Hash {
+			return true
+		}
+	}
+	for _, c1 := range a.Collections {
+		for _, c2 := range b.Collections {
+			if c1 == c2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func fileOffsets(info *metainfo.Info) []int64 {
+	offsets := make([]int64, len(info.Files))
+	var offset int64
+	for i, f := range info.Files {
+		offsets[i] = offset
+		offset += f.Length
+	}
+	return offsets
+}
+
+// findFile returns the path of the file in src that has the same content with the file f at offset in info.
+// complete is true if all bytes of the file are compared.
+func findFile(info *metainfo.Info, offset int64, f metainfo.File, src Source) (path string, complete, ok bool) {
+	if src.Info.PieceLength != info.PieceLength {
+		return "", false, false
+	}
+	pieceLength := int64(info.PieceLength)
+	srcOffsets := fileOffsets(src.Info)
+	for i, sf := range src.Info.Files {
+		if sf.Length != f.Length || sf.Padding || sf.SymlinkPath != "" {
+			continue
+		}
+		if srcOffsets[i]%pieceLength != offset%pieceLength {
+			continue
+		}
+		if !hasPieces(src, srcOffsets[i], sf.Length) {
+			continue
+		}
+		if same, complete := samePieces(info, offset, src.Info, srcOffsets[i], f.Length); same {
+			return filepath.Join(src.Dir, sf.Path), complete, true
+		}
+	}
+	return "", false, false
+}
+
+// hasPieces returns true if all pieces that overlap with the range are downloaded in src.
+func hasPieces(src Source, offset, length int64) bool {
+	if length == 0 {
+		return false
+	}
+	pieceLength := int64(src.Info.PieceLength)
+	for i := offset / pieceLength; i <= (offset+length-1)/pieceLength; i++ {
+		if !src.Bitfield.Test(uint32(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// samePieces compares the hashes of the pieces that are fully inside the range in both torrents.
+// same is false if there is no such piece. complete is true if the compared pieces cover the whole range.
+func samePieces(a *metainfo.Info, aOffset int64, b *metainfo.Info, bOffset int64, length int64) (same, complete bool) {
+	pieceLength := int64(a.PieceLength)
+	var compared int
+	var covered int64
+	// Start from the first piece boundary in the range.
+	for pos := (pieceLength - aOffset%pieceLength) % pieceLength; pos < length; pos += pieceLength {
+		aBegin, bBegin := aOffset+pos, bOffset+pos
+		aEnd := min64(aBegin+pieceLength, a.Length)
+		bEnd := min64(bBegin+pieceLength, b.Length)
+		// Piece must be fully inside the range in both torrents.
+		if aEnd-aBegin != bEnd-bBegin || pos+(aEnd-aBegin) > length {
+			continue
+		}
+		if !bytes.Equal(a.PieceHash(uint32(aBegin/pieceLength)), b.PieceHash(uint32(bBegin/pieceLength))) {
+			return false, false
+		}
+		compared++
+		covered += aEnd - aBegin
+	}
+	return compared > 0, covered == length
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
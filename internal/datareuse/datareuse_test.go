@@ -0,0 +1,132 @@
+package datareuse
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ganqierwu/rain/internal/bitfield"
+	"github.com/ganqierwu/rain/internal/logger"
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestInfo(t *testing.T, files map[string][]byte, padFiles bool) (*metainfo.Info, string) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	for name, data := range files {
+		err := os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(root, name), data, 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := metainfo.NewInfoBytes("", []string{root}, false, 32<<10, "", padFiles, nil, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := metainfo.NewInfo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info, dir
+}
+
+func completeBitfield(info *metainfo.Info) *bitfield.Bitfield {
+	bf := bitfield.New(info.NumPieces)
+	for i := uint32(0); i < info.NumPieces; i++ {
+		bf.Set(i)
+	}
+	return bf
+}
+
+func TestFindMatches(t *testing.T) {
+	big := bytes.Repeat([]byte{1}, 100<<10)
+	changed := bytes.Repeat([]byte{2}, 100<<10)
+	srcInfo, srcDir := newTestInfo(t, map[string][]byte{"a": big, "b": changed}, true)
+	src := Source{Info: srcInfo, Bitfield: completeBitfield(srcInfo), Dir: srcDir}
+
+	changed[50<<10] = 3
+	info, _ := newTestInfo(t, map[string][]byte{"a": big, "b": changed, "c": []byte("small")}, true)
+
+	matches := FindMatches(info, []Source{src})
+	// "b" is changed and "c" does not exist in source.
+	// Last piece of "a" is not compared because it contains padding.
+	assert.Equal(t, []Match{{Index: 0, Path: filepath.Join(srcDir, "root", "a")}}, matches)
+
+	// Files that are not downloaded in the source are not reused.
+	src.Bitfield.Clear(0)
+	assert.Empty(t, FindMatches(info, []Source{src}))
+}
+
+func TestRun(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, 100<<10)
+	srcInfo, srcDir := newTestInfo(t, map[string][]byte{"a": data}, false)
+	src := Source{Info: srcInfo, Bitfield: completeBitfield(srcInfo), Dir: srcDir}
+	info, _ := newTestInfo(t, map[string][]byte{"a": data, "b": []byte("other")}, false)
+
+	dir := t.TempDir()
+	resultC := make(chan *DataReuse, 1)
+	d := New()
+	d.Run(info, dir, func() []Source { return []Source{src} }, false, resultC)
+	<-resultC
+	assert.Empty(t, d.Errors)
+	assert.Equal(t, int64(len(data)), d.Reused)
+	b, err := os.ReadFile(filepath.Join(dir, "root", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, b)
+	_, err = os.Stat(filepath.Join(dir, "root", "b"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRunHardLink(t *testing.T) {
+	data := bytes.Repeat([]byte{1}, 100<<10)
+	srcInfo, srcDir := newTestInfo(t, map[string][]byte{"a": data}, false)
+	src := Source{Info: srcInfo, Bitfield: completeBitfield(srcInfo), Dir: srcDir}
+	srcStat, err := os.Stat(filepath.Join(srcDir, "root", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All pieces of the file are compared. Torrent is private to have a different info hash.
+	b, err := metainfo.NewInfoBytes("", []string{filepath.Join(srcDir, "root")}, true, 32<<10, "", false, nil, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := metainfo.NewInfo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	resultC := make(chan *DataReuse, 1)
+	d := New()
+	d.Run(info, dir, func() []Source { return []Source{src} }, true, resultC)
+	<-resultC
+	assert.Empty(t, d.Errors)
+	assert.True(t, d.Matches[0].Complete)
+	st, err := os.Stat(filepath.Join(dir, "root", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, os.SameFile(srcStat, st))
+
+	// Last piece of the file is shared with another file, so it is copied.
+	info, _ = newTestInfo(t, map[string][]byte{"a": data, "b": []byte("other")}, false)
+	dir = t.TempDir()
+	d = New()
+	d.Run(info, dir, func() []Source { return []Source{src} }, true, resultC)
+	<-resultC
+	assert.Empty(t, d.Errors)
+	assert.False(t, d.Matches[0].Complete)
+	st, err = os.Stat(filepath.Join(dir, "root", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, os.SameFile(srcStat, st))
+}
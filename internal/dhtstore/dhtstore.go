@@ -0,0 +1,384 @@
+// Package dhtstore provides storage of arbitrary data in DHT (BEP 44).
+//
+// The client does iterative lookups with "get" queries, starting from the closest nodes in its routing table,
+// and sends "put" queries to the closest nodes that have returned a write token.
+// Nodes that respond to queries are added to the routing table. Bootstrap nodes are used while the table is small.
+// Nodes known by another DHT node of the application can be added with AddNode.
+// It does not store items for other nodes.
+//
+// The client does not have its own socket. Queries are sent from the socket of a DHT node of the application,
+// and the packets received by that node must be passed to HandlePacket.
+package dhtstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+const (
+	// Number of parallel queries in a lookup.
+	alpha = 3
+	// Number of closest nodes that are queried in a lookup and that the items are stored on.
+	k = 8
+)
+
+var (
+	// ErrNotFound is returned from Get methods if no node returns a valid item.
+	ErrNotFound = errors.New("item not found in dht")
+
+	errClosed       = errors.New("dht store is closed")
+	errQueryTimeout = errors.New("query timeout")
+)
+
+// Prefix of the transaction IDs of the queries that are sent by the client.
+// It separates the responses to the client from the responses to the DHT node that share the socket.
+const tidPrefix = "s"
+
+// Conn sends packets from the socket of a DHT node.
+type Conn interface {
+	WriteTo(b []byte, addr net.Addr) (int, error)
+}
+
+// Client gets and puts items in DHT.
+type Client struct {
+	conn      Conn
+	id        [20]byte
+	bootstrap []string
+	timeout   time.Duration
+	table     *table
+
+	m            sync.Mutex
+	transactions map[string]*transaction
+	nextTID      uint16
+
+	closeC chan struct{}
+}
+
+// transaction is a query that is waiting for its response.
+type transaction struct {
+	addr  string
+	respC chan *message
+}
+
+// New returns a new Client that sends queries from conn with the node ID of the DHT node that owns conn.
+// bootstrap nodes must be given in host:port form. timeout is applied to each query.
+func New(conn Conn, id [20]byte, bootstrap []string, timeout time.Duration) *Client {
+	return &Client{
+		conn:         conn,
+		id:           id,
+		bootstrap:    bootstrap,
+		timeout:      timeout,
+		table:        newTable(),
+		transactions: make(map[string]*transaction),
+		closeC:       make(chan struct{}),
+	}
+}
+
+// AddNode adds a node that is known to be alive to the routing table.
+func (c *Client) AddNode(addr *net.UDPAddr, id [20]byte) {
+	if addr.IP.To4() == nil || addr.Port == 0 {
+		return
+	}
+	c.table.add(id, addr)
+}
+
+// Close the Client. Running operations return an error.
+func (c *Client) Close() {
+	close(c.closeC)
+}
+
+// GetImmutable returns the immutable item with the target.
+func (c *Client) GetImmutable(ctx context.Context, target [20]byte) (*Item, error) {
+	var found *Item
+	_, err := c.lookup(ctx, target, func(n *node, r *response) bool {
+		i, ok := r.item(nil)
+		if !ok || i.Mutable || i.Verify(target) != nil {
+			return false
+		}
+		found = i
+		return true
+	})
+	if found != nil {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrNotFound
+}
+
+// GetMutable returns the mutable item with the highest sequence number that is signed by the public key.
+func (c *Client) GetMutable(ctx context.Context, publicKey [32]byte, salt []byte) (*Item, error) {
+	target := MutableTarget(publicKey, salt)
+	var found *Item
+	_, err := c.lookup(ctx, target, func(n *node, r *response) bool {
+		i, ok := r.item(salt)
+		if !ok || !i.Mutable || i.Verify(target) != nil {
+			return false
+		}
+		if found == nil || i.Seq > found.Seq {
+			found = i
+		}
+		return false
+	})
+	if found != nil {
+		return found, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, ErrNotFound
+}
+
+// Put stores the item on the closest nodes to its target. Returns the number of nodes that have stored the item.
+func (c *Client) Put(ctx context.Context, item *Item) (int, error) {
+	target := item.Target()
+	nodes, err := c.lookup(ctx, target, func(n *node, r *response) bool { return false })
+	if err != nil {
+		return 0, err
+	}
+	args := &arguments{
+		ID: string(c.id[:]),
+		V:  item.V,
+	}
+	if item.Mutable {
+		seq := item.Seq
+		args.K = string(item.K[:])
+		args.Salt = string(item.Salt)
+		args.Seq = &seq
+		args.Sig = string(item.Sig[:])
+	}
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	var stored int
+	var lastErr error
+	var sent int
+	for _, n := range nodes {
+		if n.token == "" {
+			continue
+		}
+		if sent == k {
+			break
+		}
+		sent++
+		a := *args
+		a.Token = n.token
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			_, err := c.query(ctx, n.addr, "put", &a)
+			m.Lock()
+			if err != nil {
+				lastErr = err
+			} else {
+				stored++
+			}
+			m.Unlock()
+		}(n)
+	}
+	wg.Wait()
+	if stored == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no node to store the item")
+		}
+		return 0, lastErr
+	}
+	return stored, nil
+}
+
+// lookup queries the nodes closer to target until the closest k nodes have responded.
+// onResponse is called for each response to a get query. Lookup stops when it returns true.
+// Returns the nodes that have responded, sorted by distance to target.
+func (c *Client) lookup(ctx context.Context, target [20]byte, onResponse func(*node, *response) bool) ([]*node, error) {
+	candidates := c.table.closest(target, k)
+	seen := make(map[string]struct{})
+	for _, nd := range candidates {
+		seen[nd.addr.String()] = struct{}{}
+	}
+	if len(candidates) < k {
+		for _, hostport := range c.bootstrap {
+			addr, err := net.ResolveUDPAddr("udp4", hostport)
+			if err != nil {
+				continue
+			}
+			if _, ok := seen[addr.String()]; ok {
+				continue
+			}
+			seen[addr.String()] = struct{}{}
+			candidates = append(candidates, &node{addr: addr})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no bootstrap node")
+	}
+	var responded []*node
+	type result struct {
+		node *node
+		resp *response
+		err  error
+	}
+	args := &arguments{ID: string(c.id[:]), Target: string(target[:])}
+	for len(candidates) > 0 {
+		sortNodes(candidates, target)
+		sortNodes(responded, target)
+		// Stop if we have k nodes that are closer than all remaining candidates.
+		if len(responded) >= k && candidates[0].idKnown && !closer(candidates[0].id, responded[k-1].id, target) {
+			break
+		}
+		n := alpha
+		if n > len(candidates) {
+			n = len(candidates)
+		}
+		batch := candidates[:n]
+		candidates = candidates[n:]
+		resultC := make(chan result, n)
+		for _, nd := range batch {
+			go func(nd *node) {
+				resp, err := c.query(ctx, nd.addr, "get", args)
+				resultC <- result{node: nd, resp: resp, err: err}
+			}(nd)
+		}
+		var stop bool
+		for range batch {
+			res := <-resultC
+			if res.err == errQueryTimeout {
+				c.table.failed(res.node.addr)
+			}
+			if res.err != nil || stop {
+				continue
+			}
+			if len(res.resp.ID) != 20 {
+				continue
+			}
+			copy(res.node.id[:], res.resp.ID)
+			res.node.idKnown = true
+			res.node.token = res.resp.Token
+			c.table.add(res.node.id, res.node.addr)
+			responded = append(responded, res.node)
+			if onResponse(res.node, res.resp) {
+				stop = true
+				continue
+			}
+			nodes, err := parseNodes(res.resp.Nodes)
+			if err != nil {
+				continue
+			}
+			for _, nd := range nodes {
+				if _, ok := seen[nd.addr.String()]; ok {
+					continue
+				}
+				seen[nd.addr.String()] = struct{}{}
+				candidates = append(candidates, nd)
+			}
+		}
+		if stop {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return responded, ctx.Err()
+		case <-c.closeC:
+			return responded, errClosed
+		default:
+		}
+	}
+	sortNodes(responded, target)
+	return responded, nil
+}
+
+// sortNodes sorts nodes by distance to target. Nodes with unknown IDs are put first.
+func sortNodes(nodes []*node, target [20]byte) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if !nodes[i].idKnown || !nodes[j].idKnown {
+			return !nodes[i].idKnown && nodes[j].idKnown
+		}
+		return closer(nodes[i].id, nodes[j].id, target)
+	})
+}
+
+func (c *Client) query(ctx context.Context, addr net.Addr, q string, args *arguments) (*response, error) {
+	respC := make(chan *message, 1)
+	c.m.Lock()
+	c.nextTID++
+	var b2 [2]byte
+	binary.BigEndian.PutUint16(b2[:], c.nextTID)
+	tid := tidPrefix + string(b2[:])
+	c.transactions[tid] = &transaction{addr: addr.String(), respC: respC}
+	c.m.Unlock()
+	defer func() {
+		c.m.Lock()
+		delete(c.transactions, tid)
+		c.m.Unlock()
+	}()
+
+	b, err := bencode.EncodeBytes(&message{T: tid, Y: "q", Q: q, A: args})
+	if err != nil {
+		return nil, err
+	}
+	_, err = c.conn.WriteTo(b, addr)
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case msg := <-respC:
+		if msg.Y == "e" {
+			return nil, parseError(msg.E)
+		}
+		if msg.R == nil {
+			return nil, errors.New("invalid response")
+		}
+		return msg.R, nil
+	case <-timer.C:
+		return nil, errQueryTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.closeC:
+		return nil, errClosed
+	}
+}
+
+// HandlePacket handles a packet that is received by the DHT node.
+// Returns true if the packet is a response to a query of the client. Other packets must be processed by the DHT node.
+// Nodes that send valid messages are added to the routing table. b is not kept after the call.
+func (c *Client) HandlePacket(b []byte, addr *net.UDPAddr) bool {
+	var msg message
+	// Copy the packet because values in the message may refer to the buffer.
+	if bencode.DecodeBytes(append([]byte(nil), b...), &msg) != nil {
+		return false
+	}
+	var id string
+	switch {
+	case msg.Y == "q" && msg.A != nil:
+		id = msg.A.ID
+	case msg.Y == "r" && msg.R != nil:
+		id = msg.R.ID
+	}
+	if len(id) == 20 {
+		var nid [20]byte
+		copy(nid[:], id)
+		c.AddNode(addr, nid)
+	}
+	if msg.Y != "r" && msg.Y != "e" {
+		return false
+	}
+	c.m.Lock()
+	t, ok := c.transactions[msg.T]
+	c.m.Unlock()
+	if !ok || t.addr != addr.String() {
+		return false
+	}
+	select {
+	case t.respC <- &msg:
+	default:
+	}
+	return true
+}
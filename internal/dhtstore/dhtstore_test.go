@@ -0,0 +1,218 @@
+package dhtstore
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zeebo/bencode"
+)
+
+// Test vectors from BEP 44.
+func TestItemSignature(t *testing.T) {
+	pub, _ := hex.DecodeString("77ff84905a91936367c01360803104f92432fcd904a43511876df5cdf3e7e548")
+	v := []byte("12:Hello World!")
+
+	i := &Item{V: v, Mutable: true, Seq: 1}
+	copy(i.K[:], pub)
+	sig, _ := hex.DecodeString("305ac8aeb6c9c151fa120f120ea2cfb923564e11552d06a5d856091e5e853cff1260d3f39e4999684aa92eb73ffd136e6f4f3ecbfda0ce53a1608ecd7ae21f01")
+	copy(i.Sig[:], sig)
+	assert.Equal(t, "3:seqi1e1:v12:Hello World!", string(SignedBytes(nil, 1, v)))
+	assert.Equal(t, "4a533d47ec9c7d95b1ad75f576cffc641853b750", hex.EncodeToString(target(i)))
+	assert.NoError(t, i.Verify(i.Target()))
+
+	i.Salt = []byte("foobar")
+	sig, _ = hex.DecodeString("6834284b6b24c3204eb2fea824d82f88883a3d95e8b4a21b8c0ded553d17d17ddf9a8a7104b1258f30bed3787e6cb896fca78c58f8e03b5f18f14951a87d9a08")
+	copy(i.Sig[:], sig)
+	assert.Equal(t, "411eba73b6f087ca51a3795d9c8c938d365e32c1", hex.EncodeToString(target(i)))
+	assert.NoError(t, i.Verify(i.Target()))
+
+	i.Seq = 2
+	assert.Error(t, i.Verify(i.Target()))
+
+	im, err := NewImmutable(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "e5f96f6f38320f0f33959cb4d3d656452117aadb", hex.EncodeToString(target(im)))
+}
+
+func target(i *Item) []byte {
+	t := i.Target()
+	return t[:]
+}
+
+// testNode is a DHT node that stores items and returns the other test nodes in responses.
+type testNode struct {
+	conn  net.PacketConn
+	id    [20]byte
+	nodes string
+
+	m     sync.Mutex
+	items map[string]*arguments
+}
+
+func newTestNode(t *testing.T, id byte) *testNode {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &testNode{conn: conn, items: make(map[string]*arguments)}
+	n.id[0] = id
+	return n
+}
+
+func (n *testNode) compact() string {
+	addr := n.conn.LocalAddr().(*net.UDPAddr)
+	b := append(n.id[:], addr.IP.To4()...)
+	return string(append(b, byte(addr.Port>>8), byte(addr.Port)))
+}
+
+func (n *testNode) run() {
+	buf := make([]byte, 65536)
+	for {
+		l, addr, err := n.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var msg message
+		if bencode.DecodeBytes(append([]byte(nil), buf[:l]...), &msg) != nil || msg.A == nil {
+			continue
+		}
+		resp := &response{ID: string(n.id[:]), Token: "token", Nodes: n.nodes}
+		n.m.Lock()
+		switch msg.Q {
+		case "get":
+			if a, ok := n.items[msg.A.Target]; ok {
+				resp.V, resp.K, resp.Seq, resp.Sig = a.V, a.K, a.Seq, a.Sig
+			}
+		case "put":
+			i := &Item{V: msg.A.V, Salt: []byte(msg.A.Salt)}
+			if msg.A.K != "" {
+				i.Mutable = true
+				copy(i.K[:], msg.A.K)
+				copy(i.Sig[:], msg.A.Sig)
+				i.Seq = *msg.A.Seq
+			}
+			target := i.Target()
+			n.items[string(target[:])] = msg.A
+		}
+		n.m.Unlock()
+		b, _ := bencode.EncodeBytes(&message{T: msg.T, Y: "r", R: resp})
+		_, _ = n.conn.WriteTo(b, addr)
+	}
+}
+
+// newTestClient returns a Client that uses a socket like the DHT node of the application.
+func newTestClient(t *testing.T, bootstrap []string) *Client {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := New(conn, [20]byte{0xff}, bootstrap, time.Second)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			c.HandlePacket(buf[:n], addr.(*net.UDPAddr))
+		}
+	}()
+	return c
+}
+
+func TestPutGet(t *testing.T) {
+	nodes := []*testNode{newTestNode(t, 1), newTestNode(t, 2), newTestNode(t, 3)}
+	var all string
+	for _, n := range nodes {
+		all += n.compact()
+	}
+	for _, n := range nodes {
+		n.nodes = all
+		go n.run()
+		defer n.conn.Close()
+	}
+	// Only the first node is known at start, others are found in responses.
+	c := newTestClient(t, []string{nodes[0].conn.LocalAddr().String()})
+	defer c.Close()
+	ctx := context.Background()
+
+	im, _ := NewImmutable([]byte("12:Hello World!"))
+	stored, err := c.Put(ctx, im)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stored)
+	found, err := c.GetImmutable(ctx, im.Target())
+	assert.NoError(t, err)
+	assert.Equal(t, im.V, found.V)
+
+	pub, key, _ := ed25519.GenerateKey(nil)
+	var k [32]byte
+	copy(k[:], pub)
+	_, err = c.GetMutable(ctx, k, []byte("salt"))
+	assert.Equal(t, ErrNotFound, err)
+	for seq := int64(1); seq <= 2; seq++ {
+		mu, err := NewMutable(key, []byte("salt"), seq, []byte("i42e"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.Put(ctx, mu)
+		assert.NoError(t, err)
+	}
+	found, err = c.GetMutable(ctx, k, []byte("salt"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), found.Seq)
+	assert.Equal(t, []byte("i42e"), found.V)
+}
+
+func TestAddNode(t *testing.T) {
+	nodes := []*testNode{newTestNode(t, 1), newTestNode(t, 2)}
+	all := nodes[0].compact() + nodes[1].compact()
+	for _, n := range nodes {
+		n.nodes = all
+		go n.run()
+		defer n.conn.Close()
+	}
+	// There is no bootstrap node. Lookup starts from the node in the routing table.
+	c := newTestClient(t, nil)
+	defer c.Close()
+	_, err := c.GetImmutable(context.Background(), [20]byte{})
+	assert.Error(t, err)
+
+	c.AddNode(nodes[0].conn.LocalAddr().(*net.UDPAddr), nodes[0].id)
+	im, _ := NewImmutable([]byte("12:Hello World!"))
+	stored, err := c.Put(context.Background(), im)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stored)
+	// Other node is found in the response and added to the routing table.
+	assert.Equal(t, 2, c.table.len())
+}
+
+func TestHandlePacket(t *testing.T) {
+	c := New(nil, [20]byte{0xff}, nil, time.Second)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6881}
+	id := string(make([]byte, 20))
+
+	// Queries and responses to the DHT node are not handled by the client, but their senders are added to the table.
+	b, _ := bencode.EncodeBytes(&message{T: "aa", Y: "q", Q: "ping", A: &arguments{ID: id}})
+	assert.False(t, c.HandlePacket(b, addr))
+	b, _ = bencode.EncodeBytes(&message{T: "12", Y: "r", R: &response{ID: id}})
+	assert.False(t, c.HandlePacket(b, addr))
+	assert.False(t, c.HandlePacket([]byte("invalid"), addr))
+	assert.Equal(t, 1, c.table.len())
+
+	// Response to a query of the client must come from the queried node.
+	respC := make(chan *message, 1)
+	c.transactions[tidPrefix+"\x00\x01"] = &transaction{addr: addr.String(), respC: respC}
+	b, _ = bencode.EncodeBytes(&message{T: tidPrefix + "\x00\x01", Y: "r", R: &response{ID: id}})
+	assert.False(t, c.HandlePacket(b, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 6881}))
+	assert.True(t, c.HandlePacket(b, addr))
+	assert.Equal(t, "r", (<-respC).Y)
+}
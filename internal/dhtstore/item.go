@@ -0,0 +1,119 @@
+package dhtstore
+
+import (
+	"crypto/ed25519"
+	"crypto/sha1" // nolint: gosec
+	"errors"
+	"strconv"
+
+	"github.com/zeebo/bencode"
+)
+
+// MaxValueSize is the maximum length of the bencoded value of an item.
+const MaxValueSize = 1000
+
+// MaxSaltSize is the maximum length of the salt of a mutable item.
+const MaxSaltSize = 64
+
+var (
+	errValueTooLarge = errors.New("value is too large")
+	errSaltTooLarge  = errors.New("salt is too large")
+)
+
+// Item is a value that is stored in DHT (BEP 44).
+// Immutable items are identified by the hash of their value.
+// Mutable items are identified by the public key and salt, and signed with the private key.
+type Item struct {
+	// Bencoded value.
+	V []byte
+
+	// Fields below are set only for mutable items.
+	Mutable bool
+	K       [32]byte
+	Salt    []byte
+	Seq     int64
+	Sig     [64]byte
+}
+
+// NewImmutable returns a new immutable Item with the bencoded value v.
+func NewImmutable(v []byte) (*Item, error) {
+	if len(v) > MaxValueSize {
+		return nil, errValueTooLarge
+	}
+	return &Item{V: v}, nil
+}
+
+// NewMutable returns a new mutable Item with the bencoded value v that is signed with key.
+func NewMutable(key ed25519.PrivateKey, salt []byte, seq int64, v []byte) (*Item, error) {
+	if len(v) > MaxValueSize {
+		return nil, errValueTooLarge
+	}
+	if len(salt) > MaxSaltSize {
+		return nil, errSaltTooLarge
+	}
+	i := &Item{
+		V:       v,
+		Mutable: true,
+		Salt:    salt,
+		Seq:     seq,
+	}
+	copy(i.K[:], key.Public().(ed25519.PublicKey))
+	copy(i.Sig[:], ed25519.Sign(key, SignedBytes(salt, seq, v)))
+	return i, nil
+}
+
+// Target returns the DHT key of the item.
+func (i *Item) Target() [20]byte {
+	if i.Mutable {
+		return MutableTarget(i.K, i.Salt)
+	}
+	return ImmutableTarget(i.V)
+}
+
+// Verify checks the value of an item that is received from a node.
+// target is the key of the item that is requested.
+func (i *Item) Verify(target [20]byte) error {
+	if len(i.V) > MaxValueSize {
+		return errValueTooLarge
+	}
+	var v interface{}
+	if err := bencode.DecodeBytes(i.V, &v); err != nil {
+		return err
+	}
+	if i.Target() != target {
+		return errors.New("item does not match the target")
+	}
+	if i.Mutable && !ed25519.Verify(i.K[:], SignedBytes(i.Salt, i.Seq, i.V), i.Sig[:]) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// ImmutableTarget returns the DHT key of the immutable item with the bencoded value v.
+func ImmutableTarget(v []byte) [20]byte {
+	return sha1.Sum(v) // nolint: gosec
+}
+
+// MutableTarget returns the DHT key of the mutable item with the public key k and salt.
+func MutableTarget(k [32]byte, salt []byte) [20]byte {
+	b := make([]byte, 0, len(k)+len(salt))
+	b = append(b, k[:]...)
+	b = append(b, salt...)
+	return sha1.Sum(b) // nolint: gosec
+}
+
+// SignedBytes returns the buffer that is signed for a mutable item.
+// It is the bencoded form of salt, seq and v keys without the enclosing dictionary.
+func SignedBytes(salt []byte, seq int64, v []byte) []byte {
+	b := make([]byte, 0, len(salt)+len(v)+32)
+	if len(salt) > 0 {
+		b = append(b, "4:salt"...)
+		b = strconv.AppendInt(b, int64(len(salt)), 10)
+		b = append(b, ':')
+		b = append(b, salt...)
+	}
+	b = append(b, "3:seqi"...)
+	b = strconv.AppendInt(b, seq, 10)
+	b = append(b, "e1:v"...)
+	return append(b, v...)
+}
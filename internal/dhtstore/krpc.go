@@ -0,0 +1,127 @@
+package dhtstore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/zeebo/bencode"
+)
+
+// message is a KRPC message (BEP 5). Binary strings are kept in string fields.
+type message struct {
+	T string        `bencode:"t"`
+	Y string        `bencode:"y"`
+	Q string        `bencode:"q,omitempty"`
+	A *arguments    `bencode:"a,omitempty"`
+	R *response     `bencode:"r,omitempty"`
+	E []interface{} `bencode:"e,omitempty"`
+}
+
+type arguments struct {
+	ID     string             `bencode:"id"`
+	Target string             `bencode:"target,omitempty"`
+	Token  string             `bencode:"token,omitempty"`
+	V      bencode.RawMessage `bencode:"v,omitempty"`
+	K      string             `bencode:"k,omitempty"`
+	Salt   string             `bencode:"salt,omitempty"`
+	Seq    *int64             `bencode:"seq,omitempty"`
+	Sig    string             `bencode:"sig,omitempty"`
+}
+
+type response struct {
+	ID    string             `bencode:"id"`
+	Token string             `bencode:"token,omitempty"`
+	Nodes string             `bencode:"nodes,omitempty"`
+	V     bencode.RawMessage `bencode:"v,omitempty"`
+	K     string             `bencode:"k,omitempty"`
+	Seq   *int64             `bencode:"seq,omitempty"`
+	Sig   string             `bencode:"sig,omitempty"`
+}
+
+// item returns the item in a get response. Salt is not sent in responses, so it is taken from the request.
+func (r *response) item(salt []byte) (*Item, bool) {
+	if len(r.V) == 0 {
+		return nil, false
+	}
+	i := &Item{V: r.V}
+	if r.K == "" {
+		return i, true
+	}
+	if len(r.K) != len(i.K) || len(r.Sig) != len(i.Sig) || r.Seq == nil {
+		return nil, false
+	}
+	i.Mutable = true
+	copy(i.K[:], r.K)
+	copy(i.Sig[:], r.Sig)
+	i.Seq = *r.Seq
+	i.Salt = salt
+	return i, true
+}
+
+// krpcError is the error that is returned from a remote node.
+type krpcError struct {
+	Code    int64
+	Message string
+}
+
+func (e *krpcError) Error() string {
+	return fmt.Sprintf("krpc error %d: %s", e.Code, e.Message)
+}
+
+func parseError(e []interface{}) error {
+	ke := &krpcError{}
+	if len(e) > 0 {
+		ke.Code, _ = e[0].(int64)
+	}
+	if len(e) > 1 {
+		ke.Message, _ = e[1].(string)
+	}
+	return ke
+}
+
+// node is a DHT node that is found during a lookup.
+type node struct {
+	// ID is unknown for bootstrap nodes until they respond.
+	id      [20]byte
+	idKnown bool
+	addr    *net.UDPAddr
+	// Token is received in get response and must be sent back in put query.
+	token string
+}
+
+// parseNodes parses the compact node info list in a response. Only IPv4 nodes are supported.
+func parseNodes(s string) ([]*node, error) {
+	const nodeLen = 26
+	if len(s)%nodeLen != 0 {
+		return nil, errors.New("invalid compact node info")
+	}
+	nodes := make([]*node, 0, len(s)/nodeLen)
+	for i := 0; i < len(s); i += nodeLen {
+		b := s[i : i+nodeLen]
+		n := &node{
+			idKnown: true,
+			addr: &net.UDPAddr{
+				IP:   net.IPv4(b[20], b[21], b[22], b[23]),
+				Port: int(b[24])<<8 | int(b[25]),
+			},
+		}
+		copy(n.id[:], b[:20])
+		if n.addr.Port == 0 {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// closer returns true if a is closer to target than b by XOR metric.
+func closer(a, b, target [20]byte) bool {
+	for i := range target {
+		da, db := a[i]^target[i], b[i]^target[i]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
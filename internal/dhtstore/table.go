@@ -0,0 +1,91 @@
+package dhtstore
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum number of nodes that are kept in the routing table.
+	maxTableNodes = 512
+	// Node is removed from the routing table after this many consecutive queries are failed.
+	maxNodeFailures = 2
+)
+
+// table keeps the nodes that are known to be alive, so lookups start from the closest known nodes
+// instead of the bootstrap nodes.
+type table struct {
+	m     sync.Mutex
+	nodes map[string]*tableNode // by address
+}
+
+type tableNode struct {
+	id       [20]byte
+	addr     *net.UDPAddr
+	failures int
+	seenAt   time.Time
+}
+
+func newTable() *table {
+	return &table{nodes: make(map[string]*tableNode)}
+}
+
+// add the node to the table or mark it as alive if it is already in the table.
+// If the table is full, the node that is not seen for the longest time is replaced.
+func (t *table) add(id [20]byte, addr *net.UDPAddr) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	now := time.Now()
+	if n, ok := t.nodes[addr.String()]; ok {
+		n.id = id
+		n.failures = 0
+		n.seenAt = now
+		return
+	}
+	if len(t.nodes) >= maxTableNodes {
+		var oldest *tableNode
+		for _, n := range t.nodes {
+			if oldest == nil || n.seenAt.Before(oldest.seenAt) {
+				oldest = n
+			}
+		}
+		delete(t.nodes, oldest.addr.String())
+	}
+	t.nodes[addr.String()] = &tableNode{id: id, addr: addr, seenAt: now}
+}
+
+// failed must be called when the node does not respond to a query.
+func (t *table) failed(addr *net.UDPAddr) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	n, ok := t.nodes[addr.String()]
+	if !ok {
+		return
+	}
+	n.failures++
+	if n.failures >= maxNodeFailures {
+		delete(t.nodes, addr.String())
+	}
+}
+
+// closest returns at most count nodes that are closest to target.
+func (t *table) closest(target [20]byte, count int) []*node {
+	t.m.Lock()
+	nodes := make([]*node, 0, len(t.nodes))
+	for _, n := range t.nodes {
+		nodes = append(nodes, &node{id: n.id, idKnown: true, addr: n.addr})
+	}
+	t.m.Unlock()
+	sortNodes(nodes, target)
+	if len(nodes) > count {
+		nodes = nodes[:count]
+	}
+	return nodes
+}
+
+func (t *table) len() int {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return len(t.nodes)
+}
@@ -0,0 +1,23 @@
+package filesection
+
+// Zeros is used in place of the files that are not saved to disk, such as padding files and symbolic links.
+// Reads return zeros and writes are discarded.
+type Zeros struct{}
+
+// ReadAt implements io.ReaderAt interface.
+func (Zeros) ReadAt(b []byte, off int64) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+// WriteAt implements io.WriterAt interface.
+func (Zeros) WriteAt(b []byte, off int64) (int, error) {
+	return len(b), nil
+}
+
+// Close implements io.Closer interface.
+func (Zeros) Close() error {
+	return nil
+}
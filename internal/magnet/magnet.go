@@ -19,6 +19,10 @@ type Magnet struct {
 	Name     string
 	Trackers [][]string
 	Peers    []string
+	// Public key and salt of a mutable torrent (BEP 46).
+	// InfoHash is empty if the link points only to a mutable torrent with "xs" param.
+	PublicKey []byte
+	Salt      []byte
 }
 
 // New parses the string and returns new Magnet.
@@ -34,19 +38,39 @@ func New(s string) (*Magnet, error) {
 
 	params := u.Query()
 
+	var magnet Magnet
+	for _, xs := range params["xs"] {
+		if !strings.HasPrefix(xs, "urn:btpk:") {
+			continue
+		}
+		magnet.PublicKey, err = hex.DecodeString(xs[9:])
+		if err != nil {
+			return nil, err
+		}
+		if len(magnet.PublicKey) != 32 {
+			return nil, errors.New("public key must be 64 characters")
+		}
+		if s := params.Get("s"); s != "" {
+			magnet.Salt, err = hex.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+		}
+		break
+	}
+
 	xts, ok := params["xt"]
-	if !ok {
+	if !ok && magnet.PublicKey == nil {
 		return nil, errors.New("missing xt param")
 	}
-	if len(xts) == 0 {
+	if ok && len(xts) == 0 {
 		return nil, errors.New("empty xt param")
 	}
-	xt := xts[0]
-
-	var magnet Magnet
-	magnet.InfoHash, err = infoHashString(xt)
-	if err != nil {
-		return nil, err
+	if ok {
+		magnet.InfoHash, err = infoHashString(xts[0])
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	names := params["dn"]
@@ -83,8 +107,21 @@ func New(s string) (*Magnet, error) {
 func (m *Magnet) String() string {
 	var b strings.Builder
 	b.Grow(2048)
-	b.WriteString("magnet:?xt=urn:btih:")
-	b.WriteString(hex.EncodeToString(m.InfoHash[:]))
+	if m.PublicKey != nil && m.InfoHash == [20]byte{} {
+		b.WriteString("magnet:?xs=urn:btpk:")
+		b.WriteString(hex.EncodeToString(m.PublicKey))
+	} else {
+		b.WriteString("magnet:?xt=urn:btih:")
+		b.WriteString(hex.EncodeToString(m.InfoHash[:]))
+		if m.PublicKey != nil {
+			b.WriteString("&xs=urn:btpk:")
+			b.WriteString(hex.EncodeToString(m.PublicKey))
+		}
+	}
+	if len(m.Salt) > 0 {
+		b.WriteString("&s=")
+		b.WriteString(hex.EncodeToString(m.Salt))
+	}
 	if m.Name != "" {
 		b.WriteString("&dn=")
 		b.WriteString(url.QueryEscape(m.Name))
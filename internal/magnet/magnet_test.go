@@ -31,3 +31,29 @@ func TestParse(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestParseMutable(t *testing.T) {
+	u := "magnet:?xs=urn:btpk:77ff84905a91936367c01360803104f92432fcd904a43511876df5cdf3e7e548&s=666f6f626172&dn=latest"
+	m, err := New(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(m.PublicKey) != "77ff84905a91936367c01360803104f92432fcd904a43511876df5cdf3e7e548" {
+		t.Fatal("invalid public key")
+	}
+	if string(m.Salt) != "foobar" {
+		t.Fatal("invalid salt")
+	}
+	if m.InfoHash != [20]byte{} {
+		t.Fatal("info hash must be empty")
+	}
+	if s := m.String(); s != u {
+		t.Log(u)
+		t.Log(s)
+		t.FailNow()
+	}
+	_, err = New("magnet:?xs=urn:btpk:1234")
+	if err == nil {
+		t.Fatal("invalid public key must be rejected")
+	}
+}
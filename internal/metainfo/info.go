@@ -5,10 +5,12 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -33,6 +35,10 @@ type Info struct {
 	Bytes       []byte
 	Private     bool
 	Files       []File
+	// Info hashes of torrents that have files in common with this torrent (BEP 38).
+	Similar [][20]byte
+	// Names of the collections that this torrent belongs to (BEP 38).
+	Collections []string
 	pieces      []byte
 }
 
@@ -40,11 +46,56 @@ type Info struct {
 type File struct {
 	Length int64
 	Path   string
+	// Padding files contain zeros for aligning the next file to a piece boundary. They are not saved to disk.
+	Padding    bool
+	Executable bool
+	Hidden     bool
+	// SymlinkPath is the target of the symbolic link in the same form as Path.
+	// Empty if the file is not a symbolic link.
+	SymlinkPath string
+	// SHA1 hash of the file contents. Optional.
+	SHA1 []byte
 }
 
+// File attributes that are described in BEP 47.
+const (
+	attrPadding    = 'p'
+	attrExecutable = 'x'
+	attrHidden     = 'h'
+	attrSymlink    = 'l'
+)
+
 type file struct {
-	Length int64    `bencode:"length"`
-	Path   []string `bencode:"path"`
+	Length      int64    `bencode:"length"`
+	Path        []string `bencode:"path"`
+	Attr        string   `bencode:"attr,omitempty"`
+	SymlinkPath []string `bencode:"symlink path,omitempty"`
+	SHA1        []byte   `bencode:"sha1,omitempty"`
+}
+
+func (f *file) isPadding() bool {
+	return strings.ContainsRune(f.Attr, attrPadding)
+}
+
+// newFile returns a File with attributes of f. Symlink path of f is relative to dir.
+func newFile(dir, path string, f file) File {
+	ret := File{
+		Path:       path,
+		Length:     f.Length,
+		Padding:    f.isPadding(),
+		Executable: strings.ContainsRune(f.Attr, attrExecutable),
+		Hidden:     strings.ContainsRune(f.Attr, attrHidden),
+		SHA1:       f.SHA1,
+	}
+	if strings.ContainsRune(f.Attr, attrSymlink) && len(f.SymlinkPath) > 0 {
+		parts := make([]string, 0, len(f.SymlinkPath)+1)
+		parts = append(parts, dir)
+		for _, p := range f.SymlinkPath {
+			parts = append(parts, cleanName(p))
+		}
+		ret.SymlinkPath = filepath.Join(parts...)
+	}
+	return ret
 }
 
 // NewInfo returns info from bencoded bytes in b.
@@ -55,7 +106,11 @@ func NewInfo(b []byte) (*Info, error) {
 		Name        string             `bencode:"name"`
 		Private     bencode.RawMessage `bencode:"private"`
 		Length      int64              `bencode:"length"` // Single File Mode
+		Attr        string             `bencode:"attr"`   // Single File Mode
+		SHA1        []byte             `bencode:"sha1"`   // Single File Mode
 		Files       []file             `bencode:"files"`  // Multiple File mode
+		Similar     []string           `bencode:"similar"`
+		Collections []string           `bencode:"collections"`
 	}
 	if err := bencode.DecodeBytes(b, &ib); err != nil {
 		return nil, err
@@ -77,6 +132,11 @@ func NewInfo(b []byte) (*Info, error) {
 				return nil, fmt.Errorf("invalid file name: %q", filepath.Join(file.Path...))
 			}
 		}
+		for _, path := range file.SymlinkPath {
+			if strings.TrimSpace(path) == ".." {
+				return nil, fmt.Errorf("invalid symlink path: %q", filepath.Join(file.SymlinkPath...))
+			}
+		}
 	}
 	i := Info{
 		PieceLength: ib.PieceLength,
@@ -84,6 +144,15 @@ func NewInfo(b []byte) (*Info, error) {
 		pieces:      ib.Pieces,
 		Name:        ib.Name,
 		Private:     parsePrivateField(ib.Private),
+		Collections: ib.Collections,
+	}
+	for _, s := range ib.Similar {
+		// Invalid hashes are ignored because the key is only a hint.
+		if len(s) == sha1.Size {
+			var h [20]byte
+			copy(h[:], s)
+			i.Similar = append(i.Similar, h)
+		}
 	}
 	multiFile := len(ib.Files) > 0
 	if multiFile {
@@ -121,13 +190,10 @@ func NewInfo(b []byte) (*Info, error) {
 			for _, p := range f.Path {
 				parts = append(parts, cleanName(p))
 			}
-			i.Files[j] = File{
-				Path:   filepath.Join(parts...),
-				Length: f.Length,
-			}
+			i.Files[j] = newFile(parts[0], filepath.Join(parts...), f)
 		}
 	} else {
-		i.Files = []File{{Path: cleanName(i.Name), Length: i.Length}}
+		i.Files = []File{newFile("", cleanName(i.Name), file{Length: i.Length, Attr: ib.Attr, SHA1: ib.SHA1})}
 	}
 	return &i, nil
 }
@@ -182,7 +248,10 @@ func parsePrivateField(s bencode.RawMessage) bool {
 }
 
 // NewInfoBytes creates a new Info dictionary by reading and hashing the files on the disk.
-func NewInfoBytes(root string, paths []string, private bool, pieceLength uint32, name string, log logger.Logger) ([]byte, error) {
+// Pieces are hashed concurrently by multiple goroutines.
+// If progress is not nil, it is called after each piece is hashed with the number of bytes hashed so far.
+// If padFiles is true, padding files are inserted between files so that each file starts at a piece boundary (BEP 47).
+func NewInfoBytes(root string, paths []string, private bool, pieceLength uint32, name string, padFiles bool, progress func(hashed, total int64), log logger.Logger) ([]byte, error) {
 	var singleFileTorrent bool
 	switch len(paths) {
 	case 0:
@@ -204,25 +273,9 @@ func NewInfoBytes(root string, paths []string, private bool, pieceLength uint32,
 			return nil, errors.New("no name specified")
 		}
 	}
-	totalLength, err := findTotalLength(paths)
-	if err != nil {
-		return nil, err
-	}
-	if totalLength == 0 {
-		return nil, errors.New("no files")
-	}
-	if pieceLength == 0 {
-		pieceLength = calculatePieceLength(totalLength)
-		log.Infof("Calculated piece length: %d K", pieceLength>>10)
-	} else if pieceLength%(16<<10) != 0 {
-		return nil, errPieceLength
-	}
-	buf := make([]byte, pieceLength)
-	offset := 0
-	remaining := func() []byte { return buf[offset:] }
-	hash := sha1.New()
 	var files []file
-	var pieces []byte
+	var osPaths []string
+	var totalLength int64
 	for _, path := range paths {
 		relroot := path
 		if root != "" {
@@ -235,42 +288,44 @@ func NewInfoBytes(root string, paths []string, private bool, pieceLength uint32,
 			if fi.IsDir() {
 				return nil
 			}
-			f, err := os.Open(vpath)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
 			relpath, err := filepath.Rel(relroot, vpath)
-			log.Infof("Adding %q", relpath)
 			if err != nil {
 				return err
 			}
-			files = append(files, file{Path: strings.Split(relpath, string(os.PathSeparator)), Length: fi.Size()})
-			for {
-				n, err := io.ReadFull(f, remaining())
-				offset += n
-				if err == io.ErrUnexpectedEOF || err == io.EOF {
-					return nil // file finished, continue with next file
-				}
-				if err != nil {
-					return err
-				}
-				// buffer finished, calculate piece hash and append to pieces
-				_, _ = hash.Write(buf)
-				pieces = hash.Sum(pieces)
-				hash.Reset()
-				offset = 0
+			log.Infof("Adding %q", relpath)
+			f := file{Path: strings.Split(relpath, string(os.PathSeparator)), Length: fi.Size()}
+			if fi.Mode()&0111 != 0 {
+				f.Attr = string(attrExecutable)
 			}
+			files = append(files, f)
+			osPaths = append(osPaths, vpath)
+			totalLength += fi.Size()
+			return nil
 		}
-		err = filepath.Walk(path, visit)
+		err := filepath.Walk(path, visit)
 		if err != nil {
 			return nil, err
 		}
 	}
-	// hash remaining buffer
-	if offset > 0 {
-		_, _ = hash.Write(buf[:offset])
-		pieces = hash.Sum(pieces)
+	if totalLength == 0 {
+		return nil, errors.New("no files")
+	}
+	if pieceLength == 0 {
+		pieceLength = calculatePieceLength(totalLength)
+		log.Infof("Calculated piece length: %d K", pieceLength>>10)
+	} else if pieceLength%(16<<10) != 0 {
+		return nil, errPieceLength
+	}
+	if padFiles && !singleFileTorrent {
+		files, osPaths = addPadFiles(files, osPaths, pieceLength)
+		totalLength = 0
+		for _, f := range files {
+			totalLength += f.Length
+		}
+	}
+	pieces, err := hashPieces(osPaths, files, totalLength, pieceLength, progress)
+	if err != nil {
+		return nil, err
 	}
 	b := struct {
 		Name        string `bencode:"name"`
@@ -293,30 +348,143 @@ func NewInfoBytes(root string, paths []string, private bool, pieceLength uint32,
 	return bencode.EncodeBytes(b)
 }
 
-// PieceHash returns the hash of a piece at index.
-func (i *Info) PieceHash(index uint32) []byte {
-	begin := index * sha1.Size
-	end := begin + sha1.Size
-	return i.pieces[begin:end]
+// addPadFiles inserts a padding file after each file that does not end at a piece boundary, except the last one.
+// Padding files are not on the disk, so their paths in osPaths are empty.
+func addPadFiles(files []file, osPaths []string, pieceLength uint32) ([]file, []string) {
+	var retFiles []file
+	var retPaths []string
+	var offset int64
+	for i, f := range files {
+		retFiles = append(retFiles, f)
+		retPaths = append(retPaths, osPaths[i])
+		offset += f.Length
+		if i == len(files)-1 {
+			break
+		}
+		if mod := offset % int64(pieceLength); mod != 0 {
+			padLength := int64(pieceLength) - mod
+			retFiles = append(retFiles, file{
+				Path:   []string{".pad", strconv.FormatInt(padLength, 10)},
+				Length: padLength,
+				Attr:   string(attrPadding),
+			})
+			retPaths = append(retPaths, "")
+			offset += padLength
+		}
+	}
+	return retFiles, retPaths
 }
 
-func findTotalLength(paths []string) (n int64, err error) {
-	for _, path := range paths {
-		err = filepath.Walk(path, func(path string, fi os.FileInfo, err error) error {
+// hashPieces reads the files as a contiguous stream and returns the concatenated SHA-1 hashes of pieces.
+// Each piece is read and hashed independently, so work is distributed to a goroutine per CPU.
+func hashPieces(paths []string, files []file, totalLength int64, pieceLength uint32, progress func(hashed, total int64)) ([]byte, error) {
+	offsets := make([]int64, len(files))
+	var offset int64
+	for i, f := range files {
+		offsets[i] = offset
+		offset += f.Length
+	}
+	numPieces := uint32((totalLength + int64(pieceLength) - 1) / int64(pieceLength))
+	pieces := make([]byte, int(numPieces)*sha1.Size)
+
+	type result struct {
+		length int64
+		err    error
+	}
+	indexC := make(chan uint32)
+	resultC := make(chan result)
+	doneC := make(chan struct{})
+	defer close(doneC)
+
+	hashPiece := func(index uint32, buf []byte) (int64, error) {
+		begin := int64(index) * int64(pieceLength)
+		end := begin + int64(pieceLength)
+		if end > totalLength {
+			end = totalLength
+		}
+		buf = buf[:end-begin]
+		// Find the first file that contains the beginning of the piece.
+		i := sort.Search(len(offsets), func(i int) bool { return offsets[i]+files[i].Length > begin })
+		var n int64
+		for ; n < int64(len(buf)) && i < len(files); i++ {
+			if files[i].Length == 0 {
+				continue
+			}
+			pos := begin + n - offsets[i]
+			size := files[i].Length - pos
+			if size > int64(len(buf))-n {
+				size = int64(len(buf)) - n
+			}
+			if files[i].isPadding() {
+				for j := n; j < n+size; j++ {
+					buf[j] = 0
+				}
+				n += size
+				continue
+			}
+			f, err := os.Open(paths[i])
 			if err != nil {
-				return err
+				return 0, err
 			}
-			if fi.IsDir() {
-				return nil
+			_, err = f.ReadAt(buf[n:n+size], pos)
+			f.Close()
+			if err != nil {
+				return 0, err
 			}
-			n += fi.Size()
-			return nil
-		})
-		if err != nil {
-			return
+			n += size
+		}
+		sum := sha1.Sum(buf)
+		copy(pieces[index*sha1.Size:], sum[:])
+		return int64(len(buf)), nil
+	}
+
+	workers := runtime.NumCPU()
+	if uint32(workers) > numPieces {
+		workers = int(numPieces)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			buf := make([]byte, pieceLength)
+			for index := range indexC {
+				length, err := hashPiece(index, buf)
+				select {
+				case resultC <- result{length: length, err: err}:
+				case <-doneC:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(indexC)
+		for i := uint32(0); i < numPieces; i++ {
+			select {
+			case indexC <- i:
+			case <-doneC:
+				return
+			}
+		}
+	}()
+
+	var hashed int64
+	for i := uint32(0); i < numPieces; i++ {
+		res := <-resultC
+		if res.err != nil {
+			return nil, res.err
+		}
+		hashed += res.length
+		if progress != nil {
+			progress(hashed, totalLength)
 		}
 	}
-	return
+	return pieces, nil
+}
+
+// PieceHash returns the hash of a piece at index.
+func (i *Info) PieceHash(index uint32) []byte {
+	begin := index * sha1.Size
+	end := begin + sha1.Size
+	return i.pieces[begin:end]
 }
 
 func calculatePieceLength(totalLength int64) uint32 {
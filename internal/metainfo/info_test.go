@@ -1,9 +1,15 @@
 package metainfo
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/ganqierwu/rain/internal/logger"
 	"github.com/stretchr/testify/assert"
+	"github.com/zeebo/bencode"
 )
 
 func TestCalculatePieceLength(t *testing.T) {
@@ -47,3 +53,146 @@ func TestCleanName(t *testing.T) {
 		assert.Equal(t, c.cleaned, cleanNameN(c.name, c.max))
 	}
 }
+
+func TestNewInfoBytes(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	err := os.MkdirAll(filepath.Join(root, "sub"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 100<<10)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	// Split data into files with boundaries that do not align with pieces.
+	files := []struct {
+		path  string
+		begin int
+		end   int
+	}{
+		{"a", 0, 10000},
+		{"empty", 10000, 10000},
+		{filepath.Join("sub", "b"), 10000, 70000},
+		{filepath.Join("sub", "c"), 70000, len(data)},
+	}
+	for _, f := range files {
+		err = os.WriteFile(filepath.Join(root, f.path), data[f.begin:f.end], 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var lastHashed, lastTotal int64
+	progress := func(hashed, total int64) {
+		lastHashed, lastTotal = hashed, total
+	}
+	b, err := NewInfoBytes("", []string{root}, false, 32<<10, "", false, progress, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := NewInfo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "root", info.Name)
+	assert.Equal(t, int64(len(data)), info.Length)
+	assert.Equal(t, uint32(4), info.NumPieces)
+	assert.Equal(t, int64(len(data)), lastHashed)
+	assert.Equal(t, int64(len(data)), lastTotal)
+	for i := uint32(0); i < info.NumPieces; i++ {
+		begin := int(i) * int(info.PieceLength)
+		end := begin + int(info.PieceLength)
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha1.Sum(data[begin:end])
+		assert.Equal(t, sum[:], info.PieceHash(i))
+	}
+}
+
+func TestNewInfoBytesPadFiles(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	err := os.Mkdir(root, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := bytes.Repeat([]byte{1}, 10000)
+	b := bytes.Repeat([]byte{2}, 60000)
+	err = os.WriteFile(filepath.Join(root, "a"), a, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(root, "b"), b, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ib, err := NewInfoBytes("", []string{root}, false, 32<<10, "", true, nil, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := NewInfo(ib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Len(t, info.Files, 3) {
+		return
+	}
+	assert.False(t, info.Files[0].Padding)
+	assert.True(t, info.Files[1].Padding)
+	assert.Equal(t, int64(32<<10-10000), info.Files[1].Length)
+	assert.False(t, info.Files[2].Padding)
+	assert.True(t, info.Files[2].Executable)
+	assert.Equal(t, uint32(3), info.NumPieces)
+
+	// Padding file is hashed as zeros.
+	data := append(append(a, make([]byte, 32<<10-10000)...), b...)
+	assert.Equal(t, int64(len(data)), info.Length)
+	for i := uint32(0); i < info.NumPieces; i++ {
+		begin := int(i) * int(info.PieceLength)
+		end := begin + int(info.PieceLength)
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := sha1.Sum(data[begin:end])
+		assert.Equal(t, sum[:], info.PieceHash(i))
+	}
+}
+
+func TestNewInfoFileAttributes(t *testing.T) {
+	ib := struct {
+		Name        string `bencode:"name"`
+		PieceLength uint32 `bencode:"piece length"`
+		Pieces      []byte `bencode:"pieces"`
+		Files       []file `bencode:"files"`
+	}{
+		Name:        "root",
+		PieceLength: 32 << 10,
+		Pieces:      make([]byte, sha1.Size),
+		Files: []file{
+			{Path: []string{"bin", "run"}, Length: 100, Attr: "xh"},
+			{Path: []string{"link"}, Attr: "l", SymlinkPath: []string{"bin", "run"}},
+			{Path: []string{".pad", "100"}, Length: 100, Attr: "p"},
+		},
+	}
+	b, err := bencode.EncodeBytes(ib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := NewInfo(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, info.Files[0].Executable)
+	assert.True(t, info.Files[0].Hidden)
+	assert.False(t, info.Files[0].Padding)
+	assert.Equal(t, filepath.Join("root", "bin", "run"), info.Files[1].SymlinkPath)
+	assert.True(t, info.Files[2].Padding)
+
+	ib.Files[1].SymlinkPath = []string{"..", "etc"}
+	b, err = bencode.EncodeBytes(ib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewInfo(b)
+	assert.Error(t, err)
+}
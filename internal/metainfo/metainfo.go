@@ -18,6 +18,10 @@ type MetaInfo struct {
 	Info         Info
 	AnnounceList [][]string
 	URLList      []string
+	// HTTPSeeds are the seed script URLs in "httpseeds" key (BEP 17).
+	HTTPSeeds []string
+	// Signatures of the torrent by signer identity (BEP 35).
+	Signatures map[string]Signature
 }
 
 // New returns a torrent from bencoded stream.
@@ -28,6 +32,8 @@ func New(r io.Reader) (*MetaInfo, error) {
 		Announce     bencode.RawMessage `bencode:"announce"`
 		AnnounceList bencode.RawMessage `bencode:"announce-list"`
 		URLList      bencode.RawMessage `bencode:"url-list"`
+		HTTPSeeds    bencode.RawMessage `bencode:"httpseeds"`
+		Signatures   bencode.RawMessage `bencode:"signatures"`
 	}
 	err := bencode.NewDecoder(r).Decode(&t)
 	if err != nil {
@@ -83,6 +89,24 @@ func New(r io.Reader) (*MetaInfo, error) {
 			}
 		}
 	}
+	if len(t.HTTPSeeds) > 0 {
+		var l []string
+		err = bencode.DecodeBytes(t.HTTPSeeds, &l)
+		if err == nil {
+			for _, s := range l {
+				if isWebseedSupported(s) {
+					ret.HTTPSeeds = append(ret.HTTPSeeds, s)
+				}
+			}
+		}
+	}
+	if len(t.Signatures) > 0 {
+		var sigs map[string]Signature
+		err = bencode.DecodeBytes(t.Signatures, &sigs)
+		if err == nil {
+			ret.Signatures = sigs
+		}
+	}
 	return &ret, nil
 }
 
@@ -95,17 +119,19 @@ func isWebseedSupported(s string) bool {
 }
 
 // NewBytes creates a new torrent metadata file from given information.
-func NewBytes(info []byte, trackers [][]string, webseeds []string, comment string) ([]byte, error) {
+func NewBytes(info []byte, trackers [][]string, webseeds, httpSeeds []string, comment string) ([]byte, error) {
 	mi := struct {
 		Info         bencode.RawMessage `bencode:"info"`
 		Announce     string             `bencode:"announce,omitempty"`
 		AnnounceList [][]string         `bencode:"announce-list,omitempty"`
 		URLList      bencode.RawMessage `bencode:"url-list,omitempty"`
+		HTTPSeeds    []string           `bencode:"httpseeds,omitempty"`
 		Comment      string             `bencode:"comment,omitempty"`
 		CreationDate int64              `bencode:"creation date"`
 		CreatedBy    string             `bencode:"created by,omitempty"`
 	}{
 		Info:         info,
+		HTTPSeeds:    httpSeeds,
 		Comment:      comment,
 		CreationDate: time.Now().UTC().Unix(),
 		CreatedBy:    Creator,
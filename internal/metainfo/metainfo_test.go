@@ -1,6 +1,7 @@
 package metainfo
 
 import (
+	"bytes"
 	"encoding/hex"
 	"os"
 	"testing"
@@ -27,3 +28,25 @@ func TestTorrent(t *testing.T) {
 		{"http://ipv6.torrent.ubuntu.com:6969/announce"},
 	}, tor.AnnounceList)
 }
+
+func TestHTTPSeeds(t *testing.T) {
+	f, err := os.Open("testdata/ubuntu-14.04.1-server-amd64.iso.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tor, err := New(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBytes(tor.Info.Bytes, nil, []string{"http://example.com/files/"}, []string{"http://example.com/seed.php", "ftp://example.com/seed"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"http://example.com/files/"}, mi.URLList)
+	assert.Equal(t, []string{"http://example.com/seed.php"}, mi.HTTPSeeds)
+}
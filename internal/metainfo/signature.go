@@ -0,0 +1,158 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" // nolint: gosec
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/zeebo/bencode"
+)
+
+var (
+	// ErrUnsigned is returned from VerifySignature if the torrent has no signatures.
+	ErrUnsigned = errors.New("torrent is not signed")
+	// ErrUnknownSigner is returned from VerifySignature if the torrent is not signed by any of the trusted certificates.
+	ErrUnknownSigner = errors.New("torrent is not signed by a trusted signer")
+)
+
+// Signature of a torrent in "signatures" dictionary (BEP 35).
+type Signature struct {
+	// DER encoded X.509 certificate of the signer. Optional if the signer is already known.
+	Certificate []byte `bencode:"certificate,omitempty"`
+	// Optional dictionary that is signed together with the info dictionary.
+	Info bencode.RawMessage `bencode:"info,omitempty"`
+	// Signature of the info dictionary concatenated with Info.
+	Signature []byte `bencode:"signature"`
+}
+
+// VerifySignature returns the identity of the first signer that has a valid signature in the torrent.
+// The certificate of the signer must be one of the trusted certificates or must be issued by one of them.
+// If the signature does not include a certificate, the trusted certificate with the same common name as the signer is used.
+// The identity is the common name of the certificate. Signatures under a key that differs from it are ignored.
+func (m *MetaInfo) VerifySignature(trusted []*x509.Certificate) (string, error) {
+	if len(m.Signatures) == 0 {
+		return "", ErrUnsigned
+	}
+	signers := make([]string, 0, len(m.Signatures))
+	for signer := range m.Signatures {
+		signers = append(signers, signer)
+	}
+	sort.Strings(signers)
+	for _, signer := range signers {
+		sig := m.Signatures[signer]
+		cert, err := trustedCertificate(signer, sig.Certificate, trusted)
+		if err != nil {
+			continue
+		}
+		if verify(cert.PublicKey, signedMessage(m.Info.Bytes, sig.Info), sig.Signature) {
+			return cert.Subject.CommonName, nil
+		}
+	}
+	return "", ErrUnknownSigner
+}
+
+func trustedCertificate(signer string, der []byte, trusted []*x509.Certificate) (*x509.Certificate, error) {
+	if len(der) == 0 {
+		for _, cert := range trusted {
+			if cert.Subject.CommonName == signer {
+				return cert, nil
+			}
+		}
+		return nil, ErrUnknownSigner
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	// A trusted certificate must not be able to sign in the name of another signer.
+	if cert.Subject.CommonName != signer {
+		return nil, ErrUnknownSigner
+	}
+	roots := x509.NewCertPool()
+	for _, t := range trusted {
+		if bytes.Equal(t.Raw, cert.Raw) {
+			return cert, nil
+		}
+		roots.AddCert(t)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func signedMessage(info, sigInfo []byte) []byte {
+	msg := make([]byte, 0, len(info)+len(sigInfo))
+	msg = append(msg, info...)
+	return append(msg, sigInfo...)
+}
+
+// verify checks the signature of msg. RSA and ECDSA signatures are calculated over the SHA-1 digest of msg.
+func verify(pub crypto.PublicKey, msg, sig []byte) bool {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		digest := sha1.Sum(msg) // nolint: gosec
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA1, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		digest := sha1.Sum(msg) // nolint: gosec
+		return ecdsa.VerifyASN1(pub, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, msg, sig)
+	default:
+		return false
+	}
+}
+
+// Sign adds the signature of signer into the torrent file and returns the new torrent file.
+// If cert is not nil, it is included in the signature so that clients that do not know the signer can verify it.
+// Existing signatures of other signers are kept.
+func Sign(torrent []byte, signer string, key crypto.Signer, cert *x509.Certificate) ([]byte, error) {
+	var t map[string]bencode.RawMessage
+	err := bencode.DecodeBytes(torrent, &t)
+	if err != nil {
+		return nil, err
+	}
+	info, ok := t["info"]
+	if !ok {
+		return nil, errors.New("no info dict in torrent file")
+	}
+	msg := signedMessage(info, nil)
+	var sig Signature
+	switch key.Public().(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		digest := sha1.Sum(msg) // nolint: gosec
+		sig.Signature, err = key.Sign(rand.Reader, digest[:], crypto.SHA1)
+	case ed25519.PublicKey:
+		sig.Signature, err = key.Sign(rand.Reader, msg, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported key type: %T", key.Public())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		sig.Certificate = cert.Raw
+	}
+	signatures := make(map[string]Signature)
+	if b, ok := t["signatures"]; ok {
+		err = bencode.DecodeBytes(b, &signatures)
+		if err != nil {
+			return nil, err
+		}
+	}
+	signatures[signer] = sig
+	t["signatures"], err = bencode.EncodeBytes(signatures)
+	if err != nil {
+		return nil, err
+	}
+	return bencode.EncodeBytes(t)
+}
@@ -0,0 +1,110 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCertificate(t *testing.T, name string, key crypto.Signer) *x509.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSignature(t *testing.T) {
+	torrent, err := os.ReadFile("testdata/ubuntu-14.04.1-server-amd64.iso.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert := newTestCertificate(t, "release", rsaKey)
+	edCert := newTestCertificate(t, "other", edKey)
+
+	mi, err := New(bytes.NewReader(torrent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mi.VerifySignature([]*x509.Certificate{rsaCert})
+	assert.Equal(t, ErrUnsigned, err)
+	infoHash := mi.Info.Hash
+
+	// Certificate is included in the signature.
+	signed, err := Sign(torrent, "release", rsaKey, rsaCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err = New(bytes.NewReader(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, infoHash, mi.Info.Hash)
+	signer, err := mi.VerifySignature([]*x509.Certificate{edCert, rsaCert})
+	assert.NoError(t, err)
+	assert.Equal(t, "release", signer)
+	_, err = mi.VerifySignature([]*x509.Certificate{edCert})
+	assert.Equal(t, ErrUnknownSigner, err)
+
+	// Certificate is trusted but issued to another signer.
+	signed, err = Sign(torrent, "other", rsaKey, rsaCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err = New(bytes.NewReader(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mi.VerifySignature([]*x509.Certificate{rsaCert})
+	assert.Equal(t, ErrUnknownSigner, err)
+
+	// Certificate is looked up by signer name.
+	signed, err = Sign(torrent, "other", edKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err = New(bytes.NewReader(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err = mi.VerifySignature([]*x509.Certificate{rsaCert, edCert})
+	assert.NoError(t, err)
+	assert.Equal(t, "other", signer)
+
+	// Signature does not match the info dict.
+	mi.Info.Bytes = append([]byte{}, mi.Info.Bytes...)
+	mi.Info.Bytes[len(mi.Info.Bytes)-2]++
+	_, err = mi.VerifySignature([]*x509.Certificate{rsaCert, edCert})
+	assert.Equal(t, ErrUnknownSigner, err)
+}
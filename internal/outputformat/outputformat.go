@@ -0,0 +1,256 @@
+// Package outputformat writes RPC responses in a format that is easy to process in scripts.
+// Supported formats are "table", "json", "csv" and "tsv".
+//
+// Values are structs or slices of structs.
+// Fields of nested structs are flattened and named by joining the field names with dots, e.g. "Bytes.Downloaded".
+package outputformat
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+)
+
+// Formats is the list of supported output formats.
+var Formats = []string{"table", "json", "csv", "tsv"}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// field is a leaf field in a flattened struct.
+type field struct {
+	name  string
+	index []int
+}
+
+// Write v to w in format. v must be a struct or a slice of structs.
+// If names is not empty, only the fields with these names are written in the given order. Names are case-insensitive.
+// If v is a single struct, the table format lists fields and values in two columns.
+func Write(w io.Writer, format string, v interface{}, names []string) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	single := rv.Kind() != reflect.Slice
+	t := rv.Type()
+	if !single {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot format value of type %s", rv.Type())
+	}
+	fields, err := selectFields(structFields(t), names)
+	if err != nil {
+		return err
+	}
+	var rows []reflect.Value
+	if single {
+		rows = []reflect.Value{rv}
+	} else {
+		for i := 0; i < rv.Len(); i++ {
+			rows = append(rows, rv.Index(i))
+		}
+	}
+	switch format {
+	case "table":
+		return writeTable(w, fields, rows, single)
+	case "json":
+		return writeJSON(w, fields, rows, single)
+	case "csv":
+		return writeCSV(w, ',', fields, rows)
+	case "tsv":
+		return writeCSV(w, '\t', fields, rows)
+	default:
+		return fmt.Errorf("unknown output format: %q (must be one of %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+func isLeaf(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return true
+	}
+	return t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType)
+}
+
+func structFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if isLeaf(f.Type) {
+			fields = append(fields, field{name: f.Name, index: []int{i}})
+			continue
+		}
+		for _, sub := range structFields(f.Type) {
+			fields = append(fields, field{
+				name:  f.Name + "." + sub.name,
+				index: append([]int{i}, sub.index...),
+			})
+		}
+	}
+	return fields
+}
+
+func selectFields(fields []field, names []string) ([]field, error) {
+	if len(names) == 0 {
+		return fields, nil
+	}
+	selected := make([]field, 0, len(names))
+	for _, name := range names {
+		i := indexOfField(fields, name)
+		if i == -1 {
+			all := make([]string, len(fields))
+			for j, f := range fields {
+				all[j] = f.name
+			}
+			return nil, fmt.Errorf("unknown field: %q (must be one of %s)", name, strings.Join(all, ", "))
+		}
+		selected = append(selected, fields[i])
+	}
+	return selected, nil
+}
+
+func indexOfField(fields []field, name string) int {
+	for i, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// formatValue converts a field value to string for table, csv and tsv formats.
+func formatValue(v reflect.Value) string {
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		if err == nil {
+			return string(b)
+		}
+	}
+	if m, ok := v.Interface().(json.Marshaler); ok {
+		b, err := m.MarshalJSON()
+		if err == nil {
+			var s string
+			if json.Unmarshal(b, &s) == nil {
+				return s
+			}
+			return string(b)
+		}
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.String {
+			s := make([]string, v.Len())
+			for i := range s {
+				s[i] = v.Index(i).String()
+			}
+			return strings.Join(s, ",")
+		}
+		b, err := json.Marshal(v.Interface())
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func writeTable(w io.Writer, fields []field, rows []reflect.Value, single bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if single {
+		for _, f := range fields {
+			fmt.Fprintf(tw, "%s\t%s\n", f.name, formatValue(rows[0].FieldByIndex(f.index)))
+		}
+		return tw.Flush()
+	}
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = strings.ToUpper(f.name)
+	}
+	fmt.Fprintln(tw, strings.Join(names, "\t"))
+	values := make([]string, len(fields))
+	for _, row := range rows {
+		for i, f := range fields {
+			// Tabs and new lines would break the alignment of columns.
+			values[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(formatValue(row.FieldByIndex(f.index)))
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, fields []field, rows []reflect.Value, single bool) error {
+	var buf bytes.Buffer
+	if !single {
+		buf.WriteString("[")
+	}
+	for i, row := range rows {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		// Fields are written in the selected order, which is not possible with a map.
+		buf.WriteString("{")
+		for j, f := range fields {
+			if j > 0 {
+				buf.WriteString(",")
+			}
+			key, err := json.Marshal(f.name)
+			if err != nil {
+				return err
+			}
+			val, err := json.Marshal(row.FieldByIndex(f.index).Interface())
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteString(":")
+			buf.Write(val)
+		}
+		buf.WriteString("}")
+	}
+	if !single {
+		buf.WriteString("]")
+	}
+	var out bytes.Buffer
+	err := json.Indent(&out, buf.Bytes(), "", "  ")
+	if err != nil {
+		return err
+	}
+	out.WriteString("\n")
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+func writeCSV(w io.Writer, comma rune, fields []field, rows []reflect.Value) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		record[i] = f.name
+	}
+	err := cw.Write(record)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		for i, f := range fields {
+			record[i] = formatValue(row.FieldByIndex(f.index))
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
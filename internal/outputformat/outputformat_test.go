@@ -0,0 +1,75 @@
+package outputformat
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/rpctypes"
+)
+
+type testRow struct {
+	ID      string
+	AddedAt rpctypes.Time
+	Bytes   struct {
+		Total int64
+	}
+	Tags []string
+}
+
+func testRows() []testRow {
+	rows := make([]testRow, 2)
+	rows[0].ID = "a"
+	rows[0].AddedAt = rpctypes.Time{Time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	rows[0].Bytes.Total = 10
+	rows[0].Tags = []string{"x", "y"}
+	rows[1].ID = "b,c"
+	rows[1].AddedAt = rpctypes.Time{Time: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)}
+	rows[1].Bytes.Total = 20
+	return rows
+}
+
+func TestWrite(t *testing.T) {
+	cases := []struct {
+		format string
+		fields []string
+		result string
+	}{
+		{"csv", nil, "ID,AddedAt,Bytes.Total,Tags\na,2020-01-02T03:04:05Z,10,\"x,y\"\n\"b,c\",2021-01-02T03:04:05Z,20,\n"},
+		{"tsv", []string{"bytes.total", "id"}, "Bytes.Total\tID\n10\ta\n20\tb,c\n"},
+		{"table", []string{"ID", "Bytes.Total"}, "ID   BYTES.TOTAL\na    10\nb,c  20\n"},
+		{"json", []string{"ID", "AddedAt"}, "[\n  {\n    \"ID\": \"a\",\n    \"AddedAt\": \"2020-01-02T03:04:05Z\"\n  },\n  {\n    \"ID\": \"b,c\",\n    \"AddedAt\": \"2021-01-02T03:04:05Z\"\n  }\n]\n"},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		err := Write(&buf, c.format, testRows(), c.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != c.result {
+			t.Errorf("format %s: unexpected output:\n%s\nexpected:\n%s", c.format, buf.String(), c.result)
+		}
+	}
+}
+
+func TestWriteSingle(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, "table", &testRows()[0], []string{"ID", "Bytes.Total"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "ID           a\nBytes.Total  10\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "xml", testRows(), nil); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if err := Write(&buf, "csv", testRows(), []string{"Foo"}); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
@@ -17,9 +17,10 @@ import (
 	"github.com/ganqierwu/rain/internal/peersource"
 	"github.com/ganqierwu/rain/internal/pexlist"
 	"github.com/ganqierwu/rain/internal/piece"
+	"github.com/ganqierwu/rain/internal/requestqueue"
 	"github.com/ganqierwu/rain/internal/sliceset"
+	"github.com/ganqierwu/rain/internal/speedlimit"
 	"github.com/ganqierwu/rain/internal/stringutil"
-	"github.com/juju/ratelimit"
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -53,6 +54,9 @@ type Peer struct {
 
 	Downloading bool
 
+	// UploadOnly means peer does not download any more pieces (BEP 21).
+	UploadOnly bool
+
 	downloadSpeed metrics.Meter
 	uploadSpeed   metrics.Meter
 
@@ -63,8 +67,10 @@ type Peer struct {
 
 	PEX *pex
 
-	snubTimeout time.Duration
-	snubTimer   *time.Timer
+	// RequestQueue adapts the number of outstanding requests and the snub timeout to the peer.
+	RequestQueue *requestqueue.Queue
+
+	snubTimer *time.Timer
 
 	closeC chan struct{}
 	doneC  chan struct{}
@@ -87,7 +93,7 @@ type PieceMessage struct {
 }
 
 // New wraps the net.Conn and returns a new Peer.
-func New(conn net.Conn, source peersource.Source, id [20]byte, extensions [8]byte, cipher mse.CryptoMethod, pieceReadTimeout, snubTimeout time.Duration, maxRequestsIn int, br, bw *ratelimit.Bucket) *Peer {
+func New(conn net.Conn, source peersource.Source, id [20]byte, extensions [8]byte, cipher mse.CryptoMethod, pieceReadTimeout time.Duration, maxRequestsIn int, requestQueue *requestqueue.Queue, br, bw *speedlimit.Limiter) *Peer {
 	bf, _ := bitfield.NewBytes(extensions[:], 64)
 	fastEnabled := bf.Test(61)
 	extensionsEnabled := bf.Test(43)
@@ -106,7 +112,7 @@ func New(conn net.Conn, source peersource.Source, id [20]byte, extensions [8]byt
 		FastEnabled:       fastEnabled,
 		DHTEnabled:        dhtEnabled,
 		EncryptionCipher:  cipher,
-		snubTimeout:       snubTimeout,
+		RequestQueue:      requestQueue,
 		snubTimer:         t,
 		closeC:            make(chan struct{}),
 		doneC:             make(chan struct{}),
@@ -200,8 +206,9 @@ func (p *Peer) StartPEX(initialPeers map[*Peer]struct{}, recentlySeen *pexlist.R
 }
 
 // ResetSnubTimer is called when some data received from the Peer.
+// Timeout is derived from the observed block latency of the Peer.
 func (p *Peer) ResetSnubTimer() {
-	p.snubTimer.Reset(p.snubTimeout)
+	p.snubTimer.Reset(p.RequestQueue.Timeout())
 }
 
 // StopSnubTimer is used to stop the timer that is for detecting if the Peer is snub.
@@ -219,6 +226,16 @@ func (p *Peer) UploadSpeed() int {
 	return int(p.uploadSpeed.Rate1())
 }
 
+// BytesDownloaded returns the number of piece bytes downloaded from the Peer.
+func (p *Peer) BytesDownloaded() int64 {
+	return p.downloadSpeed.Count()
+}
+
+// BytesUploaded returns the number of piece bytes uploaded to the Peer.
+func (p *Peer) BytesUploaded() int64 {
+	return p.uploadSpeed.Count()
+}
+
 // Choke the connected Peer by sending a "choke" protocol message.
 func (p *Peer) Choke() {
 	p.ClientChoking = true
@@ -267,6 +284,37 @@ func (p *Peer) RequestMetadataPiece(index uint32) {
 	})
 }
 
+// SupportsExtension returns true if the peer has announced the extension with key in extension handshake.
+func (p *Peer) SupportsExtension(key string) bool {
+	if p.ExtensionHandshake == nil {
+		return false
+	}
+	_, ok := p.ExtensionHandshake.M[key]
+	return ok
+}
+
+// SendUploadOnly tells the peer our upload-only state if the peer supports the upload_only extension.
+func (p *Peer) SendUploadOnly(value bool) {
+	if !p.SupportsExtension(peerprotocol.ExtensionKeyUploadOnly) {
+		return
+	}
+	p.SendMessage(peerprotocol.ExtensionMessage{
+		ExtendedMessageID: p.ExtensionHandshake.M[peerprotocol.ExtensionKeyUploadOnly],
+		Payload:           peerprotocol.ExtensionUploadOnlyMessage{UploadOnly: value},
+	})
+}
+
+// SendDontHave retracts the piece at index if the peer supports the lt_donthave extension.
+func (p *Peer) SendDontHave(index uint32) {
+	if !p.SupportsExtension(peerprotocol.ExtensionKeyDontHave) {
+		return
+	}
+	p.SendMessage(peerprotocol.ExtensionMessage{
+		ExtendedMessageID: p.ExtensionHandshake.M[peerprotocol.ExtensionKeyDontHave],
+		Payload:           peerprotocol.ExtensionDontHaveMessage{Index: index},
+	})
+}
+
 // RequestPiece is used to request a piece at index by sending a "piece" protocol message.
 func (p *Peer) RequestPiece(index, begin, length uint32) {
 	msg := peerprotocol.RequestMessage{Index: index, Begin: begin, Length: length}
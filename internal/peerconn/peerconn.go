@@ -9,7 +9,7 @@ import (
 	"github.com/ganqierwu/rain/internal/peerconn/peerreader"
 	"github.com/ganqierwu/rain/internal/peerconn/peerwriter"
 	"github.com/ganqierwu/rain/internal/peerprotocol"
-	"github.com/juju/ratelimit"
+	"github.com/ganqierwu/rain/internal/speedlimit"
 )
 
 // Conn is a peer connection that provides a channel for receiving messages and methods for sending messages.
@@ -24,7 +24,7 @@ type Conn struct {
 }
 
 // New returns a new PeerConn by wrapping a net.Conn.
-func New(conn net.Conn, l logger.Logger, pieceTimeout time.Duration, maxRequestsIn int, fastEnabled bool, br, bw *ratelimit.Bucket) *Conn {
+func New(conn net.Conn, l logger.Logger, pieceTimeout time.Duration, maxRequestsIn int, fastEnabled bool, br, bw *speedlimit.Limiter) *Conn {
 	return &Conn{
 		conn:     conn,
 		reader:   peerreader.New(conn, l, pieceTimeout, br),
@@ -85,6 +85,11 @@ func (p *Conn) CancelRequest(msg peerprotocol.CancelMessage) {
 	p.writer.CancelRequest(msg)
 }
 
+// QueuedRequests returns the number of piece requests from the peer that are waiting to be sent.
+func (p *Conn) QueuedRequests() int {
+	return p.writer.QueuedRequests()
+}
+
 // Run starts receiving messages from peer and starts sending queued messages.
 // If any error happens during receiving or sending messages,
 // the connection and the underlying net.Conn will be closed.
@@ -14,7 +14,7 @@ import (
 	"github.com/ganqierwu/rain/internal/logger"
 	"github.com/ganqierwu/rain/internal/peerprotocol"
 	"github.com/ganqierwu/rain/internal/piece"
-	"github.com/juju/ratelimit"
+	"github.com/ganqierwu/rain/internal/speedlimit"
 )
 
 const (
@@ -34,14 +34,14 @@ type PeerReader struct {
 	r            io.Reader
 	log          logger.Logger
 	pieceTimeout time.Duration
-	bucket       *ratelimit.Bucket
+	bucket       *speedlimit.Limiter
 	messages     chan interface{}
 	stopC        chan struct{}
 	doneC        chan struct{}
 }
 
 // New returns a new PeerReader by wrapping a net.Conn.
-func New(conn net.Conn, l logger.Logger, pieceTimeout time.Duration, b *ratelimit.Bucket) *PeerReader {
+func New(conn net.Conn, l logger.Logger, pieceTimeout time.Duration, b *speedlimit.Limiter) *PeerReader {
 	return &PeerReader{
 		conn:         conn,
 		r:            bufio.NewReaderSize(conn, readBufferSize),
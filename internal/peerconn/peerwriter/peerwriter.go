@@ -6,12 +6,13 @@ import (
 	"encoding/binary"
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/ganqierwu/rain/internal/logger"
 	"github.com/ganqierwu/rain/internal/peerconn/peerreader"
 	"github.com/ganqierwu/rain/internal/peerprotocol"
-	"github.com/juju/ratelimit"
+	"github.com/ganqierwu/rain/internal/speedlimit"
 )
 
 const keepAlivePeriod = 2 * time.Minute
@@ -24,18 +25,18 @@ type PeerWriter struct {
 	writeQueue            *list.List
 	maxQueuedRequests     int
 	fastEnabled           bool
-	currentQueuedRequests int
+	currentQueuedRequests int32 // accessed atomically
 	writeC                chan peerprotocol.Message
 	messages              chan interface{}
 	servedRequests        map[peerprotocol.RequestMessage]struct{}
-	bucket                *ratelimit.Bucket
+	bucket                *speedlimit.Limiter
 	log                   logger.Logger
 	stopC                 chan struct{}
 	doneC                 chan struct{}
 }
 
 // New returns a new PeerWriter by wrapping a net.Conn.
-func New(conn net.Conn, l logger.Logger, maxQueuedRequests int, fastEnabled bool, b *ratelimit.Bucket) *PeerWriter {
+func New(conn net.Conn, l logger.Logger, maxQueuedRequests int, fastEnabled bool, b *speedlimit.Limiter) *PeerWriter {
 	return &PeerWriter{
 		conn:              conn,
 		queueC:            make(chan peerprotocol.Message),
@@ -85,6 +86,11 @@ func (p *PeerWriter) CancelRequest(msg peerprotocol.CancelMessage) {
 	}
 }
 
+// QueuedRequests returns the number of piece requests from the peer that are waiting to be sent.
+func (p *PeerWriter) QueuedRequests() int {
+	return int(atomic.LoadInt32(&p.currentQueuedRequests))
+}
+
 // Stop the writer loop.
 func (p *PeerWriter) Stop() {
 	close(p.stopC)
@@ -118,7 +124,7 @@ func (p *PeerWriter) Run() {
 		case writeC <- msg:
 			p.writeQueue.Remove(e)
 			if _, ok := msg.(Piece); ok {
-				p.currentQueuedRequests--
+				atomic.AddInt32(&p.currentQueuedRequests, -1)
 			}
 		case cm := <-p.cancelC:
 			p.cancelRequest(cm)
@@ -134,7 +140,7 @@ func (p *PeerWriter) queueMessage(msg peerprotocol.Message) {
 		p.cancelQueuedPieceMessages()
 	case Piece:
 		// Reject request if peer queued to many requests
-		if p.currentQueuedRequests >= p.maxQueuedRequests {
+		if int(atomic.LoadInt32(&p.currentQueuedRequests)) >= p.maxQueuedRequests {
 			if p.fastEnabled {
 				msg = peerprotocol.RejectMessage{RequestMessage: msg2.RequestMessage}
 				break
@@ -143,7 +149,7 @@ func (p *PeerWriter) queueMessage(msg peerprotocol.Message) {
 				return
 			}
 		}
-		p.currentQueuedRequests++
+		atomic.AddInt32(&p.currentQueuedRequests, 1)
 	}
 	p.writeQueue.PushBack(msg)
 }
@@ -154,7 +160,7 @@ func (p *PeerWriter) cancelQueuedPieceMessages() {
 		next = e.Next()
 		if _, ok := e.Value.(Piece); ok {
 			p.writeQueue.Remove(e)
-			p.currentQueuedRequests--
+			atomic.AddInt32(&p.currentQueuedRequests, -1)
 		}
 	}
 }
@@ -163,7 +169,7 @@ func (p *PeerWriter) cancelRequest(cm peerprotocol.CancelMessage) {
 	for e := p.writeQueue.Front(); e != nil; e = e.Next() {
 		if pi, ok := e.Value.(Piece); ok && pi.Index == cm.Index && pi.Begin == cm.Begin && pi.Length == cm.Length {
 			p.writeQueue.Remove(e)
-			p.currentQueuedRequests--
+			atomic.AddInt32(&p.currentQueuedRequests, -1)
 			break
 		}
 	}
@@ -17,6 +17,10 @@ const (
 	ExtensionIDMetadata
 	// ExtensionIDPEX is ID for PEX extension messages.
 	ExtensionIDPEX
+	// ExtensionIDUploadOnly is ID for upload_only extension messages.
+	ExtensionIDUploadOnly
+	// ExtensionIDDontHave is ID for lt_donthave extension messages.
+	ExtensionIDDontHave
 )
 
 const (
@@ -24,6 +28,10 @@ const (
 	ExtensionKeyMetadata = "ut_metadata"
 	// ExtensionKeyPEX is the key for the PEX extension.
 	ExtensionKeyPEX = "ut_pex"
+	// ExtensionKeyUploadOnly is the key for the upload_only extension (BEP 21).
+	ExtensionKeyUploadOnly = "upload_only"
+	// ExtensionKeyDontHave is the key for the lt_donthave extension (BEP 54).
+	ExtensionKeyDontHave = "lt_donthave"
 )
 
 const (
@@ -56,6 +64,23 @@ func (m ExtensionMessage) WriteTo(w io.Writer) (n int64, err error) {
 	if err != nil {
 		return
 	}
+	// Payloads of upload_only and lt_donthave messages are not bencoded.
+	switch mm := m.Payload.(type) {
+	case ExtensionUploadOnlyMessage:
+		var b byte
+		if mm.UploadOnly {
+			b = 1
+		}
+		nn, err = w.Write([]byte{b})
+		n += int64(nn)
+		return
+	case ExtensionDontHaveMessage:
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], mm.Index)
+		nn, err = w.Write(b[:])
+		n += int64(nn)
+		return
+	}
 	wc := newWriterCounter(w)
 	err = bencode.NewEncoder(wc).Encode(m.Payload)
 	n += wc.Count()
@@ -100,6 +125,16 @@ func (m *ExtensionMessage) UnmarshalBinary(data []byte) error {
 		var extMsg ExtensionPEXMessage
 		err = dec.Decode(&extMsg)
 		m.Payload = extMsg
+	case ExtensionIDUploadOnly:
+		if len(payload) != 1 {
+			return fmt.Errorf("invalid upload_only message length: %d", len(payload))
+		}
+		m.Payload = ExtensionUploadOnlyMessage{UploadOnly: payload[0] != 0}
+	case ExtensionIDDontHave:
+		if len(payload) != 4 {
+			return fmt.Errorf("invalid lt_donthave message length: %d", len(payload))
+		}
+		m.Payload = ExtensionDontHaveMessage{Index: binary.BigEndian.Uint32(payload)}
 	default:
 		return fmt.Errorf("peer sent invalid extension message id: %d", m.ExtendedMessageID)
 	}
@@ -113,20 +148,27 @@ type ExtensionHandshakeMessage struct {
 	YourIP       string           `bencode:"yourip,omitempty"`
 	MetadataSize int              `bencode:"metadata_size,omitempty"`
 	RequestQueue int              `bencode:"reqq"`
+	UploadOnly   int              `bencode:"upload_only,omitempty"`
 }
 
 // NewExtensionHandshake returns a new ExtensionHandshakeMessage by filling the struct with given values.
-func NewExtensionHandshake(metadataSize uint32, version string, yourip net.IP, requestQueueLength int) ExtensionHandshakeMessage {
-	return ExtensionHandshakeMessage{
+func NewExtensionHandshake(metadataSize uint32, version string, yourip net.IP, requestQueueLength int, uploadOnly bool) ExtensionHandshakeMessage {
+	m := ExtensionHandshakeMessage{
 		M: map[string]uint8{
-			ExtensionKeyMetadata: ExtensionIDMetadata,
-			ExtensionKeyPEX:      ExtensionIDPEX,
+			ExtensionKeyMetadata:   ExtensionIDMetadata,
+			ExtensionKeyPEX:        ExtensionIDPEX,
+			ExtensionKeyUploadOnly: ExtensionIDUploadOnly,
+			ExtensionKeyDontHave:   ExtensionIDDontHave,
 		},
 		V:            version,
 		YourIP:       string(truncateIP(yourip)),
 		MetadataSize: int(metadataSize),
 		RequestQueue: requestQueueLength,
 	}
+	if uploadOnly {
+		m.UploadOnly = 1
+	}
+	return m
 }
 
 // ExtensionMetadataMessage is the message for the Metadata extension.
@@ -143,6 +185,18 @@ type ExtensionPEXMessage struct {
 	Dropped string `bencode:"dropped"`
 }
 
+// ExtensionUploadOnlyMessage is sent when the upload-only state changes after the extension handshake.
+// A peer in upload-only state does not download any more pieces.
+type ExtensionUploadOnlyMessage struct {
+	UploadOnly bool
+}
+
+// ExtensionDontHaveMessage is the message for the lt_donthave extension.
+// It retracts a piece that is previously announced with a Have or Bitfield message.
+type ExtensionDontHaveMessage struct {
+	Index uint32
+}
+
 func truncateIP(ip net.IP) net.IP {
 	ip4 := ip.To4()
 	if ip4 != nil {
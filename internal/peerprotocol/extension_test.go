@@ -0,0 +1,41 @@
+package peerprotocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtensionMessageRawPayload(t *testing.T) {
+	cases := []struct {
+		msg  ExtensionMessage
+		data []byte
+	}{
+		{ExtensionMessage{ExtendedMessageID: ExtensionIDUploadOnly, Payload: ExtensionUploadOnlyMessage{UploadOnly: true}}, []byte{ExtensionIDUploadOnly, 1}},
+		{ExtensionMessage{ExtendedMessageID: ExtensionIDUploadOnly, Payload: ExtensionUploadOnlyMessage{}}, []byte{ExtensionIDUploadOnly, 0}},
+		{ExtensionMessage{ExtendedMessageID: ExtensionIDDontHave, Payload: ExtensionDontHaveMessage{Index: 258}}, []byte{ExtensionIDDontHave, 0, 0, 1, 2}},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		n, err := c.msg.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, int64(len(c.data)), n)
+		assert.Equal(t, c.data, buf.Bytes())
+
+		var msg ExtensionMessage
+		err = msg.UnmarshalBinary(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.msg, msg)
+	}
+}
+
+func TestExtensionMessageInvalidLength(t *testing.T) {
+	var msg ExtensionMessage
+	assert.Error(t, msg.UnmarshalBinary([]byte{ExtensionIDDontHave, 0, 1}))
+	assert.Error(t, msg.UnmarshalBinary([]byte{ExtensionIDUploadOnly}))
+}
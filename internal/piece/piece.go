@@ -21,6 +21,11 @@ type Piece struct {
 	Hash    []byte
 	Writing bool
 	Done    bool
+
+	// Pieces with higher priority are downloaded first.
+	Priority int
+	// Piece is not downloaded because all of the files in the piece are skipped.
+	Skip bool
 }
 
 // Block is part of a Piece that is specified in peerprotocol.Request messages.
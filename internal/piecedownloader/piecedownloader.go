@@ -2,6 +2,7 @@ package piecedownloader
 
 import (
 	"errors"
+	"time"
 
 	"github.com/ganqierwu/rain/internal/bufferpool"
 	"github.com/ganqierwu/rain/internal/piece"
@@ -15,15 +16,18 @@ var (
 )
 
 // PieceDownloader downloads all blocks of a piece from a peer.
+// If Blocks is set, the piece is downloaded from multiple peers at the same time
+// and only the blocks assigned to the peer are requested.
 type PieceDownloader struct {
 	Piece       *piece.Piece
 	Peer        Peer
 	AllowedFast bool
 	Buffer      bufferpool.Buffer
+	Blocks      Blocks
 
 	remaining []int
-	pending   map[int]struct{} // in-flight requests
-	done      map[int]struct{} // downloaded requests
+	pending   map[int]time.Time // in-flight requests and their request times
+	done      map[int]struct{}  // downloaded requests
 }
 
 // Peer of a Torrent.
@@ -33,6 +37,19 @@ type Peer interface {
 	EnabledFast() bool
 }
 
+// Blocks assigns the blocks of a piece to peers when the piece is downloaded from multiple peers at the same time.
+// PieceDownloaders of the same piece share the Blocks and the Buffer.
+type Blocks interface {
+	// Pick returns the index of the next block to request from the peer. Blocks that are pending at the peer are skipped.
+	Pick(pe Peer, pending func(i int) bool) (int, bool)
+	// Release makes the block available to other peers.
+	Release(pe Peer, i int)
+	// Received marks the block as received from the peer. Returns false if the block is already received from any peer.
+	Received(pe Peer, i int) bool
+	// Done returns true if all blocks of the piece are received.
+	Done() bool
+}
+
 // New returns a new PieceDownloader.
 func New(pi *piece.Piece, pe Peer, allowedFast bool, buf bufferpool.Buffer) *PieceDownloader {
 	remaining := make([]int, pi.NumBlocks())
@@ -45,7 +62,21 @@ func New(pi *piece.Piece, pe Peer, allowedFast bool, buf bufferpool.Buffer) *Pie
 		AllowedFast: allowedFast,
 		Buffer:      buf,
 		remaining:   remaining,
-		pending:     make(map[int]struct{}),
+		pending:     make(map[int]time.Time),
+		done:        make(map[int]struct{}),
+	}
+}
+
+// NewShared returns a new PieceDownloader for a piece that is downloaded from multiple peers.
+// buf must be the buffer of other PieceDownloaders of the same piece.
+func NewShared(pi *piece.Piece, pe Peer, allowedFast bool, buf bufferpool.Buffer, blocks Blocks) *PieceDownloader {
+	return &PieceDownloader{
+		Piece:       pi,
+		Peer:        pe,
+		AllowedFast: allowedFast,
+		Buffer:      buf,
+		Blocks:      blocks,
+		pending:     make(map[int]time.Time),
 		done:        make(map[int]struct{}),
 	}
 }
@@ -61,12 +92,19 @@ func (d *PieceDownloader) Choked() {
 	}
 	for i := range d.pending {
 		delete(d.pending, i)
-		d.remaining = append(d.remaining, i)
+		if d.Blocks != nil {
+			d.Blocks.Release(d.Peer, i)
+		} else {
+			d.remaining = append(d.remaining, i)
+		}
 	}
 }
 
 // GotBlock must be called when a block is received from the piece.
 func (d *PieceDownloader) GotBlock(block piece.Block, data []byte) error {
+	if d.Blocks != nil {
+		return d.gotSharedBlock(block, data)
+	}
 	var err error
 	if _, ok := d.done[block.Index]; ok {
 		return ErrBlockDuplicate
@@ -79,9 +117,28 @@ func (d *PieceDownloader) GotBlock(block piece.Block, data []byte) error {
 	return err
 }
 
+func (d *PieceDownloader) gotSharedBlock(block piece.Block, data []byte) error {
+	_, requested := d.pending[block.Index]
+	delete(d.pending, block.Index)
+	// Block may be received from another peer in endgame mode or after this peer is snubbed.
+	if !d.Blocks.Received(d.Peer, block.Index) {
+		return ErrBlockDuplicate
+	}
+	copy(d.Buffer.Data[block.Begin:block.Begin+block.Length], data)
+	d.done[block.Index] = struct{}{}
+	if !requested {
+		return ErrBlockNotRequested
+	}
+	return nil
+}
+
 // Rejected must be called when the peer has rejected a piece request.
 func (d *PieceDownloader) Rejected(block piece.Block) {
 	delete(d.pending, block.Index)
+	if d.Blocks != nil {
+		d.Blocks.Release(d.Peer, block.Index)
+		return
+	}
 	d.remaining = append(d.remaining, block.Index)
 }
 
@@ -99,6 +156,10 @@ func (d *PieceDownloader) CancelPending() {
 
 // RequestBlocks is called to request remaining blocks of the piece up to `queueLength`.
 func (d *PieceDownloader) RequestBlocks(queueLength int) {
+	if d.Blocks != nil {
+		d.requestSharedBlocks(queueLength)
+		return
+	}
 	remaining := d.remaining
 	for _, i := range remaining {
 		if len(d.pending) >= queueLength {
@@ -112,11 +173,44 @@ func (d *PieceDownloader) RequestBlocks(queueLength int) {
 			d.Peer.RequestPiece(d.Piece.Index, b.Begin, b.Length)
 		}
 		d.remaining = d.remaining[1:]
-		d.pending[i] = struct{}{}
+		d.pending[i] = time.Now()
+	}
+}
+
+func (d *PieceDownloader) requestSharedBlocks(queueLength int) {
+	isPending := func(i int) bool {
+		_, ok := d.pending[i]
+		return ok
+	}
+	for len(d.pending) < queueLength {
+		i, ok := d.Blocks.Pick(d.Peer, isPending)
+		if !ok {
+			break
+		}
+		b, ok := d.Piece.GetBlock(i)
+		if !ok {
+			panic("cannot get block")
+		}
+		d.Peer.RequestPiece(d.Piece.Index, b.Begin, b.Length)
+		d.pending[i] = time.Now()
 	}
 }
 
+// RequestedAt returns the time that the block is requested. Returns false if the block is not pending.
+func (d *PieceDownloader) RequestedAt(block piece.Block) (time.Time, bool) {
+	t, ok := d.pending[block.Index]
+	return t, ok
+}
+
+// Pending returns the number of blocks that are requested but not received yet.
+func (d *PieceDownloader) Pending() int {
+	return len(d.pending)
+}
+
 // Done returns true if all blocks of the piece has been downloaded.
 func (d *PieceDownloader) Done() bool {
+	if d.Blocks != nil {
+		return d.Blocks.Done()
+	}
 	return len(d.done) == d.Piece.NumBlocks()
 }
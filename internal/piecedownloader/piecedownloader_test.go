@@ -145,3 +145,76 @@ func TestPieceDownloader(t *testing.T) {
 	assert.Equal(t, 11, len(d.done))
 	assert.True(t, d.Done())
 }
+
+// testBlocks assigns blocks in order and does not give a block to two peers.
+type testBlocks struct {
+	requested map[int]Peer
+	received  map[int]Peer
+	numBlocks int
+}
+
+func (b *testBlocks) Pick(pe Peer, pending func(i int) bool) (int, bool) {
+	for i := 0; i < b.numBlocks; i++ {
+		if _, ok := b.requested[i]; !ok && !pending(i) {
+			b.requested[i] = pe
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (b *testBlocks) Release(pe Peer, i int) {
+	if b.requested[i] == pe {
+		delete(b.requested, i)
+	}
+}
+
+func (b *testBlocks) Received(pe Peer, i int) bool {
+	if _, ok := b.received[i]; ok {
+		return false
+	}
+	b.received[i] = pe
+	return true
+}
+
+func (b *testBlocks) Done() bool { return len(b.received) == b.numBlocks }
+
+func TestSharedPieceDownloader(t *testing.T) {
+	bp := bufferpool.New(4 * blockSize)
+	buf := bp.Get(4 * blockSize)
+	pi := &piece.Piece{
+		Index:  1,
+		Length: 4 * blockSize,
+	}
+	blocks := &testBlocks{requested: make(map[int]Peer), received: make(map[int]Peer), numBlocks: 4}
+	pe1, pe2 := &TestPeer{}, &TestPeer{}
+	d1 := NewShared(pi, pe1, false, buf, blocks)
+	d2 := NewShared(pi, pe2, false, buf, blocks)
+
+	d1.RequestBlocks(2)
+	d2.RequestBlocks(3)
+	assert.Equal(t, []Message{
+		{Index: 1, Begin: 0 * blockSize, Length: blockSize},
+		{Index: 1, Begin: 1 * blockSize, Length: blockSize},
+	}, pe1.requested)
+	assert.Equal(t, []Message{
+		{Index: 1, Begin: 2 * blockSize, Length: blockSize},
+		{Index: 1, Begin: 3 * blockSize, Length: blockSize},
+	}, pe2.requested)
+
+	d2.Choked()
+	assert.Equal(t, 0, d2.Pending())
+	d1.RequestBlocks(4)
+	assert.Equal(t, 4, d1.Pending())
+
+	data := make([]byte, blockSize)
+	for i := 0; i < 4; i++ {
+		data[0] = byte(i)
+		assert.Nil(t, d1.GotBlock(piece.Block{Index: i, Begin: uint32(i) * blockSize, Length: blockSize}, data))
+	}
+	// Block sent by the choked peer anyway.
+	assert.Equal(t, ErrBlockDuplicate, d2.GotBlock(piece.Block{Index: 3, Begin: 3 * blockSize, Length: blockSize}, make([]byte, blockSize)))
+	assert.Equal(t, byte(3), buf.Data[3*blockSize])
+	assert.True(t, d1.Done())
+	assert.True(t, d2.Done())
+}
@@ -0,0 +1,90 @@
+package piecepicker
+
+import (
+	"github.com/ganqierwu/rain/internal/piecedownloader"
+)
+
+// Blocks keeps the assignment of blocks to peers for a piece that is downloaded from multiple peers at the same time.
+// It implements piecedownloader.Blocks.
+type Blocks struct {
+	picker *PiecePicker
+	// Peer that the block is requested from. Nil if the block is not requested or the request is released.
+	requested []piecedownloader.Peer
+	// Peer that has sent the block. Nil if the block is not received yet.
+	received    []piecedownloader.Peer
+	numReceived int
+}
+
+var _ piecedownloader.Blocks = (*Blocks)(nil)
+
+func newBlocks(p *PiecePicker, numBlocks int) *Blocks {
+	return &Blocks{
+		picker:    p,
+		requested: make([]piecedownloader.Peer, numBlocks),
+		received:  make([]piecedownloader.Peer, numBlocks),
+	}
+}
+
+// Pick returns the first block that is not requested from any peer.
+// In endgame mode, blocks requested from other peers may be picked if they are not received yet.
+func (b *Blocks) Pick(pe piecedownloader.Peer, pending func(i int) bool) (int, bool) {
+	for i := range b.requested {
+		if b.received[i] == nil && b.requested[i] == nil && !pending(i) {
+			b.requested[i] = pe
+			return i, true
+		}
+	}
+	if !b.picker.endgame {
+		return 0, false
+	}
+	for i := range b.requested {
+		if b.received[i] == nil && !pending(i) {
+			b.requested[i] = pe
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Release makes the block available to other peers if it is requested from the peer.
+func (b *Blocks) Release(pe piecedownloader.Peer, i int) {
+	if b.requested[i] == pe {
+		b.requested[i] = nil
+	}
+}
+
+// Received marks the block as received from the peer.
+func (b *Blocks) Received(pe piecedownloader.Peer, i int) bool {
+	if b.received[i] != nil {
+		return false
+	}
+	b.received[i] = pe
+	b.numReceived++
+	return true
+}
+
+// Done returns true if all blocks are received.
+func (b *Blocks) Done() bool {
+	return b.numReceived == len(b.received)
+}
+
+// Sources returns the peers that have sent the blocks, in the order of blocks.
+func (b *Blocks) Sources() []piecedownloader.Peer {
+	return append([]piecedownloader.Peer(nil), b.received...)
+}
+
+func (b *Blocks) hasUnrequested() bool {
+	for i := range b.requested {
+		if b.received[i] == nil && b.requested[i] == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// releasePeer makes all blocks requested from the peer available to other peers.
+func (b *Blocks) releasePeer(pe piecedownloader.Peer) {
+	for i := range b.requested {
+		b.Release(pe, i)
+	}
+}
@@ -17,11 +17,14 @@ These are the things to consider when selecting a piece for downloading:
 
   * Piece is done (hash checked and written to disk)
   * Piece is writing
+  * Piece is skipped or has a different priority
   * Peer has the piece
   * Peer is choking us
   * Piece is marked as allowed-fast
   * Piece is requested from another peers
   * Piece is reserved for downloading by a webseed source
+  * Piece is downloaded from multiple peers and has unrequested blocks
+  * Peer has sent a corrupt copy of the piece before
   * Is endgame mode activated (all pieces are requested)
   * Are there stalled peers (snubbed or choked in the middle of download)
 
@@ -39,6 +42,11 @@ type PiecePicker struct {
 	maxDuplicateDownload int
 	available            uint32
 	endgame              bool
+
+	// Pieces at least this long are downloaded from multiple peers. Zero disables multi-peer downloads.
+	multiPeerPieceLength uint32
+	// Multi-peer downloads are paused to find out the peer that has sent a corrupt block.
+	multiPeerPaused bool
 }
 
 type myPiece struct {
@@ -50,6 +58,40 @@ type myPiece struct {
 
 	// Downloading from webseed source or marked to be downloaded later.
 	RequestedWebseed *webseedsource.WebseedSource
+
+	// Set if the piece is being downloaded from multiple peers.
+	Blocks *Blocks
+
+	// IPs of the peers that have sent a copy of the piece that failed the hash check.
+	Suspects []string
+}
+
+// HasFor returns true if the piece can be downloaded from the peer.
+// Peers that have sent a corrupt copy of the piece are avoided while other peers have the piece,
+// so the next copy can be compared with the corrupt one to find out the bad peer.
+func (p *myPiece) HasFor(pe *peer.Peer) bool {
+	if !p.Having.Has(pe) {
+		return false
+	}
+	if len(p.Suspects) == 0 || !p.isSuspect(pe) {
+		return true
+	}
+	for _, pe2 := range p.Having.Items {
+		if !p.isSuspect(pe2) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *myPiece) isSuspect(pe *peer.Peer) bool {
+	ip := pe.IP()
+	for _, s := range p.Suspects {
+		if s == ip {
+			return true
+		}
+	}
+	return false
 }
 
 // RunningDownloads returns the number of pieces that are being downloaded actively.
@@ -66,7 +108,7 @@ func (p *myPiece) StalledDownloads() int {
 // AvailableForWebseed returns true if the piece can be downloaded from a webseed source.
 // If the piece is already requested from a peer, it does not become eligible for downloading from webseed until entering the endgame mode.
 func (p *myPiece) AvailableForWebseed(duplicate bool) bool {
-	if p.Done || p.Writing || p.RequestedWebseed != nil {
+	if p.Done || p.Writing || p.Skip || p.RequestedWebseed != nil {
 		return false
 	}
 	if !duplicate {
@@ -76,7 +118,8 @@ func (p *myPiece) AvailableForWebseed(duplicate bool) bool {
 }
 
 // New returns a new PiecePicker.
-func New(pieces []piece.Piece, maxDuplicateDownload int, webseedSources []*webseedsource.WebseedSource) *PiecePicker {
+// Blocks of pieces that are at least multiPeerPieceLength long are requested from multiple peers at the same time.
+func New(pieces []piece.Piece, maxDuplicateDownload int, multiPeerPieceLength uint32, webseedSources []*webseedsource.WebseedSource) *PiecePicker {
 	ps := make([]myPiece, len(pieces))
 	for i := range pieces {
 		ps[i] = myPiece{Piece: &pieces[i]}
@@ -92,10 +135,23 @@ func New(pieces []piece.Piece, maxDuplicateDownload int, webseedSources []*webse
 		piecesByAvailability: sps,
 		piecesByStalled:      sps2,
 		maxDuplicateDownload: maxDuplicateDownload,
+		multiPeerPieceLength: multiPeerPieceLength,
 		webseedSources:       webseedSources,
 	}
 }
 
+// SetMultiPeer enables or disables downloading new pieces from multiple peers.
+// When disabled, each piece is downloaded from a single peer so a corrupt piece points to the peer that sent it.
+// Running multi-peer downloads are not affected.
+func (p *PiecePicker) SetMultiPeer(enabled bool) {
+	p.multiPeerPaused = !enabled
+}
+
+// Blocks returns the block assignment of the piece if it is being downloaded from multiple peers, otherwise nil.
+func (p *PiecePicker) Blocks(i uint32) *Blocks {
+	return p.pieces[i].Blocks
+}
+
 // CloseWebseedDownloader closes the download from a webseed source.
 func (p *PiecePicker) CloseWebseedDownloader(src *webseedsource.WebseedSource) {
 	src.DownloadSpeed.Stop()
@@ -136,6 +192,11 @@ func (p *PiecePicker) Available() uint32 {
 	return p.available
 }
 
+// HavingPeers returns the number of connected peers that have the piece with the index.
+func (p *PiecePicker) HavingPeers(i uint32) int {
+	return p.pieces[i].Having.Len()
+}
+
 // RequestedPeers returns the number of peers that the piece with the index is requested from.
 func (p *PiecePicker) RequestedPeers(i uint32) []*peer.Peer {
 	return p.pieces[i].Requested.Items
@@ -146,12 +207,41 @@ func (p *PiecePicker) RequestedWebseedSource(i uint32) *webseedsource.WebseedSou
 	return p.pieces[i].RequestedWebseed
 }
 
+// HandleCorrupt must be called when a downloaded copy of the piece fails the hash check.
+// The piece is downloaded again from other peers than the ones at ips, if possible.
+func (p *PiecePicker) HandleCorrupt(i uint32, ips []string) {
+	mp := &p.pieces[i]
+	for _, ip := range ips {
+		found := false
+		for _, s := range mp.Suspects {
+			if s == ip {
+				found = true
+				break
+			}
+		}
+		if !found {
+			mp.Suspects = append(mp.Suspects, ip)
+		}
+	}
+}
+
+// HandleVerified must be called when the piece passes the hash check.
+func (p *PiecePicker) HandleVerified(i uint32) {
+	p.pieces[i].Suspects = nil
+}
+
 // HandleHave must be called to set the availability of the piece at the peer.
 func (p *PiecePicker) HandleHave(pe *peer.Peer, i uint32) {
 	pe.Bitfield.Set(i)
 	p.addHavingPeer(i, pe)
 }
 
+// HandleDontHave must be called to unset the availability of the piece at the peer.
+func (p *PiecePicker) HandleDontHave(pe *peer.Peer, i uint32) {
+	pe.Bitfield.Clear(i)
+	p.removeHavingPeer(int(i), pe)
+}
+
 // HandleAllowedFast must be called to set the allowed-fast status of the piece at peer.
 func (p *PiecePicker) HandleAllowedFast(pe *peer.Peer, i uint32) {
 	pe.ReceivedAllowedFast.Add(p.pieces[i].Piece)
@@ -163,12 +253,19 @@ func (p *PiecePicker) HandleSnubbed(pe *peer.Peer, i uint32) {
 		panic("peer snubbed while choked")
 	}
 	p.pieces[i].Snubbed.Add(pe)
+	// Let other peers download the blocks of the snubbed peer.
+	if p.pieces[i].Blocks != nil {
+		p.pieces[i].Blocks.releasePeer(pe)
+	}
 }
 
 // HandleChoke must be called to set choke status of the remote peer.
 func (p *PiecePicker) HandleChoke(pe *peer.Peer, i uint32) {
 	p.pieces[i].Snubbed.Remove(pe)
 	p.pieces[i].Choked.Add(pe)
+	if p.pieces[i].Blocks != nil {
+		p.pieces[i].Blocks.releasePeer(pe)
+	}
 }
 
 // HandleUnchoke must be called to unset choke status of the remote peer.
@@ -178,8 +275,16 @@ func (p *PiecePicker) HandleUnchoke(pe *peer.Peer, i uint32) {
 
 // HandleCancelDownload must be called to update indexes when a piece download is canceled from the peer.
 func (p *PiecePicker) HandleCancelDownload(pe *peer.Peer, i uint32) {
-	p.pieces[i].Requested.Remove(pe)
-	p.pieces[i].Snubbed.Remove(pe)
+	mp := &p.pieces[i]
+	mp.Requested.Remove(pe)
+	mp.Snubbed.Remove(pe)
+	if mp.Blocks != nil {
+		mp.Blocks.releasePeer(pe)
+		// Received blocks are lost with the buffer when the last peer stops downloading.
+		if mp.Requested.Len() == 0 {
+			mp.Blocks = nil
+		}
+	}
 }
 
 // HandleDisconnect must be called to remove the peer from internal indexes.
@@ -211,10 +316,17 @@ func (p *PiecePicker) PickFor(pe *peer.Peer) (pp *piece.Piece, allowedFast bool)
 		return nil, false
 	}
 	pe.Snubbed = false
+	if pi.Blocks == nil && pi.Requested.Len() == 0 && !allowedFast && p.multiPeer(pi) {
+		pi.Blocks = newBlocks(p, pi.NumBlocks())
+	}
 	pi.Requested.Add(pe)
 	return pi.Piece, allowedFast
 }
 
+func (p *PiecePicker) multiPeer(mp *myPiece) bool {
+	return p.multiPeerPieceLength > 0 && !p.multiPeerPaused && mp.Length >= p.multiPeerPieceLength
+}
+
 func (p *PiecePicker) findPiece(pe *peer.Peer) (mp *myPiece, allowedFast bool) {
 	// Peer is allowed to download only one piece at a time
 	if pe.Downloading {
@@ -243,6 +355,11 @@ func (p *PiecePicker) findPiece(pe *peer.Peer) (mp *myPiece, allowedFast bool) {
 	if pe.PeerChoking {
 		return nil, false
 	}
+	// Join a piece that is downloaded from multiple peers
+	pi = p.pickShared(pe)
+	if pi != nil {
+		return pi, false
+	}
 	// Short path for endgame mode.
 	if p.endgame {
 		return p.pickEndgame(pe), false
@@ -263,10 +380,26 @@ func (p *PiecePicker) findPiece(pe *peer.Peer) (mp *myPiece, allowedFast bool) {
 func (p *PiecePicker) pickAllowedFast(pe *peer.Peer) *myPiece {
 	for _, pi := range pe.ReceivedAllowedFast.Items {
 		mp := &p.pieces[pi.Index]
-		if mp.Done || mp.Writing {
+		if mp.Done || mp.Writing || mp.Skip {
 			continue
 		}
-		if mp.Requested.Len() == 0 && mp.Having.Has(pe) {
+		if mp.Requested.Len() == 0 && mp.HasFor(pe) {
+			return mp
+		}
+	}
+	return nil
+}
+
+func (p *PiecePicker) pickShared(pe *peer.Peer) *myPiece {
+	if p.multiPeerPaused {
+		return nil
+	}
+	for i := range p.pieces {
+		mp := &p.pieces[i]
+		if mp.Blocks == nil || mp.Done || mp.Writing || mp.Skip {
+			continue
+		}
+		if mp.HasFor(pe) && mp.Blocks.hasUnrequested() {
 			return mp
 		}
 	}
@@ -274,18 +407,22 @@ func (p *PiecePicker) pickAllowedFast(pe *peer.Peer) *myPiece {
 }
 
 func (p *PiecePicker) pickRarest(pe *peer.Peer) *myPiece {
-	// Sort by rarity
+	// Sort by priority, then by rarity
 	sort.Slice(p.piecesByAvailability, func(i, j int) bool {
-		return len(p.piecesByAvailability[i].Having.Items) < len(p.piecesByAvailability[j].Having.Items)
+		pi, pj := p.piecesByAvailability[i], p.piecesByAvailability[j]
+		if pi.Priority != pj.Priority {
+			return pi.Priority > pj.Priority
+		}
+		return len(pi.Having.Items) < len(pj.Having.Items)
 	})
 	var picked *myPiece
 	var hasUnrequested bool
 	// Select unrequested piece
 	for _, mp := range p.piecesByAvailability {
-		if mp.Done || mp.Writing {
+		if mp.Done || mp.Writing || mp.Skip {
 			continue
 		}
-		if mp.Requested.Len() == 0 && mp.Having.Has(pe) {
+		if mp.Requested.Len() == 0 && mp.HasFor(pe) {
 			picked = mp
 			break
 		}
@@ -306,10 +443,10 @@ func (p *PiecePicker) pickEndgame(pe *peer.Peer) *myPiece {
 	})
 	// Select unrequested piece
 	for _, mp := range p.piecesByAvailability {
-		if mp.Done || mp.Writing {
+		if mp.Done || mp.Writing || mp.Skip {
 			continue
 		}
-		if mp.Requested.Len() < p.maxDuplicateDownload && mp.Having.Has(pe) {
+		if mp.Requested.Len() < p.maxDuplicateDownload && mp.HasFor(pe) {
 			return mp
 		}
 	}
@@ -323,13 +460,13 @@ func (p *PiecePicker) pickStalled(pe *peer.Peer) *myPiece {
 	})
 	// Select unrequested piece
 	for _, mp := range p.piecesByStalled {
-		if mp.Done || mp.Writing {
+		if mp.Done || mp.Writing || mp.Skip {
 			continue
 		}
 		if mp.RunningDownloads() > 0 {
 			continue
 		}
-		if mp.Requested.Len() < p.maxDuplicateDownload && mp.Having.Has(pe) {
+		if mp.Requested.Len() < p.maxDuplicateDownload && mp.HasFor(pe) {
 			return mp
 		}
 	}
@@ -1,11 +1,15 @@
 package piecepicker
 
 import (
+	"net"
 	"testing"
 
 	"github.com/ganqierwu/rain/internal/bitfield"
+	"github.com/ganqierwu/rain/internal/logger"
 	"github.com/ganqierwu/rain/internal/peer"
+	"github.com/ganqierwu/rain/internal/peerconn"
 	"github.com/ganqierwu/rain/internal/piece"
+	"github.com/ganqierwu/rain/internal/piecedownloader"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,7 +30,7 @@ func TestPiecePicker(t *testing.T) {
 	pieces[0].Done = true
 	pieces[2].Done = true
 	pieces[3].Done = true
-	pp := New(pieces, 2, nil)
+	pp := New(pieces, 2, 0, nil)
 	pp.HandleHave(peers[0], 1)
 	pp.HandleHave(peers[0], 3)
 	pp.HandleHave(peers[0], 4)
@@ -69,6 +73,24 @@ func TestPiecePicker(t *testing.T) {
 	assert.True(t, pp.endgame)
 }
 
+func TestHandleDontHave(t *testing.T) {
+	pieces := make([]piece.Piece, numPieces)
+	for i := range pieces {
+		pieces[i] = newPiece(i)
+	}
+	pe := newPeer(0)
+	pp := New(pieces, 2, 0, nil)
+	pp.HandleHave(pe, 1)
+	pp.HandleHave(pe, 2)
+	assert.Equal(t, uint32(2), pp.Available())
+
+	pp.HandleDontHave(pe, 1)
+	assert.False(t, pe.Bitfield.Test(1))
+	assert.Equal(t, 0, pp.HavingPeers(1))
+	assert.Equal(t, uint32(1), pp.Available())
+	assert.Equal(t, &pieces[2], pp.pickFor(pe))
+}
+
 func newPiece(i int) piece.Piece {
 	return piece.Piece{Index: uint32(i)}
 }
@@ -84,3 +106,99 @@ func (p *PiecePicker) pickFor(pe *peer.Peer) *piece.Piece {
 	pi, _ := p.PickFor(pe)
 	return pi
 }
+
+func TestMultiPeer(t *testing.T) {
+	pieces := make([]piece.Piece, numPieces)
+	for i := range pieces {
+		pieces[i] = newPiece(i)
+		pieces[i].Length = 4 * piece.BlockSize
+	}
+	pe0, pe1 := newPeer(0), newPeer(1)
+	pp := New(pieces, 2, 4*piece.BlockSize, nil)
+	pp.HandleHave(pe0, 1)
+	pp.HandleHave(pe1, 1)
+	pp.HandleHave(pe1, 2)
+	notPending := func(i int) bool { return false }
+
+	assert.Equal(t, &pieces[1], pp.pickFor(pe0))
+	blocks := pp.Blocks(1)
+	if blocks == nil {
+		t.Fatal("piece is not shared")
+	}
+	for _, i := range []int{0, 1} {
+		bi, ok := blocks.Pick(pe0, notPending)
+		assert.True(t, ok)
+		assert.Equal(t, i, bi)
+	}
+
+	// Second peer joins the same piece and gets the remaining blocks.
+	assert.Equal(t, &pieces[1], pp.pickFor(pe1))
+	for _, i := range []int{2, 3} {
+		bi, ok := blocks.Pick(pe1, notPending)
+		assert.True(t, ok)
+		assert.Equal(t, i, bi)
+	}
+	_, ok := blocks.Pick(pe1, notPending)
+	assert.False(t, ok)
+
+	// Blocks of the snubbed peer are given to the other peer.
+	pp.HandleSnubbed(pe1, 1)
+	bi, ok := blocks.Pick(pe0, notPending)
+	assert.True(t, ok)
+	assert.Equal(t, 2, bi)
+
+	assert.True(t, blocks.Received(pe0, 0))
+	assert.True(t, blocks.Received(pe0, 1))
+	assert.True(t, blocks.Received(pe0, 2))
+	assert.True(t, blocks.Received(pe1, 3))
+	assert.False(t, blocks.Received(pe0, 3))
+	assert.True(t, blocks.Done())
+	assert.Equal(t, []piecedownloader.Peer{pe0, pe0, pe0, pe1}, blocks.Sources())
+
+	pp.HandleCancelDownload(pe0, 1)
+	pp.HandleCancelDownload(pe1, 1)
+	assert.Nil(t, pp.Blocks(1))
+
+	// Pieces are downloaded from single peers after a corrupt piece.
+	pp.SetMultiPeer(false)
+	assert.Equal(t, &pieces[2], pp.pickFor(pe1))
+	assert.Nil(t, pp.Blocks(2))
+}
+
+type addrConn struct {
+	net.Conn
+	addr *net.TCPAddr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func newPeerWithIP(i int, ip string) *peer.Peer {
+	pe := newPeer(i)
+	conn := addrConn{addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 6881}}
+	pe.Conn = peerconn.New(conn, logger.New("peer"), 0, 0, false, nil, nil)
+	return pe
+}
+
+func TestCorruptPiece(t *testing.T) {
+	pieces := make([]piece.Piece, numPieces)
+	for i := range pieces {
+		pieces[i] = newPiece(i)
+	}
+	bad, good := newPeerWithIP(0, "1.1.1.1"), newPeerWithIP(1, "2.2.2.2")
+	pp := New(pieces, 2, 0, nil)
+	pp.HandleHave(bad, 1)
+
+	// Piece is downloaded again from the suspect if no other peer has it.
+	pp.HandleCorrupt(1, []string{"1.1.1.1"})
+	assert.Equal(t, &pieces[1], pp.pickFor(bad))
+	pp.HandleCancelDownload(bad, 1)
+
+	// Other peers are preferred to compare the blocks with the corrupt copy.
+	pp.HandleHave(good, 1)
+	assert.Nil(t, pp.pickFor(bad))
+	assert.Equal(t, &pieces[1], pp.pickFor(good))
+	pp.HandleCancelDownload(good, 1)
+
+	pp.HandleVerified(1)
+	assert.Equal(t, &pieces[1], pp.pickFor(bad))
+}
@@ -84,7 +84,7 @@ func (p *PiecePicker) peerStealsFromWebseed(pe *peer.Peer) *myPiece {
 		}
 		for i := src.Downloader.End - 1; i > src.Downloader.ReadCurrent(); i-- {
 			pi := &p.pieces[i]
-			if pi.Done || pi.Writing {
+			if pi.Done || pi.Writing || pi.Skip {
 				continue
 			}
 			if !pi.Having.Has(pe) {
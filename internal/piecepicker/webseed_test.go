@@ -14,6 +14,6 @@ func TestPickLastPieceOfSmallestGap(t *testing.T) {
 	}
 	pieces[1].Done = true
 	peer := newPeer(0)
-	pp := New(pieces, 2, nil)
+	pp := New(pieces, 2, 0, nil)
 	assert.Nil(t, pp.pickLastPieceOfSmallestGap(peer))
 }
@@ -5,7 +5,8 @@ import (
 
 	"github.com/ganqierwu/rain/internal/bufferpool"
 	"github.com/ganqierwu/rain/internal/piece"
-	"github.com/ganqierwu/rain/internal/semaphore"
+	"github.com/ganqierwu/rain/internal/smartban"
+	"github.com/ganqierwu/rain/internal/writequeue"
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -14,9 +15,17 @@ type PieceWriter struct {
 	Piece  *piece.Piece
 	Source interface{}
 	Buffer bufferpool.Buffer
+	// Calculate hashes of blocks even if the piece passes the hash check.
+	HashBlocks bool
+	// Addresses of the peers that have sent the blocks, set if the piece is downloaded from multiple peers.
+	BlockSources []string
+	// Flush the written data to the disk before returning the result.
+	Sync bool
 
 	HashOK bool
-	Error  error
+	// Hashes of blocks in the piece. Set if HashBlocks is true or the piece fails the hash check.
+	BlockHashes []smartban.Hash
+	Error       error
 }
 
 // New returns new PieceWriter for a given piece.
@@ -29,14 +38,16 @@ func New(p *piece.Piece, source interface{}, buf bufferpool.Buffer) *PieceWriter
 }
 
 // Run checks the hash, then writes the data in the buffer to the disk.
-func (w *PieceWriter) Run(resultC chan *PieceWriter, closeC chan struct{}, writesPerSecond, writeBytesPerSecond metrics.Meter, sem *semaphore.Semaphore) {
+// Adjacent pieces of the same owner that are waiting in the queue are written together.
+func (w *PieceWriter) Run(resultC chan *PieceWriter, closeC chan struct{}, writesPerSecond, writeBytesPerSecond metrics.Meter, queue *writequeue.Queue, owner interface{}) {
 	w.HashOK = w.Piece.VerifyHash(w.Buffer.Data, sha1.New())
+	if w.HashBlocks || !w.HashOK {
+		w.BlockHashes = smartban.HashBlocks(w.Buffer.Data)
+	}
 	if w.HashOK {
 		writesPerSecond.Mark(1)
 		writeBytesPerSecond.Mark(int64(len(w.Buffer.Data)))
-		sem.Wait()
-		_, w.Error = w.Piece.Data.Write(w.Buffer.Data)
-		sem.Signal()
+		w.Error = queue.Write(owner, w.Piece, w.Buffer.Data, w.Sync)
 	}
 	select {
 	case resultC <- w:
@@ -0,0 +1,164 @@
+// Package requestqueue calculates the number of outstanding block requests for a peer.
+//
+// The queue is sized by the bandwidth-delay product of the peer: the measured download rate
+// multiplied by the minimum observed block latency gives the number of blocks in flight
+// that keep the connection busy. Twice of that is requested to absorb the variation in latency.
+// Because the measured rate is bounded by the queue depth, the queue grows quickly
+// while the peer can send faster and stops growing when the rate does not increase any more.
+package requestqueue
+
+import (
+	"time"
+
+	"github.com/ganqierwu/rain/internal/piece"
+)
+
+const (
+	// Download rate is calculated over windows of this duration.
+	rateWindow = time.Second
+	// Minimum latency is forgotten after this duration to follow the changes in the path.
+	minLatencyWindow = 30 * time.Second
+	// Timeout is not decreased below this value to tolerate short stalls.
+	minTimeout = 2 * time.Second
+)
+
+// Queue keeps the request queue depth and the request timeout of a peer.
+// It is not safe for concurrent use.
+type Queue struct {
+	min, max   int
+	maxTimeout time.Duration
+
+	depth int
+
+	// Smoothed latency and its variation, calculated as in RFC 6298.
+	latency    time.Duration
+	latencyVar time.Duration
+	// Backoff multiplier of timeout, doubled on each timeout and reset when a block is received.
+	backoff time.Duration
+
+	minLatency   time.Duration
+	minLatencyAt time.Time
+
+	// Download rate in bytes per second.
+	rate        float64
+	windowStart time.Time
+	windowBytes int64
+}
+
+// New returns a new Queue. Depth starts from initial and is kept between min and max.
+// maxTimeout is used until the latency is measured and timeout never exceeds it.
+func New(initial, min, max int, maxTimeout time.Duration) *Queue {
+	q := &Queue{
+		min:        min,
+		max:        max,
+		maxTimeout: maxTimeout,
+		depth:      initial,
+		backoff:    1,
+	}
+	q.depth = q.clamp(q.depth)
+	return q
+}
+
+// Depth returns the number of blocks that should be requested from the peer but not received yet.
+func (q *Queue) Depth() int {
+	return q.depth
+}
+
+// Latency returns the smoothed time between requesting a block and receiving it.
+// Returns zero if no block is received yet.
+func (q *Queue) Latency() time.Duration {
+	return q.latency
+}
+
+// Timeout returns the duration to wait for the next block before marking the peer as snubbed.
+func (q *Queue) Timeout() time.Duration {
+	if q.latency == 0 {
+		return q.maxTimeout
+	}
+	t := (q.latency + 4*q.latencyVar) * q.backoff
+	if t < minTimeout {
+		t = minTimeout
+	}
+	if t > q.maxTimeout {
+		t = q.maxTimeout
+	}
+	return t
+}
+
+// Received must be called when a requested block of length bytes is received after latency.
+func (q *Queue) Received(length int, latency time.Duration, now time.Time) {
+	q.backoff = 1
+	q.updateLatency(latency, now)
+	if q.windowStart.IsZero() {
+		q.windowStart = now
+	}
+	q.windowBytes += int64(length)
+	elapsed := now.Sub(q.windowStart)
+	if elapsed < rateWindow {
+		return
+	}
+	rate := float64(q.windowBytes) / elapsed.Seconds()
+	if q.rate == 0 {
+		q.rate = rate
+	} else {
+		q.rate = (q.rate + rate) / 2
+	}
+	q.windowStart = now
+	q.windowBytes = 0
+	q.updateDepth()
+}
+
+// TimedOut must be called when no block is received from the peer within Timeout.
+// The queue is halved and the timeout is doubled until the next block is received.
+func (q *Queue) TimedOut() {
+	q.depth = q.clamp(q.depth / 2)
+	if q.backoff < 64 {
+		q.backoff *= 2
+	}
+	// Rate of the current window is not valid after a stall.
+	q.windowStart = time.Time{}
+	q.windowBytes = 0
+}
+
+func (q *Queue) updateLatency(latency time.Duration, now time.Time) {
+	if q.latency == 0 {
+		q.latency = latency
+		q.latencyVar = latency / 2
+	} else {
+		diff := q.latency - latency
+		if diff < 0 {
+			diff = -diff
+		}
+		q.latencyVar = (3*q.latencyVar + diff) / 4
+		q.latency = (7*q.latency + latency) / 8
+	}
+	if q.minLatency == 0 || latency < q.minLatency || now.Sub(q.minLatencyAt) > minLatencyWindow {
+		q.minLatency = latency
+		q.minLatencyAt = now
+	}
+}
+
+func (q *Queue) updateDepth() {
+	bdp := int(q.rate * q.minLatency.Seconds() / piece.BlockSize)
+	target := 2*bdp + 2
+	if target > q.depth {
+		// Grow at most twice at once, like slow start.
+		if target > 2*q.depth {
+			target = 2 * q.depth
+		}
+		q.depth = q.clamp(target)
+		return
+	}
+	// Shrink gradually because a single slow window should not drain the queue.
+	q.depth = q.clamp(q.depth - (q.depth-target+3)/4)
+}
+
+func (q *Queue) clamp(n int) int {
+	if n > q.max {
+		n = q.max
+	}
+	if n < q.min {
+		n = q.min
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package requestqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/piece"
+	"github.com/stretchr/testify/assert"
+)
+
+// simulate receives blocks for duration d from a peer that sends at rate bytes per second with constant latency.
+func simulate(q *Queue, rate int, latency time.Duration, d time.Duration) {
+	now := time.Now()
+	interval := time.Duration(int64(time.Second) * piece.BlockSize / int64(rate))
+	for end := now.Add(d); now.Before(end); now = now.Add(interval) {
+		q.Received(piece.BlockSize, latency, now)
+	}
+}
+
+func TestQueueGrows(t *testing.T) {
+	q := New(4, 2, 250, 20*time.Second)
+	assert.Equal(t, 20*time.Second, q.Timeout())
+	// 10 MB/s with 100ms latency is 64 blocks in flight.
+	simulate(q, 10<<20, 100*time.Millisecond, 10*time.Second)
+	assert.Equal(t, 130, q.Depth())
+	assert.Equal(t, 100*time.Millisecond, q.Latency())
+	assert.Equal(t, 2*time.Second, q.Timeout())
+}
+
+func TestQueueShrinks(t *testing.T) {
+	q := New(50, 2, 250, 20*time.Second)
+	// 16 KB/s with 1s latency is 1 block in flight.
+	simulate(q, piece.BlockSize, time.Second, time.Minute)
+	assert.Equal(t, 4, q.Depth())
+	assert.Equal(t, time.Second, q.Latency())
+}
+
+func TestQueueTimedOut(t *testing.T) {
+	q := New(50, 2, 250, 20*time.Second)
+	simulate(q, 1<<20, 3*time.Second, time.Second)
+	timeout := q.Timeout()
+	depth := q.Depth()
+	q.TimedOut()
+	assert.Equal(t, depth/2, q.Depth())
+	assert.Equal(t, 2*timeout, q.Timeout())
+	q.TimedOut()
+	q.TimedOut()
+	assert.Equal(t, 20*time.Second, q.Timeout())
+}
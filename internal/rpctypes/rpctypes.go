@@ -7,6 +7,7 @@ type Torrent struct {
 	InfoHash string
 	Port     int
 	AddedAt  Time
+	DataDir  string
 }
 
 // Peer of a Torrent.
@@ -27,15 +28,53 @@ type Peer struct {
 	EncryptedStream    bool
 	DownloadSpeed      int
 	UploadSpeed        int
+	BytesDownloaded    int64
+	BytesUploaded      int64
+	Pieces             uint32
+	RequestsOut        int
+	RequestsIn         int
+	RequestQueue       int
+	LatencyMS          int
+	Extensions         []string
+	FastExtension      bool
+}
+
+// Ban of an IP range in a Session or a Torrent.
+type Ban struct {
+	Net       string
+	TorrentID string
+	Reason    string
+	CreatedAt Time
+	ExpiresAt Time
 }
 
 // Webseed source of a Torrent.
 type Webseed struct {
 	URL           string
+	HTTPSeed      bool
 	Error         string
 	DownloadSpeed int
 }
 
+// File in a Torrent.
+type File struct {
+	Path      string
+	Length    int64
+	Completed int64
+	Priority  string
+	Padding   bool
+}
+
+// Pieces contains the state of pieces in a Torrent.
+type Pieces struct {
+	Total        uint32
+	Have         []byte
+	Availability []int
+	Downloading  []uint32
+	Writing      []uint32
+	Verifying    []uint32
+}
+
 // Tracker of a Torrent.
 type Tracker struct {
 	URL           string
@@ -50,6 +89,14 @@ type Tracker struct {
 	NextAnnounce  Time
 }
 
+// BlocklistSource contains the status of a blocklist source in a Session.
+type BlocklistSource struct {
+	Source    string
+	Rules     int
+	UpdatedAt Time
+	Error     string
+}
+
 // SessionStats contains statistics about a Session.
 type SessionStats struct {
 	Uptime         int
@@ -59,6 +106,9 @@ type SessionStats struct {
 
 	BlockListRules   int
 	BlockListRecency int
+	BlockListSources []BlocklistSource
+
+	BannedIPs int
 
 	ReadCacheObjects     int
 	ReadCacheSize        int64
@@ -75,6 +125,10 @@ type SessionStats struct {
 	WritesPerSecond int
 	WritesActive    int
 	WritesPending   int
+	WriteQueueBytes int64
+	WriteLatencyMS  int
+	SyncsPerSecond  int
+	SyncLatencyMS   int
 
 	SpeedDownload int
 	SpeedUpload   int
@@ -98,6 +152,7 @@ type Stats struct {
 	Bytes struct {
 		Total      int64
 		Allocated  int64
+		Moved      int64
 		Completed  int64
 		Incomplete int64
 		Downloaded int64
@@ -108,6 +163,7 @@ type Stats struct {
 		Total    int
 		Incoming int
 		Outgoing int
+		Banned   int
 	}
 	Handshakes struct {
 		Total    int
@@ -131,11 +187,13 @@ type Stats struct {
 		Snubbed int
 		Running int
 	}
-	Name        string
-	Private     bool
-	PieceLength uint32
-	SeededFor   uint
-	Speed       struct {
+	Name         string
+	Private      bool
+	PieceLength  uint32
+	SeededFor    uint
+	SuperSeeding bool
+	Signer       string
+	Speed        struct {
 		Download int
 		Upload   int
 	}
@@ -164,6 +222,8 @@ type GetTorrentResponse struct {
 
 // ListTorrentsRequest contains request arguments for Session.ListTorrents method.
 type ListTorrentsRequest struct {
+	// If not empty, only the torrents with one of these statuses are returned (e.g. Seeding). Comparison is case insensitive.
+	Status []string
 }
 
 // ListTorrentsResponse contains response arguments for Session.ListTorrents method.
@@ -177,6 +237,7 @@ type AddTorrentOptions struct {
 	Stopped           bool
 	StopAfterDownload bool
 	StopAfterMetadata bool
+	DataDir           string
 }
 
 // AddTorrentRequest contains request arguments for Session.AddTorrent method.
@@ -201,6 +262,38 @@ type AddURIResponse struct {
 	Torrent Torrent
 }
 
+// CreateTorrentRequest contains request arguments for Session.CreateTorrent method.
+type CreateTorrentRequest struct {
+	Paths       []string
+	ID          string
+	Stopped     bool
+	Private     bool
+	PieceLength uint32
+	PadFiles    bool
+	Trackers    []string
+	Webseeds    []string
+	Comment     string
+	// If not empty, hashing progress can be polled with Session.GetCreateTorrentProgress while the torrent is being created.
+	ProgressID string
+}
+
+// CreateTorrentResponse contains response arguments for Session.CreateTorrent method.
+type CreateTorrentResponse struct {
+	Torrent     Torrent
+	TorrentFile string
+}
+
+// GetCreateTorrentProgressRequest contains request arguments for Session.GetCreateTorrentProgress method.
+type GetCreateTorrentProgressRequest struct {
+	ProgressID string
+}
+
+// GetCreateTorrentProgressResponse contains response arguments for Session.GetCreateTorrentProgress method.
+type GetCreateTorrentProgressResponse struct {
+	Hashed int64
+	Total  int64
+}
+
 // RemoveTorrentRequest contains request arguments for Session.RemoveTorrent method.
 type RemoveTorrentRequest struct {
 	ID string
@@ -267,6 +360,49 @@ type GetTorrentWebseedsResponse struct {
 	Webseeds []Webseed
 }
 
+// GetTorrentFilesRequest contains request arguments for Session.GetTorrentFiles method.
+type GetTorrentFilesRequest struct {
+	ID string
+}
+
+// GetTorrentFilesResponse contains response arguments for Session.GetTorrentFiles method.
+type GetTorrentFilesResponse struct {
+	Files []File
+}
+
+// GetTorrentPiecesRequest contains request arguments for Session.GetTorrentPieces method.
+type GetTorrentPiecesRequest struct {
+	ID string
+}
+
+// GetTorrentPiecesResponse contains response arguments for Session.GetTorrentPieces method.
+type GetTorrentPiecesResponse struct {
+	Pieces Pieces
+}
+
+// SetFilePriorityRequest contains request arguments for Session.SetFilePriority method.
+type SetFilePriorityRequest struct {
+	ID string
+	// Index of the file in the list returned from Session.GetTorrentFiles method.
+	Index int
+	// One of "Skip", "Low", "Normal" or "High".
+	Priority string
+}
+
+// SetFilePriorityResponse contains response arguments for Session.SetFilePriority method.
+type SetFilePriorityResponse struct {
+}
+
+// SetSuperSeedRequest contains request arguments for Session.SetSuperSeed method.
+type SetSuperSeedRequest struct {
+	ID      string
+	Enabled bool
+}
+
+// SetSuperSeedResponse contains response arguments for Session.SetSuperSeed method.
+type SetSuperSeedResponse struct {
+}
+
 // StartTorrentRequest contains request arguments for Session.StartTorrent method.
 type StartTorrentRequest struct {
 	ID string
@@ -313,6 +449,16 @@ type MoveTorrentRequest struct {
 type MoveTorrentResponse struct {
 }
 
+// MoveTorrentDataRequest contains request arguments for Session.MoveTorrentData method.
+type MoveTorrentDataRequest struct {
+	ID  string
+	Dir string
+}
+
+// MoveTorrentDataResponse contains response arguments for Session.MoveTorrentData method.
+type MoveTorrentDataResponse struct {
+}
+
 // AddPeerRequest contains request arguments for Session.AddPeer method.
 type AddPeerRequest struct {
 	ID   string
@@ -323,6 +469,95 @@ type AddPeerRequest struct {
 type AddPeerResponse struct {
 }
 
+// DisconnectPeerRequest contains request arguments for Session.DisconnectPeer method.
+type DisconnectPeerRequest struct {
+	ID   string
+	Addr string
+}
+
+// DisconnectPeerResponse contains response arguments for Session.DisconnectPeer method.
+type DisconnectPeerResponse struct {
+}
+
+// SetSpeedLimitRequest contains request arguments for Session.SetSpeedLimit method.
+type SetSpeedLimitRequest struct {
+	// Torrent ID. Empty for changing the global limits of the session.
+	ID string
+	// Speed limits in KB/s. Zero means no limit.
+	Download int64
+	Upload   int64
+}
+
+// SetSpeedLimitResponse contains response arguments for Session.SetSpeedLimit method.
+type SetSpeedLimitResponse struct {
+}
+
+// GetSpeedLimitRequest contains request arguments for Session.GetSpeedLimit method.
+type GetSpeedLimitRequest struct {
+	// Torrent ID. Empty for getting the global limits of the session.
+	ID string
+}
+
+// GetSpeedLimitResponse contains response arguments for Session.GetSpeedLimit method.
+type GetSpeedLimitResponse struct {
+	// Speed limits in KB/s. Zero means no limit.
+	Download int64
+	Upload   int64
+}
+
+// GetSessionConfigRequest contains request arguments for Session.GetSessionConfig method.
+type GetSessionConfigRequest struct {
+}
+
+// GetSessionConfigResponse contains response arguments for Session.GetSessionConfig method.
+type GetSessionConfigResponse struct {
+	// Config in YAML format, same as the config file.
+	Config string
+}
+
+// SetSessionConfigRequest contains request arguments for Session.SetSessionConfig method.
+type SetSessionConfigRequest struct {
+	// Config in YAML format, same as the config file. Missing fields are not changed.
+	Config string
+}
+
+// SetSessionConfigResponse contains response arguments for Session.SetSessionConfig method.
+type SetSessionConfigResponse struct {
+}
+
+// BanIPRequest contains request arguments for Session.BanIP method.
+type BanIPRequest struct {
+	// Torrent ID. Empty for banning in all torrents.
+	ID string
+	// IP address or CIDR range.
+	Addr string
+	// Duration of the ban in seconds. 0 for a permanent ban.
+	Duration int
+}
+
+// BanIPResponse contains response arguments for Session.BanIP method.
+type BanIPResponse struct {
+}
+
+// UnbanIPRequest contains request arguments for Session.UnbanIP method.
+type UnbanIPRequest struct {
+	ID   string
+	Addr string
+}
+
+// UnbanIPResponse contains response arguments for Session.UnbanIP method.
+type UnbanIPResponse struct {
+}
+
+// ListBansRequest contains request arguments for Session.ListBans method.
+type ListBansRequest struct {
+}
+
+// ListBansResponse contains response arguments for Session.ListBans method.
+type ListBansResponse struct {
+	Bans []Ban
+}
+
 // AddTrackerRequest contains request arguments for Session.AddTracker method.
 type AddTrackerRequest struct {
 	ID  string
@@ -0,0 +1,109 @@
+// Package smartban finds peers that send corrupt data.
+//
+// When a piece fails the hash check, the hashes of its blocks are saved together with the peers that sent them.
+// When the same piece is downloaded again and passes the hash check, the saved blocks are compared with the good ones.
+// The peers that sent a block different from the good one are the ones that corrupted the piece.
+package smartban
+
+import (
+	"crypto/sha1"
+
+	"github.com/ganqierwu/rain/internal/piece"
+)
+
+// Hash of a block.
+type Hash [sha1.Size]byte
+
+// HashBlocks splits the piece data into blocks and returns the hash of each block.
+func HashBlocks(data []byte) []Hash {
+	hashes := make([]Hash, 0, (len(data)+piece.BlockSize-1)/piece.BlockSize)
+	for begin := 0; begin < len(data); begin += piece.BlockSize {
+		end := begin + piece.BlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		hashes = append(hashes, sha1.Sum(data[begin:end]))
+	}
+	return hashes
+}
+
+// SmartBan keeps the blocks of pieces that failed the hash check until a good copy of the piece is received.
+type SmartBan struct {
+	// Piece index -> block index -> peer address -> block hash
+	pieces map[uint32][]map[string]Hash
+}
+
+// New returns a new SmartBan.
+func New() *SmartBan {
+	return &SmartBan{
+		pieces: make(map[uint32][]map[string]Hash),
+	}
+}
+
+// Failed saves the blocks of a piece that failed the hash check.
+// sources contains the address of the peer that sent the block at the same index in hashes.
+func (s *SmartBan) Failed(index uint32, sources []string, hashes []Hash) {
+	blocks, ok := s.pieces[index]
+	if !ok {
+		blocks = make([]map[string]Hash, len(hashes))
+		s.pieces[index] = blocks
+	}
+	for i, h := range hashes {
+		if i >= len(blocks) || sources[i] == "" {
+			continue
+		}
+		if blocks[i] == nil {
+			blocks[i] = make(map[string]Hash)
+		}
+		blocks[i][sources[i]] = h
+	}
+}
+
+// FailedBy returns true if the peer at addr has sent any of the saved blocks of the piece.
+func (s *SmartBan) FailedBy(index uint32, addr string) bool {
+	for _, m := range s.pieces[index] {
+		if _, ok := m[addr]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Waiting returns true if there are saved blocks of the piece waiting to be compared with a good copy.
+func (s *SmartBan) Waiting(index uint32) bool {
+	_, ok := s.pieces[index]
+	return ok
+}
+
+// Len returns the number of pieces waiting to be compared with a good copy.
+func (s *SmartBan) Len() int {
+	return len(s.pieces)
+}
+
+// Verified compares the saved blocks of the piece with the blocks of a copy that has passed the hash check.
+// It returns the addresses of the peers that have sent a corrupt block and forgets the piece.
+func (s *SmartBan) Verified(index uint32, hashes []Hash) []string {
+	blocks, ok := s.pieces[index]
+	if !ok {
+		return nil
+	}
+	delete(s.pieces, index)
+	found := make(map[string]struct{})
+	var culprits []string
+	for i, m := range blocks {
+		if i >= len(hashes) {
+			break
+		}
+		for addr, h := range m {
+			if h == hashes[i] {
+				continue
+			}
+			if _, ok := found[addr]; ok {
+				continue
+			}
+			found[addr] = struct{}{}
+			culprits = append(culprits, addr)
+		}
+	}
+	return culprits
+}
@@ -0,0 +1,39 @@
+package smartban
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ganqierwu/rain/internal/piece"
+)
+
+func TestSmartBan(t *testing.T) {
+	good := bytes.Repeat([]byte{1}, 3*piece.BlockSize+100)
+	bad := make([]byte, len(good))
+	copy(bad, good)
+	bad[piece.BlockSize+5] = 2
+
+	goodHashes := HashBlocks(good)
+	if len(goodHashes) != 4 {
+		t.Fatalf("invalid number of blocks: %d", len(goodHashes))
+	}
+
+	s := New()
+	s.Failed(7, []string{"1.1.1.1", "2.2.2.2", "1.1.1.1", "3.3.3.3"}, HashBlocks(bad))
+	if !s.Waiting(7) {
+		t.Fatal("piece is not waiting")
+	}
+	if s.Waiting(8) {
+		t.Fatal("unexpected piece is waiting")
+	}
+	if !s.FailedBy(7, "3.3.3.3") || s.FailedBy(7, "4.4.4.4") {
+		t.Fatal("invalid peers of failed blocks")
+	}
+	culprits := s.Verified(7, goodHashes)
+	if len(culprits) != 1 || culprits[0] != "2.2.2.2" {
+		t.Fatalf("invalid culprits: %v", culprits)
+	}
+	if s.Waiting(7) {
+		t.Fatal("piece must be removed after verification")
+	}
+}
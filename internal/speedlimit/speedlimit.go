@@ -0,0 +1,71 @@
+// Package speedlimit provides a rate limiter whose limit can be changed while it is in use.
+package speedlimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/ratelimit"
+)
+
+// Limiter limits the rate of transferred bytes.
+// A Limiter may have a parent, then the transfer is limited by both the Limiter and the parent.
+// Methods of Limiter are safe to call on nil value, which means there is no limit.
+type Limiter struct {
+	parent *Limiter
+
+	m      sync.Mutex
+	limit  int64
+	bucket *ratelimit.Bucket
+}
+
+// New returns a new Limiter without a limit.
+// parent may be nil.
+func New(parent *Limiter) *Limiter {
+	return &Limiter{parent: parent}
+}
+
+// SetLimit changes the limit to bytesPerSecond. Zero or negative value removes the limit.
+func (l *Limiter) SetLimit(bytesPerSecond int64) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if bytesPerSecond <= 0 {
+		l.limit = 0
+		l.bucket = nil
+		return
+	}
+	if bytesPerSecond == l.limit {
+		return
+	}
+	l.limit = bytesPerSecond
+	l.bucket = ratelimit.NewBucketWithRate(float64(bytesPerSecond), bytesPerSecond)
+}
+
+// Limit returns the current limit in bytes per second. Zero means there is no limit.
+// The limit of the parent is not included.
+func (l *Limiter) Limit() int64 {
+	if l == nil {
+		return 0
+	}
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.limit
+}
+
+// Take n bytes from the Limiter and the parent.
+// Returns the time to wait until the bytes are available.
+func (l *Limiter) Take(n int64) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.m.Lock()
+	var d time.Duration
+	if l.bucket != nil {
+		d = l.bucket.Take(n)
+	}
+	l.m.Unlock()
+	if pd := l.parent.Take(n); pd > d {
+		d = pd
+	}
+	return d
+}
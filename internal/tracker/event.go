@@ -9,6 +9,9 @@ const (
 	EventCompleted
 	EventStarted
 	EventStopped
+	// EventPaused is sent by partial seeds instead of EventNone (BEP 21).
+	// UDP tracker protocol does not have this event.
+	EventPaused
 )
 
 var eventNames = [...]string{
@@ -16,6 +19,7 @@ var eventNames = [...]string{
 	"completed",
 	"started",
 	"stopped",
+	"paused",
 }
 
 // String returns the name of event as represented in HTTP tracker protocol.
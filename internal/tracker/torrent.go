@@ -8,4 +8,6 @@ type Torrent struct {
 	InfoHash        [20]byte
 	PeerID          [20]byte
 	Port            int
+	// PartialSeed is true if all wanted files are downloaded but some files are skipped.
+	PartialSeed bool
 }
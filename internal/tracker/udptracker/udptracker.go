@@ -45,13 +45,18 @@ func (t *UDPTracker) URL() string {
 
 // Announce the torrent to UDP tracker.
 func (t *UDPTracker) Announce(ctx context.Context, req tracker.AnnounceRequest) (*tracker.AnnounceResponse, error) {
+	event := req.Event
+	if event == tracker.EventPaused {
+		// There is no paused event in UDP tracker protocol.
+		event = tracker.EventNone
+	}
 	request := &announceRequest{
 		InfoHash:   req.Torrent.InfoHash,
 		PeerID:     req.Torrent.PeerID,
 		Downloaded: req.Torrent.BytesDownloaded,
 		Left:       req.Torrent.BytesLeft,
 		Uploaded:   req.Torrent.BytesUploaded,
-		Event:      req.Event,
+		Event:      event,
 		NumWant:    int32(req.NumWant),
 		Port:       uint16(req.Torrent.Port),
 	}
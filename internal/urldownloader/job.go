@@ -8,6 +8,8 @@ type downloadJob struct {
 	Filename   string
 	RangeBegin int64
 	Length     int64
+	// Piece index is set only for HTTP seeds.
+	Piece uint32
 }
 
 func createJobs(pieces []piece.Piece, begin, end uint32) []downloadJob {
@@ -46,3 +48,15 @@ func createJobs(pieces []piece.Piece, begin, end uint32) []downloadJob {
 	}
 	return jobs
 }
+
+// createHTTPSeedJobs returns a job for each piece because HTTP seeds serve a single piece in a request.
+func createHTTPSeedJobs(pieces []piece.Piece, begin, end uint32) []downloadJob {
+	jobs := make([]downloadJob, 0, end-begin)
+	for i := begin; i < end; i++ {
+		jobs = append(jobs, downloadJob{
+			Piece:  i,
+			Length: int64(pieces[i].Length),
+		})
+	}
+	return jobs
+}
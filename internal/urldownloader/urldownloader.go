@@ -6,20 +6,40 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/ganqierwu/rain/internal/bufferpool"
 	"github.com/ganqierwu/rain/internal/piece"
-	"github.com/juju/ratelimit"
+	"github.com/ganqierwu/rain/internal/speedlimit"
 )
 
 // URLDownloader downloads files from a HTTP source.
 type URLDownloader struct {
 	URL                 string
 	Begin, End, current uint32 // piece index
-	bucket              *ratelimit.Bucket
+	bucket              *speedlimit.Limiter
 	closeC, doneC       chan struct{}
+
+	// Set for HTTP seeds (BEP 17). Pieces are requested one by one from a seed script instead of files.
+	httpSeed bool
+	infoHash [20]byte
+}
+
+// BusyError is returned when a HTTP seed responds with 503 status code.
+// Client should not send new requests to the seed before RetryAfter duration passes.
+type BusyError struct {
+	// RetryAfter is zero if the seed did not send a valid duration in response body.
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string {
+	if e.RetryAfter == 0 {
+		return "http seed is busy"
+	}
+	return "http seed is busy, retry after " + e.RetryAfter.String()
 }
 
 // PieceResult wraps the downloaded piece data.
@@ -32,7 +52,7 @@ type PieceResult struct {
 }
 
 // New returns a new URLDownloader for the given source and piece range.
-func New(source string, begin, end uint32, b *ratelimit.Bucket) *URLDownloader {
+func New(source string, begin, end uint32, b *speedlimit.Limiter) *URLDownloader {
 	return &URLDownloader{
 		URL:     source,
 		Begin:   begin,
@@ -44,6 +64,14 @@ func New(source string, begin, end uint32, b *ratelimit.Bucket) *URLDownloader {
 	}
 }
 
+// NewHTTPSeed returns a new URLDownloader for the HTTP seed (BEP 17) of the torrent with the given info hash.
+func NewHTTPSeed(source string, infoHash [20]byte, begin, end uint32, b *speedlimit.Limiter) *URLDownloader {
+	d := New(source, begin, end, b)
+	d.httpSeed = true
+	d.infoHash = infoHash
+	return d
+}
+
 // Close the URLDownloader.
 func (d *URLDownloader) Close() {
 	close(d.closeC)
@@ -85,19 +113,22 @@ func (d *URLDownloader) Run(client *http.Client, pieces []piece.Piece, multifile
 		cancel()
 	}()
 
-	jobs := createJobs(pieces, d.Begin, d.readEnd())
+	var jobs []downloadJob
+	if d.httpSeed {
+		jobs = createHTTPSeedJobs(pieces, d.Begin, d.readEnd())
+	} else {
+		jobs = createJobs(pieces, d.Begin, d.readEnd())
+	}
 
 	var n int // position in piece
 	buf := pool.Get(int(pieces[d.current].Length))
 
 	processJob := func(job downloadJob) bool {
-		u := d.getURL(job.Filename, multifile)
-		req, err := http.NewRequest(http.MethodGet, u, nil)
+		req, err := d.newRequest(job, multifile)
 		if err != nil {
 			d.sendResult(resultC, &PieceResult{Downloader: d, Error: err})
 			return false
 		}
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", job.RangeBegin, job.RangeBegin+job.Length-1))
 		req = req.WithContext(ctx)
 		resp, err := client.Do(req)
 		if err != nil {
@@ -105,7 +136,7 @@ func (d *URLDownloader) Run(client *http.Client, pieces []piece.Piece, multifile
 			return false
 		}
 		defer resp.Body.Close()
-		err = checkStatus(resp)
+		err = d.checkStatus(resp)
 		if err != nil {
 			d.sendResult(resultC, &PieceResult{Downloader: d, Error: err})
 			return false
@@ -177,6 +208,37 @@ func readFull(r io.Reader, b []byte, t *time.Timer, d time.Duration) (o int, err
 	return
 }
 
+func (d *URLDownloader) newRequest(job downloadJob, multifile bool) (*http.Request, error) {
+	if d.httpSeed {
+		u, err := d.getHTTPSeedURL(job)
+		if err != nil {
+			return nil, err
+		}
+		return http.NewRequest(http.MethodGet, u, nil)
+	}
+	req, err := http.NewRequest(http.MethodGet, d.getURL(job.Filename, multifile), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", job.RangeBegin, job.RangeBegin+job.Length-1))
+	return req, nil
+}
+
+// getHTTPSeedURL adds the parameters of the piece request to the seed script URL.
+// Ranges are inclusive as in HTTP Range header.
+func (d *URLDownloader) getHTTPSeedURL(job downloadJob) (string, error) {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("info_hash", string(d.infoHash[:]))
+	q.Set("piece", strconv.FormatUint(uint64(job.Piece), 10))
+	q.Set("ranges", fmt.Sprintf("%d-%d", job.RangeBegin, job.RangeBegin+job.Length-1))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
 func (d *URLDownloader) getURL(filename string, multifile bool) string {
 	src := d.URL
 	if !multifile {
@@ -203,11 +265,27 @@ func (d *URLDownloader) sendResult(resultC chan *PieceResult, res *PieceResult)
 	}
 }
 
-func checkStatus(resp *http.Response) error {
+func (d *URLDownloader) checkStatus(resp *http.Response) error {
 	switch resp.StatusCode {
 	case 200, 206:
 		return nil
-	default:
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	case 503:
+		if d.httpSeed {
+			return parseBusyResponse(resp.Body)
+		}
+	}
+	return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+}
+
+// parseBusyResponse reads the number of seconds to wait from the body of a 503 response of a HTTP seed.
+func parseBusyResponse(r io.Reader) error {
+	b, err := io.ReadAll(io.LimitReader(r, 32))
+	if err != nil {
+		return &BusyError{}
+	}
+	seconds, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 32)
+	if err != nil {
+		return &BusyError{}
 	}
+	return &BusyError{RetryAfter: time.Duration(seconds) * time.Second}
 }
@@ -0,0 +1,71 @@
+package urldownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/bufferpool"
+	"github.com/ganqierwu/rain/internal/piece"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPSeed(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	pieces := []piece.Piece{
+		{Index: 0, Length: 8},
+		{Index: 1, Length: 8},
+		{Index: 2, Length: 4},
+	}
+	var infoHash [20]byte
+	copy(infoHash[:], "\x00\x01info hash&=?%/ \xfe\xff")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("info_hash") != string(infoHash[:]) || q.Get("key") != "value" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		i, _ := strconv.Atoi(q.Get("piece"))
+		begin := i * 8
+		end := begin + int(pieces[i].Length)
+		assert.Equal(t, "0-"+strconv.Itoa(int(pieces[i].Length)-1), q.Get("ranges"))
+		_, _ = w.Write(data[begin:end])
+	}))
+	defer srv.Close()
+
+	d := NewHTTPSeed(srv.URL+"/seed?key=value", infoHash, 0, 3, nil)
+	resultC := make(chan *PieceResult)
+	go d.Run(http.DefaultClient, pieces, false, resultC, bufferpool.New(8), time.Second)
+	for i := range pieces {
+		res := <-resultC
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		assert.Equal(t, uint32(i), res.Index)
+		assert.Equal(t, string(data[i*8:i*8+int(pieces[i].Length)]), string(res.Buffer.Data))
+		assert.Equal(t, i == len(pieces)-1, res.Done)
+	}
+	d.Close()
+}
+
+func TestHTTPSeedBusy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("30\n"))
+	}))
+	defer srv.Close()
+
+	d := NewHTTPSeed(srv.URL, [20]byte{}, 0, 1, nil)
+	resultC := make(chan *PieceResult)
+	go d.Run(http.DefaultClient, []piece.Piece{{Length: 8}}, false, resultC, bufferpool.New(8), time.Second)
+	res := <-resultC
+	var busy *BusyError
+	if !errors.As(res.Error, &busy) {
+		t.Fatal("unexpected error:", res.Error)
+	}
+	assert.Equal(t, 30*time.Second, busy.RetryAfter)
+	d.Close()
+}
@@ -9,7 +9,10 @@ import (
 
 // WebseedSource is a URL for downloading torrent data from web sources.
 type WebseedSource struct {
-	URL           string
+	URL string
+	// HTTPSeed is true if the source is a seed script in "httpseeds" key (BEP 17).
+	// Otherwise, files are requested from the source with Range headers (BEP 19).
+	HTTPSeed      bool
 	Disabled      bool
 	Downloader    *urldownloader.URLDownloader
 	LastError     error
@@ -17,14 +20,28 @@ type WebseedSource struct {
 	DownloadSpeed metrics.Meter
 }
 
-// NewList returns a new WebseedSource list.
-func NewList(sources []string) []*WebseedSource {
-	l := make([]*WebseedSource, len(sources))
-	for i := range sources {
-		l[i] = &WebseedSource{
-			URL:           sources[i],
+// NewList returns a new WebseedSource list from url-list (BEP 19) and httpseeds (BEP 17) URLs.
+// HTTP seeds that are also in url-list are ignored.
+func NewList(urlList, httpSeeds []string) []*WebseedSource {
+	l := make([]*WebseedSource, 0, len(urlList)+len(httpSeeds))
+	seen := make(map[string]struct{}, len(urlList))
+	for _, u := range urlList {
+		seen[u] = struct{}{}
+		l = append(l, &WebseedSource{
+			URL:           u,
 			DownloadSpeed: metrics.NilMeter{},
+		})
+	}
+	for _, u := range httpSeeds {
+		if _, ok := seen[u]; ok {
+			continue
 		}
+		seen[u] = struct{}{}
+		l = append(l, &WebseedSource{
+			URL:           u,
+			HTTPSeed:      true,
+			DownloadSpeed: metrics.NilMeter{},
+		})
 	}
 	return l
 }
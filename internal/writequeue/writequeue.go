@@ -0,0 +1,237 @@
+// Package writequeue writes downloaded pieces to storage.
+//
+// Pieces that are waiting for a write slot are kept in a queue.
+// When a slot is available, the piece is written together with the adjacent pieces of the same torrent
+// that are waiting in the queue, so the contiguous regions of files are written with a single call.
+package writequeue
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/filesection"
+	"github.com/ganqierwu/rain/internal/piece"
+	"github.com/ganqierwu/rain/internal/semaphore"
+	"github.com/ganqierwu/rain/storage"
+	"github.com/rcrowley/go-metrics"
+)
+
+// Adjacent pieces are not merged beyond this size to limit the memory used for copying them into a single buffer.
+const maxBatchSize = 16 << 20
+
+// Queue of pieces waiting to be written.
+type Queue struct {
+	sem          *semaphore.Semaphore
+	writeLatency metrics.Timer
+	syncLatency  metrics.Timer
+
+	m       sync.Mutex
+	pending []*job
+	size    int64
+}
+
+type job struct {
+	owner interface{}
+	piece *piece.Piece
+	data  []byte
+	sync  bool
+	errC  chan error
+}
+
+// New returns a new Queue. The number of parallel writes is limited by sem.
+// Durations of writes and syncs are recorded in writeLatency and syncLatency.
+func New(sem *semaphore.Semaphore, writeLatency, syncLatency metrics.Timer) *Queue {
+	return &Queue{
+		sem:          sem,
+		writeLatency: writeLatency,
+		syncLatency:  syncLatency,
+	}
+}
+
+// Len returns the number of pieces waiting in the queue.
+func (q *Queue) Len() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return len(q.pending)
+}
+
+// Size returns the number of bytes in the pieces waiting in the queue.
+func (q *Queue) Size() int64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.size
+}
+
+// Write the data of the piece to its files. Returns after the data is written.
+// Only the pieces with the same owner are merged.
+// If sync is true, the files of the piece are flushed to the disk before returning.
+func (q *Queue) Write(owner interface{}, p *piece.Piece, data []byte, sync bool) error {
+	j := &job{
+		owner: owner,
+		piece: p,
+		data:  data,
+		sync:  sync,
+		errC:  make(chan error, 1),
+	}
+	q.m.Lock()
+	q.pending = append(q.pending, j)
+	q.size += int64(len(data))
+	q.m.Unlock()
+
+	q.sem.Wait()
+	batch := q.take(j)
+	if batch == nil {
+		// Piece is written by another goroutine together with an adjacent piece.
+		q.sem.Signal()
+		return <-j.errC
+	}
+	err := q.write(batch)
+	q.sem.Signal()
+	for _, j2 := range batch {
+		if j2 != j {
+			j2.errC <- err
+		}
+	}
+	return err
+}
+
+// Sync flushes the files of the sections to the disk if they implement storage.Syncer.
+func (q *Queue) Sync(sections filesection.Piece) error {
+	start := time.Now()
+	var synced bool
+	for _, f := range files(sections) {
+		s, ok := f.(storage.Syncer)
+		if !ok {
+			continue
+		}
+		if err := s.Sync(); err != nil {
+			return err
+		}
+		synced = true
+	}
+	if synced {
+		q.syncLatency.UpdateSince(start)
+	}
+	return nil
+}
+
+// take removes the job and the jobs of adjacent pieces from the queue.
+// Returns nil if the job is already taken by another goroutine.
+func (q *Queue) take(j *job) []*job {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if !q.remove(j) {
+		return nil
+	}
+	batch := []*job{j}
+	size := len(j.data)
+	for size < maxBatchSize {
+		next := q.find(j.owner, batch[len(batch)-1].piece.Index+1)
+		if next == nil {
+			break
+		}
+		q.remove(next)
+		batch = append(batch, next)
+		size += len(next.data)
+	}
+	for size < maxBatchSize && batch[0].piece.Index > 0 {
+		prev := q.find(j.owner, batch[0].piece.Index-1)
+		if prev == nil {
+			break
+		}
+		q.remove(prev)
+		batch = append([]*job{prev}, batch...)
+		size += len(prev.data)
+	}
+	return batch
+}
+
+func (q *Queue) find(owner interface{}, index uint32) *job {
+	for _, j := range q.pending {
+		if j.owner == owner && j.piece.Index == index {
+			return j
+		}
+	}
+	return nil
+}
+
+func (q *Queue) remove(j *job) bool {
+	for i, j2 := range q.pending {
+		if j2 == j {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.size -= int64(len(j.data))
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Queue) write(batch []*job) error {
+	sections, data := merge(batch)
+	start := time.Now()
+	_, err := sections.Write(data)
+	if err != nil {
+		return err
+	}
+	q.writeLatency.UpdateSince(start)
+	for _, j := range batch {
+		if j.sync {
+			return q.Sync(sections)
+		}
+	}
+	return nil
+}
+
+// merge concatenates the data of adjacent pieces and joins the sections that are contiguous in the same file.
+func merge(batch []*job) (filesection.Piece, []byte) {
+	if len(batch) == 1 {
+		return batch[0].piece.Data, batch[0].data
+	}
+	var size int
+	for _, j := range batch {
+		size += len(j.data)
+	}
+	data := make([]byte, 0, size)
+	var sections filesection.Piece
+	for _, j := range batch {
+		data = append(data, j.data...)
+		for _, sec := range j.piece.Data {
+			if n := len(sections); n > 0 {
+				last := &sections[n-1]
+				if sameFile(last.File, sec.File) && last.Offset+last.Length == sec.Offset {
+					last.Length += sec.Length
+					continue
+				}
+			}
+			sections = append(sections, sec)
+		}
+	}
+	return sections, data
+}
+
+// files returns the distinct files of the sections.
+func files(sections filesection.Piece) []filesection.ReadWriterAt {
+	var ret []filesection.ReadWriterAt
+	for _, sec := range sections {
+		var found bool
+		for _, f := range ret {
+			if sameFile(f, sec.File) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, sec.File)
+		}
+	}
+	return ret
+}
+
+// sameFile compares the files without panicking for storage implementations with non-comparable file types.
+func sameFile(a, b filesection.ReadWriterAt) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,80 @@
+package writequeue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/filesection"
+	"github.com/ganqierwu/rain/internal/piece"
+	"github.com/ganqierwu/rain/internal/semaphore"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFile struct {
+	m      sync.Mutex
+	data   []byte
+	writes int
+	syncs  int
+}
+
+func (f *testFile) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, f.data[off:]), nil
+}
+
+func (f *testFile) WriteAt(p []byte, off int64) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.writes++
+	return copy(f.data[off:], p), nil
+}
+
+func (f *testFile) Sync() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.syncs++
+	return nil
+}
+
+func TestWriteAdjacentPieces(t *testing.T) {
+	a := &testFile{data: make([]byte, 6)}
+	b := &testFile{data: make([]byte, 2)}
+	p0 := &piece.Piece{Index: 0, Data: filesection.Piece{
+		{File: a, Offset: 0, Length: 4},
+	}}
+	p1 := &piece.Piece{Index: 1, Data: filesection.Piece{
+		{File: a, Offset: 4, Length: 2},
+		{File: b, Offset: 0, Length: 2},
+	}}
+	sem := semaphore.New(1)
+	q := New(sem, metrics.NilTimer{}, metrics.NilTimer{})
+
+	// Hold the semaphore until both pieces are queued.
+	sem.Wait()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, q.Write(q, p1, []byte("efgh"), true))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, q.Write(q, p0, []byte("abcd"), false))
+	}()
+	for q.Len() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, int64(8), q.Size())
+	sem.Signal()
+	wg.Wait()
+
+	assert.Equal(t, "abcdef", string(a.data))
+	assert.Equal(t, "gh", string(b.data))
+	assert.Equal(t, 1, a.writes)
+	assert.Equal(t, 1, b.writes)
+	assert.Equal(t, 1, a.syncs)
+	assert.Equal(t, 1, b.syncs)
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, int64(0), q.Size())
+}
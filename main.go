@@ -1,8 +1,12 @@
 package main
 
 import (
+	"crypto"
 	"crypto/sha1"
+	"crypto/x509"
 	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"github.com/hokaccha/go-prettyjson"
 	"io/ioutil"
@@ -12,6 +16,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"strconv"
@@ -25,8 +30,10 @@ import (
 	"github.com/ganqierwu/rain/internal/logger"
 	"github.com/ganqierwu/rain/internal/magnet"
 	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/ganqierwu/rain/internal/outputformat"
 	"github.com/ganqierwu/rain/rainrpc"
 	"github.com/ganqierwu/rain/torrent"
+	"github.com/ganqierwu/rain/torrentfile"
 	"github.com/mitchellh/go-homedir"
 	"github.com/urfave/cli"
 	"github.com/zeebo/bencode"
@@ -170,6 +177,7 @@ func main() {
 					Usage:    "list torrents",
 					Category: "Getters",
 					Action:   handleList,
+					Flags:    outputFlags(),
 				},
 				{
 					Name:     "add",
@@ -198,20 +206,73 @@ func main() {
 							Name:  "id",
 							Usage: "if id is not given, a unique id is automatically generated",
 						},
+						cli.StringFlag{
+							Name:  "data-dir",
+							Usage: "save files into `DIR` on the server instead of the default location. existing files are verified and seeded.",
+						},
 					},
 				},
 				{
-					Name:     "remove",
-					Usage:    "remove torrent",
+					Name:     "create",
+					Usage:    "create new torrent from files on the server and start seeding",
 					Category: "Actions",
-					Action:   handleRemove,
+					Action:   handleCreate,
 					Flags: []cli.Flag{
-						cli.StringFlag{
-							Name:     "id",
+						cli.StringSliceFlag{
+							Name:     "file,f",
+							Usage:    "include this file or directory in torrent. all files must be in the same directory.",
 							Required: true,
 						},
+						cli.StringFlag{
+							Name:  "out,o",
+							Usage: "save generated torrent to this `FILE`",
+						},
+						cli.BoolFlag{
+							Name:  "private,p",
+							Usage: "create torrent for private trackers",
+						},
+						cli.IntFlag{
+							Name:  "piece-length,l",
+							Usage: "override default piece length. by default, piece length calculated automatically based on the total size of files. given in KB. must be multiple of 16.",
+						},
+						cli.BoolFlag{
+							Name:  "pad-files",
+							Usage: "insert padding files so that each file starts at a piece boundary",
+						},
+						cli.StringFlag{
+							Name:  "comment,c",
+							Usage: "add `COMMENT` to torrent",
+						},
+						cli.StringSliceFlag{
+							Name:  "tracker,t",
+							Usage: "add tracker `URL`",
+						},
+						cli.StringSliceFlag{
+							Name:  "webseed,w",
+							Usage: "add webseed `URL`",
+						},
+						cli.BoolFlag{
+							Name:  "stopped",
+							Usage: "do not start torrent automatically",
+						},
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "if id is not given, a unique id is automatically generated",
+						},
 					},
 				},
+				{
+					Name:     "remove",
+					Usage:    "remove torrent",
+					Category: "Actions",
+					Action:   handleRemove,
+					Flags: selectorFlags(
+						cli.BoolFlag{
+							Name:  "yes",
+							Usage: "confirm removing more than one torrent",
+						},
+					),
+				},
 				{
 					Name:     "clean-database",
 					Usage:    "clean session database",
@@ -223,7 +284,7 @@ func main() {
 					Usage:    "get stats of torrent",
 					Category: "Getters",
 					Action:   handleStats,
-					Flags: []cli.Flag{
+					Flags: outputFlags(
 						cli.StringFlag{
 							Name:     "id",
 							Required: true,
@@ -232,7 +293,7 @@ func main() {
 							Name:  "json",
 							Usage: "print raw stats as JSON",
 						},
-					},
+					),
 				},
 				{
 					Name:     "session-stats",
@@ -246,23 +307,53 @@ func main() {
 						},
 					},
 				},
+				{
+					Name:     "session-config",
+					Usage:    "get config of session in YAML format",
+					Category: "Getters",
+					Action:   handleSessionConfig,
+				},
 				{
 					Name:     "trackers",
 					Usage:    "get trackers of torrent",
 					Category: "Getters",
 					Action:   handleTrackers,
-					Flags: []cli.Flag{
+					Flags: outputFlags(
 						cli.StringFlag{
 							Name:     "id",
 							Required: true,
 						},
-					},
+					),
 				},
 				{
 					Name:     "webseeds",
 					Usage:    "get webseed sources of torrent",
 					Category: "Getters",
 					Action:   handleWebseeds,
+					Flags: outputFlags(
+						cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+					),
+				},
+				{
+					Name:     "files",
+					Usage:    "get files of torrent",
+					Category: "Getters",
+					Action:   handleFiles,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+					},
+				},
+				{
+					Name:     "pieces",
+					Usage:    "get state of pieces of torrent",
+					Category: "Getters",
+					Action:   handlePieces,
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:     "id",
@@ -275,12 +366,12 @@ func main() {
 					Usage:    "get peers of torrent",
 					Category: "Getters",
 					Action:   handlePeers,
-					Flags: []cli.Flag{
+					Flags: outputFlags(
 						cli.StringFlag{
 							Name:     "id",
 							Required: true,
 						},
-					},
+					),
 				},
 				{
 					Name:     "add-peer",
@@ -300,70 +391,169 @@ func main() {
 					},
 				},
 				{
-					Name:     "add-tracker",
-					Usage:    "add tracker to torrent",
+					Name:     "set-file-priority",
+					Usage:    "change download priority of a file in torrent",
 					Category: "Actions",
-					Action:   handleAddTracker,
+					Action:   handleSetFilePriority,
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:     "id",
 							Required: true,
 						},
+						cli.IntFlag{
+							Name:     "index",
+							Usage:    "index of the file in the output of files command",
+							Required: true,
+						},
 						cli.StringFlag{
-							Name:     "tracker,t",
+							Name:     "priority",
+							Usage:    "one of skip, low, normal, high",
 							Required: true,
-							Usage:    "tracker URL",
 						},
 					},
 				},
 				{
-					Name:     "announce",
-					Usage:    "announce to tracker",
+					Name:     "set-super-seed",
+					Usage:    "enable or disable super-seeding mode of torrent",
 					Category: "Actions",
-					Action:   handleAnnounce,
+					Action:   handleSetSuperSeed,
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:     "id",
 							Required: true,
 						},
+						cli.BoolTFlag{
+							Name:  "enabled",
+							Usage: "use --enabled=false to disable",
+						},
 					},
 				},
 				{
-					Name:     "verify",
-					Usage:    "verify files",
+					Name:     "set-speed-limit",
+					Usage:    "change speed limits of torrent or session",
 					Category: "Actions",
-					Action:   handleVerify,
+					Action:   handleSetSpeedLimit,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "change limits of torrent with `ID`, instead of global limits",
+						},
+						cli.Int64Flag{
+							Name:  "download",
+							Usage: "download speed limit in KB/s, 0 for no limit",
+						},
+						cli.Int64Flag{
+							Name:  "upload",
+							Usage: "upload speed limit in KB/s, 0 for no limit",
+						},
+					},
+				},
+				{
+					Name:     "disconnect-peer",
+					Usage:    "close connection to a peer of torrent",
+					Category: "Actions",
+					Action:   handleDisconnectPeer,
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:     "id",
 							Required: true,
 						},
+						cli.StringFlag{
+							Name:     "addr",
+							Usage:    "peer address in ip:port format",
+							Required: true,
+						},
 					},
 				},
 				{
-					Name:     "start",
-					Usage:    "start torrent",
+					Name:     "ban",
+					Usage:    "ban IP address or CIDR range",
 					Category: "Actions",
-					Action:   handleStart,
+					Action:   handleBan,
 					Flags: []cli.Flag{
 						cli.StringFlag{
-							Name:     "id",
+							Name:  "id",
+							Usage: "ban only in torrent with `ID`, instead of all torrents",
+						},
+						cli.StringFlag{
+							Name:     "addr",
+							Usage:    "IP address or CIDR range",
 							Required: true,
 						},
+						cli.DurationFlag{
+							Name:  "duration",
+							Usage: "remove ban after `DURATION`, ban is permanent if not set",
+						},
 					},
 				},
 				{
-					Name:     "stop",
-					Usage:    "stop torrent",
+					Name:     "unban",
+					Usage:    "remove ban of IP address or CIDR range",
 					Category: "Actions",
-					Action:   handleStop,
+					Action:   handleUnban,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "remove ban in torrent with `ID`",
+						},
+						cli.StringFlag{
+							Name:     "addr",
+							Usage:    "IP address or CIDR range",
+							Required: true,
+						},
+					},
+				},
+				{
+					Name:     "bans",
+					Usage:    "list banned IP addresses",
+					Category: "Getters",
+					Action:   handleBans,
+				},
+				{
+					Name:     "add-tracker",
+					Usage:    "add tracker to torrent",
+					Category: "Actions",
+					Action:   handleAddTracker,
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:     "id",
 							Required: true,
 						},
+						cli.StringFlag{
+							Name:     "tracker,t",
+							Required: true,
+							Usage:    "tracker URL",
+						},
 					},
 				},
+				{
+					Name:     "announce",
+					Usage:    "announce to tracker",
+					Category: "Actions",
+					Action:   handleAnnounce,
+					Flags:    selectorFlags(),
+				},
+				{
+					Name:     "verify",
+					Usage:    "verify files",
+					Category: "Actions",
+					Action:   handleVerify,
+					Flags:    selectorFlags(),
+				},
+				{
+					Name:     "start",
+					Usage:    "start torrent",
+					Category: "Actions",
+					Action:   handleStart,
+					Flags:    selectorFlags(),
+				},
+				{
+					Name:     "stop",
+					Usage:    "stop torrent",
+					Category: "Actions",
+					Action:   handleStop,
+					Flags:    selectorFlags(),
+				},
 				{
 					Name:     "start-all",
 					Usage:    "start all torrents",
@@ -381,15 +571,28 @@ func main() {
 					Usage:    "move torrent to another server",
 					Category: "Actions",
 					Action:   handleMove,
+					Flags: selectorFlags(
+						cli.StringFlag{
+							Name:     "target",
+							Required: true,
+							Usage:    "target server in host:port format",
+						},
+					),
+				},
+				{
+					Name:     "move-data",
+					Usage:    "move files of torrent to another directory on the server",
+					Category: "Actions",
+					Action:   handleMoveData,
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:     "id",
 							Required: true,
 						},
 						cli.StringFlag{
-							Name:     "target",
+							Name:     "dir",
 							Required: true,
-							Usage:    "target server in host:port format",
+							Usage:    "target `DIR` on the server",
 						},
 					},
 				},
@@ -509,6 +712,10 @@ func main() {
 							Name:  "piece-length,l",
 							Usage: "override default piece length. by default, piece length calculated automatically based on the total size of files. given in KB. must be multiple of 16.",
 						},
+						cli.BoolFlag{
+							Name:  "pad-files",
+							Usage: "insert padding files so that each file starts at a piece boundary",
+						},
 						cli.StringFlag{
 							Name:  "comment,c",
 							Usage: "add `COMMENT` to torrent",
@@ -521,6 +728,66 @@ func main() {
 							Name:  "webseed,w",
 							Usage: "add webseed `URL`",
 						},
+						cli.StringFlag{
+							Name:  "sign-key",
+							Usage: "sign torrent with the PEM encoded private key in `FILE` (BEP 35)",
+						},
+						cli.StringFlag{
+							Name:  "sign-cert",
+							Usage: "include the PEM encoded certificate in `FILE` in the signature",
+						},
+						cli.StringFlag{
+							Name:  "signer",
+							Usage: "identity of the signer. default is the common name in the certificate",
+						},
+					},
+				},
+				{
+					Name:   "edit",
+					Usage:  "edit existing torrent file",
+					Action: handleTorrentEdit,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:     "file,f",
+							Usage:    "read torrent from `FILE`",
+							Required: true,
+						},
+						cli.StringFlag{
+							Name:  "out,o",
+							Usage: "save edited torrent to this `FILE`. input file is overwritten if not given.",
+						},
+						cli.StringSliceFlag{
+							Name:  "tracker,t",
+							Usage: "replace trackers with tracker `URL`. each tracker is put into a separate tier.",
+						},
+						cli.StringSliceFlag{
+							Name:  "add-tracker",
+							Usage: "add tracker `URL` as a new tier",
+						},
+						cli.BoolFlag{
+							Name:  "clear-trackers",
+							Usage: "remove all trackers",
+						},
+						cli.StringSliceFlag{
+							Name:  "webseed,w",
+							Usage: "replace webseeds with webseed `URL`",
+						},
+						cli.BoolFlag{
+							Name:  "clear-webseeds",
+							Usage: "remove all webseeds",
+						},
+						cli.StringFlag{
+							Name:  "comment,c",
+							Usage: "set comment. empty value removes the comment.",
+						},
+						cli.StringFlag{
+							Name:  "private,p",
+							Usage: "set private flag. changes info-hash. `VALUE` must be true or false.",
+						},
+						cli.StringFlag{
+							Name:  "source,s",
+							Usage: "set source tag. changes info-hash. empty value removes the tag.",
+						},
 					},
 				},
 			},
@@ -860,20 +1127,168 @@ func handleVersion(c *cli.Context) error {
 	return nil
 }
 
-func handleList(c *cli.Context) error {
-	resp, err := clt.ListTorrents()
+// selectorFlags returns the flags for selecting torrents in commands that can act on many torrents, followed by extra flags.
+func selectorFlags(extra ...cli.Flag) []cli.Flag {
+	flags := []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "id",
+			Usage: "select torrent by ID, can be given multiple times",
+		},
+		cli.BoolFlag{
+			Name:  "all",
+			Usage: "select all torrents",
+		},
+		cli.StringSliceFlag{
+			Name:  "status",
+			Usage: "select torrents by status (e.g. Seeding), can be given multiple times",
+		},
+		cli.StringFlag{
+			Name:  "name-regex",
+			Usage: "select torrents with names matching the regular expression",
+		},
+		cli.StringSliceFlag{
+			Name:  "info-hash",
+			Usage: "select torrent by info-hash, can be given multiple times",
+		},
+	}
+	return append(flags, extra...)
+}
+
+// outputFlags returns the flags for selecting output format and fields in getter commands, followed by extra flags.
+func outputFlags(extra ...cli.Flag) []cli.Flag {
+	flags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "output",
+			Usage: "output format: " + strings.Join(outputformat.Formats, ", "),
+		},
+		cli.StringFlag{
+			Name:  "fields",
+			Usage: "comma separated list of fields to print, nested fields are separated with dot (e.g. Bytes.Downloaded)",
+		},
+	}
+	return append(flags, extra...)
+}
+
+// selectTorrents returns the IDs of the torrents that are selected with the flags from selectorFlags.
+// Torrents given with --id are always selected.
+// Other flags select the torrents that match all of them.
+func selectTorrents(c *cli.Context) ([]string, error) {
+	ids := c.StringSlice("id")
+	statuses := c.StringSlice("status")
+	hashes := c.StringSlice("info-hash")
+	nameRegex := c.String("name-regex")
+	if !c.Bool("all") && len(statuses) == 0 && len(hashes) == 0 && nameRegex == "" {
+		if len(ids) == 0 {
+			return nil, errors.New("no torrent is selected, use --id, --all, --status, --name-regex or --info-hash flags")
+		}
+		return ids, nil
+	}
+	var re *regexp.Regexp
+	if nameRegex != "" {
+		var err error
+		re, err = regexp.Compile(nameRegex)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Torrents are filtered by status on the server.
+	torrents, err := clt.ListTorrentsByStatus(statuses...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	b, err := prettyjson.Marshal(resp)
+	selected := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		selected[id] = struct{}{}
+	}
+	for _, t := range torrents {
+		if _, ok := selected[t.ID]; ok {
+			continue
+		}
+		if re != nil && !re.MatchString(t.Name) {
+			continue
+		}
+		if len(hashes) > 0 && !containsFold(hashes, t.InfoHash) {
+			continue
+		}
+		ids = append(ids, t.ID)
+		selected[t.ID] = struct{}{}
+	}
+	return ids, nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// forEachTorrent calls fn with the ID of each selected torrent.
+// If fn returns an error, it is logged and the remaining torrents are processed.
+func forEachTorrent(c *cli.Context, fn func(id string) error) error {
+	ids, err := selectTorrents(c)
 	if err != nil {
 		return err
 	}
-	_, _ = os.Stdout.Write(b)
-	_, _ = os.Stdout.WriteString("\n")
+	return forEachID(ids, fn)
+}
+
+// forEachID calls fn with each ID.
+// If fn returns an error, it is logged and the remaining IDs are processed.
+func forEachID(ids []string, fn func(id string) error) error {
+	var err error
+	if len(ids) == 1 {
+		return fn(ids[0])
+	}
+	var failed int
+	for _, id := range ids {
+		err = fn(id)
+		if err != nil {
+			log.Errorf("torrent %s: %s", id, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d torrents failed", failed, len(ids))
+	}
 	return nil
 }
 
+// writeOutput prints v in the format selected with the flags from outputFlags.
+// If neither of the flags is given, v is printed as colored JSON.
+func writeOutput(c *cli.Context, v interface{}) error {
+	format := c.String("output")
+	var fields []string
+	if s := c.String("fields"); s != "" {
+		for _, f := range strings.Split(s, ",") {
+			fields = append(fields, strings.TrimSpace(f))
+		}
+	}
+	if format == "" {
+		if len(fields) == 0 {
+			b, err := prettyjson.Marshal(v)
+			if err != nil {
+				return err
+			}
+			_, _ = os.Stdout.Write(b)
+			_, _ = os.Stdout.WriteString("\n")
+			return nil
+		}
+		format = "table"
+	}
+	return outputformat.Write(os.Stdout, format, v, fields)
+}
+
+func handleList(c *cli.Context) error {
+	resp, err := clt.ListTorrents()
+	if err != nil {
+		return err
+	}
+	return writeOutput(c, resp)
+}
+
 func isURI(arg string) bool {
 	return strings.HasPrefix(arg, "magnet:") || strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://")
 }
@@ -887,6 +1302,7 @@ func handleAdd(c *cli.Context) error {
 		StopAfterDownload: c.Bool("stop-after-download"),
 		StopAfterMetadata: c.Bool("stop-after-metadata"),
 		ID:                c.String("id"),
+		DataDir:           c.String("data-dir"),
 	}
 	if isURI(arg) {
 		resp, err := clt.AddURI(arg, addOpt)
@@ -914,8 +1330,52 @@ func handleAdd(c *cli.Context) error {
 	return nil
 }
 
+func handleCreate(c *cli.Context) error {
+	opt := &rainrpc.CreateTorrentOptions{
+		ID:          c.String("id"),
+		Stopped:     c.Bool("stopped"),
+		Private:     c.Bool("private"),
+		PieceLength: uint32(c.Uint("piece-length") << 10),
+		PadFiles:    c.Bool("pad-files"),
+		Trackers:    c.StringSlice("tracker"),
+		Webseeds:    c.StringSlice("webseed"),
+		Comment:     c.String("comment"),
+		Progress:    logHashProgress(),
+	}
+	// Hashing large files may take much longer than a regular request.
+	clt.SetTimeout(0)
+	resp, torrentFile, err := clt.CreateTorrent(c.StringSlice("file"), opt)
+	if err != nil {
+		return err
+	}
+	if out := c.String("out"); out != "" {
+		out, err = homedir.Expand(out)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(out, torrentFile, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	b, err := prettyjson.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	_, _ = os.Stdout.Write(b)
+	_, _ = os.Stdout.WriteString("\n")
+	return nil
+}
+
 func handleRemove(c *cli.Context) error {
-	return clt.RemoveTorrent(c.String("id"))
+	ids, err := selectTorrents(c)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 1 && !c.Bool("yes") {
+		return fmt.Errorf("%d torrents are selected, use --yes to remove all of them with their data", len(ids))
+	}
+	return forEachID(ids, clt.RemoveTorrent)
 }
 
 func handleCleanDatabase(c *cli.Context) error {
@@ -927,14 +1387,8 @@ func handleStats(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	if c.Bool("json") {
-		b, err := prettyjson.Marshal(s)
-		if err != nil {
-			return err
-		}
-		_, _ = os.Stdout.Write(b)
-		_, _ = os.Stdout.WriteString("\n")
-		return nil
+	if c.Bool("json") || c.IsSet("output") || c.IsSet("fields") {
+		return writeOutput(c, s)
 	}
 	console.FormatStats(s, os.Stdout)
 	return nil
@@ -958,11 +1412,36 @@ func handleSessionStats(c *cli.Context) error {
 	return nil
 }
 
+func handleSessionConfig(c *cli.Context) error {
+	cfg, err := clt.GetSessionConfig()
+	if err != nil {
+		return err
+	}
+	_, _ = os.Stdout.WriteString(cfg)
+	return nil
+}
+
 func handleTrackers(c *cli.Context) error {
 	resp, err := clt.GetTorrentTrackers(c.String("id"))
 	if err != nil {
 		return err
 	}
+	return writeOutput(c, resp)
+}
+
+func handleWebseeds(c *cli.Context) error {
+	resp, err := clt.GetTorrentWebseeds(c.String("id"))
+	if err != nil {
+		return err
+	}
+	return writeOutput(c, resp)
+}
+
+func handleFiles(c *cli.Context) error {
+	resp, err := clt.GetTorrentFiles(c.String("id"))
+	if err != nil {
+		return err
+	}
 	b, err := prettyjson.Marshal(resp)
 	if err != nil {
 		return err
@@ -972,8 +1451,8 @@ func handleTrackers(c *cli.Context) error {
 	return nil
 }
 
-func handleWebseeds(c *cli.Context) error {
-	resp, err := clt.GetTorrentWebseeds(c.String("id"))
+func handlePieces(c *cli.Context) error {
+	resp, err := clt.GetTorrentPieces(c.String("id"))
 	if err != nil {
 		return err
 	}
@@ -991,6 +1470,42 @@ func handlePeers(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	return writeOutput(c, resp)
+}
+
+func handleAddPeer(c *cli.Context) error {
+	return clt.AddPeer(c.String("id"), c.String("addr"))
+}
+
+func handleSetFilePriority(c *cli.Context) error {
+	return clt.SetFilePriority(c.String("id"), c.Int("index"), c.String("priority"))
+}
+
+func handleSetSuperSeed(c *cli.Context) error {
+	return clt.SetSuperSeed(c.String("id"), c.BoolT("enabled"))
+}
+
+func handleSetSpeedLimit(c *cli.Context) error {
+	return clt.SetSpeedLimit(c.String("id"), c.Int64("download"), c.Int64("upload"))
+}
+
+func handleDisconnectPeer(c *cli.Context) error {
+	return clt.DisconnectPeer(c.String("id"), c.String("addr"))
+}
+
+func handleBan(c *cli.Context) error {
+	return clt.BanIP(c.String("id"), c.String("addr"), c.Duration("duration"))
+}
+
+func handleUnban(c *cli.Context) error {
+	return clt.UnbanIP(c.String("id"), c.String("addr"))
+}
+
+func handleBans(c *cli.Context) error {
+	resp, err := clt.ListBans()
+	if err != nil {
+		return err
+	}
 	b, err := prettyjson.Marshal(resp)
 	if err != nil {
 		return err
@@ -1000,28 +1515,24 @@ func handlePeers(c *cli.Context) error {
 	return nil
 }
 
-func handleAddPeer(c *cli.Context) error {
-	return clt.AddPeer(c.String("id"), c.String("addr"))
-}
-
 func handleAddTracker(c *cli.Context) error {
 	return clt.AddTracker(c.String("id"), c.String("tracker"))
 }
 
 func handleAnnounce(c *cli.Context) error {
-	return clt.AnnounceTorrent(c.String("id"))
+	return forEachTorrent(c, clt.AnnounceTorrent)
 }
 
 func handleVerify(c *cli.Context) error {
-	return clt.VerifyTorrent(c.String("id"))
+	return forEachTorrent(c, clt.VerifyTorrent)
 }
 
 func handleStart(c *cli.Context) error {
-	return clt.StartTorrent(c.String("id"))
+	return forEachTorrent(c, clt.StartTorrent)
 }
 
 func handleStop(c *cli.Context) error {
-	return clt.StopTorrent(c.String("id"))
+	return forEachTorrent(c, clt.StopTorrent)
 }
 
 func handleStartAll(c *cli.Context) error {
@@ -1033,7 +1544,33 @@ func handleStopAll(c *cli.Context) error {
 }
 
 func handleMove(c *cli.Context) error {
-	return clt.MoveTorrent(c.String("id"), c.String("target"))
+	// Moving files to another server may take much longer than a regular request.
+	clt.SetTimeout(0)
+	target := c.String("target")
+	return forEachTorrent(c, func(id string) error {
+		return clt.MoveTorrent(id, target)
+	})
+}
+
+func handleMoveData(c *cli.Context) error {
+	id := c.String("id")
+	// Copying files across disks may take much longer than a regular request.
+	clt.SetTimeout(0)
+	errC := make(chan error, 1)
+	go func() { errC <- clt.MoveTorrentData(id, c.String("dir")) }()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errC:
+			return err
+		case <-ticker.C:
+			stats, err := clt.GetTorrentStats(id)
+			if err == nil && stats.Status == "Moving" && stats.Bytes.Total > 0 {
+				log.Infof("Moved: %d%%", stats.Bytes.Moved*100/stats.Bytes.Total)
+			}
+		}
+	}
 }
 
 func handleConsole(c *cli.Context) error {
@@ -1088,6 +1625,18 @@ func handleInfoHash(c *cli.Context) error {
 	return nil
 }
 
+// logHashProgress returns a function that logs the percentage of hashed bytes whenever it changes.
+func logHashProgress() func(hashed, total int64) {
+	last := int64(-1)
+	return func(hashed, total int64) {
+		percent := hashed * 100 / total
+		if percent != last {
+			last = percent
+			log.Infof("Hashed: %d%%", percent)
+		}
+	}
+}
+
 func handleTorrentCreate(c *cli.Context) error {
 	paths := c.StringSlice("file")
 	out := c.String("out")
@@ -1116,14 +1665,20 @@ func handleTorrentCreate(c *cli.Context) error {
 		tiers[i] = []string{tr}
 	}
 
-	info, err := metainfo.NewInfoBytes(root, paths, private, uint32(pieceLength<<10), name, log)
+	info, err := metainfo.NewInfoBytes(root, paths, private, uint32(pieceLength<<10), name, c.Bool("pad-files"), logHashProgress(), log)
 	if err != nil {
 		return err
 	}
-	mi, err := metainfo.NewBytes(info, tiers, webseeds, comment)
+	mi, err := metainfo.NewBytes(info, tiers, webseeds, nil, comment)
 	if err != nil {
 		return err
 	}
+	if c.IsSet("sign-key") {
+		mi, err = signTorrent(mi, c.String("sign-key"), c.String("sign-cert"), c.String("signer"))
+		if err != nil {
+			return err
+		}
+	}
 	log.Infof("Created torrent size: %d bytes", len(mi))
 	f, err := os.Create(out)
 	if err != nil {
@@ -1136,6 +1691,142 @@ func handleTorrentCreate(c *cli.Context) error {
 	return f.Close()
 }
 
+func signTorrent(mi []byte, keyPath, certPath, signer string) ([]byte, error) {
+	key, err := readPrivateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	var cert *x509.Certificate
+	if certPath != "" {
+		cert, err = readCertificate(certPath)
+		if err != nil {
+			return nil, err
+		}
+		if signer == "" {
+			signer = cert.Subject.CommonName
+		}
+	}
+	if signer == "" {
+		return nil, errors.New("signer is required if certificate is not given")
+	}
+	return metainfo.Sign(mi, signer, key, cert)
+}
+
+func readPEM(path, typ string) (*pem.Block, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("no %s in %s", strings.ToLower(typ), path)
+		}
+		if strings.HasSuffix(block.Type, typ) {
+			return block, nil
+		}
+	}
+}
+
+func readPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+	return signer, nil
+}
+
+func readCertificate(path string) (*x509.Certificate, error) {
+	block, err := readPEM(path, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func handleTorrentEdit(c *cli.Context) error {
+	in, err := homedir.Expand(c.String("file"))
+	if err != nil {
+		return err
+	}
+	out := in
+	if c.IsSet("out") {
+		out, err = homedir.Expand(c.String("out"))
+		if err != nil {
+			return err
+		}
+	}
+	tor, err := torrentfile.Load(in)
+	if err != nil {
+		return err
+	}
+	oldHash := tor.InfoHash()
+
+	if c.Bool("clear-trackers") || c.IsSet("tracker") || c.IsSet("add-tracker") {
+		var tiers [][]string
+		if !c.Bool("clear-trackers") && !c.IsSet("tracker") {
+			tiers = tor.Trackers()
+		}
+		for _, tr := range c.StringSlice("tracker") {
+			tiers = append(tiers, []string{tr})
+		}
+		for _, tr := range c.StringSlice("add-tracker") {
+			tiers = append(tiers, []string{tr})
+		}
+		tor.SetTrackers(tiers)
+	}
+
+	if c.Bool("clear-webseeds") {
+		tor.SetWebseeds(nil)
+	} else if c.IsSet("webseed") {
+		tor.SetWebseeds(c.StringSlice("webseed"))
+	}
+	if c.IsSet("comment") {
+		tor.SetComment(c.String("comment"))
+	}
+	if c.IsSet("private") {
+		private, err2 := strconv.ParseBool(c.String("private"))
+		if err2 != nil {
+			return err2
+		}
+		err = tor.SetPrivate(private)
+		if err != nil {
+			return err
+		}
+	}
+	if c.IsSet("source") {
+		err = tor.SetSource(c.String("source"))
+		if err != nil {
+			return err
+		}
+	}
+	if newHash := tor.InfoHash(); newHash != oldHash {
+		log.Infof("Info-hash changed from %s to %s", hex.EncodeToString(oldHash[:]), hex.EncodeToString(newHash[:]))
+	}
+	return tor.Save(out)
+}
+
 func handleSaveTorrent(c *cli.Context) error {
 	torrent, err := clt.GetTorrent(c.String("id"))
 	if err != nil {
@@ -2,7 +2,9 @@
 package rainrpc
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -57,12 +59,21 @@ func (c *Client) ListTorrents() ([]rpctypes.Torrent, error) {
 	return reply.Torrents, c.client.Call("Session.ListTorrents", nil, &reply)
 }
 
+// ListTorrentsByStatus returns the list of torrents in remote Session that have one of the statuses.
+// The list is filtered on the server, so statuses of torrents do not need to be requested one by one.
+func (c *Client) ListTorrentsByStatus(statuses ...string) ([]rpctypes.Torrent, error) {
+	args := rpctypes.ListTorrentsRequest{Status: statuses}
+	var reply rpctypes.ListTorrentsResponse
+	return reply.Torrents, c.client.Call("Session.ListTorrents", args, &reply)
+}
+
 // AddTorrentOptions contains optional parameters for adding a new Torrent.
 type AddTorrentOptions struct {
 	ID                string
 	Stopped           bool
 	StopAfterDownload bool
 	StopAfterMetadata bool
+	DataDir           string
 }
 
 // AddTorrent adds a new torrent by reading .torrent file.
@@ -77,6 +88,7 @@ func (c *Client) AddTorrent(f io.Reader, options *AddTorrentOptions) (*rpctypes.
 		args.AddTorrentOptions.Stopped = options.Stopped
 		args.AddTorrentOptions.StopAfterDownload = options.StopAfterDownload
 		args.AddTorrentOptions.StopAfterMetadata = options.StopAfterMetadata
+		args.AddTorrentOptions.DataDir = options.DataDir
 	}
 	var reply rpctypes.AddTorrentResponse
 	return &reply.Torrent, c.client.Call("Session.AddTorrent", args, &reply)
@@ -90,12 +102,98 @@ func (c *Client) AddURI(uri string, options *AddTorrentOptions) (*rpctypes.Torre
 		args.AddTorrentOptions.Stopped = options.Stopped
 		args.AddTorrentOptions.StopAfterDownload = options.StopAfterDownload
 		args.AddTorrentOptions.StopAfterMetadata = options.StopAfterMetadata
+		args.AddTorrentOptions.DataDir = options.DataDir
 	}
 	var reply rpctypes.AddURIResponse
 	return &reply.Torrent, c.client.Call("Session.AddURI", args, &reply)
 }
 
+// CreateTorrentOptions contains optional parameters for creating a new Torrent.
+type CreateTorrentOptions struct {
+	ID          string
+	Stopped     bool
+	Private     bool
+	PieceLength uint32
+	PadFiles    bool
+	Trackers    []string
+	Webseeds    []string
+	Comment     string
+	// If not nil, called periodically with the number of bytes hashed so far while the torrent is being created.
+	Progress func(hashed, total int64)
+}
+
+// Interval for polling the hashing progress while creating a torrent.
+const createProgressInterval = time.Second
+
+// CreateTorrent creates a new torrent from files at paths on the remote server and starts seeding it.
+// Returned byte slice contains the contents of the created .torrent file.
+func (c *Client) CreateTorrent(paths []string, options *CreateTorrentOptions) (*rpctypes.Torrent, []byte, error) {
+	args := rpctypes.CreateTorrentRequest{Paths: paths}
+	if options != nil && options.Progress != nil {
+		var b [16]byte
+		_, err := rand.Read(b[:])
+		if err != nil {
+			return nil, nil, err
+		}
+		args.ProgressID = hex.EncodeToString(b[:])
+		closeC := make(chan struct{})
+		doneC := make(chan struct{})
+		defer func() {
+			close(closeC)
+			<-doneC
+		}()
+		go c.pollCreateProgress(args.ProgressID, options.Progress, closeC, doneC)
+	}
+	if options != nil {
+		args.ID = options.ID
+		args.Stopped = options.Stopped
+		args.Private = options.Private
+		args.PieceLength = options.PieceLength
+		args.PadFiles = options.PadFiles
+		args.Trackers = options.Trackers
+		args.Webseeds = options.Webseeds
+		args.Comment = options.Comment
+	}
+	var reply rpctypes.CreateTorrentResponse
+	err := c.client.Call("Session.CreateTorrent", args, &reply)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(reply.TorrentFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &reply.Torrent, b, nil
+}
+
+func (c *Client) pollCreateProgress(progressID string, f func(hashed, total int64), closeC, doneC chan struct{}) {
+	defer close(doneC)
+	ticker := time.NewTicker(createProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			hashed, total, err := c.GetCreateTorrentProgress(progressID)
+			// Creation may not be started on the server yet.
+			if err == nil && total > 0 {
+				f(hashed, total)
+			}
+		case <-closeC:
+			return
+		}
+	}
+}
+
+// GetCreateTorrentProgress returns the hashing progress of a torrent that is being created with the progress ID on the remote server.
+func (c *Client) GetCreateTorrentProgress(progressID string) (hashed, total int64, err error) {
+	args := rpctypes.GetCreateTorrentProgressRequest{ProgressID: progressID}
+	var reply rpctypes.GetCreateTorrentProgressResponse
+	err = c.client.Call("Session.GetCreateTorrentProgress", args, &reply)
+	return reply.Hashed, reply.Total, err
+}
+
 // RemoveTorrent removes a torrent from remote Session and deletes its data.
+// Original files of the torrents created with CreateTorrent are kept.
 func (c *Client) RemoveTorrent(id string) error {
 	args := rpctypes.RemoveTorrentRequest{ID: id}
 	var reply rpctypes.RemoveTorrentResponse
@@ -156,6 +254,36 @@ func (c *Client) GetTorrentPeers(id string) ([]rpctypes.Peer, error) {
 	return reply.Peers, c.client.Call("Session.GetTorrentPeers", args, &reply)
 }
 
+// GetTorrentFiles returns the files of a torrent with their download progress.
+func (c *Client) GetTorrentFiles(id string) ([]rpctypes.File, error) {
+	args := rpctypes.GetTorrentFilesRequest{ID: id}
+	var reply rpctypes.GetTorrentFilesResponse
+	return reply.Files, c.client.Call("Session.GetTorrentFiles", args, &reply)
+}
+
+// SetFilePriority changes the download priority of a file in a torrent.
+// index is the index of the file in the list returned from GetTorrentFiles.
+// priority is one of "Skip", "Low", "Normal" or "High".
+func (c *Client) SetFilePriority(id string, index int, priority string) error {
+	args := rpctypes.SetFilePriorityRequest{ID: id, Index: index, Priority: priority}
+	var reply rpctypes.SetFilePriorityResponse
+	return c.client.Call("Session.SetFilePriority", args, &reply)
+}
+
+// SetSuperSeed enables or disables super-seeding mode of a torrent.
+func (c *Client) SetSuperSeed(id string, enabled bool) error {
+	args := rpctypes.SetSuperSeedRequest{ID: id, Enabled: enabled}
+	var reply rpctypes.SetSuperSeedResponse
+	return c.client.Call("Session.SetSuperSeed", args, &reply)
+}
+
+// GetTorrentPieces returns the state of the pieces of a torrent.
+func (c *Client) GetTorrentPieces(id string) (*rpctypes.Pieces, error) {
+	args := rpctypes.GetTorrentPiecesRequest{ID: id}
+	var reply rpctypes.GetTorrentPiecesResponse
+	return &reply.Pieces, c.client.Call("Session.GetTorrentPieces", args, &reply)
+}
+
 // GetTorrentWebseeds returns the WebSeed sources of a torrent.
 func (c *Client) GetTorrentWebseeds(id string) ([]rpctypes.Webseed, error) {
 	args := rpctypes.GetTorrentWebseedsRequest{ID: id}
@@ -199,6 +327,14 @@ func (c *Client) MoveTorrent(id, target string) error {
 	return c.client.Call("Session.MoveTorrent", args, &reply)
 }
 
+// MoveTorrentData moves the files of the torrent into another directory on the server.
+// The call blocks until all files are moved.
+func (c *Client) MoveTorrentData(id, dir string) error {
+	args := rpctypes.MoveTorrentDataRequest{ID: id, Dir: dir}
+	var reply rpctypes.MoveTorrentDataResponse
+	return c.client.Call("Session.MoveTorrentData", args, &reply)
+}
+
 // StartAllTorrents starts all torrents in the Session.
 func (c *Client) StartAllTorrents() error {
 	args := rpctypes.StartAllTorrentsRequest{}
@@ -220,6 +356,68 @@ func (c *Client) AddPeer(id string, addr string) error {
 	return c.client.Call("Session.AddPeer", args, &reply)
 }
 
+// DisconnectPeer closes the connection to a peer of a torrent.
+func (c *Client) DisconnectPeer(id string, addr string) error {
+	args := rpctypes.DisconnectPeerRequest{ID: id, Addr: addr}
+	var reply rpctypes.DisconnectPeerResponse
+	return c.client.Call("Session.DisconnectPeer", args, &reply)
+}
+
+// BanIP bans an IP address or a CIDR range in a torrent.
+// If id is empty, the ban applies to all torrents.
+// If d is not zero, the ban is removed after d.
+func (c *Client) BanIP(id string, addr string, d time.Duration) error {
+	args := rpctypes.BanIPRequest{ID: id, Addr: addr, Duration: int(d / time.Second)}
+	var reply rpctypes.BanIPResponse
+	return c.client.Call("Session.BanIP", args, &reply)
+}
+
+// SetSpeedLimit changes the download and upload speed limits of a torrent in KB/s. Zero means no limit.
+// If id is empty, the global limits of the session are changed.
+func (c *Client) SetSpeedLimit(id string, download, upload int64) error {
+	args := rpctypes.SetSpeedLimitRequest{ID: id, Download: download, Upload: upload}
+	var reply rpctypes.SetSpeedLimitResponse
+	return c.client.Call("Session.SetSpeedLimit", args, &reply)
+}
+
+// GetSpeedLimit returns the download and upload speed limits of a torrent in KB/s.
+// If id is empty, the global limits of the session are returned.
+func (c *Client) GetSpeedLimit(id string) (download, upload int64, err error) {
+	args := rpctypes.GetSpeedLimitRequest{ID: id}
+	var reply rpctypes.GetSpeedLimitResponse
+	err = c.client.Call("Session.GetSpeedLimit", args, &reply)
+	return reply.Download, reply.Upload, err
+}
+
+// GetSessionConfig returns the config of the remote Session in YAML format.
+func (c *Client) GetSessionConfig() (string, error) {
+	var args rpctypes.GetSessionConfigRequest
+	var reply rpctypes.GetSessionConfigResponse
+	return reply.Config, c.client.Call("Session.GetSessionConfig", args, &reply)
+}
+
+// SetSessionConfig changes the config of the remote Session.
+// config is in YAML format. Only the fields that can be changed at runtime may differ from the current config.
+func (c *Client) SetSessionConfig(config string) error {
+	args := rpctypes.SetSessionConfigRequest{Config: config}
+	var reply rpctypes.SetSessionConfigResponse
+	return c.client.Call("Session.SetSessionConfig", args, &reply)
+}
+
+// UnbanIP removes a ban that is added with BanIP.
+func (c *Client) UnbanIP(id string, addr string) error {
+	args := rpctypes.UnbanIPRequest{ID: id, Addr: addr}
+	var reply rpctypes.UnbanIPResponse
+	return c.client.Call("Session.UnbanIP", args, &reply)
+}
+
+// ListBans returns the bans in the session.
+func (c *Client) ListBans() ([]rpctypes.Ban, error) {
+	var args rpctypes.ListBansRequest
+	var reply rpctypes.ListBansResponse
+	return reply.Bans, c.client.Call("Session.ListBans", args, &reply)
+}
+
 // AddTracker adds a new tracker to a torrent.
 func (c *Client) AddTracker(id string, uri string) error {
 	args := rpctypes.AddTrackerRequest{ID: id, URL: uri}
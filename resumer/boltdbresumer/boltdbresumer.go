@@ -0,0 +1,649 @@
+// Package boltdbresumer provides a Resumer implementation that uses a Bolt database file as storage.
+package boltdbresumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/ganqierwu/rain/resumer"
+	"go.etcd.io/bbolt"
+)
+
+// Keys for the persisten storage.
+var Keys = struct {
+	InfoHash           []byte
+	Port               []byte
+	Name               []byte
+	Trackers           []byte
+	URLList            []byte
+	HTTPSeeds          []byte
+	FixedPeers         []byte
+	Dest               []byte
+	KeepData           []byte
+	FilePriorities     []byte
+	Info               []byte
+	Bitfield           []byte
+	AddedAt            []byte
+	BytesDownloaded    []byte
+	BytesUploaded      []byte
+	BytesWasted        []byte
+	SeededFor          []byte
+	Started            []byte
+	StopAfterDownload  []byte
+	StopAfterMetadata  []byte
+	CompleteCmdRun     []byte
+	SpeedLimitDownload []byte
+	SpeedLimitUpload   []byte
+	SuperSeed          []byte
+	Signer             []byte
+	MutableLink        []byte
+	DestOwned          []byte
+	PreviousVersion    []byte
+}{
+	InfoHash:           []byte("info_hash"),
+	Port:               []byte("port"),
+	Name:               []byte("name"),
+	Trackers:           []byte("trackers"),
+	URLList:            []byte("url_list"),
+	HTTPSeeds:          []byte("http_seeds"),
+	FixedPeers:         []byte("fixed_peers"),
+	Dest:               []byte("dest"),
+	KeepData:           []byte("keep_data"),
+	FilePriorities:     []byte("file_priorities"),
+	Info:               []byte("info"),
+	Bitfield:           []byte("bitfield"),
+	AddedAt:            []byte("added_at"),
+	BytesDownloaded:    []byte("bytes_downloaded"),
+	BytesUploaded:      []byte("bytes_uploaded"),
+	BytesWasted:        []byte("bytes_wasted"),
+	SeededFor:          []byte("seeded_for"),
+	Started:            []byte("started"),
+	StopAfterDownload:  []byte("stop_after_download"),
+	StopAfterMetadata:  []byte("stop_after_metadata"),
+	CompleteCmdRun:     []byte("complete_cmd_run"),
+	SpeedLimitDownload: []byte("speed_limit_download"),
+	SpeedLimitUpload:   []byte("speed_limit_upload"),
+	SuperSeed:          []byte("super_seed"),
+	Signer:             []byte("signer"),
+	MutableLink:        []byte("mutable_link"),
+	DestOwned:          []byte("dest_owned"),
+	PreviousVersion:    []byte("previous_version"),
+}
+
+// Resumer contains methods for saving/loading resume information of a torrent to a BoltDB database.
+type Resumer struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+var _ resumer.Resumer = (*Resumer)(nil)
+
+// New returns a new Resumer.
+func New(db *bbolt.DB, bucket []byte) (*Resumer, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err2 := tx.CreateBucketIfNotExists(bucket)
+		return err2
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Resumer{
+		db:     db,
+		bucket: bucket,
+	}, nil
+}
+
+// Write the torrent spec for torrent with `torrentID`.
+func (r *Resumer) Write(torrentID string, spec *resumer.Spec) error {
+	port := strconv.Itoa(spec.Port)
+	trackers, err := json.Marshal(spec.Trackers)
+	if err != nil {
+		return err
+	}
+	urlList, err := json.Marshal(spec.URLList)
+	if err != nil {
+		return err
+	}
+	httpSeeds, err := json.Marshal(spec.HTTPSeeds)
+	if err != nil {
+		return err
+	}
+	fixedPeers, err := json.Marshal(spec.FixedPeers)
+	if err != nil {
+		return err
+	}
+	filePriorities, err := json.Marshal(spec.FilePriorities)
+	if err != nil {
+		return err
+	}
+	var previousVersion []byte
+	if spec.PreviousVersion != nil {
+		previousVersion, err = json.Marshal(spec.PreviousVersion)
+		if err != nil {
+			return err
+		}
+	}
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(r.bucket).CreateBucketIfNotExists([]byte(torrentID))
+		if err != nil {
+			return err
+		}
+		_ = b.Put(Keys.InfoHash, spec.InfoHash)
+		_ = b.Put(Keys.Port, []byte(port))
+		_ = b.Put(Keys.Name, []byte(spec.Name))
+		_ = b.Put(Keys.Trackers, trackers)
+		_ = b.Put(Keys.URLList, urlList)
+		_ = b.Put(Keys.HTTPSeeds, httpSeeds)
+		_ = b.Put(Keys.FixedPeers, fixedPeers)
+		_ = b.Put(Keys.Dest, []byte(spec.Dest))
+		_ = b.Put(Keys.KeepData, []byte(strconv.FormatBool(spec.KeepData)))
+		_ = b.Put(Keys.FilePriorities, filePriorities)
+		_ = b.Put(Keys.Info, spec.Info)
+		_ = b.Put(Keys.Bitfield, spec.Bitfield)
+		_ = b.Put(Keys.AddedAt, []byte(spec.AddedAt.Format(time.RFC3339)))
+		_ = b.Put(Keys.BytesDownloaded, []byte(strconv.FormatInt(spec.BytesDownloaded, 10)))
+		_ = b.Put(Keys.BytesUploaded, []byte(strconv.FormatInt(spec.BytesUploaded, 10)))
+		_ = b.Put(Keys.BytesWasted, []byte(strconv.FormatInt(spec.BytesWasted, 10)))
+		_ = b.Put(Keys.SeededFor, []byte(spec.SeededFor.String()))
+		_ = b.Put(Keys.Started, []byte(strconv.FormatBool(spec.Started)))
+		_ = b.Put(Keys.StopAfterDownload, []byte(strconv.FormatBool(spec.StopAfterDownload)))
+		_ = b.Put(Keys.StopAfterMetadata, []byte(strconv.FormatBool(spec.StopAfterMetadata)))
+		_ = b.Put(Keys.CompleteCmdRun, []byte(strconv.FormatBool(spec.CompleteCmdRun)))
+		_ = b.Put(Keys.SpeedLimitDownload, []byte(strconv.FormatInt(spec.SpeedLimitDownload, 10)))
+		_ = b.Put(Keys.SpeedLimitUpload, []byte(strconv.FormatInt(spec.SpeedLimitUpload, 10)))
+		_ = b.Put(Keys.SuperSeed, []byte(strconv.FormatBool(spec.SuperSeed)))
+		_ = b.Put(Keys.Signer, []byte(spec.Signer))
+		_ = b.Put(Keys.MutableLink, []byte(spec.MutableLink))
+		_ = b.Put(Keys.DestOwned, []byte(strconv.FormatBool(spec.DestOwned)))
+		if previousVersion != nil {
+			_ = b.Put(Keys.PreviousVersion, previousVersion)
+		} else {
+			_ = b.Delete(Keys.PreviousVersion)
+		}
+		return nil
+	})
+}
+
+// WriteInfo writes only the info dict of a torrent.
+func (r *Resumer) WriteInfo(torrentID string, value []byte) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		return b.Put(Keys.Info, value)
+	})
+}
+
+// WriteBitfield writes only bitfield of a torrent.
+func (r *Resumer) WriteBitfield(torrentID string, value []byte) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		if value == nil {
+			return b.Delete(Keys.Bitfield)
+		}
+		return b.Put(Keys.Bitfield, value)
+	})
+}
+
+// WriteStats writes the transfer statistics of a torrent.
+func (r *Resumer) WriteStats(torrentID string, stats resumer.Stats) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		putStats(b, stats)
+		return nil
+	})
+}
+
+// WriteStates writes the stats and bitfields of multiple torrents in a single transaction.
+func (r *Resumer) WriteStates(states map[string]resumer.State) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		mb := tx.Bucket(r.bucket)
+		for id, state := range states {
+			b := mb.Bucket([]byte(id))
+			if b == nil {
+				continue
+			}
+			putStats(b, state.Stats)
+			if state.Bitfield != nil {
+				_ = b.Put(Keys.Bitfield, state.Bitfield)
+			}
+		}
+		return nil
+	})
+}
+
+func putStats(b *bbolt.Bucket, stats resumer.Stats) {
+	_ = b.Put(Keys.BytesDownloaded, []byte(strconv.FormatInt(stats.BytesDownloaded, 10)))
+	_ = b.Put(Keys.BytesUploaded, []byte(strconv.FormatInt(stats.BytesUploaded, 10)))
+	_ = b.Put(Keys.BytesWasted, []byte(strconv.FormatInt(stats.BytesWasted, 10)))
+	_ = b.Put(Keys.SeededFor, []byte(time.Duration(stats.SeededFor).String()))
+}
+
+// WriteTrackers writes the tracker tiers of a torrent.
+func (r *Resumer) WriteTrackers(torrentID string, trackers [][]string) error {
+	value, err := json.Marshal(trackers)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		return b.Put(Keys.Trackers, value)
+	})
+}
+
+// WriteDest writes the custom data directory of a torrent.
+func (r *Resumer) WriteDest(torrentID string, dest string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		err := b.Delete(Keys.DestOwned)
+		if err != nil {
+			return err
+		}
+		if dest == "" {
+			return b.Delete(Keys.Dest)
+		}
+		return b.Put(Keys.Dest, []byte(dest))
+	})
+}
+
+// WritePreviousVersion writes the previous version of a mutable torrent.
+func (r *Resumer) WritePreviousVersion(torrentID string, value *resumer.PreviousVersion) error {
+	var b2 []byte
+	if value != nil {
+		var err error
+		b2, err = json.Marshal(value)
+		if err != nil {
+			return err
+		}
+	}
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		if b2 == nil {
+			return b.Delete(Keys.PreviousVersion)
+		}
+		return b.Put(Keys.PreviousVersion, b2)
+	})
+}
+
+// WriteFilePriorities writes the download priorities of the files in a torrent.
+func (r *Resumer) WriteFilePriorities(torrentID string, priorities []int) error {
+	value, err := json.Marshal(priorities)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		if priorities == nil {
+			return b.Delete(Keys.FilePriorities)
+		}
+		return b.Put(Keys.FilePriorities, value)
+	})
+}
+
+// WriteSpeedLimit writes the download and upload speed limits of a torrent in KB/s.
+func (r *Resumer) WriteSpeedLimit(torrentID string, download, upload int64) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		_ = b.Put(Keys.SpeedLimitDownload, []byte(strconv.FormatInt(download, 10)))
+		_ = b.Put(Keys.SpeedLimitUpload, []byte(strconv.FormatInt(upload, 10)))
+		return nil
+	})
+}
+
+// WriteSuperSeed writes the super-seeding mode of a torrent.
+func (r *Resumer) WriteSuperSeed(torrentID string, value bool) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		return b.Put(Keys.SuperSeed, []byte(strconv.FormatBool(value)))
+	})
+}
+
+// WriteStarted writes the start status of a torrent.
+func (r *Resumer) WriteStarted(torrentID string, value bool) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		return b.Put(Keys.Started, []byte(strconv.FormatBool(value)))
+	})
+}
+
+// HandleStopAfterDownload clears the start status and stop_after_download fields.
+func (r *Resumer) HandleStopAfterDownload(torrentID string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		err := b.Put(Keys.Started, []byte(strconv.FormatBool(false)))
+		if err != nil {
+			return err
+		}
+		return b.Put(Keys.StopAfterDownload, []byte(strconv.FormatBool(false)))
+	})
+}
+
+// HandleStopAfterMetadata clears the start status and stop_after_metadata fields.
+func (r *Resumer) HandleStopAfterMetadata(torrentID string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		err := b.Put(Keys.Started, []byte(strconv.FormatBool(false)))
+		if err != nil {
+			return err
+		}
+		return b.Put(Keys.StopAfterMetadata, []byte(strconv.FormatBool(false)))
+	})
+}
+
+// WriteCompleteCmdRun writes the start status of a torrent.
+func (r *Resumer) WriteCompleteCmdRun(torrentID string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return nil
+		}
+		return b.Put(Keys.CompleteCmdRun, []byte(strconv.FormatBool(true)))
+	})
+}
+
+// List returns the IDs of all torrents in the database.
+func (r *Resumer) List() ([]string, error) {
+	var ids []string
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(r.bucket).ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// Delete the torrent with `torrentID` from the database.
+func (r *Resumer) Delete(torrentID string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		err := tx.Bucket(r.bucket).DeleteBucket([]byte(torrentID))
+		if err == bbolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// Read the torrent spec for torrent with `torrentID`.
+func (r *Resumer) Read(torrentID string) (spec *resumer.Spec, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot read torrent %q from db: %s", torrentID, r)
+		}
+	}()
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket).Bucket([]byte(torrentID))
+		if b == nil {
+			return fmt.Errorf("bucket not found: %q", torrentID)
+		}
+
+		value := b.Get(Keys.InfoHash)
+		if value == nil {
+			return fmt.Errorf("key not found: %q", string(Keys.InfoHash))
+		}
+
+		spec = new(resumer.Spec)
+		spec.InfoHash = make([]byte, len(value))
+		copy(spec.InfoHash, value)
+
+		var err error
+		value = b.Get(Keys.Port)
+		spec.Port, err = strconv.Atoi(string(value))
+		if err != nil {
+			return err
+		}
+
+		value = b.Get(Keys.Name)
+		if value != nil {
+			spec.Name = string(value)
+		}
+
+		value = b.Get(Keys.Trackers)
+		if value != nil {
+			err = json.Unmarshal(value, &spec.Trackers)
+			if err != nil {
+				// Try to unmarshal old format `[]string`
+				trackers := make([]string, 0)
+				err = json.Unmarshal(value, &trackers)
+				if err != nil {
+					return err
+				}
+				// Migrate to new format `[][]string`
+				spec.Trackers = make([][]string, len(trackers))
+				for i, t := range trackers {
+					spec.Trackers[i] = []string{t}
+				}
+				// Save in new format
+				bt, err := json.Marshal(spec.Trackers)
+				if err != nil {
+					return err
+				}
+				err = b.Put(Keys.Trackers, bt)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		value = b.Get(Keys.URLList)
+		if value != nil {
+			err = json.Unmarshal(value, &spec.URLList)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.HTTPSeeds)
+		if value != nil {
+			err = json.Unmarshal(value, &spec.HTTPSeeds)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.FixedPeers)
+		if value != nil {
+			err = json.Unmarshal(value, &spec.FixedPeers)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.Dest)
+		if value != nil {
+			spec.Dest = string(value)
+		}
+
+		value = b.Get(Keys.KeepData)
+		if value != nil {
+			spec.KeepData, err = strconv.ParseBool(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.FilePriorities)
+		if value != nil {
+			err = json.Unmarshal(value, &spec.FilePriorities)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.Info)
+		if value != nil {
+			spec.Info = make([]byte, len(value))
+			copy(spec.Info, value)
+		}
+
+		value = b.Get(Keys.Bitfield)
+		if value != nil {
+			spec.Bitfield = make([]byte, len(value))
+			copy(spec.Bitfield, value)
+		}
+
+		value = b.Get(Keys.AddedAt)
+		if value != nil {
+			spec.AddedAt, err = time.Parse(time.RFC3339, string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.BytesDownloaded)
+		if value != nil {
+			spec.BytesDownloaded, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.BytesUploaded)
+		if value != nil {
+			spec.BytesUploaded, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.BytesWasted)
+		if value != nil {
+			spec.BytesWasted, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SeededFor)
+		if value != nil {
+			spec.SeededFor, err = time.ParseDuration(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.Started)
+		if value != nil {
+			spec.Started, err = strconv.ParseBool(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.StopAfterDownload)
+		if value != nil {
+			spec.StopAfterDownload, err = strconv.ParseBool(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.StopAfterMetadata)
+		if value != nil {
+			spec.StopAfterMetadata, err = strconv.ParseBool(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.CompleteCmdRun)
+		if value != nil {
+			spec.CompleteCmdRun, err = strconv.ParseBool(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SpeedLimitDownload)
+		if value != nil {
+			spec.SpeedLimitDownload, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SpeedLimitUpload)
+		if value != nil {
+			spec.SpeedLimitUpload, err = strconv.ParseInt(string(value), 10, 64)
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.SuperSeed)
+		if value != nil {
+			spec.SuperSeed, err = strconv.ParseBool(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.Signer)
+		if value != nil {
+			spec.Signer = string(value)
+		}
+
+		value = b.Get(Keys.MutableLink)
+		if value != nil {
+			spec.MutableLink = string(value)
+		}
+
+		value = b.Get(Keys.DestOwned)
+		if value != nil {
+			spec.DestOwned, err = strconv.ParseBool(string(value))
+			if err != nil {
+				return err
+			}
+		}
+
+		value = b.Get(Keys.PreviousVersion)
+		if value != nil {
+			spec.PreviousVersion = new(resumer.PreviousVersion)
+			err = json.Unmarshal(value, spec.PreviousVersion)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	return
+}
@@ -0,0 +1,233 @@
+// Package dirresumer provides a Resumer implementation that saves resume data of each torrent into a separate JSON file in a directory.
+// Files can be inspected and edited with standard tools while the Session is not running.
+package dirresumer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ganqierwu/rain/resumer"
+)
+
+const fileExt = ".json"
+
+// Resumer contains methods for saving/loading resume information of a torrent to files in a directory.
+type Resumer struct {
+	dir string
+	m   sync.Mutex
+}
+
+var _ resumer.Resumer = (*Resumer)(nil)
+
+// New returns a new Resumer that saves files into dir. The directory is created if it does not exist.
+func New(dir string) (*Resumer, error) {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	return &Resumer{dir: dir}, nil
+}
+
+func (r *Resumer) path(torrentID string) string {
+	return filepath.Join(r.dir, url.PathEscape(torrentID)+fileExt)
+}
+
+// List returns the IDs of all torrents that have a file in the directory.
+func (r *Resumer) List() ([]string, error) {
+	entries, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(name, fileExt))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Read the torrent spec for torrent with `torrentID`.
+func (r *Resumer) Read(torrentID string) (*resumer.Spec, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.read(torrentID)
+}
+
+func (r *Resumer) read(torrentID string) (*resumer.Spec, error) {
+	b, err := ioutil.ReadFile(r.path(torrentID))
+	if err != nil {
+		return nil, err
+	}
+	spec := new(resumer.Spec)
+	err = json.Unmarshal(b, spec)
+	if err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// Write the torrent spec for torrent with `torrentID`.
+func (r *Resumer) Write(torrentID string, spec *resumer.Spec) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.write(torrentID, spec)
+}
+
+// write the spec into a temporary file first, then rename it to make the change atomic.
+func (r *Resumer) write(torrentID string, spec *resumer.Spec) error {
+	b, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(r.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), r.path(torrentID))
+}
+
+// Delete the file of the torrent with `torrentID`.
+func (r *Resumer) Delete(torrentID string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	err := os.Remove(r.path(torrentID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *Resumer) update(torrentID string, f func(spec *resumer.Spec)) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	spec, err := r.read(torrentID)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f(spec)
+	return r.write(torrentID, spec)
+}
+
+// WriteInfo writes only the info dict of a torrent.
+func (r *Resumer) WriteInfo(torrentID string, value []byte) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Info = value })
+}
+
+// WriteBitfield writes only bitfield of a torrent.
+func (r *Resumer) WriteBitfield(torrentID string, value []byte) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Bitfield = value })
+}
+
+// WriteStarted writes the start status of a torrent.
+func (r *Resumer) WriteStarted(torrentID string, value bool) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Started = value })
+}
+
+// WriteStats writes the transfer statistics of a torrent.
+func (r *Resumer) WriteStats(torrentID string, stats resumer.Stats) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.SetStats(stats) })
+}
+
+// WriteStates writes the stats and bitfields of multiple torrents.
+func (r *Resumer) WriteStates(states map[string]resumer.State) error {
+	for id, state := range states {
+		state := state
+		err := r.update(id, func(spec *resumer.Spec) {
+			spec.SetStats(state.Stats)
+			if state.Bitfield != nil {
+				spec.Bitfield = state.Bitfield
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTrackers writes the tracker tiers of a torrent.
+func (r *Resumer) WriteTrackers(torrentID string, trackers [][]string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Trackers = trackers })
+}
+
+// WriteDest writes the custom data directory of a torrent.
+func (r *Resumer) WriteDest(torrentID string, dest string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.Dest = dest
+		spec.DestOwned = false
+	})
+}
+
+// WriteFilePriorities writes the download priorities of the files in a torrent.
+func (r *Resumer) WriteFilePriorities(torrentID string, priorities []int) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.FilePriorities = priorities })
+}
+
+// WritePreviousVersion writes the previous version of a mutable torrent.
+func (r *Resumer) WritePreviousVersion(torrentID string, value *resumer.PreviousVersion) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.PreviousVersion = value })
+}
+
+// WriteSpeedLimit writes the download and upload speed limits of a torrent in KB/s.
+func (r *Resumer) WriteSpeedLimit(torrentID string, download, upload int64) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.SpeedLimitDownload = download
+		spec.SpeedLimitUpload = upload
+	})
+}
+
+// WriteSuperSeed writes the super-seeding mode of a torrent.
+func (r *Resumer) WriteSuperSeed(torrentID string, value bool) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.SuperSeed = value })
+}
+
+// WriteCompleteCmdRun marks that the completion command has run for a torrent.
+func (r *Resumer) WriteCompleteCmdRun(torrentID string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.CompleteCmdRun = true })
+}
+
+// HandleStopAfterDownload clears the start status and stop_after_download fields.
+func (r *Resumer) HandleStopAfterDownload(torrentID string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.Started = false
+		spec.StopAfterDownload = false
+	})
+}
+
+// HandleStopAfterMetadata clears the start status and stop_after_metadata fields.
+func (r *Resumer) HandleStopAfterMetadata(torrentID string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.Started = false
+		spec.StopAfterMetadata = false
+	})
+}
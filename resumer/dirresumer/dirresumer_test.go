@@ -0,0 +1,70 @@
+package dirresumer
+
+import (
+	"testing"
+
+	"github.com/ganqierwu/rain/resumer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumer(t *testing.T) {
+	r, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ID with a path separator must not escape the directory.
+	const id = "foo/bar"
+	spec := &resumer.Spec{
+		InfoHash: []byte{1, 2, 3},
+		Name:     "foo",
+		Trackers: [][]string{{"http://tracker.example.com/announce"}},
+	}
+	err = r.Write(id, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{id}, ids)
+
+	assert.NoError(t, r.WriteStarted(id, true))
+	assert.NoError(t, r.WriteBitfield(id, []byte{0xff}))
+	assert.NoError(t, r.WriteStats(id, resumer.Stats{BytesDownloaded: 10, BytesUploaded: 20}))
+	assert.NoError(t, r.WriteStarted("missing", true))
+
+	spec2, err := r.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, spec.InfoHash, spec2.InfoHash)
+	assert.Equal(t, spec.Name, spec2.Name)
+	assert.Equal(t, spec.Trackers, spec2.Trackers)
+	assert.True(t, spec2.Started)
+	assert.Equal(t, []byte{0xff}, spec2.Bitfield)
+	assert.Equal(t, int64(10), spec2.BytesDownloaded)
+	assert.Equal(t, int64(20), spec2.BytesUploaded)
+
+	// Bitfield is kept if it is not in the state.
+	states := map[string]resumer.State{
+		id:        {Stats: resumer.Stats{BytesDownloaded: 30}},
+		"missing": {Stats: resumer.Stats{BytesDownloaded: 40}, Bitfield: []byte{0x80}},
+	}
+	assert.NoError(t, r.WriteStates(states))
+	spec2, err = r.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []byte{0xff}, spec2.Bitfield)
+	assert.Equal(t, int64(30), spec2.BytesDownloaded)
+
+	assert.NoError(t, r.Delete(id))
+	ids, err = r.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, ids)
+	_, err = r.Read(id)
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+// Package memresumer provides a Resumer implementation that keeps resume data in memory.
+// Resume data is lost when the process exits.
+package memresumer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ganqierwu/rain/resumer"
+)
+
+// Resumer contains methods for saving/loading resume information of a torrent in memory.
+type Resumer struct {
+	m     sync.Mutex
+	specs map[string]resumer.Spec
+}
+
+var _ resumer.Resumer = (*Resumer)(nil)
+
+// New returns a new empty Resumer.
+func New() *Resumer {
+	return &Resumer{
+		specs: make(map[string]resumer.Spec),
+	}
+}
+
+// List returns the IDs of all torrents.
+func (r *Resumer) List() ([]string, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	ids := make([]string, 0, len(r.specs))
+	for id := range r.specs {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Read the torrent spec for torrent with `torrentID`.
+func (r *Resumer) Read(torrentID string) (*resumer.Spec, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	spec, ok := r.specs[torrentID]
+	if !ok {
+		return nil, fmt.Errorf("torrent not found: %q", torrentID)
+	}
+	return &spec, nil
+}
+
+// Write the torrent spec for torrent with `torrentID`.
+func (r *Resumer) Write(torrentID string, spec *resumer.Spec) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.specs[torrentID] = *spec
+	return nil
+}
+
+// Delete the torrent with `torrentID`.
+func (r *Resumer) Delete(torrentID string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	delete(r.specs, torrentID)
+	return nil
+}
+
+func (r *Resumer) update(torrentID string, f func(spec *resumer.Spec)) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	spec, ok := r.specs[torrentID]
+	if !ok {
+		return nil
+	}
+	f(&spec)
+	r.specs[torrentID] = spec
+	return nil
+}
+
+// WriteInfo writes only the info dict of a torrent.
+func (r *Resumer) WriteInfo(torrentID string, value []byte) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Info = value })
+}
+
+// WriteBitfield writes only bitfield of a torrent.
+func (r *Resumer) WriteBitfield(torrentID string, value []byte) error {
+	b := append([]byte(nil), value...)
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Bitfield = b })
+}
+
+// WriteStarted writes the start status of a torrent.
+func (r *Resumer) WriteStarted(torrentID string, value bool) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Started = value })
+}
+
+// WriteStats writes the transfer statistics of a torrent.
+func (r *Resumer) WriteStats(torrentID string, stats resumer.Stats) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.SetStats(stats) })
+}
+
+// WriteStates writes the stats and bitfields of multiple torrents.
+func (r *Resumer) WriteStates(states map[string]resumer.State) error {
+	for id, state := range states {
+		state := state
+		err := r.update(id, func(spec *resumer.Spec) {
+			spec.SetStats(state.Stats)
+			if state.Bitfield != nil {
+				spec.Bitfield = append([]byte(nil), state.Bitfield...)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTrackers writes the tracker tiers of a torrent.
+func (r *Resumer) WriteTrackers(torrentID string, trackers [][]string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.Trackers = trackers })
+}
+
+// WriteDest writes the custom data directory of a torrent.
+func (r *Resumer) WriteDest(torrentID string, dest string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.Dest = dest
+		spec.DestOwned = false
+	})
+}
+
+// WriteFilePriorities writes the download priorities of the files in a torrent.
+func (r *Resumer) WriteFilePriorities(torrentID string, priorities []int) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.FilePriorities = priorities })
+}
+
+// WritePreviousVersion writes the previous version of a mutable torrent.
+func (r *Resumer) WritePreviousVersion(torrentID string, value *resumer.PreviousVersion) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.PreviousVersion = value })
+}
+
+// WriteSpeedLimit writes the download and upload speed limits of a torrent in KB/s.
+func (r *Resumer) WriteSpeedLimit(torrentID string, download, upload int64) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.SpeedLimitDownload = download
+		spec.SpeedLimitUpload = upload
+	})
+}
+
+// WriteSuperSeed writes the super-seeding mode of a torrent.
+func (r *Resumer) WriteSuperSeed(torrentID string, value bool) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.SuperSeed = value })
+}
+
+// WriteCompleteCmdRun marks that the completion command has run for a torrent.
+func (r *Resumer) WriteCompleteCmdRun(torrentID string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) { spec.CompleteCmdRun = true })
+}
+
+// HandleStopAfterDownload clears the start status and stop_after_download fields.
+func (r *Resumer) HandleStopAfterDownload(torrentID string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.Started = false
+		spec.StopAfterDownload = false
+	})
+}
+
+// HandleStopAfterMetadata clears the start status and stop_after_metadata fields.
+func (r *Resumer) HandleStopAfterMetadata(torrentID string) error {
+	return r.update(torrentID, func(spec *resumer.Spec) {
+		spec.Started = false
+		spec.StopAfterMetadata = false
+	})
+}
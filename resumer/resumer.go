@@ -0,0 +1,60 @@
+// Package resumer contains an interface that is used by torrent package for resuming an existing download.
+// Implement this interface to save resume data to somewhere other than the default Bolt database.
+package resumer
+
+// Resumer saves and loads resume data of torrents in a Session.
+// Methods that update a single field must not fail if the torrent does not exist.
+type Resumer interface {
+	// List returns the IDs of all torrents that have resume data.
+	List() ([]string, error)
+	// Read the resume data of a torrent.
+	Read(torrentID string) (*Spec, error)
+	// Write all resume data of a torrent, replacing the existing one.
+	Write(torrentID string, spec *Spec) error
+	// WriteInfo writes only the info dict of a torrent.
+	WriteInfo(torrentID string, value []byte) error
+	// WriteBitfield writes only the bitfield of a torrent. Nil value clears the bitfield.
+	WriteBitfield(torrentID string, value []byte) error
+	// WriteStarted writes the start status of a torrent.
+	WriteStarted(torrentID string, value bool) error
+	// WriteStats writes the transfer statistics of a torrent.
+	WriteStats(torrentID string, stats Stats) error
+	// WriteStates writes the stats and bitfields of multiple torrents at once, keyed by torrent ID.
+	// Called periodically for all torrents in the Session, so it should be done in a single transaction if possible.
+	WriteStates(states map[string]State) error
+	// WriteTrackers writes the tracker tiers of a torrent.
+	WriteTrackers(torrentID string, trackers [][]string) error
+	// WriteDest writes the custom data directory of a torrent. It also clears the DestOwned field.
+	WriteDest(torrentID string, dest string) error
+	// WriteFilePriorities writes the download priorities of the files in a torrent. Nil value resets all to normal.
+	WriteFilePriorities(torrentID string, priorities []int) error
+	// WriteSpeedLimit writes the download and upload speed limits of a torrent in KB/s.
+	WriteSpeedLimit(torrentID string, download, upload int64) error
+	// WriteSuperSeed writes the super-seeding mode of a torrent.
+	WriteSuperSeed(torrentID string, value bool) error
+	// WriteCompleteCmdRun marks that the completion command has run for a torrent.
+	WriteCompleteCmdRun(torrentID string) error
+	// WritePreviousVersion writes the previous version of a mutable torrent. Nil value clears it.
+	WritePreviousVersion(torrentID string, value *PreviousVersion) error
+	// HandleStopAfterDownload clears the start status and stop after download fields.
+	HandleStopAfterDownload(torrentID string) error
+	// HandleStopAfterMetadata clears the start status and stop after metadata fields.
+	HandleStopAfterMetadata(torrentID string) error
+	// Delete the resume data of a torrent.
+	Delete(torrentID string) error
+}
+
+// State of a torrent that changes while it is running.
+type State struct {
+	Stats Stats
+	// Bitfield is not changed if nil.
+	Bitfield []byte
+}
+
+// Stats of a torrent.
+type Stats struct {
+	BytesDownloaded int64
+	BytesUploaded   int64
+	BytesWasted     int64
+	SeededFor       int64 // time.Duration
+}
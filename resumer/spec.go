@@ -0,0 +1,185 @@
+package resumer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+// Spec contains fields for resuming an existing torrent.
+type Spec struct {
+	InfoHash          []byte
+	Port              int
+	Name              string
+	Trackers          [][]string
+	URLList           []string
+	HTTPSeeds         []string
+	FixedPeers        []string
+	Dest              string
+	FilePriorities    []int
+	Info              []byte
+	Bitfield          []byte
+	AddedAt           time.Time
+	BytesDownloaded   int64
+	BytesUploaded     int64
+	BytesWasted       int64
+	SeededFor         time.Duration
+	Started           bool
+	StopAfterDownload bool
+	StopAfterMetadata bool
+	CompleteCmdRun    bool
+	// Speed limits in KB/s. Zero means no limit.
+	SpeedLimitDownload int64
+	SpeedLimitUpload   int64
+	SuperSeed          bool
+	// Identity of the trusted signer of the torrent (BEP 35).
+	Signer string
+	// Magnet link of the mutable torrent (BEP 46) that the torrent is a version of.
+	MutableLink string
+	// Files are not deleted when the torrent is removed.
+	// Set for torrents that are created from existing files and seeded from their original location.
+	KeepData bool
+	// Dest is a directory that is created by the Session for the torrent.
+	// The whole directory is deleted when the torrent is removed.
+	DestOwned bool
+	// Version of the mutable torrent that the torrent is being updated from.
+	// Cleared after the new version is completed.
+	PreviousVersion *PreviousVersion
+}
+
+// PreviousVersion of a mutable torrent (BEP 46) that is kept until the new version is downloaded.
+type PreviousVersion struct {
+	InfoHash []byte
+	Info     []byte
+	Bitfield []byte
+	// Root directory of the files. Files are reused by the new version.
+	Dir string
+	// Path that is deleted after the new version is completed. Empty if the files are kept.
+	RemovePath string
+	// Download priorities of the files, keyed by file path.
+	FilePriorities map[string]int
+}
+
+// SetStats copies the transfer statistics into the Spec.
+func (s *Spec) SetStats(stats Stats) {
+	s.BytesDownloaded = stats.BytesDownloaded
+	s.BytesUploaded = stats.BytesUploaded
+	s.BytesWasted = stats.BytesWasted
+	s.SeededFor = time.Duration(stats.SeededFor)
+}
+
+type jsonSpec struct {
+	Port               int
+	Name               string
+	Trackers           [][]string
+	URLList            []string
+	HTTPSeeds          []string
+	FixedPeers         []string
+	Dest               string
+	KeepData           bool
+	FilePriorities     []int
+	AddedAt            time.Time
+	BytesDownloaded    int64
+	BytesUploaded      int64
+	BytesWasted        int64
+	Started            bool
+	StopAfterDownload  bool
+	StopAfterMetadata  bool
+	CompleteCmdRun     bool
+	SpeedLimitDownload int64
+	SpeedLimitUpload   int64
+	SuperSeed          bool
+	Signer             string
+	MutableLink        string
+	DestOwned          bool
+	PreviousVersion    *PreviousVersion
+
+	// JSON unsafe types
+	InfoHash  string
+	Info      string
+	Bitfield  string
+	SeededFor int64
+}
+
+// MarshalJSON converts the Spec to a JSON string.
+func (s Spec) MarshalJSON() ([]byte, error) {
+	j := jsonSpec{
+		Port:               s.Port,
+		Name:               s.Name,
+		Trackers:           s.Trackers,
+		URLList:            s.URLList,
+		HTTPSeeds:          s.HTTPSeeds,
+		FixedPeers:         s.FixedPeers,
+		Dest:               s.Dest,
+		KeepData:           s.KeepData,
+		FilePriorities:     s.FilePriorities,
+		AddedAt:            s.AddedAt,
+		BytesDownloaded:    s.BytesDownloaded,
+		BytesUploaded:      s.BytesUploaded,
+		BytesWasted:        s.BytesWasted,
+		Started:            s.Started,
+		StopAfterDownload:  s.StopAfterDownload,
+		StopAfterMetadata:  s.StopAfterMetadata,
+		CompleteCmdRun:     s.CompleteCmdRun,
+		SpeedLimitDownload: s.SpeedLimitDownload,
+		SpeedLimitUpload:   s.SpeedLimitUpload,
+		SuperSeed:          s.SuperSeed,
+		Signer:             s.Signer,
+		MutableLink:        s.MutableLink,
+		DestOwned:          s.DestOwned,
+		PreviousVersion:    s.PreviousVersion,
+
+		InfoHash:  base64.StdEncoding.EncodeToString(s.InfoHash),
+		Info:      base64.StdEncoding.EncodeToString(s.Info),
+		Bitfield:  base64.StdEncoding.EncodeToString(s.Bitfield),
+		SeededFor: int64(s.SeededFor),
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON fills the Spec from a JSON string.
+func (s *Spec) UnmarshalJSON(b []byte) error {
+	var j jsonSpec
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+	s.InfoHash, err = base64.StdEncoding.DecodeString(j.InfoHash)
+	if err != nil {
+		return err
+	}
+	s.Info, err = base64.StdEncoding.DecodeString(j.Info)
+	if err != nil {
+		return err
+	}
+	s.Bitfield, err = base64.StdEncoding.DecodeString(j.Bitfield)
+	if err != nil {
+		return err
+	}
+	s.SeededFor = time.Duration(j.SeededFor)
+	s.Port = j.Port
+	s.Name = j.Name
+	s.Trackers = j.Trackers
+	s.URLList = j.URLList
+	s.HTTPSeeds = j.HTTPSeeds
+	s.FixedPeers = j.FixedPeers
+	s.Dest = j.Dest
+	s.KeepData = j.KeepData
+	s.FilePriorities = j.FilePriorities
+	s.AddedAt = j.AddedAt
+	s.BytesDownloaded = j.BytesDownloaded
+	s.BytesUploaded = j.BytesUploaded
+	s.BytesWasted = j.BytesWasted
+	s.Started = j.Started
+	s.StopAfterDownload = j.StopAfterDownload
+	s.StopAfterMetadata = j.StopAfterMetadata
+	s.CompleteCmdRun = j.CompleteCmdRun
+	s.SpeedLimitDownload = j.SpeedLimitDownload
+	s.SpeedLimitUpload = j.SpeedLimitUpload
+	s.SuperSeed = j.SuperSeed
+	s.Signer = j.Signer
+	s.MutableLink = j.MutableLink
+	s.DestOwned = j.DestOwned
+	s.PreviousVersion = j.PreviousVersion
+	return nil
+}
@@ -1,4 +1,4 @@
-package boltdbresumer
+package resumer
 
 import (
 	"bytes"
@@ -0,0 +1,257 @@
+// Package archivestorage implements a read-only Storage interface that serves files from a tar or zip archive.
+// Paths of the files in the archive must match the paths of the files in the torrent, including the torrent name.
+package archivestorage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ganqierwu/rain/storage"
+)
+
+var errReadOnly = errors.New("archive storage is read-only")
+
+var zipMagic = []byte("PK\x03\x04")
+
+// ArchiveStorage implements Storage interface for reading files from a tar or zip archive.
+// Uncompressed tar archives and zip archives with stored entries support fast random access.
+// Compressed zip entries are decompressed from the beginning when a read goes backwards.
+// The archive is closed when the torrent stops and opened again on the next call to Open.
+type ArchiveStorage struct {
+	path    string
+	m       sync.Mutex
+	f       *os.File
+	entries map[string]entry
+}
+
+type entry struct {
+	size int64
+	open func() storage.File
+}
+
+// New opens the archive at path and returns a new ArchiveStorage.
+// Archive format is detected from the file contents.
+func New(path string) (*ArchiveStorage, error) {
+	s := &ArchiveStorage{path: path}
+	err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open the archive file and index its entries.
+func (s *ArchiveStorage) open() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	s.entries = make(map[string]entry)
+	magic := make([]byte, len(zipMagic))
+	_, err = io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		f.Close()
+		s.f = nil
+		return err
+	}
+	if bytes.Equal(magic, zipMagic) {
+		err = s.indexZip()
+	} else {
+		err = s.indexTar()
+	}
+	if err != nil {
+		f.Close()
+		s.f = nil
+		return err
+	}
+	return nil
+}
+
+func (s *ArchiveStorage) indexTar() error {
+	_, err := s.f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(s.f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// Reader is positioned at the beginning of file data after reading the header.
+		offset, err := s.f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		sr := io.NewSectionReader(s.f, offset, hdr.Size)
+		s.entries[cleanName(hdr.Name)] = entry{
+			size: hdr.Size,
+			open: func() storage.File { return sectionFile{sr} },
+		}
+	}
+}
+
+func (s *ArchiveStorage) indexZip() error {
+	fi, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(s.f, fi.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		zf := zf
+		size := int64(zf.UncompressedSize64)
+		var open func() storage.File
+		if zf.Method == zip.Store {
+			offset, err := zf.DataOffset()
+			if err != nil {
+				return err
+			}
+			sr := io.NewSectionReader(s.f, offset, size)
+			open = func() storage.File { return sectionFile{sr} }
+		} else {
+			open = func() storage.File { return &compressedFile{zf: zf} }
+		}
+		s.entries[cleanName(zf.Name)] = entry{size: size, open: open}
+	}
+	return nil
+}
+
+func cleanName(name string) string {
+	return filepath.ToSlash(filepath.Clean(filepath.FromSlash(name)))
+}
+
+var _ storage.Storage = (*ArchiveStorage)(nil)
+
+// Open a file in the archive. Returns error if file does not exist in the archive or its size is different.
+func (s *ArchiveStorage) Open(name string, size int64) (f storage.File, exists bool, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.f == nil {
+		err = s.open()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	e, ok := s.entries[cleanName(name)]
+	if !ok {
+		return nil, false, fmt.Errorf("file not found in archive: %s", name)
+	}
+	if e.size != size {
+		return nil, false, fmt.Errorf("file size mismatch in archive: %s (%d != %d)", name, e.size, size)
+	}
+	return e.open(), true, nil
+}
+
+// RootDir returns empty string because files are not saved in a directory.
+func (s *ArchiveStorage) RootDir() string {
+	return ""
+}
+
+// Close the archive file. Files opened before must not be used after Close.
+func (s *ArchiveStorage) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// sectionFile is a file that is stored uncompressed in the archive.
+type sectionFile struct {
+	*io.SectionReader
+}
+
+func (f sectionFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, errReadOnly
+}
+
+func (f sectionFile) Close() error {
+	return nil
+}
+
+// compressedFile is a file that must be decompressed sequentially.
+type compressedFile struct {
+	zf  *zip.File
+	m   sync.Mutex
+	rc  io.ReadCloser
+	pos int64
+}
+
+func (f *compressedFile) ReadAt(p []byte, off int64) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if f.rc == nil || off < f.pos {
+		err := f.reopen()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if off > f.pos {
+		n, err := io.CopyN(io.Discard, f.rc, off-f.pos)
+		f.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(f.rc, p)
+	f.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *compressedFile) reopen() error {
+	if f.rc != nil {
+		_ = f.rc.Close()
+		f.rc = nil
+	}
+	rc, err := f.zf.Open()
+	if err != nil {
+		return err
+	}
+	f.rc = rc
+	f.pos = 0
+	return nil
+}
+
+func (f *compressedFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, errReadOnly
+}
+
+func (f *compressedFile) Close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.rc == nil {
+		return nil
+	}
+	err := f.rc.Close()
+	f.rc = nil
+	return err
+}
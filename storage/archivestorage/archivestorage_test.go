@@ -0,0 +1,139 @@
+package archivestorage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testFiles = []struct {
+	name string
+	data []byte
+}{
+	{"torrent/a.txt", []byte("hello world")},
+	{"torrent/sub/b.bin", bytes.Repeat([]byte("0123456789"), 1000)},
+}
+
+func writeTar(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, tf := range testFiles {
+		err = tw.WriteHeader(&tar.Header{Name: tf.name, Mode: 0600, Size: int64(len(tf.data)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tw.Write(tf.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeZip(t *testing.T, path string, method uint16) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, tf := range testFiles {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: tf.name, Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = w.Write(tf.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testArchive(t *testing.T, path string) {
+	s, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, tf := range testFiles {
+		f, exists, err := s.Open(filepath.FromSlash(tf.name), int64(len(tf.data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.True(t, exists)
+
+		// Read the end of file first to force a backwards read next.
+		buf := make([]byte, 5)
+		n, err := f.ReadAt(buf, int64(len(tf.data)-5))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tf.data[len(tf.data)-5:], buf[:n])
+
+		buf = make([]byte, len(tf.data))
+		n, err = f.ReadAt(buf, 0)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tf.data, buf[:n])
+
+		_, err = f.WriteAt([]byte("x"), 0)
+		assert.Error(t, err)
+		assert.NoError(t, f.Close())
+	}
+
+	_, _, err = s.Open("torrent/missing", 1)
+	assert.Error(t, err)
+	_, _, err = s.Open("torrent/a.txt", 1)
+	assert.Error(t, err)
+
+	// Archive is opened again after it is closed.
+	assert.NoError(t, s.Close())
+	tf := testFiles[0]
+	f, _, err := s.Open(filepath.FromSlash(tf.name), int64(len(tf.data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(tf.data))
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tf.data, buf[:n])
+}
+
+func TestTar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.tar")
+	writeTar(t, path)
+	testArchive(t, path)
+}
+
+func TestZipStored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.zip")
+	writeZip(t, path, zip.Store)
+	testArchive(t, path)
+}
+
+func TestZipDeflated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.zip")
+	writeZip(t, path, zip.Deflate)
+	testArchive(t, path)
+}
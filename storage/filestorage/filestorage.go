@@ -5,15 +5,17 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/ganqierwu/rain/internal/storage"
+	"github.com/ganqierwu/rain/storage"
 )
 
 // FileStorage implements Storage interface for saving files on disk.
 type FileStorage struct {
-	dest string
+	dest     string
+	buffered bool
 }
 
 // New returns a new FileStorage at the destination.
+// Files are opened with O_SYNC, so each write returns after the data reaches the disk.
 func New(dest string) (*FileStorage, error) {
 	var err error
 	dest, err = filepath.Abs(dest)
@@ -23,6 +25,17 @@ func New(dest string) (*FileStorage, error) {
 	return &FileStorage{dest: dest}, nil
 }
 
+// NewBuffered returns a new FileStorage at the destination that writes through the page cache.
+// Files implement storage.Syncer and written data is flushed to the disk with fdatasync when Sync is called.
+func NewBuffered(dest string) (*FileStorage, error) {
+	s, err := New(dest)
+	if err != nil {
+		return nil, err
+	}
+	s.buffered = true
+	return s, nil
+}
+
 var _ storage.Storage = (*FileStorage)(nil)
 
 // Open a file.
@@ -44,16 +57,23 @@ func (s *FileStorage) Open(name string, size int64) (f storage.File, exists bool
 		if err == nil && of != nil {
 			err = disableReadAhead(of)
 		}
-		if err != nil && of != nil {
+		switch {
+		case err != nil && of != nil:
 			_ = of.Close()
-		} else {
+		case err != nil:
+		case s.buffered:
+			f = &bufferedFile{of}
+		default:
 			f = of
 		}
 	}()
 
 	// Open OS file.
 	const mode = 0o640
-	openFlags := os.O_RDWR | os.O_SYNC
+	openFlags := os.O_RDWR
+	if !s.buffered {
+		openFlags |= os.O_SYNC
+	}
 	openFlags = applyNoAtimeFlag(openFlags)
 	of, err = os.OpenFile(name, openFlags, mode)
 	if os.IsNotExist(err) {
@@ -82,3 +102,15 @@ func (s *FileStorage) Open(name string, size int64) (f storage.File, exists bool
 func (s *FileStorage) RootDir() string {
 	return s.dest
 }
+
+// bufferedFile is a file that is opened without O_SYNC.
+type bufferedFile struct {
+	*os.File
+}
+
+var _ storage.Syncer = (*bufferedFile)(nil)
+
+// Sync flushes the written data of the file to the disk.
+func (f *bufferedFile) Sync() error {
+	return fdatasync(f.File)
+}
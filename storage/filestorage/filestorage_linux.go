@@ -14,3 +14,7 @@ func disableReadAhead(f *os.File) error {
 func applyNoAtimeFlag(f int) int {
 	return f | syscall.O_NOATIME
 }
+
+func fdatasync(f *os.File) error {
+	return unix.Fdatasync(int(f.Fd()))
+}
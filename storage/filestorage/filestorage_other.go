@@ -11,3 +11,7 @@ func disableReadAhead(f *os.File) error {
 func applyNoAtimeFlag(f int) int {
 	return f
 }
+
+func fdatasync(f *os.File) error {
+	return f.Sync()
+}
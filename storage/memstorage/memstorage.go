@@ -0,0 +1,98 @@
+// Package memstorage implements Storage interface that keeps files in memory.
+// It is useful for tests and ephemeral transfers that do not need to touch the disk.
+package memstorage
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/ganqierwu/rain/storage"
+)
+
+var errOutOfRange = errors.New("offset out of file range")
+
+// MemStorage implements Storage interface for saving files in memory.
+type MemStorage struct {
+	m     sync.Mutex
+	files map[string]*File
+}
+
+// New returns a new empty MemStorage.
+func New() *MemStorage {
+	return &MemStorage{
+		files: make(map[string]*File),
+	}
+}
+
+var _ storage.Storage = (*MemStorage)(nil)
+
+// Open a file. Data of the file is kept after the file is closed,
+// so the same file can be opened again while the MemStorage is alive.
+func (s *MemStorage) Open(name string, size int64) (f storage.File, exists bool, err error) {
+	name = filepath.Clean(name)
+	s.m.Lock()
+	defer s.m.Unlock()
+	mf, ok := s.files[name]
+	if !ok {
+		mf = &File{data: make([]byte, size)}
+		s.files[name] = mf
+		return mf, false, nil
+	}
+	mf.truncate(size)
+	return mf, true, nil
+}
+
+// RootDir returns empty string because files are not saved on disk.
+func (s *MemStorage) RootDir() string {
+	return ""
+}
+
+// File is a file in MemStorage.
+type File struct {
+	m    sync.RWMutex
+	data []byte
+}
+
+var _ storage.File = (*File)(nil)
+
+// ReadAt implements io.ReaderAt interface.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.m.RLock()
+	defer f.m.RUnlock()
+	if off < 0 || off > int64(len(f.data)) {
+		return 0, errOutOfRange
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt interface. Files cannot grow by writing past the end.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if off < 0 || off+int64(len(p)) > int64(len(f.data)) {
+		return 0, errOutOfRange
+	}
+	return copy(f.data[off:], p), nil
+}
+
+// Close does nothing. Data is kept in MemStorage.
+func (f *File) Close() error {
+	return nil
+}
+
+func (f *File) truncate(size int64) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	switch {
+	case size < int64(len(f.data)):
+		f.data = f.data[:size]
+	case size > int64(len(f.data)):
+		f.data = append(f.data, make([]byte, size-int64(len(f.data)))...)
+	}
+}
@@ -0,0 +1,30 @@
+// Package storage contains an interface for reading and writing files in a torrent.
+// Implement this interface to save torrent data to somewhere other than local disk.
+package storage
+
+import "io"
+
+// Storage is an interface for reading/writing torrent files.
+// If the Storage implements io.Closer, it is closed when the torrent is stopped or removed.
+type Storage interface {
+	// Open the file with name. If file does not exist, it is created with the given size.
+	// exists must be true if the file was existing before the call,
+	// so the torrent can decide whether the existing data needs to be verified.
+	Open(name string, size int64) (f File, exists bool, err error)
+	// RootDir returns the directory on disk that files are saved under.
+	// Storage implementations that are not backed by a directory return empty string.
+	RootDir() string
+}
+
+// File interface for reading/writing torrent data.
+type File interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// Syncer is implemented by Files whose writes are cached and need to be flushed to reach the disk.
+// Sync is called after pieces are written and before they are saved as completed to the resume database.
+type Syncer interface {
+	Sync() error
+}
@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/ganqierwu/rain/resumer"
+	"github.com/ganqierwu/rain/storage"
 )
 
 var (
@@ -19,7 +21,11 @@ func init() {
 // Config for Session.
 type Config struct {
 	// Database file to save resume data.
+	// If empty, session data such as the cached blocklist is not saved,
+	// and resume data is kept in memory unless ResumeDir or Resumer is set.
 	Database string
+	// If set, resume data of each torrent is saved into a separate JSON file in this directory instead of Database.
+	ResumeDir string
 	// DataDir is where files are downloaded.
 	DataDir string
 	// If true, torrent files are saved into <data_dir>/<torrent_id>/<torrent_name>.
@@ -39,11 +45,18 @@ type Config struct {
 	// Client version that is sent in BEP 10 handshake message.
 	// Only applies to private torrents.
 	PrivateExtensionHandshakeClientVersion string
-	// URL to the blocklist file in CIDR format.
+	// URL to the blocklist file. It is loaded before the ones in BlocklistSources.
 	BlocklistURL string
+	// URLs or local file paths of blocklists.
+	// Supported formats are CIDR per line, PeerGuardian P2P and eMule DAT.
+	// Files may be compressed with gzip or zip.
+	BlocklistSources []string
+	// IP ranges that are never blocked even if they are in one of the blocklists.
+	// Each item may be a single IP, a CIDR or a range in "first-last" form.
+	BlocklistAllow []string
 	// When to refresh blocklist
 	BlocklistUpdateInterval time.Duration
-	// HTTP timeout for downloading blocklist
+	// HTTP timeout for downloading a blocklist
 	BlocklistUpdateTimeout time.Duration
 	// Do not contact tracker if it's IP is blocked
 	BlocklistEnabledForTrackers bool
@@ -51,8 +64,10 @@ type Config struct {
 	BlocklistEnabledForOutgoingConnections bool
 	// Do not accept connections from peer if it's IP is blocked
 	BlocklistEnabledForIncomingConnections bool
-	// Do not accept response larger than this size
+	// Do not accept a blocklist larger than this size
 	BlocklistMaxResponseSize int64
+	// Do not accept a compressed blocklist larger than this size after decompression
+	BlocklistMaxDecompressedSize int64
 	// Time to wait when adding torrent with AddURI().
 	TorrentAddHTTPTimeout time.Duration
 	// Maximum allowed size to be received by metadata extension.
@@ -61,6 +76,12 @@ type Config struct {
 	MaxTorrentSize uint
 	// Maximum allowed number of pieces in a torrent.
 	MaxPieces uint32
+	// Files that contain PEM encoded certificates of the trusted signers of torrents (BEP 35).
+	// Signatures are verified when adding torrents and the identity of the signer is shown in torrent stats.
+	TrustedCertificates []string
+	// Reject adding torrents that are unsigned or not signed by one of TrustedCertificates.
+	// Magnet links are rejected too because they do not contain signatures.
+	RequireSignedTorrents bool
 	// Time to wait when resolving host names for trackers and peers.
 	DNSResolveTimeout time.Duration
 	// Global download speed limit in KB/s.
@@ -95,6 +116,10 @@ type Config struct {
 	DHTMinAnnounceInterval time.Duration
 	// Known routers to bootstrap local DHT node.
 	DHTBootstrapNodes []string
+	// Time to wait for storing or retrieving an item in DHT (BEP 44), including resolving mutable torrents (BEP 46).
+	DHTStoreTimeout time.Duration
+	// Interval for checking new versions of mutable torrents (BEP 46). Torrents keep their IDs when they are switched to new versions.
+	MutableTorrentUpdateInterval time.Duration
 
 	// Number of peer addresses to request in announce request.
 	TrackerNumWant int
@@ -122,14 +147,22 @@ type Config struct {
 	// Max number of blocks allowed to be queued without dropping any.
 	MaxRequestsIn int
 	// Max number of blocks requested from a peer but not received yet.
-	// `rreq` value from extended handshake cannot exceed this limit.
+	// Request queue of a peer grows and shrinks between MinRequestsOut and MaxRequestsOut
+	// based on the download speed and block latency of the peer.
+	// `reqq` value from extended handshake also limits the queue.
 	MaxRequestsOut int
-	// Number of bloks requested from peer if it does not send `rreq` value in extended handshake.
+	// Min number of blocks requested from a peer but not received yet.
+	MinRequestsOut int
+	// Number of blocks requested from a peer before its speed and latency is measured.
 	DefaultRequestsOut int
-	// Time to wait for a requested block to be received before marking peer as snubbed
+	// Max time to wait for a requested block to be received before marking peer as snubbed.
+	// Actual timeout is derived from the observed block latency of the peer.
 	RequestTimeout time.Duration
 	// Max number of running downloads on piece in endgame mode, snubbed and choed peers don't count
 	EndgameMaxDuplicateDownloads int
+	// Blocks of pieces at least this long are requested from multiple peers at the same time.
+	// Set to 0 to download each piece from a single peer.
+	MultiPeerPieceLength int64
 	// Max number of outgoing connections to dial
 	MaxPeerDial int
 	// Max number of incoming connections to accept
@@ -146,6 +179,9 @@ type Config struct {
 	MaxPeerAddresses int
 	// Number of allowed-fast messages to send after handshake.
 	AllowedFastSet int
+	// An IP that is banned in this many torrents for sending corrupt data is banned in all torrents.
+	// Set to 0 to ban IPs only in the torrent that has received the corrupt data.
+	SmartBanSessionThreshold int
 
 	// Number of bytes to read when a piece is requested by a peer.
 	ReadCacheBlockSize int64
@@ -157,8 +193,26 @@ type Config struct {
 	ParallelReads uint
 	// Number of write operations to do in parallel.
 	ParallelWrites uint
+	// Write pieces through the page cache of the OS instead of opening files with O_SYNC.
+	// Written pieces are flushed to disk with fdatasync before the bitfield is saved to the resume db,
+	// once in every ResumeWriteInterval. Adjacent pieces waiting to be written are merged into a single write.
+	// Has no effect if a custom Storage is set.
+	BufferedWrites bool
+	// Flush each piece to disk after it is written, if BufferedWrites is enabled.
+	SyncEveryPiece bool
+	// Max number of bytes in pieces of a torrent that are waiting to be written, if BufferedWrites is enabled.
+	// Without buffered writes, only 1 piece is written at a time per torrent.
+	WriteQueueSize int64
 	// Number of bytes allocated in memory for downloading piece data.
 	WriteCacheSize int64
+	// Before downloading a new torrent, copy the files that have the same content from other torrents in the session.
+	// Torrents that are listed in "similar" key or share a collection with the new torrent are searched first (BEP 38).
+	// Disabled by default because the files of other torrents are read and copied when a torrent is added.
+	DataReuse bool
+	// Create hard links instead of copying files when reusing data.
+	// Linked files share the data on disk, so a write to one of them is seen in the other.
+	// Only the files that are fully covered by equal piece hashes in both torrents are linked, others are copied.
+	DataReuseHardLink bool
 
 	// When the client want to connect a peer, first it tries to do encrypted handshake.
 	// If it does not work, it connects to same peer again and does unencrypted handshake.
@@ -188,6 +242,14 @@ type Config struct {
 
 	// Shell command to execute on torrent completion.
 	OnCompleteCmd []string
+
+	// Storage is called to create the storage of a torrent when it is added or loaded from the database.
+	// dataDir is the directory chosen for the torrent by DataDir, DataDirIncludesTorrentID and AddTorrentOptions.DataDir.
+	// If nil, files are saved under dataDir on disk.
+	Storage func(torrentID, dataDir string) (storage.Storage, error) `yaml:"-"`
+
+	// Resumer saves resume data of torrents. Overrides Database and ResumeDir options for resume data.
+	Resumer resumer.Resumer `yaml:"-"`
 }
 
 // DefaultConfig for Session. Do not pass zero value Config to NewSession. Copy this struct and modify instead.
@@ -209,6 +271,7 @@ var DefaultConfig = Config{
 	BlocklistEnabledForOutgoingConnections: true,
 	BlocklistEnabledForIncomingConnections: true,
 	BlocklistMaxResponseSize:               100 << 20,
+	BlocklistMaxDecompressedSize:           500 << 20,
 	TorrentAddHTTPTimeout:                  30 * time.Second,
 	MaxMetadataSize:                        30 << 20,
 	MaxTorrentSize:                         10 << 20,
@@ -246,15 +309,19 @@ var DefaultConfig = Config{
 		"dht.libtorrent.org:25401",
 		"dht.aelitis.com:6881",
 	},
+	DHTStoreTimeout:              30 * time.Second,
+	MutableTorrentUpdateInterval: time.Hour,
 
 	// Peer
 	UnchokedPeers:                3,
 	OptimisticUnchokedPeers:      1,
 	MaxRequestsIn:                250,
 	MaxRequestsOut:               250,
+	MinRequestsOut:               2,
 	DefaultRequestsOut:           50,
 	RequestTimeout:               20 * time.Second,
 	EndgameMaxDuplicateDownloads: 20,
+	MultiPeerPieceLength:         4 << 20,
 	MaxPeerDial:                  80,
 	MaxPeerAccept:                20,
 	ParallelMetadataDownloads:    2,
@@ -263,6 +330,7 @@ var DefaultConfig = Config{
 	PieceReadTimeout:             30 * time.Second,
 	MaxPeerAddresses:             2000,
 	AllowedFastSet:               10,
+	SmartBanSessionThreshold:     3,
 
 	// IO
 	ReadCacheBlockSize: 128 << 10,
@@ -270,6 +338,8 @@ var DefaultConfig = Config{
 	ReadCacheTTL:       1 * time.Minute,
 	ParallelReads:      1,
 	ParallelWrites:     1,
+	BufferedWrites:     true,
+	WriteQueueSize:     64 << 20,
 	WriteCacheSize:     1 << 30,
 
 	// Webseed settings
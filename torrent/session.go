@@ -4,45 +4,53 @@ package torrent
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/ganqierwu/rain/internal/bitfield"
 	"github.com/ganqierwu/rain/internal/blocklist"
+	"github.com/ganqierwu/rain/internal/dht"
+	"github.com/ganqierwu/rain/internal/dhtstore"
 	"github.com/ganqierwu/rain/internal/logger"
 	"github.com/ganqierwu/rain/internal/peer"
 	"github.com/ganqierwu/rain/internal/piececache"
 	"github.com/ganqierwu/rain/internal/resolver"
 	"github.com/ganqierwu/rain/internal/resourcemanager"
-	"github.com/ganqierwu/rain/internal/resumer/boltdbresumer"
 	"github.com/ganqierwu/rain/internal/semaphore"
+	"github.com/ganqierwu/rain/internal/speedlimit"
 	"github.com/ganqierwu/rain/internal/tracker"
 	"github.com/ganqierwu/rain/internal/trackermanager"
-	"github.com/juju/ratelimit"
+	"github.com/ganqierwu/rain/internal/writequeue"
+	"github.com/ganqierwu/rain/resumer"
+	"github.com/ganqierwu/rain/resumer/boltdbresumer"
+	"github.com/ganqierwu/rain/resumer/dirresumer"
+	"github.com/ganqierwu/rain/resumer/memresumer"
+	"github.com/ganqierwu/rain/storage"
+	"github.com/ganqierwu/rain/storage/filestorage"
 	"github.com/mitchellh/go-homedir"
-	"github.com/nictuku/dht"
 	"go.etcd.io/bbolt"
 )
 
 var (
 	sessionBucket         = []byte("session")
 	torrentsBucket        = []byte("torrents")
+	bansBucket            = []byte("bans")
+	blocklistsBucket      = []byte("blocklists")
 	blocklistKey          = []byte("blocklist")
 	blocklistTimestampKey = []byte("blocklist-timestamp")
-	blocklistURLHashKey   = []byte("blocklist-url-hash")
 )
 
 // Session contains torrents, DHT node, caches and other data structures shared by multiple torrents.
 type Session struct {
 	config         Config
 	db             *bbolt.DB
-	resumer        *boltdbresumer.Resumer
+	resumer        resumer.Resumer
 	log            logger.Logger
 	extensions     [8]byte
 	dht            *dht.DHT
@@ -53,11 +61,25 @@ type Session struct {
 	webseedClient  http.Client
 	createdAt      time.Time
 	semWrite       *semaphore.Semaphore
+	writeQueue     *writequeue.Queue
 	metrics        *sessionMetrics
-	bucketDownload *ratelimit.Bucket
-	bucketUpload   *ratelimit.Bucket
+	limitDownload  *speedlimit.Limiter
+	limitUpload    *speedlimit.Limiter
 	closeC         chan struct{}
 
+	// Limits that can be changed with SetConfig while the session is running.
+	// Torrents read them atomically instead of reading the same fields of config.
+	maxPeerDial               int32
+	maxPeerAccept             int32
+	parallelMetadataDownloads int32
+	webseedMaxDownloads       int32
+
+	// Certificates of the trusted torrent signers (BEP 35).
+	trustedCertificates []*x509.Certificate
+
+	// Client for storing items in DHT (BEP 44). Nil if DHT is disabled.
+	dhtStore *dhtstore.Client
+
 	mPeerRequests   sync.Mutex
 	dhtPeerRequests map[*torrent]struct{}
 
@@ -69,9 +91,23 @@ type Session struct {
 	mPorts         sync.RWMutex
 	availablePorts map[int]struct{}
 
+	mMoves        sync.Mutex
+	incomingMoves map[string]*incomingMove // by torrent id
+	outgoingMoves map[string]*outgoingMove // by token
+
+	mBannedIPs sync.RWMutex
+	// Number of torrents that have banned the IP for sending corrupt data.
+	smartBanStrikes map[string]int
+	// IPs that are banned in all torrents for sending corrupt data, with the time of the ban.
+	bannedIPs map[string]time.Time
+	// Bans that are added manually, by Ban.key().
+	bans map[string]*Ban
+
 	mBlocklist         sync.RWMutex
 	blocklist          *blocklist.Blocklist
 	blocklistTimestamp time.Time
+	blocklistSources   []*blocklistSource
+	blocklistAllow     []blocklist.Range
 }
 
 // NewSession creates a new Session for downloading and seeding torrents.
@@ -95,57 +131,36 @@ func NewSession(cfg Config) (*Session, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = os.MkdirAll(filepath.Dir(cfg.Database), 0750)
+	cfg.ResumeDir, err = homedir.Expand(cfg.ResumeDir)
+	if err != nil {
+		return nil, err
+	}
+	trustedCertificates, err := loadTrustedCertificates(cfg.TrustedCertificates)
 	if err != nil {
 		return nil, err
 	}
 	l := logger.New("session")
-	db, err := bbolt.Open(cfg.Database, 0640, &bbolt.Options{Timeout: time.Second})
-	if err == bbolt.ErrTimeout {
-		return nil, errors.New("resume database is locked by another process")
-	} else if err != nil {
+	db, err := openDatabase(cfg.Database)
+	if err != nil {
 		return nil, err
 	}
 	defer func() {
-		if err != nil {
+		if err != nil && db != nil {
 			db.Close()
 		}
 	}()
-	var ids []string
-	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err2 := tx.CreateBucketIfNotExists(sessionBucket)
-		if err2 != nil {
-			return err2
-		}
-		b, err2 := tx.CreateBucketIfNotExists(torrentsBucket)
-		if err2 != nil {
-			return err2
-		}
-		return b.ForEach(func(k, _ []byte) error {
-			ids = append(ids, string(k))
-			return nil
-		})
-	})
+	res, err := newResumer(cfg, db)
 	if err != nil {
 		return nil, err
 	}
-	res, err := boltdbresumer.New(db, torrentsBucket)
+	ids, err := res.List()
 	if err != nil {
 		return nil, err
 	}
 	var dhtNode *dht.DHT
+	var dhtStore *dhtstore.Client
 	if cfg.DHTEnabled {
-		dhtConfig := dht.NewConfig()
-		dhtConfig.Address = cfg.DHTHost
-		dhtConfig.Port = int(cfg.DHTPort)
-		dhtConfig.DHTRouters = strings.Join(cfg.DHTBootstrapNodes, ",")
-		dhtConfig.SaveRoutingTable = false
-		dhtConfig.NumTargetPeers = 0
-		dhtNode, err = dht.New(dhtConfig)
-		if err != nil {
-			return nil, err
-		}
-		err = dhtNode.Start()
+		dhtNode, dhtStore, err = startDHT(cfg)
 		if err != nil {
 			return nil, err
 		}
@@ -169,6 +184,11 @@ func NewSession(cfg Config) (*Session, error) {
 		log:                l,
 		torrents:           make(map[string]*Torrent),
 		torrentsByInfoHash: make(map[dht.InfoHash][]*Torrent),
+		incomingMoves:      make(map[string]*incomingMove),
+		outgoingMoves:      make(map[string]*outgoingMove),
+		smartBanStrikes:    make(map[string]int),
+		bannedIPs:          make(map[string]time.Time),
+		bans:               make(map[string]*Ban),
 		availablePorts:     ports,
 		dht:                dhtNode,
 		pieceCache:         piececache.New(cfg.ReadCacheSize, cfg.ReadCacheTTL, cfg.ParallelReads),
@@ -195,18 +215,24 @@ func NewSession(cfg Config) (*Session, error) {
 			},
 		},
 	}
-	dlSpeed := cfg.SpeedLimitDownload * 1024
-	if cfg.SpeedLimitDownload > 0 {
-		c.bucketDownload = ratelimit.NewBucketWithRate(float64(dlSpeed), dlSpeed)
-	}
-	ulSpeed := cfg.SpeedLimitUpload * 1024
-	if cfg.SpeedLimitUpload > 0 {
-		c.bucketUpload = ratelimit.NewBucketWithRate(float64(ulSpeed), ulSpeed)
-	}
+	c.limitDownload = speedlimit.New(nil)
+	c.limitDownload.SetLimit(cfg.SpeedLimitDownload * 1024)
+	c.limitUpload = speedlimit.New(nil)
+	c.limitUpload.SetLimit(cfg.SpeedLimitUpload * 1024)
+	c.trustedCertificates = trustedCertificates
+	c.maxPeerDial = int32(cfg.MaxPeerDial)
+	c.maxPeerAccept = int32(cfg.MaxPeerAccept)
+	c.parallelMetadataDownloads = int32(cfg.ParallelMetadataDownloads)
+	c.webseedMaxDownloads = int32(cfg.WebseedMaxDownloads)
+	c.dhtStore = dhtStore
 	err = c.startBlocklistReloader()
 	if err != nil {
 		return nil, err
 	}
+	err = c.loadBans()
+	if err != nil {
+		return nil, err
+	}
 	ext, err := bitfield.NewBytes(c.extensions[:], 64)
 	if err != nil {
 		panic(err)
@@ -218,6 +244,7 @@ func NewSession(cfg Config) (*Session, error) {
 		c.dhtPeerRequests = make(map[*torrent]struct{})
 	}
 	c.initMetrics()
+	c.writeQueue = writequeue.New(c.semWrite, c.metrics.WriteLatency, c.metrics.SyncLatency)
 	c.loadExistingTorrents(ids)
 	if c.config.RPCEnabled {
 		c.rpc = newRPCServer(c)
@@ -228,6 +255,7 @@ func NewSession(cfg Config) (*Session, error) {
 	}
 	if cfg.DHTEnabled {
 		go c.processDHTResults()
+		go c.checkMutableTorrentsLoop()
 	}
 	go c.updateStatsLoop()
 	return c, nil
@@ -262,9 +290,12 @@ func (s *Session) getTrackerUserAgent(private bool) string {
 // Close stops all torrents and release the resources.
 func (s *Session) Close() error {
 	close(s.closeC)
+	// Moves are closed before the torrents so that a received torrent is not loaded into a closing Session.
+	s.closeIncomingMoves()
 
 	if s.config.DHTEnabled {
 		s.dht.Stop()
+		s.dhtStore.Close()
 	}
 
 	s.updateStats()
@@ -292,9 +323,61 @@ func (s *Session) Close() error {
 	s.ram.Close()
 	s.pieceCache.Close()
 	s.metrics.Close()
+	if s.db == nil {
+		return nil
+	}
 	return s.db.Close()
 }
 
+// openDatabase opens the Bolt database that keeps session data.
+// Returns nil if path is empty.
+func openDatabase(path string) (*bbolt.DB, error) {
+	if path == "" {
+		return nil, nil
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0750)
+	if err != nil {
+		return nil, err
+	}
+	db, err := bbolt.Open(path, 0640, &bbolt.Options{Timeout: time.Second})
+	if err == bbolt.ErrTimeout {
+		return nil, errors.New("resume database is locked by another process")
+	} else if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err2 := tx.CreateBucketIfNotExists(sessionBucket)
+		if err2 != nil {
+			return err2
+		}
+		_, err2 = tx.CreateBucketIfNotExists(bansBucket)
+		if err2 != nil {
+			return err2
+		}
+		_, err2 = tx.CreateBucketIfNotExists(blocklistsBucket)
+		return err2
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// newResumer returns the Resumer selected by the config.
+func newResumer(cfg Config, db *bbolt.DB) (resumer.Resumer, error) {
+	switch {
+	case cfg.Resumer != nil:
+		return cfg.Resumer, nil
+	case cfg.ResumeDir != "":
+		return dirresumer.New(cfg.ResumeDir)
+	case db != nil:
+		return boltdbresumer.New(db, torrentsBucket)
+	default:
+		return memresumer.New(), nil
+	}
+}
+
 // ListTorrents returns all torrents in session as a slice.
 // The order of the torrents returned is different on each call.
 func (s *Session) ListTorrents() []*Torrent {
@@ -331,6 +414,7 @@ func (s *Session) GetTorrent(id string) *Torrent {
 }
 
 // RemoveTorrent removes the torrent from the session and delete its files.
+// Files of torrents that are created with CreateTorrent are kept, because they are seeded from their original location.
 func (s *Session) RemoveTorrent(id string) error {
 	t, err := s.removeTorrentFromClient(id)
 	if t != nil {
@@ -340,13 +424,29 @@ func (s *Session) RemoveTorrent(id string) error {
 }
 
 func (s *Session) removeTorrentFromClient(id string) (*Torrent, error) {
-	s.mTorrents.Lock()
+	s.mTorrents.RLock()
 	t, ok := s.torrents[id]
-	if !ok {
-		s.mTorrents.Unlock()
+	s.mTorrents.RUnlock()
+	if !ok || !s.detachTorrent(t) {
 		return nil, nil
 	}
 	t.torrent.log.Info("removing torrent")
+	err := s.removeTorrentBans(id)
+	if err != nil {
+		return t, err
+	}
+	return t, s.resumer.Delete(id)
+}
+
+// detachTorrent removes the torrent from the Session without touching its resume data.
+// Returns false if the torrent is already removed.
+func (s *Session) detachTorrent(t *Torrent) bool {
+	id := t.torrent.id
+	s.mTorrents.Lock()
+	if s.torrents[id] != t {
+		s.mTorrents.Unlock()
+		return false
+	}
 	delete(s.torrents, id)
 
 	// Delete from the list of torrents with same info hash
@@ -369,45 +469,65 @@ func (s *Session) removeTorrentFromClient(id string) (*Torrent, error) {
 	if s.config.DHTEnabled && len(s.torrentsByInfoHash[ih]) == 0 {
 		s.dht.RemoveInfoHash(string(ih))
 	}
-	return t, s.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(torrentsBucket).DeleteBucket([]byte(id))
-	})
+	return true
 }
 
 func (s *Session) stopAndRemoveData(t *Torrent) error {
 	t.torrent.Close()
 	s.releasePort(t.torrent.port)
+	if t.torrent.keepData {
+		t.torrent.log.Infof("keeping original files in %s", t.torrent.storage.RootDir())
+	}
 	var err error
-	var dest string
-	if s.config.DataDirIncludesTorrentID {
-		dest = filepath.Join(s.config.DataDir, t.torrent.id)
-	} else if t.torrent.info != nil {
-		dest = filepath.Join(s.config.DataDir, t.torrent.info.Name)
+	dests := []string{s.dataPath(t.torrent)}
+	if pv := t.torrent.previousVersion; pv != nil {
+		dests = append(dests, pv.RemovePath)
 	}
-	if dest != "" {
-		err = os.RemoveAll(dest)
-		if err != nil {
-			s.log.Errorf("cannot remove torrent data. err: %s dest: %s", err, dest)
+	for _, dest := range dests {
+		if dest == "" {
+			continue
+		}
+		err2 := os.RemoveAll(dest)
+		if err2 != nil {
+			s.log.Errorf("cannot remove torrent data. err: %s dest: %s", err2, dest)
+			err = err2
 		}
 	}
 	return err
 }
 
+// dataPath returns the path that contains only the files of the torrent and is deleted when the torrent is removed.
+// Empty string is returned if the files must not be deleted.
+// The torrent must be closed before calling this.
+func (s *Session) dataPath(t *torrent) string {
+	root := t.storage.RootDir()
+	switch {
+	case root == "":
+		// Storage is not on disk.
+		return ""
+	case t.keepData:
+		return ""
+	case t.destOwned:
+		return root
+	case t.dest == "" && s.config.DataDirIncludesTorrentID:
+		return root
+	case t.info != nil:
+		return filepath.Join(root, t.info.Name)
+	}
+	return ""
+}
+
 // StartAll starts all torrents in session.
 func (s *Session) StartAll() error {
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		tb := tx.Bucket(torrentsBucket)
-		s.mTorrents.RLock()
-		for _, t := range s.torrents {
-			b := tb.Bucket([]byte(t.torrent.id))
-			_ = b.Put([]byte("started"), []byte("true"))
+	s.mTorrents.RLock()
+	for _, t := range s.torrents {
+		err := s.resumer.WriteStarted(t.torrent.id, true)
+		if err != nil {
+			s.mTorrents.RUnlock()
+			return err
 		}
-		defer s.mTorrents.RUnlock()
-		return nil
-	})
-	if err != nil {
-		return err
 	}
+	s.mTorrents.RUnlock()
 	for _, t := range s.torrents {
 		t.torrent.Start()
 	}
@@ -416,19 +536,15 @@ func (s *Session) StartAll() error {
 
 // StopAll stops all torrents in session.
 func (s *Session) StopAll() error {
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		tb := tx.Bucket(torrentsBucket)
-		s.mTorrents.RLock()
-		for _, t := range s.torrents {
-			b := tb.Bucket([]byte(t.torrent.id))
-			_ = b.Put([]byte("started"), []byte("false"))
+	s.mTorrents.RLock()
+	for _, t := range s.torrents {
+		err := s.resumer.WriteStarted(t.torrent.id, false)
+		if err != nil {
+			s.mTorrents.RUnlock()
+			return err
 		}
-		defer s.mTorrents.RUnlock()
-		return nil
-	})
-	if err != nil {
-		return err
 	}
+	s.mTorrents.RUnlock()
 	for _, t := range s.torrents {
 		t.torrent.Stop()
 	}
@@ -441,3 +557,18 @@ func (s *Session) getDataDir(torrentID string) string {
 	}
 	return s.config.DataDir
 }
+
+// newStorage returns the storage of a torrent.
+// If dest is empty, the data dir is chosen by the config.
+func (s *Session) newStorage(torrentID, dest string) (storage.Storage, error) {
+	if dest == "" {
+		dest = s.getDataDir(torrentID)
+	}
+	if s.config.Storage != nil {
+		return s.config.Storage(torrentID, dest)
+	}
+	if s.config.BufferedWrites {
+		return filestorage.NewBuffered(dest)
+	}
+	return filestorage.New(dest)
+}
@@ -7,17 +7,18 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/ganqierwu/rain/internal/bitfield"
+	"github.com/ganqierwu/rain/internal/dht"
 	"github.com/ganqierwu/rain/internal/magnet"
 	"github.com/ganqierwu/rain/internal/metainfo"
-	"github.com/ganqierwu/rain/internal/resumer"
-	"github.com/ganqierwu/rain/internal/resumer/boltdbresumer"
-	"github.com/ganqierwu/rain/internal/storage/filestorage"
 	"github.com/ganqierwu/rain/internal/webseedsource"
+	"github.com/ganqierwu/rain/resumer"
+	"github.com/ganqierwu/rain/storage"
 	"github.com/gofrs/uuid"
-	"github.com/nictuku/dht"
 )
 
 // AddTorrentOptions contains options for adding a new torrent.
@@ -31,6 +32,13 @@ type AddTorrentOptions struct {
 	StopAfterDownload bool
 	// Stop torrent after metadata is downloaded from magnet links.
 	StopAfterMetadata bool
+	// Directory to save the files of the torrent.
+	// Existing files in this directory are verified and seeded.
+	// If empty, the directory is chosen by Config.DataDir and Config.DataDirIncludesTorrentID.
+	DataDir string
+	// Storage for saving the files of the torrent. Overrides Config.Storage and DataDir options.
+	// It is not saved into the database, so Config.Storage is used when the torrent is loaded in the next session.
+	Storage storage.Storage
 }
 
 // AddTorrent adds a new torrent to the session by reading .torrent metainfo from reader.
@@ -39,6 +47,10 @@ func (s *Session) AddTorrent(r io.Reader, opt *AddTorrentOptions) (*Torrent, err
 	if opt == nil {
 		opt = &AddTorrentOptions{}
 	}
+	opt, err := absDataDir(opt)
+	if err != nil {
+		return nil, err
+	}
 	t, err := s.addTorrentStopped(r, opt)
 	if err != nil {
 		return nil, err
@@ -66,6 +78,18 @@ func (s *Session) addTorrentStopped(r io.Reader, opt *AddTorrentOptions) (*Torre
 	if err != nil {
 		return nil, newInputError(err)
 	}
+	signer, err := s.verifySignature(mi)
+	if err != nil {
+		return nil, newInputError(err)
+	}
+	return s.addMetaInfoStopped(mi, opt, nil, signer, false)
+}
+
+// addMetaInfoStopped adds a torrent from parsed metainfo.
+// If bf is not nil, it is saved as the initial bitfield and verification of existing files is skipped.
+// signer is the identity of the verified signer of the torrent, or empty if it is not signed.
+// If keepData is true, files are not deleted when the torrent is removed.
+func (s *Session) addMetaInfoStopped(mi *metainfo.MetaInfo, opt *AddTorrentOptions, bf *bitfield.Bitfield, signer string, keepData bool) (*Torrent, error) {
 	id, port, sto, err := s.add(opt)
 	if err != nil {
 		return nil, err
@@ -86,9 +110,9 @@ func (s *Session) addTorrentStopped(r io.Reader, opt *AddTorrentOptions) (*Torre
 		s.parseTrackers(mi.AnnounceList, mi.Info.Private),
 		nil, // fixedPeers
 		&mi.Info,
-		nil, // bitfield
+		bf,
 		resumer.Stats{},
-		webseedsource.NewList(mi.URLList),
+		webseedsource.NewList(mi.URLList, mi.HTTPSeeds),
 		opt.StopAfterDownload,
 		opt.StopAfterMetadata,
 		false, // completeCmdRun
@@ -96,22 +120,32 @@ func (s *Session) addTorrentStopped(r io.Reader, opt *AddTorrentOptions) (*Torre
 	if err != nil {
 		return nil, err
 	}
+	t.dest = opt.DataDir
+	t.signer = signer
+	t.keepData = keepData
 	go s.checkTorrent(t)
 	defer func() {
 		if err != nil {
 			t.Close()
 		}
 	}()
-	rspec := &boltdbresumer.Spec{
+	rspec := &resumer.Spec{
 		InfoHash:          mi.Info.Hash[:],
 		Port:              port,
 		Name:              mi.Info.Name,
 		Trackers:          mi.AnnounceList,
 		URLList:           mi.URLList,
+		HTTPSeeds:         mi.HTTPSeeds,
+		Dest:              opt.DataDir,
+		KeepData:          keepData,
 		Info:              mi.Info.Bytes,
 		AddedAt:           t.addedAt,
 		StopAfterDownload: opt.StopAfterDownload,
 		StopAfterMetadata: opt.StopAfterMetadata,
+		Signer:            signer,
+	}
+	if bf != nil {
+		rspec.Bitfield = bf.Bytes()
 	}
 	err = s.resumer.Write(id, rspec)
 	if err != nil {
@@ -130,6 +164,10 @@ func (s *Session) AddURI(uri string, opt *AddTorrentOptions) (*Torrent, error) {
 	if opt == nil {
 		opt = &AddTorrentOptions{}
 	}
+	opt, err := absDataDir(opt)
+	if err != nil {
+		return nil, err
+	}
 	u, err := url.Parse(uri)
 	if err != nil {
 		return nil, newInputError(err)
@@ -175,10 +213,29 @@ func (s *Session) addURL(u string, opt *AddTorrentOptions) (*Torrent, error) {
 }
 
 func (s *Session) addMagnet(link string, opt *AddTorrentOptions) (*Torrent, error) {
+	if s.config.RequireSignedTorrents {
+		return nil, newInputError(errSignatureRequired)
+	}
 	ma, err := magnet.New(link)
 	if err != nil {
 		return nil, newInputError(err)
 	}
+	var mutable string
+	if ma.PublicKey != nil {
+		mutable = mutableLink(ma)
+		if ma.InfoHash == [20]byte{} {
+			ma.InfoHash, err = s.resolveMutableTorrent(ma.PublicKey, ma.Salt)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s.addParsedMagnet(ma, opt, mutable)
+}
+
+// addParsedMagnet adds a torrent from a parsed magnet link.
+// If mutable is not empty, the torrent is a version of the mutable torrent (BEP 46) with that link.
+func (s *Session) addParsedMagnet(ma *magnet.Magnet, opt *AddTorrentOptions, mutable string) (*Torrent, error) {
 	id, port, sto, err := s.add(opt)
 	if err != nil {
 		return nil, err
@@ -209,21 +266,25 @@ func (s *Session) addMagnet(link string, opt *AddTorrentOptions) (*Torrent, erro
 	if err != nil {
 		return nil, err
 	}
+	t.dest = opt.DataDir
+	t.mutableLink = mutable
 	go s.checkTorrent(t)
 	defer func() {
 		if err != nil {
 			t.Close()
 		}
 	}()
-	rspec := &boltdbresumer.Spec{
+	rspec := &resumer.Spec{
 		InfoHash:          ma.InfoHash[:],
 		Port:              port,
 		Name:              ma.Name,
 		Trackers:          ma.Trackers,
 		FixedPeers:        ma.Peers,
+		Dest:              opt.DataDir,
 		AddedAt:           t.addedAt,
 		StopAfterDownload: opt.StopAfterDownload,
 		StopAfterMetadata: opt.StopAfterMetadata,
+		MutableLink:       mutable,
 	}
 	err = s.resumer.Write(id, rspec)
 	if err != nil {
@@ -236,7 +297,7 @@ func (s *Session) addMagnet(link string, opt *AddTorrentOptions) (*Torrent, erro
 	return t2, err
 }
 
-func (s *Session) add(opt *AddTorrentOptions) (id string, port int, sto *filestorage.FileStorage, err error) {
+func (s *Session) add(opt *AddTorrentOptions) (id string, port int, sto storage.Storage, err error) {
 	port, err = s.getPort()
 	if err != nil {
 		return
@@ -259,20 +320,42 @@ func (s *Session) add(opt *AddTorrentOptions) (id string, port int, sto *filesto
 		}
 		id = givenID
 	} else {
-		u1, err2 := uuid.NewV1()
-		if err2 != nil {
-			err = err2
+		id, err = newTorrentID()
+		if err != nil {
 			return
 		}
-		id = base64.RawURLEncoding.EncodeToString(u1[:])
 	}
-	sto, err = filestorage.New(s.getDataDir(id))
-	if err != nil {
+	if opt.Storage != nil {
+		sto = opt.Storage
 		return
 	}
+	sto, err = s.newStorage(id, opt.DataDir)
 	return
 }
 
+func newTorrentID() (string, error) {
+	u1, err := uuid.NewV1()
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(u1[:]), nil
+}
+
+// absDataDir returns a copy of options with the data dir converted to an absolute path,
+// so the torrent does not depend on the working directory of the process.
+func absDataDir(opt *AddTorrentOptions) (*AddTorrentOptions, error) {
+	if opt.DataDir == "" {
+		return opt, nil
+	}
+	dir, err := filepath.Abs(opt.DataDir)
+	if err != nil {
+		return nil, newInputError(err)
+	}
+	opt2 := *opt
+	opt2.DataDir = dir
+	return &opt2, nil
+}
+
 func (s *Session) insertTorrent(t *torrent) *Torrent {
 	t.log.Info("added torrent")
 	t2 := &Torrent{
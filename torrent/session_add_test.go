@@ -1,9 +1,12 @@
 package torrent
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/ganqierwu/rain/storage/memstorage"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,3 +22,82 @@ func TestInvalidTorrentData(t *testing.T) {
 
 	assert.Error(t, err)
 }
+
+func TestAddTorrentDataDir(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dir := t.TempDir()
+	tor, err := s.AddTorrent(f, &AddTorrentOptions{Stopped: true, DataDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dir, tor.DataDir())
+
+	spec, err := s.resumer.Read(tor.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dir, spec.Dest)
+	assert.False(t, spec.KeepData)
+
+	// Downloaded files in custom data dir are deleted with the torrent.
+	err = os.Mkdir(filepath.Join(dir, torrentName), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, s.RemoveTorrent(tor.ID()))
+	_, err = os.Stat(filepath.Join(dir, torrentName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAddTorrentStorage(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tor, err := s.AddTorrent(f, &AddTorrentOptions{Stopped: true, Storage: memstorage.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", tor.DataDir())
+	assert.Error(t, tor.Move("http://127.0.0.1:1"))
+	assert.NoError(t, s.RemoveTorrent(tor.ID()))
+}
+
+type closingStorage struct {
+	*memstorage.MemStorage
+	closed int
+}
+
+func (s *closingStorage) Close() error {
+	s.closed++
+	return nil
+}
+
+func TestAddTorrentStorageClosed(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	sto := &closingStorage{MemStorage: memstorage.New()}
+	tor, err := s.AddTorrent(f, &AddTorrentOptions{Stopped: true, Storage: sto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, s.RemoveTorrent(tor.ID()))
+	assert.Equal(t, 1, sto.closed)
+}
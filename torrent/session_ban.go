@@ -0,0 +1,268 @@
+package torrent
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/peer"
+	"go.etcd.io/bbolt"
+)
+
+// Ban prevents connections to and from a range of IP addresses.
+type Ban struct {
+	// Banned IP range in CIDR notation. A single IP is a range of one address.
+	Net string
+	// ID of the torrent that the ban applies to. Empty if the ban applies to all torrents.
+	TorrentID string
+	// Reason of the ban. Empty for bans that are added manually.
+	Reason    string
+	CreatedAt time.Time
+	// The ban is removed after this time. Zero if the ban does not expire.
+	ExpiresAt time.Time
+
+	ipnet *net.IPNet
+}
+
+func (b *Ban) key() string {
+	return b.TorrentID + " " + b.Net
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return !b.ExpiresAt.IsZero() && now.After(b.ExpiresAt)
+}
+
+func (b *Ban) matches(torrentID string, ip net.IP, now time.Time) bool {
+	if b.TorrentID != "" && b.TorrentID != torrentID {
+		return false
+	}
+	return !b.expired(now) && b.ipnet.Contains(ip)
+}
+
+// parseBanNet parses an IP address or a CIDR range.
+func parseBanNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipnet, err := net.ParseCIDR(s)
+		return ipnet, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.New("invalid IP address: " + s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)}, nil
+}
+
+// BanIP bans an IP address or a CIDR range in all torrents and disconnects the matching peers.
+// If d is not zero, the ban is removed after d.
+func (s *Session) BanIP(addr string, d time.Duration) error {
+	return s.addBan(addr, "", d)
+}
+
+// UnbanIP removes a ban that is added with Session.BanIP.
+// IPs that are banned in all torrents for sending corrupt data can be unbanned too.
+func (s *Session) UnbanIP(addr string) error {
+	return s.removeBan(addr, "")
+}
+
+// ListBans returns the bans of the session and torrents, except the ones that have expired.
+// IPs that are banned in all torrents for sending corrupt data are included.
+// IPs that are banned in a single torrent for sending corrupt data are not included.
+func (s *Session) ListBans() []Ban {
+	now := time.Now()
+	var expired []*Ban
+	s.mBannedIPs.Lock()
+	bans := make([]Ban, 0, len(s.bans)+len(s.bannedIPs))
+	for key, b := range s.bans {
+		if b.expired(now) {
+			expired = append(expired, b)
+			delete(s.bans, key)
+			continue
+		}
+		bans = append(bans, *b)
+	}
+	for ip, at := range s.bannedIPs {
+		ipnet, _ := parseBanNet(ip)
+		bans = append(bans, Ban{
+			Net:       ipnet.String(),
+			Reason:    "corrupt data",
+			CreatedAt: at,
+			ipnet:     ipnet,
+		})
+	}
+	s.mBannedIPs.Unlock()
+	for _, b := range expired {
+		err := s.deleteBan(b)
+		if err != nil {
+			s.log.Errorln("cannot delete expired ban:", err.Error())
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].CreatedAt.Before(bans[j].CreatedAt) })
+	return bans
+}
+
+func (s *Session) addBan(addr, torrentID string, d time.Duration) error {
+	ipnet, err := parseBanNet(addr)
+	if err != nil {
+		return newInputError(err)
+	}
+	if d < 0 {
+		return newInputError(errors.New("negative ban duration"))
+	}
+	b := &Ban{
+		Net:       ipnet.String(),
+		TorrentID: torrentID,
+		CreatedAt: time.Now(),
+		ipnet:     ipnet,
+	}
+	if d > 0 {
+		b.ExpiresAt = b.CreatedAt.Add(d)
+	}
+	err = s.writeBan(b)
+	if err != nil {
+		return err
+	}
+	s.mBannedIPs.Lock()
+	s.bans[b.key()] = b
+	s.mBannedIPs.Unlock()
+
+	var torrents []*Torrent
+	if torrentID == "" {
+		torrents = s.ListTorrents()
+	} else if t := s.GetTorrent(torrentID); t != nil {
+		torrents = []*Torrent{t}
+	}
+	for _, t := range torrents {
+		t.torrent.disconnectPeers(func(pe *peer.Peer) bool { return ipnet.Contains(pe.Addr().IP) })
+	}
+	return nil
+}
+
+func (s *Session) removeBan(addr, torrentID string) error {
+	ipnet, err := parseBanNet(addr)
+	if err != nil {
+		return newInputError(err)
+	}
+	key := (&Ban{Net: ipnet.String(), TorrentID: torrentID}).key()
+	s.mBannedIPs.Lock()
+	b, ok := s.bans[key]
+	delete(s.bans, key)
+	var smartBanned bool
+	if torrentID == "" {
+		ip := ipnet.IP.String()
+		if _, smartBanned = s.bannedIPs[ip]; smartBanned {
+			delete(s.bannedIPs, ip)
+			delete(s.smartBanStrikes, ip)
+		}
+	}
+	s.mBannedIPs.Unlock()
+	if !ok && !smartBanned {
+		return newInputError(errors.New("ban not found"))
+	}
+	if !ok {
+		return nil
+	}
+	return s.deleteBan(b)
+}
+
+// isBanned returns true if the IP is banned in all torrents or in the torrent with the ID.
+func (s *Session) isBanned(torrentID string, ip net.IP) bool {
+	now := time.Now()
+	s.mBannedIPs.RLock()
+	defer s.mBannedIPs.RUnlock()
+	if _, ok := s.bannedIPs[ip.String()]; ok {
+		return true
+	}
+	for _, b := range s.bans {
+		if b.matches(torrentID, ip, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Session) removeTorrentBans(torrentID string) error {
+	var bans []*Ban
+	s.mBannedIPs.Lock()
+	for key, b := range s.bans {
+		if b.TorrentID == torrentID {
+			bans = append(bans, b)
+			delete(s.bans, key)
+		}
+	}
+	s.mBannedIPs.Unlock()
+	for _, b := range bans {
+		err := s.deleteBan(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) loadBans() error {
+	if s.db == nil {
+		return nil
+	}
+	now := time.Now()
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bansBucket)
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			var b Ban
+			err := json.Unmarshal(v, &b)
+			if err != nil {
+				s.log.Errorf("cannot load ban %q: %s", k, err)
+				return nil
+			}
+			if b.expired(now) {
+				expired = append(expired, k)
+				return nil
+			}
+			b.ipnet, err = parseBanNet(b.Net)
+			if err != nil {
+				s.log.Errorf("cannot load ban %q: %s", k, err)
+				return nil
+			}
+			s.bans[b.key()] = &b
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			err = bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *Session) writeBan(b *Ban) error {
+	if s.db == nil {
+		return nil
+	}
+	val, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bansBucket).Put([]byte(b.key()), val)
+	})
+}
+
+func (s *Session) deleteBan(b *Ban) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bansBucket).Delete([]byte(b.key()))
+	})
+}
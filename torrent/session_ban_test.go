@@ -0,0 +1,65 @@
+package torrent
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanIP(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Database = filepath.Join(t.TempDir(), "session.db")
+	cfg.DataDir = t.TempDir()
+	cfg.DHTEnabled = false
+	cfg.RPCEnabled = false
+	s, err := NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Error(t, s.BanIP("not an ip", 0))
+	assert.NoError(t, s.BanIP("10.0.0.0/8", 0))
+	assert.NoError(t, s.BanIP("1.2.3.4", time.Hour))
+	assert.NoError(t, s.BanIP("5.6.7.8", time.Nanosecond))
+	time.Sleep(time.Millisecond)
+
+	assert.True(t, s.isBanned("", net.ParseIP("10.1.2.3")))
+	assert.True(t, s.isBanned("any", net.ParseIP("1.2.3.4")))
+	assert.False(t, s.isBanned("", net.ParseIP("1.2.3.5")))
+	assert.False(t, s.isBanned("", net.ParseIP("5.6.7.8")), "ban must expire")
+	assert.Len(t, s.ListBans(), 2)
+
+	assert.NoError(t, s.Close())
+	s, err = NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	bans := s.ListBans()
+	assert.Len(t, bans, 2)
+	assert.True(t, s.isBanned("", net.ParseIP("10.1.2.3")))
+	assert.NoError(t, s.UnbanIP("10.0.0.0/8"))
+	assert.False(t, s.isBanned("", net.ParseIP("10.1.2.3")))
+	assert.Error(t, s.UnbanIP("10.0.0.0/8"))
+}
+
+func TestBanIPTorrent(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	tor, err := s.AddURI("magnet:?xt=urn:btih:0000000000000000000000000000000000000000", &AddTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, tor.BanIP("1.2.3.4", 0))
+	assert.True(t, s.isBanned(tor.ID(), net.ParseIP("1.2.3.4")))
+	assert.False(t, s.isBanned("other", net.ParseIP("1.2.3.4")))
+	assert.Error(t, tor.DisconnectPeer("1.2.3.4:5678"))
+
+	assert.NoError(t, s.RemoveTorrent(tor.ID()))
+	assert.Empty(t, s.ListBans())
+}
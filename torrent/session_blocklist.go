@@ -1,113 +1,181 @@
 package torrent
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
-	"crypto/sha1"
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
+	"github.com/ganqierwu/rain/internal/blocklist"
 	"go.etcd.io/bbolt"
 )
 
-func (s *Session) startBlocklistReloader() error {
-	if s.config.BlocklistURL == "" {
-		return nil
-	}
-	blocklistTimestamp, err := s.getBlocklistTimestamp()
-	if err != nil {
-		return err
-	}
+// BlocklistSourceStats contains the status of a blocklist source.
+type BlocklistSourceStats struct {
+	// URL or file path of the blocklist.
+	Source string
+	// Number of rules loaded from the source.
+	Rules int
+	// Time of the last successful load. Zero if the source has never been loaded.
+	UpdatedAt time.Time
+	// Error of the last load attempt. Nil if the last attempt was successful.
+	Error error
+}
 
-	s.mBlocklist.Lock()
-	s.blocklistTimestamp = blocklistTimestamp
-	s.mBlocklist.Unlock()
+type blocklistSource struct {
+	Source    string
+	Ranges    []blocklist.Range
+	UpdatedAt time.Time
+	Error     error
 
-	deadline := blocklistTimestamp.Add(s.config.BlocklistUpdateInterval)
-	now := time.Now()
-	var nextReload time.Duration
-	switch {
-	case blocklistTimestamp.IsZero():
-		s.log.Infof("Blocklist is empty. Loading blocklist...")
-		s.retryReloadBlocklist()
-		nextReload = s.config.BlocklistUpdateInterval
-	case deadline.Before(now):
-		s.log.Infof("Last blocklist reload was %s ago. Reloading blocklist...", now.Sub(s.blocklistTimestamp).Truncate(time.Second).String())
-		s.retryReloadBlocklist()
-		nextReload = s.config.BlocklistUpdateInterval
-	default:
-		s.log.Infof("Loading blocklist from session db...")
-		err = s.loadBlocklistFromDB()
+	nextReload time.Time
+	backoff    *backoff.ExponentialBackOff
+}
+
+func (b *blocklistSource) remote() bool {
+	return strings.HasPrefix(b.Source, "http://") || strings.HasPrefix(b.Source, "https://")
+}
+
+func (s *Session) startBlocklistReloader() error {
+	for _, a := range s.config.BlocklistAllow {
+		r, err := blocklist.ParseRange(a)
 		if err != nil {
-			s.log.Errorln("Couldn't load blocklist from sesson db:", err)
-			s.log.Infof("Loading blocklist from remote URL...")
-			s.retryReloadBlocklist()
-			nextReload = s.config.BlocklistUpdateInterval
-		} else {
-			nextReload = deadline.Sub(now)
+			return fmt.Errorf("invalid range in blocklist allow-list %q: %w", a, err)
 		}
+		s.blocklistAllow = append(s.blocklistAllow, r)
 	}
-	go s.blocklistReloader(nextReload)
-	return nil
-}
-
-func (s *Session) getBlocklistTimestamp() (time.Time, error) {
-	sum := sha1.Sum([]byte(s.config.BlocklistURL))
-	var t time.Time
-	err := s.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(sessionBucket)
-		val := b.Get(blocklistURLHashKey)
-		if val == nil {
-			return nil
+	sources := s.config.BlocklistSources
+	if s.config.BlocklistURL != "" {
+		sources = append([]string{s.config.BlocklistURL}, sources...)
+	}
+	seen := make(map[string]struct{})
+	for _, src := range sources {
+		if _, ok := seen[src]; ok {
+			continue
 		}
-		if !bytes.Equal(val, sum[:]) {
-			return nil
+		seen[src] = struct{}{}
+		bo := backoff.NewExponentialBackOff()
+		bo.MaxElapsedTime = 0
+		bs := &blocklistSource{Source: src, backoff: bo}
+		s.blocklistSources = append(s.blocklistSources, bs)
+		if !bs.remote() {
+			continue
 		}
-		val = b.Get(blocklistTimestampKey)
-		if val == nil {
-			return nil
+		err := s.loadBlocklistSourceFromDB(bs)
+		if err != nil {
+			s.log.Errorf("Couldn't load blocklist %s from session db: %s", src, err)
+			continue
 		}
-		var err2 error
-		t, err2 = time.Parse(time.RFC3339, string(val))
-		return err2
-	})
-	return t, err
+		if !bs.UpdatedAt.IsZero() {
+			s.log.Infof("Loaded %d rules of blocklist %s from session db.", len(bs.Ranges), src)
+			bs.nextReload = bs.UpdatedAt.Add(s.config.BlocklistUpdateInterval)
+		}
+	}
+	s.updateBlocklist()
+	if len(s.blocklistSources) == 0 {
+		return nil
+	}
+	// Sources that are not loaded yet or out of date are loaded once before session starts.
+	// Failed ones are retried in background.
+	s.reloadBlocklistSources(time.Now())
+	go s.blocklistReloader()
+	return nil
 }
 
-func (s *Session) retryReloadBlocklist() {
-	bo := backoff.NewExponentialBackOff()
-	bo.MaxElapsedTime = 0
-
-	ticker := backoff.NewTicker(bo)
-	defer ticker.Stop()
-
+func (s *Session) blocklistReloader() {
 	for {
-		select {
-		case <-ticker.C:
-			err := s.reloadBlocklist()
-			if err != nil {
-				s.log.Errorln("cannot load blocklist:", err.Error())
-				continue
+		s.mBlocklist.RLock()
+		var next time.Time
+		for _, bs := range s.blocklistSources {
+			if next.IsZero() || bs.nextReload.Before(next) {
+				next = bs.nextReload
 			}
-			return
+		}
+		s.mBlocklist.RUnlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
 		case <-s.closeC:
+			timer.Stop()
 			return
 		}
+		s.reloadBlocklistSources(time.Now())
+	}
+}
+
+// reloadBlocklistSources reloads the sources that are due and rebuilds the blocklist.
+func (s *Session) reloadBlocklistSources(now time.Time) {
+	s.mBlocklist.RLock()
+	var due []*blocklistSource
+	for _, bs := range s.blocklistSources {
+		if !bs.nextReload.After(now) {
+			due = append(due, bs)
+		}
+	}
+	s.mBlocklist.RUnlock()
+
+	var reloaded bool
+	for _, bs := range due {
+		s.log.Infoln("Loading blocklist", bs.Source)
+		err := s.reloadBlocklistSource(bs)
+		if err != nil {
+			s.log.Errorf("cannot load blocklist %s: %s", bs.Source, err)
+			continue
+		}
+		reloaded = true
+	}
+	if reloaded {
+		s.updateBlocklist()
 	}
 }
 
-func (s *Session) reloadBlocklist() error {
-	req, err := http.NewRequest(http.MethodGet, s.config.BlocklistURL, nil)
+func (s *Session) reloadBlocklistSource(bs *blocklistSource) error {
+	data, err := s.readBlocklistSource(bs)
+	var ranges []blocklist.Range
+	if err == nil {
+		ranges, err = blocklist.Parse(data, s.config.BlocklistMaxDecompressedSize, s.blocklist.Logger)
+	}
+	now := time.Now()
+	s.mBlocklist.Lock()
 	if err != nil {
+		bs.Error = err
+		bs.nextReload = now.Add(bs.backoff.NextBackOff())
+		s.mBlocklist.Unlock()
 		return err
 	}
+	bs.Ranges = ranges
+	bs.UpdatedAt = now
+	bs.Error = nil
+	bs.nextReload = now.Add(s.config.BlocklistUpdateInterval)
+	bs.backoff.Reset()
+	s.mBlocklist.Unlock()
+
+	s.log.Infof("Loaded %d rules from blocklist %s.", len(ranges), bs.Source)
+	if !bs.remote() {
+		return nil
+	}
+	return s.saveBlocklistSourceToDB(bs.Source, data, now)
+}
+
+func (s *Session) readBlocklistSource(bs *blocklistSource) ([]byte, error) {
+	if !bs.remote() {
+		fi, err := os.Stat(bs.Source)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Size() > s.config.BlocklistMaxResponseSize {
+			return nil, errors.New("blocklist file too big")
+		}
+		return os.ReadFile(bs.Source)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.BlocklistUpdateTimeout)
 	defer cancel()
 	go func() {
 		select {
@@ -116,107 +184,103 @@ func (s *Session) reloadBlocklist() error {
 		case <-ctx.Done():
 		}
 	}()
-	req = req.WithContext(ctx)
-
-	client := http.Client{
-		Timeout: s.config.BlocklistUpdateTimeout,
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, bs.Source, nil)
+	if err != nil {
+		return nil, err
 	}
-
-	resp, err := client.Do(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	s.log.Infoln("Blocklist response status code:", resp.StatusCode)
-	s.log.Infoln("Blocklist response content length:", resp.ContentLength)
-	s.log.Infoln("Blocklist response content type:", resp.Header.Get("content-type"))
-
 	if resp.StatusCode != 200 {
-		return errors.New("invalid blocklist status code")
-	}
-	if resp.ContentLength == -1 {
-		return errors.New("unknown content length")
+		return nil, fmt.Errorf("invalid blocklist status code: %d", resp.StatusCode)
 	}
 	if resp.ContentLength > s.config.BlocklistMaxResponseSize {
-		return errors.New("response too big")
+		return nil, errors.New("response too big")
 	}
-
-	buf := make([]byte, resp.ContentLength)
-	_, err = io.ReadFull(resp.Body, buf)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.config.BlocklistMaxResponseSize+1))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	resp.Body.Close()
-
-	if resp.Header.Get("content-type") == "application/x-gzip" {
-		gr, gerr := gzip.NewReader(bytes.NewReader(buf))
-		if gerr != nil {
-			return gerr
-		}
-		defer gr.Close()
-		buf, err = ioutil.ReadAll(gr)
-		if err != nil {
-			return err
-		}
-	}
-
-	err = s.loadBlocklistReader(bytes.NewReader(buf))
-	if err != nil {
-		return err
+	if int64(len(data)) > s.config.BlocklistMaxResponseSize {
+		return nil, errors.New("response too big")
 	}
+	return data, nil
+}
 
-	now := time.Now()
-
+// updateBlocklist merges the rules of all sources and the allow-list into the blocklist.
+func (s *Session) updateBlocklist() {
 	s.mBlocklist.Lock()
-	s.blocklistTimestamp = now
-	s.mBlocklist.Unlock()
-
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(sessionBucket)
-		err2 := b.Put(blocklistKey, buf)
-		if err2 != nil {
-			return err2
+	defer s.mBlocklist.Unlock()
+	var ranges []blocklist.Range
+	var oldest time.Time
+	for _, bs := range s.blocklistSources {
+		ranges = append(ranges, bs.Ranges...)
+		if !bs.UpdatedAt.IsZero() && (oldest.IsZero() || bs.UpdatedAt.Before(oldest)) {
+			oldest = bs.UpdatedAt
 		}
-		sum := sha1.Sum([]byte(s.config.BlocklistURL))
-		err2 = b.Put(blocklistURLHashKey, sum[:])
-		if err2 != nil {
-			return err2
+	}
+	s.blocklistTimestamp = oldest
+	s.blocklist.Set(ranges, s.blocklistAllow)
+}
+
+func (s *Session) blocklistStats() []BlocklistSourceStats {
+	s.mBlocklist.RLock()
+	defer s.mBlocklist.RUnlock()
+	stats := make([]BlocklistSourceStats, len(s.blocklistSources))
+	for i, bs := range s.blocklistSources {
+		stats[i] = BlocklistSourceStats{
+			Source:    bs.Source,
+			Rules:     len(bs.Ranges),
+			UpdatedAt: bs.UpdatedAt,
+			Error:     bs.Error,
 		}
-		return b.Put(blocklistTimestampKey, []byte(now.Format(time.RFC3339)))
-	})
+	}
+	return stats
 }
 
-func (s *Session) loadBlocklistFromDB() error {
+func (s *Session) loadBlocklistSourceFromDB(bs *blocklistSource) error {
+	if s.db == nil {
+		return nil
+	}
 	return s.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(sessionBucket)
-		val := b.Get(blocklistKey)
-		if len(val) == 0 {
-			return errors.New("no blocklist data in db")
+		b := tx.Bucket(blocklistsBucket).Bucket([]byte(bs.Source))
+		if b == nil {
+			return nil
+		}
+		val := b.Get(blocklistTimestampKey)
+		if val == nil {
+			return nil
 		}
-		return s.loadBlocklistReader(bytes.NewReader(val))
+		t, err := time.Parse(time.RFC3339, string(val))
+		if err != nil {
+			return err
+		}
+		ranges, err := blocklist.Parse(b.Get(blocklistKey), s.config.BlocklistMaxDecompressedSize, s.blocklist.Logger)
+		if err != nil {
+			return err
+		}
+		bs.Ranges = ranges
+		bs.UpdatedAt = t
+		return nil
 	})
 }
 
-func (s *Session) loadBlocklistReader(r io.Reader) error {
-	n, err := s.blocklist.Reload(r)
-	if err != nil {
-		return err
+func (s *Session) saveBlocklistSourceToDB(source string, data []byte, t time.Time) error {
+	if s.db == nil {
+		return nil
 	}
-	s.log.Infof("Loaded %d rules from blocklist.", n)
-	return nil
-}
-
-func (s *Session) blocklistReloader(d time.Duration) {
-	for {
-		select {
-		case <-time.After(d):
-		case <-s.closeC:
-			return
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(blocklistsBucket).CreateBucketIfNotExists([]byte(source))
+		if err != nil {
+			return err
 		}
-
-		s.log.Info("Reloading blocklist...")
-		s.retryReloadBlocklist()
-		d = s.config.BlocklistUpdateInterval
-	}
+		err = b.Put(blocklistKey, data)
+		if err != nil {
+			return err
+		}
+		return b.Put(blocklistTimestampKey, []byte(t.Format(time.RFC3339)))
+	})
 }
@@ -0,0 +1,67 @@
+package torrent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlocklistSources(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write([]byte("10.0.0.0/8\n"))
+	gw.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(gz.Bytes())
+	}))
+
+	file := filepath.Join(t.TempDir(), "list.p2p")
+	err := os.WriteFile(file, []byte("Some Org:20.0.0.1-20.0.0.10\n"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.Database = filepath.Join(t.TempDir(), "session.db")
+	cfg.DataDir = t.TempDir()
+	cfg.DHTEnabled = false
+	cfg.RPCEnabled = false
+	cfg.BlocklistURL = srv.URL
+	cfg.BlocklistSources = []string{file, filepath.Join(t.TempDir(), "missing")}
+	cfg.BlocklistAllow = []string{"10.1.0.0/16"}
+	s, err := NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.True(t, s.blocklist.Blocked(net.ParseIP("10.0.0.1")))
+	assert.False(t, s.blocklist.Blocked(net.ParseIP("10.1.0.1")))
+	assert.True(t, s.blocklist.Blocked(net.ParseIP("20.0.0.5")))
+	assert.False(t, s.blocklist.Blocked(net.ParseIP("20.0.0.11")))
+
+	stats := s.Stats()
+	assert.Equal(t, 2, stats.BlockListRules)
+	if assert.Len(t, stats.BlockListSources, 3) {
+		assert.Equal(t, 1, stats.BlockListSources[0].Rules)
+		assert.Equal(t, 1, stats.BlockListSources[1].Rules)
+		assert.NoError(t, stats.BlockListSources[1].Error)
+		assert.Error(t, stats.BlockListSources[2].Error)
+	}
+	assert.NoError(t, s.Close())
+
+	// Remote blocklist is loaded from session db when the server is not reachable.
+	srv.Close()
+	s, err = NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	assert.True(t, s.blocklist.Blocked(net.ParseIP("10.0.0.1")))
+}
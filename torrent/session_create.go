@@ -0,0 +1,104 @@
+package torrent
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+
+	"github.com/ganqierwu/rain/internal/bitfield"
+	"github.com/ganqierwu/rain/internal/metainfo"
+)
+
+// CreateTorrentOptions contains options for creating a new torrent from files on the disk.
+type CreateTorrentOptions struct {
+	// ID uniquely identifies the torrent in Session.
+	// If empty, a random ID is generated.
+	ID string
+	// Do not start torrent automatically after adding.
+	Stopped bool
+	// Create torrent for private trackers.
+	Private bool
+	// Piece length in bytes. Must be multiple of 16K.
+	// If zero, it is calculated automatically from the total size of files.
+	PieceLength uint32
+	// Insert padding files so that each file starts at a piece boundary (BEP 47).
+	PadFiles bool
+	// Tiers of tracker URLs that are put into the torrent.
+	Trackers [][]string
+	// Webseed URLs that are put into the torrent.
+	Webseeds []string
+	// Comment that is put into the torrent.
+	Comment string
+	// If not nil, called after each piece is hashed with the number of bytes hashed so far.
+	Progress func(hashed, total int64)
+}
+
+// CreateTorrent creates a new torrent from files at paths and adds it to the session.
+// If more than one path is given, all of them must be in the same directory.
+// The torrent is seeded from the original location of the files.
+// Since all pieces are hashed while creating the torrent, files are not verified again.
+// Returned byte slice contains the contents of the created .torrent file.
+// Nil value can be passed as opt for default options.
+func (s *Session) CreateTorrent(paths []string, opt *CreateTorrentOptions) (*Torrent, []byte, error) {
+	if opt == nil {
+		opt = &CreateTorrentOptions{}
+	}
+	if len(paths) == 0 {
+		return nil, nil, newInputError(errors.New("no path specified"))
+	}
+	absPaths := make([]string, len(paths))
+	for i, p := range paths {
+		ap, err := filepath.Abs(p)
+		if err != nil {
+			return nil, nil, newInputError(err)
+		}
+		absPaths[i] = ap
+	}
+	// Torrent name becomes the top level file or directory in data dir.
+	// Choose root and name so that the data dir contains the files at their original location.
+	var root, name string
+	if len(absPaths) > 1 {
+		root = filepath.Dir(absPaths[0])
+		for _, p := range absPaths[1:] {
+			if filepath.Dir(p) != root {
+				return nil, nil, newInputError(errors.New("all paths must be in the same directory"))
+			}
+		}
+		name = filepath.Base(root)
+	}
+	dest := filepath.Dir(absPaths[0])
+	if root != "" {
+		dest = filepath.Dir(root)
+	}
+	s.log.Infof("creating torrent from %d paths, data dir: %s", len(absPaths), dest)
+	info, err := metainfo.NewInfoBytes(root, absPaths, opt.Private, opt.PieceLength, name, opt.PadFiles, opt.Progress, s.log)
+	if err != nil {
+		return nil, nil, newInputError(err)
+	}
+	b, err := metainfo.NewBytes(info, opt.Trackers, opt.Webseeds, nil, opt.Comment)
+	if err != nil {
+		return nil, nil, err
+	}
+	mi, err := s.parseMetaInfo(bytes.NewReader(b))
+	if err != nil {
+		return nil, nil, newInputError(err)
+	}
+	bf := bitfield.New(mi.Info.NumPieces)
+	for i := uint32(0); i < bf.Len(); i++ {
+		bf.Set(i)
+	}
+	addOpt := &AddTorrentOptions{
+		ID:      opt.ID,
+		Stopped: opt.Stopped,
+		DataDir: dest,
+	}
+	// Files belong to the user, so they are kept when the torrent is removed.
+	t, err := s.addMetaInfoStopped(mi, addOpt, bf, "", true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !opt.Stopped {
+		err = t.Start()
+	}
+	return t, b, err
+}
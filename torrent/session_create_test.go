@@ -0,0 +1,135 @@
+package torrent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/ganqierwu/rain/internal/rpctypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTorrent(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	src := filepath.Join(t.TempDir(), "content")
+	err := os.MkdirAll(filepath.Join(src, "sub"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(src, "a"), bytes.Repeat([]byte{1}, 50<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(src, "sub", "b"), bytes.Repeat([]byte{2}, 30<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hashed, total int64
+	opt := &CreateTorrentOptions{
+		PieceLength: 32 << 10,
+		Trackers:    [][]string{{"http://tracker.example.com/announce"}},
+		Progress:    func(h, t int64) { hashed, total = h, t },
+	}
+	tor, b, err := s.CreateTorrent([]string{src}, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(80<<10), hashed)
+	assert.Equal(t, int64(80<<10), total)
+	assert.Equal(t, "content", tor.Name())
+
+	mi, err := metainfo.New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, mi.Info.Hash, [20]byte(tor.InfoHash()))
+	assert.Equal(t, [][]string{{"http://tracker.example.com/announce"}}, mi.AnnounceList)
+
+	select {
+	case <-tor.NotifyComplete():
+	case err = <-tor.NotifyStop():
+		t.Fatal(err)
+	case <-time.After(timeout):
+		t.Fatal("torrent is not completed")
+	}
+	stats := tor.Stats()
+	assert.Equal(t, "Seeding", stats.Status.String())
+	assert.Equal(t, uint32(0), stats.Pieces.Checked)
+	assert.Equal(t, stats.Pieces.Total, stats.Pieces.Have)
+	assert.Equal(t, filepath.Dir(src), tor.torrent.storage.RootDir())
+
+	// Original files must be kept when the torrent is removed.
+	spec, err := s.resumer.Read(tor.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, spec.KeepData)
+	assert.NoError(t, s.RemoveTorrent(tor.ID()))
+	_, err = os.Stat(filepath.Join(src, "sub", "b"))
+	assert.NoError(t, err)
+}
+
+func TestCreateTorrentPadFiles(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	src := filepath.Join(t.TempDir(), "content")
+	err := os.Mkdir(src, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(src, "a"), bytes.Repeat([]byte{1}, 50<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(src, "b"), bytes.Repeat([]byte{2}, 30<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, _, err := s.CreateTorrent([]string{src}, &CreateTorrentOptions{PieceLength: 32 << 10, PadFiles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-tor.NotifyComplete():
+	case err = <-tor.NotifyStop():
+		t.Fatal(err)
+	case <-time.After(timeout):
+		t.Fatal("torrent is not completed")
+	}
+	files := tor.Files()
+	if assert.Len(t, files, 3) {
+		assert.True(t, files[1].Padding)
+		assert.Equal(t, int64(14<<10), files[1].Length)
+	}
+	// Padding files are not saved to disk.
+	_, err = os.Stat(filepath.Join(src, ".pad"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCreateTorrentRPCProgress(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	src := filepath.Join(t.TempDir(), "a")
+	err := os.WriteFile(src, bytes.Repeat([]byte{1}, 50<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &rpcHandler{session: s, createProgress: make(map[string]*rpctypes.GetCreateTorrentProgressResponse)}
+	var reply rpctypes.CreateTorrentResponse
+	err = handler.CreateTorrent(&rpctypes.CreateTorrentRequest{Paths: []string{src}, PieceLength: 32 << 10, ProgressID: "foo", Stopped: true}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Progress is forgotten after the torrent is created.
+	assert.Empty(t, handler.createProgress)
+	err = handler.GetCreateTorrentProgress(&rpctypes.GetCreateTorrentProgressRequest{ProgressID: "foo"}, &rpctypes.GetCreateTorrentProgressResponse{})
+	assert.Equal(t, errCreateProgressNotFound, err)
+}
@@ -1,10 +1,48 @@
 package torrent
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"net"
+	"strings"
 	"time"
+
+	"github.com/ganqierwu/rain/internal/dht"
+	"github.com/ganqierwu/rain/internal/dhtstore"
 )
 
+// Timeout for a single query that is sent to a node while storing or retrieving DHT items.
+const dhtStoreQueryTimeout = 5 * time.Second
+
+var errDHTDisabled = errors.New("dht is not enabled")
+
+// startDHT starts the DHT node and the client that stores and retrieves BEP 44 items.
+// The client sends its queries from the socket of the DHT node and finds its nodes from the packets received by the node.
+func startDHT(cfg Config) (*dht.DHT, *dhtstore.Client, error) {
+	dhtConfig := dht.NewConfig()
+	dhtConfig.Address = cfg.DHTHost
+	dhtConfig.Port = int(cfg.DHTPort)
+	dhtConfig.DHTRouters = strings.Join(cfg.DHTBootstrapNodes, ",")
+	dhtConfig.SaveRoutingTable = false
+	dhtConfig.NumTargetPeers = 0
+	node, err := dht.New(dhtConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	var id [20]byte
+	copy(id[:], node.ID())
+	store := dhtstore.New(node, id, cfg.DHTBootstrapNodes, dhtStoreQueryTimeout)
+	node.PacketHandler = func(b []byte, addr net.UDPAddr) bool {
+		return store.HandlePacket(b, &addr)
+	}
+	err = node.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+	return node, store, nil
+}
+
 func (s *Session) processDHTResults() {
 	dhtLimiter := time.NewTicker(time.Second)
 	defer dhtLimiter.Stop()
@@ -60,3 +98,68 @@ func parseDHTPeers(peers []string) []*net.TCPAddr {
 	}
 	return addrs
 }
+
+// DHTPutImmutable stores the bencoded value in DHT (BEP 44) and returns the key of the item.
+func (s *Session) DHTPutImmutable(v []byte) ([20]byte, error) {
+	var target [20]byte
+	if s.dhtStore == nil {
+		return target, errDHTDisabled
+	}
+	item, err := dhtstore.NewImmutable(v)
+	if err != nil {
+		return target, newInputError(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DHTStoreTimeout)
+	defer cancel()
+	_, err = s.dhtStore.Put(ctx, item)
+	return item.Target(), err
+}
+
+// DHTGetImmutable returns the bencoded value of the immutable item with the key from DHT (BEP 44).
+func (s *Session) DHTGetImmutable(target [20]byte) ([]byte, error) {
+	if s.dhtStore == nil {
+		return nil, errDHTDisabled
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DHTStoreTimeout)
+	defer cancel()
+	item, err := s.dhtStore.GetImmutable(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return item.V, nil
+}
+
+// DHTPutMutable stores the bencoded value in DHT that is signed with the key (BEP 44).
+// seq must be greater than the sequence number of the value that is stored before with the same key and salt.
+func (s *Session) DHTPutMutable(key ed25519.PrivateKey, salt []byte, seq int64, v []byte) error {
+	if s.dhtStore == nil {
+		return errDHTDisabled
+	}
+	item, err := dhtstore.NewMutable(key, salt, seq, v)
+	if err != nil {
+		return newInputError(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DHTStoreTimeout)
+	defer cancel()
+	_, err = s.dhtStore.Put(ctx, item)
+	return err
+}
+
+// DHTGetMutable returns the latest bencoded value that is signed with the public key and its sequence number from DHT (BEP 44).
+func (s *Session) DHTGetMutable(publicKey ed25519.PublicKey, salt []byte) (v []byte, seq int64, err error) {
+	if s.dhtStore == nil {
+		return nil, 0, errDHTDisabled
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, 0, newInputError(errors.New("invalid public key"))
+	}
+	var k [32]byte
+	copy(k[:], publicKey)
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.DHTStoreTimeout)
+	defer cancel()
+	item, err := s.dhtStore.GetMutable(ctx, k, salt)
+	if err != nil {
+		return nil, 0, err
+	}
+	return item.V, item.Seq, nil
+}
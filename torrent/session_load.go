@@ -5,10 +5,9 @@ import (
 
 	"github.com/ganqierwu/rain/internal/bitfield"
 	"github.com/ganqierwu/rain/internal/metainfo"
-	"github.com/ganqierwu/rain/internal/resumer"
-	"github.com/ganqierwu/rain/internal/resumer/boltdbresumer"
-	"github.com/ganqierwu/rain/internal/storage/filestorage"
 	"github.com/ganqierwu/rain/internal/webseedsource"
+	"github.com/ganqierwu/rain/resumer"
+	"github.com/ganqierwu/rain/resumer/boltdbresumer"
 	"go.etcd.io/bbolt"
 )
 
@@ -73,7 +72,7 @@ func (s *Session) loadExistingTorrent(id string) (tt *Torrent, hasStarted bool,
 			bf = bf3
 		}
 	}
-	sto, err := filestorage.New(s.getDataDir(id))
+	sto, err := s.newStorage(id, spec.Dest)
 	if err != nil {
 		return
 	}
@@ -95,7 +94,7 @@ func (s *Session) loadExistingTorrent(id string) (tt *Torrent, hasStarted bool,
 			BytesWasted:     spec.BytesWasted,
 			SeededFor:       int64(spec.SeededFor),
 		},
-		webseedsource.NewList(spec.URLList),
+		webseedsource.NewList(spec.URLList, spec.HTTPSeeds),
 		spec.StopAfterDownload,
 		spec.StopAfterMetadata,
 		spec.CompleteCmdRun,
@@ -105,6 +104,25 @@ func (s *Session) loadExistingTorrent(id string) (tt *Torrent, hasStarted bool,
 	}
 	t.rawTrackers = spec.Trackers
 	t.rawWebseedSources = spec.URLList
+	t.rawHTTPSeeds = spec.HTTPSeeds
+	t.dest = spec.Dest
+	t.keepData = spec.KeepData
+	t.destOwned = spec.DestOwned
+	t.previousVersion = spec.PreviousVersion
+	t.limitDownload.SetLimit(spec.SpeedLimitDownload * 1024)
+	t.limitUpload.SetLimit(spec.SpeedLimitUpload * 1024)
+	t.superSeed = spec.SuperSeed
+	t.signer = spec.Signer
+	t.mutableLink = spec.MutableLink
+	if info != nil && len(spec.FilePriorities) == len(info.Files) {
+		t.filePriorities = make([]FilePriority, len(spec.FilePriorities))
+		for i, p := range spec.FilePriorities {
+			t.filePriorities[i] = FilePriority(p)
+		}
+	}
+	if info != nil {
+		t.setPreviousFilePriorities()
+	}
 	go s.checkTorrent(t)
 	delete(s.availablePorts, spec.Port)
 
@@ -115,24 +133,17 @@ func (s *Session) loadExistingTorrent(id string) (tt *Torrent, hasStarted bool,
 // CleanDatabase removes invalid records in the database.
 // Normally you don't need to call this.
 func (s *Session) CleanDatabase() error {
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(torrentsBucket)
-		for _, id := range s.invalidTorrentIDs {
-			err := b.DeleteBucket([]byte(id))
-			if err != nil {
-				return err
-			}
+	for _, id := range s.invalidTorrentIDs {
+		err := s.resumer.Delete(id)
+		if err != nil {
+			return err
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 	s.invalidTorrentIDs = nil
 	return nil
 }
 
-// CompactDatabase rewrites the database using existing torrent records to a new file.
+// CompactDatabase rewrites the resume data of existing torrents to a new Bolt database file.
 // Normally you don't need to call this.
 func (s *Session) CompactDatabase(output string) error {
 	db, err := bbolt.Open(output, 0600, nil)
@@ -152,18 +163,29 @@ func (s *Session) CompactDatabase(output string) error {
 		return err
 	}
 	for _, t := range s.torrents {
-		spec := &boltdbresumer.Spec{
-			InfoHash:          t.torrent.InfoHash(),
-			Port:              t.torrent.port,
-			Name:              t.torrent.name,
-			Trackers:          t.torrent.rawTrackers,
-			URLList:           t.torrent.rawWebseedSources,
-			FixedPeers:        t.torrent.fixedPeers,
-			Info:              t.torrent.info.Bytes,
-			AddedAt:           t.torrent.addedAt,
-			StopAfterDownload: t.torrent.stopAfterDownload,
-			StopAfterMetadata: t.torrent.stopAfterMetadata,
+		spec := &resumer.Spec{
+			InfoHash:           t.torrent.InfoHash(),
+			Port:               t.torrent.port,
+			Name:               t.torrent.name,
+			Trackers:           t.torrent.rawTrackers,
+			URLList:            t.torrent.rawWebseedSources,
+			HTTPSeeds:          t.torrent.rawHTTPSeeds,
+			FixedPeers:         t.torrent.fixedPeers,
+			Dest:               t.torrent.dest,
+			KeepData:           t.torrent.keepData,
+			FilePriorities:     t.torrent.rawFilePriorities(),
+			Info:               t.torrent.info.Bytes,
+			AddedAt:            t.torrent.addedAt,
+			StopAfterDownload:  t.torrent.stopAfterDownload,
+			StopAfterMetadata:  t.torrent.stopAfterMetadata,
+			SpeedLimitDownload: t.torrent.limitDownload.Limit() / 1024,
+			SpeedLimitUpload:   t.torrent.limitUpload.Limit() / 1024,
+			SuperSeed:          t.torrent.superSeed,
+			Signer:             t.torrent.signer,
+			MutableLink:        t.torrent.mutableLink,
 		}
+		spec.DestOwned = t.torrent.destOwned
+		spec.PreviousVersion = t.torrent.previousVersion
 		err = res.Write(t.torrent.id, spec)
 		if err != nil {
 			return err
@@ -0,0 +1,49 @@
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFromResumeDir(t *testing.T) {
+	tmp := t.TempDir()
+	cfg := DefaultConfig
+	cfg.Database = ""
+	cfg.ResumeDir = filepath.Join(tmp, "resume")
+	cfg.DataDir = filepath.Join(tmp, "data")
+	cfg.DHTEnabled = false
+	cfg.PEXEnabled = false
+	cfg.RPCEnabled = false
+	s, err := NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tor, err := s.AddTorrent(f, &AddTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	torrents := s.ListTorrents()
+	if len(torrents) != 1 {
+		t.Fatalf("expected 1 torrent, got %d", len(torrents))
+	}
+	assert.Equal(t, tor.ID(), torrents[0].ID())
+	assert.Equal(t, tor.InfoHash(), torrents[0].InfoHash())
+}
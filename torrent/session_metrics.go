@@ -16,6 +16,7 @@ type sessionMetrics struct {
 	Uptime                metrics.Gauge
 	BlockListRules        metrics.Gauge
 	BlockListRecency      metrics.Gauge
+	BannedIPs             metrics.Gauge
 	ReadCacheObjects      metrics.Gauge
 	ReadCacheSize         metrics.Gauge
 	ReadCacheUtilization  metrics.Gauge
@@ -28,6 +29,9 @@ type sessionMetrics struct {
 	WritesPerSecond       metrics.Meter
 	WritesActive          metrics.Gauge
 	WritesPending         metrics.Gauge
+	WriteQueueBytes       metrics.Gauge
+	WriteLatency          metrics.Timer
+	SyncLatency           metrics.Timer
 	SpeedDownload         metrics.Meter
 	SpeedUpload           metrics.Meter
 	SpeedRead             metrics.Meter
@@ -63,6 +67,12 @@ func (s *Session) initMetrics() {
 			return int64(time.Since(s.blocklistTimestamp) / time.Second)
 		}),
 
+		BannedIPs: metrics.NewRegisteredFunctionalGauge("banned_ips", r, func() int64 {
+			s.mBannedIPs.RLock()
+			defer s.mBannedIPs.RUnlock()
+			return int64(len(s.bannedIPs))
+		}),
+
 		ReadCacheObjects:     metrics.NewRegisteredFunctionalGauge("read_cache_objects", r, func() int64 { return int64(s.pieceCache.Len()) }),
 		ReadCacheSize:        metrics.NewRegisteredFunctionalGauge("read_cache_size", r, func() int64 { return s.pieceCache.Size() }),
 		ReadCacheUtilization: metrics.NewRegisteredFunctionalGauge("read_cache_utilization", r, func() int64 { return int64(s.pieceCache.Utilization()) }),
@@ -78,6 +88,9 @@ func (s *Session) initMetrics() {
 		WritesPerSecond: metrics.NewRegisteredMeter("writes_per_second", r),
 		WritesActive:    metrics.NewRegisteredFunctionalGauge("writes_active", r, func() int64 { return int64(s.semWrite.Len()) }),
 		WritesPending:   metrics.NewRegisteredFunctionalGauge("writes_pending", r, func() int64 { return int64(s.semWrite.Waiting()) }),
+		WriteQueueBytes: metrics.NewRegisteredFunctionalGauge("write_queue_bytes", r, func() int64 { return s.writeQueue.Size() }),
+		WriteLatency:    metrics.NewRegisteredTimer("write_latency", r),
+		SyncLatency:     metrics.NewRegisteredTimer("sync_latency", r),
 
 		SpeedDownload: metrics.NewRegisteredMeter("speed_download", r),
 		SpeedUpload:   metrics.NewRegisteredMeter("speed_upload", r),
@@ -93,4 +106,6 @@ func (m *sessionMetrics) Close() {
 	m.SpeedDownload.Stop()
 	m.SpeedUpload.Stop()
 	m.SpeedWrite.Stop()
+	m.WriteLatency.Stop()
+	m.SyncLatency.Stop()
 }
@@ -0,0 +1,796 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/allocator"
+	"github.com/ganqierwu/rain/internal/bitfield"
+	"github.com/ganqierwu/rain/internal/filesection"
+	"github.com/ganqierwu/rain/internal/logger"
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/ganqierwu/rain/internal/piece"
+	"github.com/ganqierwu/rain/resumer"
+	"github.com/ganqierwu/rain/storage"
+)
+
+// Moving a torrent to another Session works as follows:
+//  1. Source stops the torrent and sends the resume spec with a random token and the port of its RPC server
+//     to the target with a POST request to /move-torrent.
+//  2. Target opens the files in its data dir. Files left from a previous attempt are verified.
+//  3. Target downloads the missing pieces from the source in chunks with GET requests to /move-torrent/data,
+//     starting from the first missing piece. Both RPC servers must be reachable from each other.
+//     Each piece is verified against the hash in metainfo before it is written.
+//     If a request fails, the next request starts from the first missing piece in the bitfield.
+//  4. Source polls the status of the move from the target with a GET request to /move-torrent.
+//     If the target is restarted or loses the state of the move, source sends the POST request again
+//     and target continues from the pieces it has already written.
+//  5. After all pieces are received, target adds the torrent and reports that the move is done.
+//     Source removes the torrent and its data only after this point.
+//
+// Target forgets the move after the result is reported to the source,
+// or if the source does not send any request for moveIdleTimeout.
+// Both sides log the progress of the move every moveProgressInterval.
+const (
+	moveChunkSize   = 16 << 20
+	moveMaxRetries  = 10
+	moveHTTPTimeout = time.Minute
+)
+
+// Intervals are variables so they can be shortened in tests.
+var (
+	movePollInterval     = time.Second
+	moveIdleTimeout      = 10 * time.Minute
+	moveProgressInterval = 10 * time.Second
+)
+
+// Status values of a move on the target Session.
+const (
+	moveRunning = "Running"
+	moveDone    = "Done"
+	moveFailed  = "Failed"
+)
+
+// moveTorrentRequest is sent from the source Session to the target Session to start or resume a move.
+type moveTorrentRequest struct {
+	ID    string
+	Token string
+	Spec  *resumer.Spec
+	// RPC port of the source Session.
+	// Target downloads the data from this port at the address that the request is received from.
+	Port int
+}
+
+// moveTorrentStatus is returned from the target Session to report the progress of a move.
+type moveTorrentStatus struct {
+	Status   string
+	Error    string
+	Received int64
+	Total    int64
+}
+
+// outgoingMove serves the files of a torrent that is being moved to another Session.
+type outgoingMove struct {
+	id    string
+	token string
+	info  *metainfo.Info
+	log   logger.Logger
+	// Number of bytes that are sent to the target. Accessed atomically.
+	sent int64
+
+	// Files are read by concurrent requests. Write lock is held while closing them.
+	m      sync.RWMutex
+	files  []allocator.File
+	pieces []piece.Piece
+}
+
+// incomingMove receives the files of a torrent that is being moved from another Session.
+type incomingMove struct {
+	id    string
+	token string
+	spec  *resumer.Spec
+	// URL of the RPC server of the source Session that the data is downloaded from.
+	source string
+	log    logger.Logger
+	// Removes the move if the source does not send any request for a while.
+	timer *time.Timer
+	// Canceled when the move is closed. Stops the download from the source.
+	ctx    context.Context
+	cancel context.CancelFunc
+	doneC  chan struct{}
+
+	// Protects the fields below, which are read by the requests of the source.
+	// Files and pieces are used only by the goroutine that downloads the data, without holding the lock.
+	m sync.Mutex
+	// Pieces that are received and verified. Nil until the existing files are verified.
+	bitfield *bitfield.Bitfield
+	received int64
+	total    int64
+	status   string
+	err      error
+}
+
+// Move torrent to another Session.
+// target must be the RPC server address of the other Session in host:port form.
+// The target Session downloads the files from the RPC server of this Session, verifies and saves them into the data dir chosen by its config.
+// Move blocks until the target confirms that the torrent is loaded, then the torrent is removed from this Session.
+// If the move fails, the files are kept and the torrent is started again if it was running.
+func (t *Torrent) Move(target string) error {
+	s := t.torrent.session
+	if t.DataDir() == "" {
+		return errors.New("torrent storage is not on disk")
+	}
+	if s.rpc == nil {
+		return errors.New("rpc server must be enabled for moving torrents")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	t.torrent.Stop()
+	spec, err := s.resumer.Read(t.torrent.id)
+	if err != nil {
+		return err
+	}
+	token, err := newMoveToken()
+	if err != nil {
+		return err
+	}
+	om := &outgoingMove{
+		id:    t.torrent.id,
+		token: token,
+		log:   t.torrent.log,
+	}
+	if len(spec.Info) > 0 {
+		om.info, err = metainfo.NewInfo(spec.Info)
+		if err != nil {
+			return err
+		}
+		t.torrent.mStorage.RLock()
+		sto := t.torrent.storage
+		t.torrent.mStorage.RUnlock()
+		om.files, _, err = openFiles(om.info, sto)
+		if err != nil {
+			return err
+		}
+		om.pieces = piece.NewPieces(om.info, om.files)
+	}
+	s.addOutgoingMove(om)
+	defer s.removeOutgoingMove(om)
+	req := &moveTorrentRequest{
+		ID:    t.torrent.id,
+		Token: token,
+		Spec:  spec,
+		Port:  s.config.RPCPort,
+	}
+	t.torrent.log.Infof("moving torrent to %s", target)
+	err = s.runOutgoingMove(target, req, om)
+	if err != nil {
+		t.torrent.log.Errorf("cannot move torrent: %s", err)
+		if spec.Started {
+			t.torrent.Start()
+		}
+		return err
+	}
+	t.torrent.log.Infof("torrent is moved to %s, sent %d bytes", target, atomic.LoadInt64(&om.sent))
+	return s.RemoveTorrent(t.torrent.id)
+}
+
+func newMoveToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Session) addOutgoingMove(om *outgoingMove) {
+	s.mMoves.Lock()
+	s.outgoingMoves[om.token] = om
+	s.mMoves.Unlock()
+}
+
+func (s *Session) removeOutgoingMove(om *outgoingMove) {
+	s.mMoves.Lock()
+	delete(s.outgoingMoves, om.token)
+	s.mMoves.Unlock()
+	om.close()
+}
+
+// getOutgoingMove returns the move of the torrent if the token matches.
+func (s *Session) getOutgoingMove(id, token string) *outgoingMove {
+	s.mMoves.Lock()
+	defer s.mMoves.Unlock()
+	om, ok := s.outgoingMoves[token]
+	if !ok || om.id != id {
+		return nil
+	}
+	return om
+}
+
+// runOutgoingMove starts the move on the target and waits until it is done.
+func (s *Session) runOutgoingMove(target string, req *moveTorrentRequest, om *outgoingMove) error {
+	client := http.Client{Timeout: moveHTTPTimeout}
+	var failures int
+	var lastProgress time.Time
+	send := true
+	for {
+		var err error
+		if send {
+			err = postMoveRequest(&client, target, req)
+			if err == nil {
+				send = false
+			}
+		} else {
+			var st *moveTorrentStatus
+			st, err = getMoveStatus(&client, target, req.ID, req.Token)
+			if err == nil {
+				switch st.Status {
+				case moveDone:
+					return nil
+				case moveFailed:
+					return errors.New(st.Error)
+				}
+				failures = 0
+				if time.Since(lastProgress) >= moveProgressInterval {
+					lastProgress = time.Now()
+					om.log.Infof("move progress: target has %d of %d bytes, sent %d bytes", st.Received, st.Total, atomic.LoadInt64(&om.sent))
+				}
+			} else {
+				// Target may be restarted or failed. Send the request again to resume the move.
+				send = true
+			}
+		}
+		if err != nil {
+			failures++
+			if failures > moveMaxRetries {
+				return err
+			}
+			om.log.Warningf("move error (retry %d/%d): %s", failures, moveMaxRetries, err)
+		}
+		select {
+		case <-time.After(movePollInterval):
+		case <-s.closeC:
+			return errors.New("session is closed")
+		}
+	}
+}
+
+func postMoveRequest(client *http.Client, target string, req *moveTorrentRequest) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(target+"/move-torrent", "application/json", bytes.NewReader(b)) // nolint: noctx
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return httpError(resp)
+	}
+	return nil
+}
+
+func getMoveStatus(client *http.Client, target, id, token string) (*moveTorrentStatus, error) {
+	q := url.Values{"id": {id}, "token": {token}}
+	resp, err := client.Get(target + "/move-torrent?" + q.Encode()) // nolint: noctx
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, httpError(resp)
+	}
+	var st moveTorrentStatus
+	err = json.NewDecoder(resp.Body).Decode(&st)
+	return &st, err
+}
+
+func httpError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("http error: %d %s", resp.StatusCode, strings.TrimSpace(string(b)))
+}
+
+// openFiles opens all files of a torrent in sto.
+// hasExisting is true if any of the files was existing before.
+func openFiles(info *metainfo.Info, sto storage.Storage) (files []allocator.File, hasExisting bool, err error) {
+	files = make([]allocator.File, 0, len(info.Files))
+	for _, f := range info.Files {
+		if allocator.NotOnDisk(f) {
+			files = append(files, allocator.File{Storage: filesection.Zeros{}, Name: f.Path})
+			continue
+		}
+		sf, exists, err := sto.Open(f.Path, f.Length)
+		if err != nil {
+			closeFiles(files)
+			return nil, false, err
+		}
+		files = append(files, allocator.File{Storage: sf, Name: f.Path})
+		hasExisting = hasExisting || exists
+	}
+	return files, hasExisting, nil
+}
+
+func closeFiles(files []allocator.File) {
+	for _, f := range files {
+		_ = f.Storage.Close()
+	}
+}
+
+// serve writes the pieces in the range requested by the target to w.
+func (om *outgoingMove) serve(w http.ResponseWriter, offset, length int64) {
+	om.m.RLock()
+	defer om.m.RUnlock()
+	if om.pieces == nil {
+		http.Error(w, "torrent has no data", http.StatusBadRequest)
+		return
+	}
+	pieceLength := int64(om.info.PieceLength)
+	end := offset + length
+	maxLength := int64(moveChunkSize)
+	if pieceLength > maxLength {
+		maxLength = pieceLength
+	}
+	if offset < 0 || offset%pieceLength != 0 || length <= 0 || length > maxLength || end > om.info.Length || (end%pieceLength != 0 && end != om.info.Length) {
+		http.Error(w, "invalid range", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusOK)
+	buf := make([]byte, pieceLength)
+	for i := offset / pieceLength; i*pieceLength < end; i++ {
+		p := &om.pieces[i]
+		data := buf[:p.Length]
+		_, err := p.Data.ReadAt(data, 0)
+		if err != nil {
+			// Target sees a short response and requests the missing pieces again.
+			om.log.Errorln("cannot read piece for move:", err)
+			return
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&om.sent, int64(p.Length))
+	}
+}
+
+func (om *outgoingMove) close() {
+	om.m.Lock()
+	defer om.m.Unlock()
+	closeFiles(om.files)
+	om.files = nil
+	om.pieces = nil
+}
+
+// nextMoveChunk returns the range of consecutive missing pieces to request in a single request.
+func nextMoveChunk(pieces []piece.Piece, bf *bitfield.Bitfield) (first, last uint32) {
+	n := uint32(len(pieces))
+	for first = 0; first < n && bf.Test(first); first++ {
+	}
+	var size int64
+	for last = first; last < n && !bf.Test(last); last++ {
+		if size > 0 && size+int64(pieces[last].Length) > moveChunkSize {
+			break
+		}
+		size += int64(pieces[last].Length)
+	}
+	return
+}
+
+func (m *incomingMove) getStatus() *moveTorrentStatus {
+	m.m.Lock()
+	defer m.m.Unlock()
+	st := &moveTorrentStatus{
+		Status:   m.status,
+		Received: m.received,
+		Total:    m.total,
+	}
+	if m.err != nil {
+		st.Error = m.err.Error()
+	}
+	return st
+}
+
+// close stops the download from the source and waits until the files are released.
+// A running move is failed.
+func (m *incomingMove) close() {
+	m.timer.Stop()
+	m.cancel()
+	<-m.doneC
+}
+
+// startIncomingMove starts receiving the torrent from the source Session at the source URL.
+// If the same move is already running, nothing is done.
+func (s *Session) startIncomingMove(req *moveTorrentRequest, source string) error {
+	s.mMoves.Lock()
+	defer s.mMoves.Unlock()
+	select {
+	case <-s.closeC:
+		return errors.New("session is closed")
+	default:
+	}
+	m, ok := s.incomingMoves[req.ID]
+	if ok && m.token == req.Token {
+		m.timer.Reset(moveIdleTimeout)
+		return nil
+	}
+	if ok {
+		if m.getStatus().Status == moveRunning {
+			return errors.New("torrent is already being moved")
+		}
+		delete(s.incomingMoves, req.ID)
+		m.close()
+	}
+	if s.GetTorrent(req.ID) != nil {
+		s.log.Warningln("duplicate torrent id, removing existing one:", req.ID)
+		t, err := s.removeTorrentFromClient(req.ID)
+		if err != nil {
+			return err
+		}
+		err = s.stopAndRemoveData(t)
+		if err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m = &incomingMove{
+		id:     req.ID,
+		token:  req.Token,
+		spec:   req.Spec,
+		source: source,
+		log:    logger.New("move " + req.ID),
+		ctx:    ctx,
+		cancel: cancel,
+		doneC:  make(chan struct{}),
+		status: moveRunning,
+	}
+	m.timer = time.AfterFunc(moveIdleTimeout, func() {
+		m.log.Warning("source is not responding, removing move")
+		s.removeIncomingMove(m)
+	})
+	s.incomingMoves[req.ID] = m
+	go s.runIncomingMove(m)
+	return nil
+}
+
+// getIncomingMove returns the move if the token matches and resets its idle timer.
+func (s *Session) getIncomingMove(id, token string) *incomingMove {
+	s.mMoves.Lock()
+	defer s.mMoves.Unlock()
+	m, ok := s.incomingMoves[id]
+	if !ok || m.token != token {
+		return nil
+	}
+	m.timer.Reset(moveIdleTimeout)
+	return m
+}
+
+func (s *Session) removeIncomingMove(m *incomingMove) {
+	s.mMoves.Lock()
+	if s.incomingMoves[m.id] == m {
+		delete(s.incomingMoves, m.id)
+	}
+	s.mMoves.Unlock()
+	m.close()
+}
+
+func (s *Session) closeIncomingMoves() {
+	s.mMoves.Lock()
+	moves := s.incomingMoves
+	s.incomingMoves = make(map[string]*incomingMove)
+	s.mMoves.Unlock()
+	for _, m := range moves {
+		m.close()
+	}
+}
+
+// runIncomingMove receives the torrent and sets the result of the move.
+func (s *Session) runIncomingMove(m *incomingMove) {
+	defer close(m.doneC)
+	err := s.receiveMove(m)
+	m.m.Lock()
+	defer m.m.Unlock()
+	if err != nil {
+		if m.ctx.Err() != nil {
+			err = errors.New("move is closed")
+		}
+		m.log.Errorf("cannot move torrent: %s", err)
+		m.status, m.err = moveFailed, err
+		return
+	}
+	m.log.Info("torrent is moved")
+	m.status = moveDone
+}
+
+// receiveMove opens the files in the data dir, downloads the missing pieces from the source and loads the torrent.
+func (s *Session) receiveMove(m *incomingMove) error {
+	if len(m.spec.Info) == 0 {
+		// Magnet download without metadata. There is no data to move.
+		return s.loadMovedTorrent(m, nil)
+	}
+	info, err := s.parseInfo(m.spec.Info)
+	if err != nil {
+		return err
+	}
+	sto, err := s.newStorage(m.id, "")
+	if err != nil {
+		return err
+	}
+	files, hasExisting, err := openFiles(info, sto)
+	if err != nil {
+		return err
+	}
+	pieces := piece.NewPieces(info, files)
+	bf, err := s.verifyMovedPieces(m, pieces, info.PieceLength, hasExisting)
+	if err != nil {
+		closeFiles(files)
+		return err
+	}
+	var received int64
+	for i := range pieces {
+		if bf.Test(pieces[i].Index) {
+			received += int64(pieces[i].Length)
+		}
+	}
+	m.m.Lock()
+	m.bitfield = bf
+	m.received = received
+	m.total = info.Length
+	m.m.Unlock()
+	m.log.Infof("receiving data from %s, %d of %d bytes exist", m.source, received, info.Length)
+	err = s.pullMoveData(m, pieces, info.PieceLength)
+	closeFiles(files)
+	if err != nil {
+		return err
+	}
+	if m.ctx.Err() != nil {
+		return m.ctx.Err()
+	}
+	return s.loadMovedTorrent(m, bf)
+}
+
+// verifyMovedPieces returns the bitfield of the pieces that are left from a previous attempt interrupted by restart of this Session.
+func (s *Session) verifyMovedPieces(m *incomingMove, pieces []piece.Piece, pieceLength uint32, hasExisting bool) (*bitfield.Bitfield, error) {
+	bf := bitfield.New(uint32(len(pieces)))
+	if !hasExisting {
+		return bf, nil
+	}
+	m.log.Info("verifying existing files")
+	buf := make([]byte, pieceLength)
+	hash := sha1.New()
+	for i := range pieces {
+		if m.ctx.Err() != nil {
+			return nil, m.ctx.Err()
+		}
+		p := &pieces[i]
+		_, err := p.Data.ReadAt(buf[:p.Length], 0)
+		if err != nil {
+			return nil, err
+		}
+		if p.VerifyHash(buf[:p.Length], hash) {
+			bf.Set(p.Index)
+		}
+		hash.Reset()
+	}
+	return bf, nil
+}
+
+// pullMoveData requests the missing pieces from the source until all pieces are received.
+// Each request starts from the first missing piece in the bitfield, so the download continues from where it is left after an error.
+func (s *Session) pullMoveData(m *incomingMove, pieces []piece.Piece, pieceLength uint32) error {
+	client := http.Client{Timeout: moveHTTPTimeout}
+	buf := make([]byte, pieceLength)
+	hash := sha1.New()
+	var failures int
+	lastProgress := time.Now()
+	for {
+		m.m.Lock()
+		first, last := nextMoveChunk(pieces, m.bitfield)
+		m.m.Unlock()
+		if first == last {
+			return nil
+		}
+		n, err := m.downloadChunk(&client, pieces[first:last], pieceLength, buf, hash)
+		if n > 0 {
+			failures = 0
+		}
+		if err != nil {
+			if m.ctx.Err() != nil {
+				return m.ctx.Err()
+			}
+			failures++
+			if failures > moveMaxRetries {
+				return err
+			}
+			m.log.Warningf("cannot receive move data (retry %d/%d): %s", failures, moveMaxRetries, err)
+			select {
+			case <-time.After(movePollInterval):
+			case <-m.ctx.Done():
+				return m.ctx.Err()
+			}
+		}
+		if time.Since(lastProgress) >= moveProgressInterval {
+			lastProgress = time.Now()
+			st := m.getStatus()
+			m.log.Infof("move progress: received %d of %d bytes", st.Received, st.Total)
+		}
+	}
+}
+
+// downloadChunk requests the consecutive pieces from the source, verifies and writes them.
+// Returns the number of pieces written, which may be less than requested if the response is cut.
+func (m *incomingMove) downloadChunk(client *http.Client, pieces []piece.Piece, pieceLength uint32, buf []byte, h hash.Hash) (n int, err error) {
+	var length int64
+	for i := range pieces {
+		length += int64(pieces[i].Length)
+	}
+	q := url.Values{
+		"id":     {m.id},
+		"token":  {m.token},
+		"offset": {strconv.FormatInt(int64(pieces[0].Index)*int64(pieceLength), 10)},
+		"length": {strconv.FormatInt(length, 10)},
+	}
+	req, err := http.NewRequestWithContext(m.ctx, http.MethodGet, m.source+"/move-torrent/data?"+q.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, httpError(resp)
+	}
+	for i := range pieces {
+		p := &pieces[i]
+		data := buf[:p.Length]
+		_, err = io.ReadFull(resp.Body, data)
+		if err != nil {
+			return n, err
+		}
+		ok := p.VerifyHash(data, h)
+		h.Reset()
+		if !ok {
+			return n, fmt.Errorf("received piece #%d does not match hash", p.Index)
+		}
+		_, err = p.Data.Write(data)
+		if err != nil {
+			return n, err
+		}
+		m.m.Lock()
+		m.bitfield.Set(p.Index)
+		m.received += int64(p.Length)
+		m.m.Unlock()
+		n++
+	}
+	return n, nil
+}
+
+// loadMovedTorrent saves the resume spec of the received torrent and adds it to the Session.
+// bf is the bitfield of the received pieces. It is nil if the torrent has no metadata.
+func (s *Session) loadMovedTorrent(m *incomingMove, bf *bitfield.Bitfield) error {
+	port, err := s.getPort()
+	if err != nil {
+		return err
+	}
+	spec := *m.spec
+	spec.Port = port
+	// Data dir of the source Session is not meaningful here.
+	// Files are created by this Session, so they are deleted when the torrent is removed.
+	spec.Dest = ""
+	spec.KeepData = false
+	spec.DestOwned = false
+	// Files of the previous version are on the source host.
+	spec.PreviousVersion = nil
+	if bf != nil && bf.Len() > 0 {
+		// All pieces are verified while they are received.
+		spec.Bitfield = bf.Bytes()
+	}
+	err = s.resumer.Write(m.id, &spec)
+	if err != nil {
+		s.releasePort(port)
+		return err
+	}
+	t, started, err := s.loadExistingTorrent(m.id)
+	if err != nil {
+		s.releasePort(port)
+		_ = s.resumer.Delete(m.id)
+		return err
+	}
+	if started {
+		return t.Start()
+	}
+	return nil
+}
+
+// handleMoveTorrent handles the requests of the source Session on the target Session.
+// POST starts or resumes a move, GET returns the status of the move.
+func (h *rpcHandler) handleMoveTorrent(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req moveTorrentRequest
+		err := json.NewDecoder(io.LimitReader(r.Body, int64(h.session.config.MaxTorrentSize)*2)).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.ID == "" || req.Token == "" || req.Spec == nil || req.Port <= 0 {
+			http.Error(w, "id, token, spec and port are required", http.StatusBadRequest)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		source := "http://" + net.JoinHostPort(host, strconv.Itoa(req.Port))
+		err = h.session.startIncomingMove(&req, source)
+		if err != nil {
+			h.session.log.Error(err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodGet:
+		q := r.URL.Query()
+		m := h.session.getIncomingMove(q.Get("id"), q.Get("token"))
+		if m == nil {
+			http.Error(w, "move not found", http.StatusNotFound)
+			return
+		}
+		st := m.getStatus()
+		if st.Status != moveRunning {
+			// The move is removed after its result is sent.
+			h.session.removeIncomingMove(m)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(st)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleMoveTorrentData sends the data of a torrent that is being moved to the target Session.
+func (h *rpcHandler) handleMoveTorrentData(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	om := h.session.getOutgoingMove(q.Get("id"), q.Get("token"))
+	if om == nil {
+		http.Error(w, "move not found", http.StatusNotFound)
+		return
+	}
+	offset, err := strconv.ParseInt(q.Get("offset"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(q.Get("length"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	om.serve(w, offset, length)
+}
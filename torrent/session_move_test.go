@@ -0,0 +1,205 @@
+package torrent
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/logger"
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/ganqierwu/rain/resumer"
+	"github.com/stretchr/testify/assert"
+)
+
+// freeTestPort returns a port that is free at the time of the call.
+func freeTestPort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func newMoveTestSession(t *testing.T) *Session {
+	peerPort := uint16(freeTestPort(t))
+	tmp := t.TempDir()
+	cfg := DefaultConfig
+	cfg.Database = filepath.Join(tmp, "session.db")
+	cfg.DataDir = filepath.Join(tmp, "data")
+	cfg.DHTEnabled = false
+	cfg.PEXEnabled = false
+	cfg.RPCPort = freeTestPort(t)
+	cfg.PortBegin = peerPort
+	cfg.PortEnd = peerPort + 1
+	s, err := NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestMoveTorrent(t *testing.T) {
+	src := newMoveTestSession(t)
+	dst := newMoveTestSession(t)
+
+	content := bytes.Repeat([]byte("rain"), 100<<10)
+	path := filepath.Join(t.TempDir(), "content")
+	err := os.WriteFile(path, content, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, _, err := src.CreateTorrent([]string{path}, &CreateTorrentOptions{PieceLength: 32 << 10, Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := tor.ID()
+
+	err = tor.Move("127.0.0.1:" + strconv.Itoa(dst.config.RPCPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, src.GetTorrent(id))
+	moved := dst.GetTorrent(id)
+	if moved == nil {
+		t.Fatal("torrent is not moved")
+	}
+	b, err := os.ReadFile(filepath.Join(dst.getDataDir(id), "content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, bytes.Equal(content, b))
+	spec, err := dst.resumer.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "", spec.Dest)
+	assert.NotEmpty(t, spec.Bitfield)
+	assert.Empty(t, dst.incomingMoves)
+}
+
+func TestMoveTorrentIdleTimeout(t *testing.T) {
+	dst := newMoveTestSession(t)
+	moveIdleTimeout = 10 * time.Millisecond
+	defer func() { moveIdleTimeout = 10 * time.Minute }()
+
+	path := filepath.Join(t.TempDir(), "content")
+	err := os.WriteFile(path, bytes.Repeat([]byte("rain"), 100<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err := metainfo.NewInfoBytes("", []string{path}, false, 32<<10, "", false, nil, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is listening on the port of the source.
+	req := &moveTorrentRequest{ID: "abc", Token: "token", Spec: &resumer.Spec{Info: mi}, Port: freeTestPort(t)}
+	client := http.Client{Timeout: timeout}
+	target := "http://127.0.0.1:" + strconv.Itoa(dst.config.RPCPort)
+	err = postMoveRequest(&client, target, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Move is removed because the source does not poll the status.
+	time.Sleep(100 * time.Millisecond)
+	dst.mMoves.Lock()
+	assert.Empty(t, dst.incomingMoves)
+	dst.mMoves.Unlock()
+	_, err = getMoveStatus(&client, target, req.ID, req.Token)
+	assert.Error(t, err)
+}
+
+func TestMoveTorrentResume(t *testing.T) {
+	dst := newMoveTestSession(t)
+	movePollInterval = time.Millisecond
+	defer func() { movePollInterval = time.Second }()
+
+	content := bytes.Repeat([]byte("rain"), 100<<10)
+	path := filepath.Join(t.TempDir(), "content")
+	err := os.WriteFile(path, content, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err := metainfo.NewInfoBytes("", []string{path}, false, 32<<10, "", false, nil, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Source cuts the first response after the first piece.
+	var mOffsets sync.Mutex
+	var offsets []int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+		length, _ := strconv.ParseInt(r.URL.Query().Get("length"), 10, 64)
+		mOffsets.Lock()
+		offsets = append(offsets, offset)
+		first := len(offsets) == 1
+		mOffsets.Unlock()
+		if first {
+			length = 32 << 10
+		}
+		_, _ = w.Write(content[offset : offset+length])
+	}))
+	defer srv.Close()
+
+	info, err := metainfo.NewInfo(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &moveTorrentRequest{ID: "abc", Token: "token", Spec: &resumer.Spec{InfoHash: info.Hash[:], Info: mi}}
+	err = dst.startIncomingMove(req, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := dst.getIncomingMove(req.ID, req.Token)
+	<-m.doneC
+
+	st := m.getStatus()
+	assert.Equal(t, moveDone, st.Status)
+	assert.Equal(t, int64(len(content)), st.Received)
+	assert.Equal(t, []int64{0, 32 << 10}, offsets)
+	assert.NotNil(t, dst.GetTorrent(req.ID))
+	b, err := os.ReadFile(filepath.Join(dst.getDataDir(req.ID), "content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, bytes.Equal(content, b))
+}
+
+func TestMoveTorrentTargetUnreachable(t *testing.T) {
+	src := newMoveTestSession(t)
+	movePollInterval = time.Millisecond
+	defer func() { movePollInterval = time.Second }()
+
+	path := filepath.Join(t.TempDir(), "content")
+	err := os.WriteFile(path, []byte("rain"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, _, err := src.CreateTorrent([]string{path}, &CreateTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is listening on the RPC port of a closed session.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	err = tor.Move(addr)
+	assert.Error(t, err)
+	assert.NotNil(t, src.GetTorrent(tor.ID()))
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+}
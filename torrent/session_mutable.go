@@ -0,0 +1,204 @@
+package torrent
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/magnet"
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/ganqierwu/rain/resumer"
+	"github.com/zeebo/bencode"
+)
+
+// mutableTorrentValue is the value of the DHT item that points to the latest version of a mutable torrent (BEP 46).
+type mutableTorrentValue struct {
+	IH string `bencode:"ih"`
+}
+
+// PublishMutableTorrent points the mutable torrent (BEP 46) of the key and salt to the torrent with the info hash.
+// seq must be greater than the sequence number of the previous version.
+// The torrent can be added with "magnet:?xs=urn:btpk:<hex public key>&s=<hex salt>" link.
+func (s *Session) PublishMutableTorrent(key ed25519.PrivateKey, salt []byte, seq int64, infoHash [20]byte) error {
+	v, err := bencode.EncodeBytes(mutableTorrentValue{IH: string(infoHash[:])})
+	if err != nil {
+		return err
+	}
+	return s.DHTPutMutable(key, salt, seq, v)
+}
+
+// resolveMutableTorrent returns the info hash of the latest version of the mutable torrent.
+func (s *Session) resolveMutableTorrent(publicKey, salt []byte) ([20]byte, error) {
+	var ih [20]byte
+	v, _, err := s.DHTGetMutable(publicKey, salt)
+	if err != nil {
+		return ih, err
+	}
+	var val mutableTorrentValue
+	err = bencode.DecodeBytes(v, &val)
+	if err != nil {
+		return ih, err
+	}
+	if len(val.IH) != len(ih) {
+		return ih, errors.New("invalid info hash in mutable torrent")
+	}
+	copy(ih[:], val.IH)
+	return ih, nil
+}
+
+// mutableLink returns the magnet link that points to the latest version of the mutable torrent.
+func mutableLink(ma *magnet.Magnet) string {
+	m := *ma
+	m.InfoHash = [20]byte{}
+	return m.String()
+}
+
+func (s *Session) checkMutableTorrentsLoop() {
+	ticker := time.NewTicker(s.config.MutableTorrentUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.checkMutableTorrents()
+		case <-s.closeC:
+			return
+		}
+	}
+}
+
+// checkMutableTorrents switches the mutable torrents in the session to their latest versions.
+func (s *Session) checkMutableTorrents() {
+	latest := make(map[string][20]byte)
+	for _, t := range s.ListTorrents() {
+		link := t.torrent.mutableLink
+		if link == "" {
+			continue
+		}
+		ih, ok := latest[link]
+		if !ok {
+			ma, err := magnet.New(link)
+			if err != nil {
+				continue
+			}
+			ih, err = s.resolveMutableTorrent(ma.PublicKey, ma.Salt)
+			if err != nil {
+				s.log.Warningf("cannot resolve mutable torrent %s: %s", link, err)
+				continue
+			}
+			latest[link] = ih
+		}
+		if ih == t.torrent.infoHash {
+			continue
+		}
+		_, err := s.updateMutableTorrent(t, ih)
+		if err != nil {
+			t.torrent.log.Errorf("cannot switch to new version of mutable torrent: %s", err)
+		}
+	}
+}
+
+// updateMutableTorrent switches the torrent to another version of the mutable torrent and returns the reloaded torrent.
+// Torrent keeps its ID, settings and statistics. Info hash is replaced and the metadata of the new version is downloaded.
+// New version is saved into its own directory, so the files of the previous version are not changed until it is completed.
+// Files that have not changed are reused from the previous version by data reuse.
+// Files of the previous version are deleted after the new version is completed.
+func (s *Session) updateMutableTorrent(t *Torrent, ih [20]byte) (*Torrent, error) {
+	id := t.torrent.id
+	spec, err := s.resumer.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	running := t.torrent.Stats().Status != Stopped
+	if !s.detachTorrent(t) {
+		return nil, errors.New("torrent is removed")
+	}
+	t.torrent.Close()
+
+	// Torrent loop is stopped, fields can be read without locking.
+	old := t.torrent
+	pv := &resumer.PreviousVersion{
+		InfoHash:   old.InfoHash(),
+		Dir:        old.storage.RootDir(),
+		RemovePath: s.dataPath(old),
+	}
+	if old.info != nil {
+		pv.Info = old.info.Bytes
+		if old.filePriorities != nil {
+			pv.FilePriorities = make(map[string]int, len(old.info.Files))
+			for i, f := range old.info.Files {
+				pv.FilePriorities[f.Path] = int(old.filePriorities[i])
+			}
+		}
+	}
+	if old.bitfield != nil {
+		pv.Bitfield = old.bitfield.Bytes()
+	}
+	dir := filepath.Join(s.config.DataDir, id+"-"+hex.EncodeToString(ih[:]))
+	spec.Info = nil
+	spec.Bitfield = nil
+	spec.FilePriorities = nil
+	if opv := old.previousVersion; opv != nil {
+		// Previous update is not completed yet.
+		// Files of the older version are kept for reuse and partially downloaded files are deleted.
+		if pv.RemovePath != "" {
+			err = os.RemoveAll(pv.RemovePath)
+			if err != nil {
+				old.log.Errorf("cannot remove files of incomplete version: %s", err)
+			}
+		}
+		if old.info == nil {
+			pv.FilePriorities = opv.FilePriorities
+		}
+		pv.InfoHash, pv.Info, pv.Bitfield, pv.Dir, pv.RemovePath = opv.InfoHash, opv.Info, opv.Bitfield, opv.Dir, opv.RemovePath
+		if pv.Dir == dir {
+			// Switched back to the version that the torrent is being updated from. Its files are complete.
+			spec.Info, spec.Bitfield = pv.Info, pv.Bitfield
+			spec.FilePriorities = filePrioritiesByPath(spec.Info, pv.FilePriorities)
+			pv = nil
+		}
+	}
+	spec.InfoHash = ih[:]
+	spec.CompleteCmdRun = false
+	spec.Dest = dir
+	spec.DestOwned = true
+	spec.KeepData = false
+	spec.PreviousVersion = pv
+	spec.SetStats(resumer.Stats{
+		BytesDownloaded: old.bytesDownloaded.Count(),
+		BytesUploaded:   old.bytesUploaded.Count(),
+		BytesWasted:     old.bytesWasted.Count(),
+		SeededFor:       old.seededFor.Count(),
+	})
+	err = s.resumer.Write(id, spec)
+	if err != nil {
+		return nil, err
+	}
+	nt, _, err := s.loadExistingTorrent(id)
+	if err != nil {
+		return nil, err
+	}
+	nt.torrent.log.Infof("switched to new version of mutable torrent, previous version: %x", old.InfoHash())
+	if running {
+		nt.torrent.Start()
+	}
+	return nt, nil
+}
+
+// filePrioritiesByPath returns the file priorities of the info from the priorities keyed by file path.
+func filePrioritiesByPath(infoBytes []byte, priorities map[string]int) []int {
+	if len(priorities) == 0 {
+		return nil
+	}
+	info, err := metainfo.NewInfo(infoBytes)
+	if err != nil {
+		return nil
+	}
+	ret := make([]int, len(info.Files))
+	for i, f := range info.Files {
+		ret[i] = priorities[f.Path]
+	}
+	return ret
+}
@@ -0,0 +1,165 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/logger"
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMutableTorrentUpdate(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	src := filepath.Join(t.TempDir(), "content")
+	err := os.Mkdir(src, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile := func(name string, b byte) {
+		err2 := os.WriteFile(filepath.Join(src, name), bytes.Repeat([]byte{b}, 16<<10), 0640)
+		if err2 != nil {
+			t.Fatal(err2)
+		}
+	}
+	newInfo := func() []byte {
+		info, err2 := metainfo.NewInfoBytes("", []string{src}, false, 16<<10, "", false, nil, logger.New("test"))
+		if err2 != nil {
+			t.Fatal(err2)
+		}
+		return info
+	}
+	writeFile("a", 1)
+	writeFile("b", 2)
+	info1 := newInfo()
+	// A new file is added in the second version.
+	writeFile("c", 3)
+	info2 := newInfo()
+	err = os.Remove(filepath.Join(src, "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi1, err := metainfo.NewBytes(info1, nil, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev, err := s.AddTorrent(bytes.NewReader(mi1), &AddTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := prev.ID()
+	prevDir := filepath.Join(s.config.DataDir, id)
+	err = os.Mkdir(prevDir, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CopyDir(src, filepath.Join(prevDir, "content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev.Start()
+	select {
+	case <-prev.NotifyComplete():
+	case <-time.After(timeout):
+		t.Fatal("torrent is not completed")
+	}
+	err = prev.SetSpeedLimit(10, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = prev.SetFilePriority(0, PriorityHigh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev.torrent.bytesUploaded.Inc(100)
+
+	i2, err := metainfo.NewInfo(info2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ih := i2.Hash
+
+	// Torrent is switched to the new version in place.
+	tor, err := s.updateMutableTorrent(prev, ih)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []*Torrent{tor}, s.ListTorrents())
+	assert.Equal(t, id, tor.ID())
+	assert.Equal(t, ih[:], tor.torrent.InfoHash())
+	dir := filepath.Join(s.config.DataDir, id+"-"+hex.EncodeToString(ih[:]))
+	assert.Equal(t, dir, tor.torrent.storage.RootDir())
+	assert.True(t, tor.torrent.destOwned)
+	down, up := tor.SpeedLimit()
+	assert.Equal(t, int64(10), down)
+	assert.Equal(t, int64(20), up)
+	assert.Equal(t, int64(100), tor.Stats().Bytes.Uploaded)
+	spec, err := s.resumer.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, spec.DestOwned)
+	if assert.NotNil(t, spec.PreviousVersion) {
+		assert.Equal(t, prev.torrent.InfoHash(), spec.PreviousVersion.InfoHash)
+		assert.Equal(t, prevDir, spec.PreviousVersion.Dir)
+		assert.Equal(t, prevDir, spec.PreviousVersion.RemovePath)
+		assert.Equal(t, map[string]int{"content/a": int(PriorityHigh), "content/b": int(PriorityNormal)}, spec.PreviousVersion.FilePriorities)
+	}
+
+	// Metadata is written as if it is received from peers.
+	assert.True(t, s.detachTorrent(tor))
+	tor.torrent.Close()
+	err = s.resumer.WriteInfo(id, info2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, _, err = s.loadExistingTorrent(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, PriorityHigh, tor.torrent.filePriority(0))
+	// New file cannot be downloaded without peers.
+	err = tor.SetFilePriority(2, PrioritySkip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files are reused from the previous version, which is removed after the new version is completed.
+	tor.Start()
+	select {
+	case <-tor.NotifyComplete():
+	case <-time.After(timeout):
+		t.Fatal("torrent is not completed")
+	}
+	spec, err = s.resumer.Read(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, spec.PreviousVersion)
+	_, err = os.Stat(filepath.Join(dir, "content", "b"))
+	assert.NoError(t, err)
+	for i := 0; ; i++ {
+		_, err = os.Stat(prevDir)
+		if os.IsNotExist(err) {
+			break
+		}
+		if i == 100 {
+			t.Fatal("files of previous version are not removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Directory of the version is owned by the session.
+	err = s.RemoveTorrent(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
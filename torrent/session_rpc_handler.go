@@ -1,28 +1,29 @@
 package torrent
 
 import (
-	"archive/tar"
 	"encoding/base64"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
-	"io"
-	"io/ioutil"
-	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/ganqierwu/rain/internal/resumer/boltdbresumer"
 	"github.com/ganqierwu/rain/internal/rpctypes"
 	"github.com/powerman/rpc-codec/jsonrpc2"
+	"gopkg.in/yaml.v2"
 )
 
-var errTorrentNotFound = jsonrpc2.NewError(1, "torrent not found")
+var (
+	errTorrentNotFound        = jsonrpc2.NewError(1, "torrent not found")
+	errCreateProgressNotFound = jsonrpc2.NewError(3, "torrent creation not found")
+)
 
 type rpcHandler struct {
 	session *Session
+
+	// Hashing progress of torrents that are being created, keyed by CreateTorrentRequest.ProgressID.
+	createProgress  map[string]*rpctypes.GetCreateTorrentProgressResponse
+	mCreateProgress sync.Mutex
 }
 
 func (h *rpcHandler) Version(args struct{}, reply *string) error {
@@ -34,11 +35,23 @@ func (h *rpcHandler) ListTorrents(args *rpctypes.ListTorrentsRequest, reply *rpc
 	torrents := h.session.ListTorrents()
 	reply.Torrents = make([]rpctypes.Torrent, 0, len(torrents))
 	for _, t := range torrents {
+		if len(args.Status) > 0 && !hasStatus(args.Status, t.Stats().Status) {
+			continue
+		}
 		reply.Torrents = append(reply.Torrents, newTorrent(t))
 	}
 	return nil
 }
 
+func hasStatus(statuses []string, status Status) bool {
+	for _, s := range statuses {
+		if strings.EqualFold(s, status.String()) {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *rpcHandler) AddTorrent(args *rpctypes.AddTorrentRequest, reply *rpctypes.AddTorrentResponse) error {
 	r := base64.NewDecoder(base64.StdEncoding, strings.NewReader(args.Torrent))
 	opt := &AddTorrentOptions{
@@ -46,6 +59,7 @@ func (h *rpcHandler) AddTorrent(args *rpctypes.AddTorrentRequest, reply *rpctype
 		ID:                args.AddTorrentOptions.ID,
 		StopAfterDownload: args.StopAfterDownload,
 		StopAfterMetadata: args.StopAfterMetadata,
+		DataDir:           args.DataDir,
 	}
 	t, err := h.session.AddTorrent(r, opt)
 	var e *InputError
@@ -65,6 +79,7 @@ func (h *rpcHandler) AddURI(args *rpctypes.AddURIRequest, reply *rpctypes.AddURI
 		ID:                args.AddTorrentOptions.ID,
 		StopAfterDownload: args.StopAfterDownload,
 		StopAfterMetadata: args.StopAfterMetadata,
+		DataDir:           args.DataDir,
 	}
 	t, err := h.session.AddURI(args.URI, opt)
 	var e *InputError
@@ -78,6 +93,59 @@ func (h *rpcHandler) AddURI(args *rpctypes.AddURIRequest, reply *rpctypes.AddURI
 	return nil
 }
 
+func (h *rpcHandler) CreateTorrent(args *rpctypes.CreateTorrentRequest, reply *rpctypes.CreateTorrentResponse) error {
+	opt := &CreateTorrentOptions{
+		ID:          args.ID,
+		Stopped:     args.Stopped,
+		Private:     args.Private,
+		PieceLength: args.PieceLength,
+		PadFiles:    args.PadFiles,
+		Webseeds:    args.Webseeds,
+		Comment:     args.Comment,
+	}
+	for _, tr := range args.Trackers {
+		opt.Trackers = append(opt.Trackers, []string{tr})
+	}
+	if args.ProgressID != "" {
+		p := new(rpctypes.GetCreateTorrentProgressResponse)
+		h.mCreateProgress.Lock()
+		h.createProgress[args.ProgressID] = p
+		h.mCreateProgress.Unlock()
+		defer func() {
+			h.mCreateProgress.Lock()
+			delete(h.createProgress, args.ProgressID)
+			h.mCreateProgress.Unlock()
+		}()
+		opt.Progress = func(hashed, total int64) {
+			h.mCreateProgress.Lock()
+			p.Hashed, p.Total = hashed, total
+			h.mCreateProgress.Unlock()
+		}
+	}
+	t, b, err := h.session.CreateTorrent(args.Paths, opt)
+	var e *InputError
+	if errors.As(err, &e) {
+		return jsonrpc2.NewError(2, e.Error())
+	}
+	if err != nil {
+		return err
+	}
+	reply.Torrent = newTorrent(t)
+	reply.TorrentFile = base64.StdEncoding.EncodeToString(b)
+	return nil
+}
+
+func (h *rpcHandler) GetCreateTorrentProgress(args *rpctypes.GetCreateTorrentProgressRequest, reply *rpctypes.GetCreateTorrentProgressResponse) error {
+	h.mCreateProgress.Lock()
+	defer h.mCreateProgress.Unlock()
+	p, ok := h.createProgress[args.ProgressID]
+	if !ok {
+		return errCreateProgressNotFound
+	}
+	*reply = *p
+	return nil
+}
+
 func newTorrent(t *Torrent) rpctypes.Torrent {
 	return rpctypes.Torrent{
 		ID:       t.ID(),
@@ -85,6 +153,7 @@ func newTorrent(t *Torrent) rpctypes.Torrent {
 		InfoHash: t.InfoHash().String(),
 		Port:     t.Port(),
 		AddedAt:  rpctypes.Time{Time: t.AddedAt()},
+		DataDir:  t.DataDir(),
 	}
 }
 
@@ -129,6 +198,9 @@ func (h *rpcHandler) GetSessionStats(args *rpctypes.GetSessionStatsRequest, repl
 
 		BlockListRules:   s.BlockListRules,
 		BlockListRecency: int(s.BlockListRecency / time.Second),
+		BlockListSources: make([]rpctypes.BlocklistSource, len(s.BlockListSources)),
+
+		BannedIPs: s.BannedIPs,
 
 		ReadCacheObjects:     s.ReadCacheObjects,
 		ReadCacheSize:        s.ReadCacheSize,
@@ -145,12 +217,26 @@ func (h *rpcHandler) GetSessionStats(args *rpctypes.GetSessionStatsRequest, repl
 		WritesPerSecond: s.WritesPerSecond,
 		WritesActive:    s.WritesActive,
 		WritesPending:   s.WritesPending,
+		WriteQueueBytes: s.WriteQueueBytes,
+		WriteLatencyMS:  int(s.WriteLatency / time.Millisecond),
+		SyncsPerSecond:  s.SyncsPerSecond,
+		SyncLatencyMS:   int(s.SyncLatency / time.Millisecond),
 
 		SpeedDownload: s.SpeedDownload,
 		SpeedUpload:   s.SpeedUpload,
 		SpeedRead:     s.SpeedRead,
 		SpeedWrite:    s.SpeedWrite,
 	}
+	for i, src := range s.BlockListSources {
+		reply.Stats.BlockListSources[i] = rpctypes.BlocklistSource{
+			Source:    src.Source,
+			Rules:     src.Rules,
+			UpdatedAt: rpctypes.Time{Time: src.UpdatedAt},
+		}
+		if src.Error != nil {
+			reply.Stats.BlockListSources[i].Error = src.Error.Error()
+		}
+	}
 	return nil
 }
 
@@ -180,6 +266,7 @@ func (h *rpcHandler) GetTorrentStats(args *rpctypes.GetTorrentStatsRequest, repl
 		Bytes: struct {
 			Total      int64
 			Allocated  int64
+			Moved      int64
 			Completed  int64
 			Incomplete int64
 			Downloaded int64
@@ -188,6 +275,7 @@ func (h *rpcHandler) GetTorrentStats(args *rpctypes.GetTorrentStatsRequest, repl
 		}{
 			Total:      s.Bytes.Total,
 			Allocated:  s.Bytes.Allocated,
+			Moved:      s.Bytes.Moved,
 			Completed:  s.Bytes.Completed,
 			Incomplete: s.Bytes.Incomplete,
 			Downloaded: s.Bytes.Downloaded,
@@ -198,10 +286,12 @@ func (h *rpcHandler) GetTorrentStats(args *rpctypes.GetTorrentStatsRequest, repl
 			Total    int
 			Incoming int
 			Outgoing int
+			Banned   int
 		}{
 			Total:    s.Peers.Total,
 			Incoming: s.Peers.Incoming,
 			Outgoing: s.Peers.Outgoing,
+			Banned:   s.Peers.Banned,
 		},
 		Handshakes: struct {
 			Total    int
@@ -243,10 +333,12 @@ func (h *rpcHandler) GetTorrentStats(args *rpctypes.GetTorrentStatsRequest, repl
 			Snubbed: s.MetadataDownloads.Snubbed,
 			Running: s.MetadataDownloads.Running,
 		},
-		Name:        s.Name,
-		Private:     s.Private,
-		PieceLength: s.PieceLength,
-		SeededFor:   uint(s.SeededFor / time.Second),
+		Name:         s.Name,
+		Private:      s.Private,
+		PieceLength:  s.PieceLength,
+		SeededFor:    uint(s.SeededFor / time.Second),
+		SuperSeeding: s.SuperSeeding,
+		Signer:       s.Signer,
 		Speed: struct {
 			Download int
 			Upload   int
@@ -336,6 +428,15 @@ func (h *rpcHandler) GetTorrentPeers(args *rpctypes.GetTorrentPeersRequest, repl
 			EncryptedStream:    p.EncryptedStream,
 			DownloadSpeed:      p.DownloadSpeed,
 			UploadSpeed:        p.UploadSpeed,
+			BytesDownloaded:    p.BytesDownloaded,
+			BytesUploaded:      p.BytesUploaded,
+			Pieces:             p.Pieces,
+			RequestsOut:        p.RequestsOut,
+			RequestsIn:         p.RequestsIn,
+			RequestQueue:       p.RequestQueue,
+			LatencyMS:          int(p.Latency / time.Millisecond),
+			Extensions:         p.Extensions,
+			FastExtension:      p.FastExtension,
 		}
 	}
 	return nil
@@ -351,6 +452,7 @@ func (h *rpcHandler) GetTorrentWebseeds(args *rpctypes.GetTorrentWebseedsRequest
 	for i, p := range webseeds {
 		reply.Webseeds[i] = rpctypes.Webseed{
 			URL:           p.URL,
+			HTTPSeed:      p.HTTPSeed,
 			DownloadSpeed: p.DownloadSpeed,
 		}
 		if p.Error != nil {
@@ -360,6 +462,67 @@ func (h *rpcHandler) GetTorrentWebseeds(args *rpctypes.GetTorrentWebseedsRequest
 	return nil
 }
 
+func (h *rpcHandler) GetTorrentFiles(args *rpctypes.GetTorrentFilesRequest, reply *rpctypes.GetTorrentFilesResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	files := t.Files()
+	reply.Files = make([]rpctypes.File, len(files))
+	for i, f := range files {
+		reply.Files[i] = rpctypes.File{
+			Path:      f.Path,
+			Length:    f.Length,
+			Completed: f.Completed,
+			Priority:  f.Priority.String(),
+			Padding:   f.Padding,
+		}
+	}
+	return nil
+}
+
+func (h *rpcHandler) SetFilePriority(args *rpctypes.SetFilePriorityRequest, reply *rpctypes.SetFilePriorityResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	prio, err := ParseFilePriority(args.Priority)
+	if err != nil {
+		return jsonrpc2.NewError(2, err.Error())
+	}
+	err = t.SetFilePriority(args.Index, prio)
+	var e *InputError
+	if errors.As(err, &e) {
+		return jsonrpc2.NewError(2, e.Error())
+	}
+	return err
+}
+
+func (h *rpcHandler) SetSuperSeed(args *rpctypes.SetSuperSeedRequest, reply *rpctypes.SetSuperSeedResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	return t.SetSuperSeed(args.Enabled)
+}
+
+func (h *rpcHandler) GetTorrentPieces(args *rpctypes.GetTorrentPiecesRequest, reply *rpctypes.GetTorrentPiecesResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
+	}
+	p := t.Pieces()
+	reply.Pieces = rpctypes.Pieces{
+		Total:        p.Total,
+		Have:         p.Have,
+		Availability: p.Availability,
+		Downloading:  p.Downloading,
+		Writing:      p.Writing,
+		Verifying:    p.Verifying,
+	}
+	return nil
+}
+
 func (h *rpcHandler) StartTorrent(args *rpctypes.StartTorrentRequest, reply *rpctypes.StartTorrentResponse) error {
 	t := h.session.GetTorrent(args.ID)
 	if t == nil {
@@ -409,168 +572,151 @@ func (h *rpcHandler) AddPeer(args *rpctypes.AddPeerRequest, reply *rpctypes.AddP
 	return t.AddPeer(args.Addr)
 }
 
-func (h *rpcHandler) AddTracker(args *rpctypes.AddTrackerRequest, reply *rpctypes.AddTrackerResponse) error {
+func (h *rpcHandler) DisconnectPeer(args *rpctypes.DisconnectPeerRequest, reply *rpctypes.DisconnectPeerResponse) error {
 	t := h.session.GetTorrent(args.ID)
 	if t == nil {
 		return errTorrentNotFound
 	}
-	return t.AddTracker(args.URL)
+	err := t.DisconnectPeer(args.Addr)
+	var e *InputError
+	if errors.As(err, &e) {
+		return jsonrpc2.NewError(2, e.Error())
+	}
+	return err
 }
 
-func (h *rpcHandler) MoveTorrent(args *rpctypes.MoveTorrentRequest, reply *rpctypes.MoveTorrentResponse) error {
+func (h *rpcHandler) BanIP(args *rpctypes.BanIPRequest, reply *rpctypes.BanIPResponse) error {
+	d := time.Duration(args.Duration) * time.Second
+	var err error
+	if args.ID == "" {
+		err = h.session.BanIP(args.Addr, d)
+	} else {
+		t := h.session.GetTorrent(args.ID)
+		if t == nil {
+			return errTorrentNotFound
+		}
+		err = t.BanIP(args.Addr, d)
+	}
+	var e *InputError
+	if errors.As(err, &e) {
+		return jsonrpc2.NewError(2, e.Error())
+	}
+	return err
+}
+
+func (h *rpcHandler) SetSpeedLimit(args *rpctypes.SetSpeedLimitRequest, reply *rpctypes.SetSpeedLimitResponse) error {
+	var err error
+	if args.ID == "" {
+		err = h.session.SetSpeedLimit(args.Download, args.Upload)
+	} else {
+		t := h.session.GetTorrent(args.ID)
+		if t == nil {
+			return errTorrentNotFound
+		}
+		err = t.SetSpeedLimit(args.Download, args.Upload)
+	}
+	var e *InputError
+	if errors.As(err, &e) {
+		return jsonrpc2.NewError(2, e.Error())
+	}
+	return err
+}
+
+func (h *rpcHandler) GetSpeedLimit(args *rpctypes.GetSpeedLimitRequest, reply *rpctypes.GetSpeedLimitResponse) error {
+	if args.ID == "" {
+		reply.Download, reply.Upload = h.session.SpeedLimit()
+		return nil
+	}
 	t := h.session.GetTorrent(args.ID)
 	if t == nil {
 		return errTorrentNotFound
 	}
-	return t.Move(args.Target)
+	reply.Download, reply.Upload = t.SpeedLimit()
+	return nil
 }
 
-func (h *rpcHandler) handleMoveTorrent(w http.ResponseWriter, r *http.Request) {
-	port, err := h.session.getPort()
+func (h *rpcHandler) GetSessionConfig(args *rpctypes.GetSessionConfigRequest, reply *rpctypes.GetSessionConfigResponse) error {
+	cfg := h.session.Config()
+	b, err := yaml.Marshal(&cfg)
 	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return err
 	}
+	reply.Config = string(b)
+	return nil
+}
 
-	var success bool
-	defer func() {
-		if !success {
-			h.session.releasePort(port)
-		}
-	}()
-
-	mr, err := r.MultipartReader()
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	// case "id":
-	p, err := mr.NextPart()
+func (h *rpcHandler) SetSessionConfig(args *rpctypes.SetSessionConfigRequest, reply *rpctypes.SetSessionConfigResponse) error {
+	cfg := h.session.Config()
+	err := yaml.UnmarshalStrict([]byte(args.Config), &cfg)
 	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return jsonrpc2.NewError(2, err.Error())
 	}
-	if p.FormName() != "id" {
-		http.Error(w, "id expected in multipart form", http.StatusBadRequest)
-		return
+	err = h.session.SetConfig(cfg)
+	var e *InputError
+	if errors.As(err, &e) {
+		return jsonrpc2.NewError(2, e.Error())
 	}
-	b, err := ioutil.ReadAll(p)
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	id := string(b)
-	if id == "" {
-		http.Error(w, "id required", http.StatusBadRequest)
-		return
-	}
-	if _, ok := h.session.torrents[id]; ok {
-		h.session.log.Warningln("duplicate torrent id, removing existing one:", id)
-		t, err := h.session.removeTorrentFromClient(id)
-		if err != nil {
-			h.session.log.Error(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		err = h.session.stopAndRemoveData(t)
-		if err != nil {
-			h.session.log.Error(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	return err
+}
+
+func (h *rpcHandler) UnbanIP(args *rpctypes.UnbanIPRequest, reply *rpctypes.UnbanIPResponse) error {
+	var err error
+	if args.ID == "" {
+		err = h.session.UnbanIP(args.Addr)
+	} else {
+		t := h.session.GetTorrent(args.ID)
+		if t == nil {
+			return errTorrentNotFound
 		}
+		err = t.UnbanIP(args.Addr)
 	}
-	// case "metadata":
-	p, err = mr.NextPart()
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if p.FormName() != "metadata" {
-		http.Error(w, "metadata expected in multipart form", http.StatusBadRequest)
-		return
-	}
-	var s boltdbresumer.Spec
-	err = json.NewDecoder(p).Decode(&s)
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	s.Port = port
-	spec := &s
-	// case "data":
-	p, err = mr.NextPart()
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if p.FormName() != "data" {
-		http.Error(w, "data expected in multipart form", http.StatusBadRequest)
-		return
+	var e *InputError
+	if errors.As(err, &e) {
+		return jsonrpc2.NewError(2, e.Error())
 	}
-	err = readData(p, h.session.getDataDir(id))
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	return err
+}
+
+func (h *rpcHandler) ListBans(args *rpctypes.ListBansRequest, reply *rpctypes.ListBansResponse) error {
+	bans := h.session.ListBans()
+	reply.Bans = make([]rpctypes.Ban, len(bans))
+	for i, b := range bans {
+		reply.Bans[i] = rpctypes.Ban{
+			Net:       b.Net,
+			TorrentID: b.TorrentID,
+			Reason:    b.Reason,
+			CreatedAt: rpctypes.Time{Time: b.CreatedAt},
+			ExpiresAt: rpctypes.Time{Time: b.ExpiresAt},
+		}
 	}
-	err = h.session.resumer.Write(id, spec)
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	return nil
+}
+
+func (h *rpcHandler) AddTracker(args *rpctypes.AddTrackerRequest, reply *rpctypes.AddTrackerResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
 	}
-	t, started, err := h.session.loadExistingTorrent(id)
-	if err != nil {
-		h.session.log.Error(err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if started {
-		err = t.Start()
-		if err != nil {
-			h.session.log.Error(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	return t.AddTracker(args.URL)
+}
+
+func (h *rpcHandler) MoveTorrent(args *rpctypes.MoveTorrentRequest, reply *rpctypes.MoveTorrentResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
 	}
-	success = true
+	return t.Move(args.Target)
 }
 
-func readData(r io.Reader, dir string) error {
-	tr := tar.NewReader(r)
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
-		name := filepath.Join(dir, hdr.Name)
-		err = os.MkdirAll(filepath.Dir(name), os.ModeDir|0750)
-		if err != nil {
-			return err
-		}
-		f, err := os.Create(name)
-		if err != nil {
-			return err
-		}
-		_, err = io.Copy(f, tr) // nolint: gosec
-		if err != nil {
-			return err
-		}
-		err = f.Sync()
-		if err != nil {
-			return err
-		}
-		_ = f.Close()
+func (h *rpcHandler) MoveTorrentData(args *rpctypes.MoveTorrentDataRequest, reply *rpctypes.MoveTorrentDataResponse) error {
+	t := h.session.GetTorrent(args.ID)
+	if t == nil {
+		return errTorrentNotFound
 	}
-	return nil
+	err := t.MoveData(args.Dir)
+	var e *InputError
+	if errors.As(err, &e) {
+		return jsonrpc2.NewError(2, e.Error())
+	}
+	return err
 }
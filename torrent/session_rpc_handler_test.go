@@ -0,0 +1,40 @@
+package torrent
+
+import (
+	"os"
+	"testing"
+
+	"github.com/ganqierwu/rain/internal/rpctypes"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListTorrentsStatus(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	f, err := os.Open(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tor, err := s.AddTorrent(f, &AddTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &rpcHandler{session: s}
+
+	var reply rpctypes.ListTorrentsResponse
+	err = h.ListTorrents(&rpctypes.ListTorrentsRequest{Status: []string{"seeding", "stopped"}}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, reply.Torrents, 1) {
+		assert.Equal(t, tor.ID(), reply.Torrents[0].ID)
+	}
+
+	err = h.ListTorrents(&rpctypes.ListTorrentsRequest{Status: []string{"Seeding"}}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, reply.Torrents)
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/ganqierwu/rain/internal/logger"
+	"github.com/ganqierwu/rain/internal/rpctypes"
 	"github.com/powerman/rpc-codec/jsonrpc2"
 )
 
@@ -20,13 +21,17 @@ type rpcServer struct {
 }
 
 func newRPCServer(ses *Session) *rpcServer {
-	h := &rpcHandler{session: ses}
+	h := &rpcHandler{
+		session:        ses,
+		createProgress: make(map[string]*rpctypes.GetCreateTorrentProgressResponse),
+	}
 	srv := rpc.NewServer()
 	_ = srv.RegisterName("Session", h)
 
 	mux := http.NewServeMux()
 	mux.Handle("/debug/vars", expvar.Handler())
 	mux.HandleFunc("/move-torrent", h.handleMoveTorrent)
+	mux.HandleFunc("/move-torrent/data", h.handleMoveTorrentData)
 	mux.Handle("/", jsonrpc2.HTTPHandler(srv))
 
 	return &rpcServer{
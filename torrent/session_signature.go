@@ -0,0 +1,67 @@
+package torrent
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/mitchellh/go-homedir"
+)
+
+var errSignatureRequired = errors.New("signed torrent is required, magnet links cannot be verified")
+
+// loadTrustedCertificates reads the PEM encoded certificates in the files at paths.
+func loadTrustedCertificates(paths []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, path := range paths {
+		path, err := homedir.Expand(path)
+		if err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var found bool
+		for {
+			var block *pem.Block
+			block, b = pem.Decode(b)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate in %s: %w", path, err)
+			}
+			certs = append(certs, cert)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("no certificate in %s", path)
+		}
+	}
+	return certs, nil
+}
+
+// verifySignature returns the identity of the trusted signer of the torrent (BEP 35).
+// An error is returned only if Config.RequireSignedTorrents is set.
+func (s *Session) verifySignature(mi *metainfo.MetaInfo) (string, error) {
+	if len(mi.Signatures) == 0 && !s.config.RequireSignedTorrents {
+		return "", nil
+	}
+	signer, err := mi.VerifySignature(s.trustedCertificates)
+	if err != nil {
+		if s.config.RequireSignedTorrents {
+			return "", err
+		}
+		s.log.Warningf("cannot verify signature of torrent %q: %s", mi.Info.Name, err)
+		return "", nil
+	}
+	return signer, nil
+}
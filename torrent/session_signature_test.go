@@ -0,0 +1,80 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireSignedTorrents(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "release"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	certFile := filepath.Join(tmp, "cert.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig
+	cfg.Database = filepath.Join(tmp, "session.db")
+	cfg.DataDir = tmp
+	cfg.DHTEnabled = false
+	cfg.PEXEnabled = false
+	cfg.RPCEnabled = false
+	cfg.TrustedCertificates = []string{certFile}
+	cfg.RequireSignedTorrents = true
+	s, err := NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	unsigned, err := os.ReadFile(torrentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.AddTorrent(bytes.NewReader(unsigned), &AddTorrentOptions{Stopped: true})
+	assert.ErrorIs(t, err, metainfo.ErrUnsigned)
+	_, err = s.AddURI(torrentMagnetLink, &AddTorrentOptions{Stopped: true})
+	assert.ErrorIs(t, err, errSignatureRequired)
+
+	signed, err := metainfo.Sign(unsigned, "release", key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, err := s.AddTorrent(bytes.NewReader(signed), &AddTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "release", tor.Stats().Signer)
+	spec, err := s.resumer.Read(tor.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "release", spec.Signer)
+}
@@ -0,0 +1,20 @@
+package torrent
+
+import "time"
+
+// addSmartBanStrike is called when a torrent bans an IP for sending corrupt data.
+// The IP is banned in all torrents after it is banned in Config.SmartBanSessionThreshold torrents.
+// Existing connections in other torrents are kept until they send corrupt data too.
+func (s *Session) addSmartBanStrike(ip string) {
+	s.mBannedIPs.Lock()
+	defer s.mBannedIPs.Unlock()
+	s.smartBanStrikes[ip]++
+	if s.config.SmartBanSessionThreshold <= 0 || s.smartBanStrikes[ip] < s.config.SmartBanSessionThreshold {
+		return
+	}
+	if _, ok := s.bannedIPs[ip]; ok {
+		return
+	}
+	s.log.Infof("banned %s in all torrents for sending corrupt data to %d torrents", ip, s.smartBanStrikes[ip])
+	s.bannedIPs[ip] = time.Now()
+}
@@ -0,0 +1,22 @@
+package torrent
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSmartBanSessionThreshold(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	for i := 0; i < s.config.SmartBanSessionThreshold-1; i++ {
+		s.addSmartBanStrike("1.2.3.4")
+	}
+	assert.False(t, s.isBanned("", net.ParseIP("1.2.3.4")))
+	s.addSmartBanStrike("1.2.3.4")
+	assert.True(t, s.isBanned("", net.ParseIP("1.2.3.4")))
+	assert.False(t, s.isBanned("", net.ParseIP("5.6.7.8")))
+	assert.Equal(t, 1, s.Stats().BannedIPs)
+}
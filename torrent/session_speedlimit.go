@@ -0,0 +1,119 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+)
+
+// SetSpeedLimit changes the global download and upload speed limits in KB/s. Zero means no limit.
+// The change is not saved. Config.SpeedLimitDownload and Config.SpeedLimitUpload are used in the next session.
+func (s *Session) SetSpeedLimit(download, upload int64) error {
+	if download < 0 || upload < 0 {
+		return newInputError(errors.New("negative speed limit"))
+	}
+	s.limitDownload.SetLimit(download * 1024)
+	s.limitUpload.SetLimit(upload * 1024)
+	return nil
+}
+
+// SpeedLimit returns the global download and upload speed limits in KB/s. Zero means no limit.
+func (s *Session) SpeedLimit() (download, upload int64) {
+	return s.limitDownload.Limit() / 1024, s.limitUpload.Limit() / 1024
+}
+
+// Config returns the configuration of the Session.
+// Fields that are changed while the Session is running have their current values.
+func (s *Session) Config() Config {
+	cfg := s.config
+	cfg.SpeedLimitDownload, cfg.SpeedLimitUpload = s.SpeedLimit()
+	cfg.MaxPeerDial = s.maxPeerDialLimit()
+	cfg.MaxPeerAccept = s.maxPeerAcceptLimit()
+	cfg.ParallelMetadataDownloads = s.parallelMetadataDownloadsLimit()
+	cfg.WebseedMaxDownloads = s.webseedMaxDownloadsLimit()
+	return cfg
+}
+
+// SetConfig applies the changes in cfg to the running Session.
+// Only the fields that are safe to change at runtime can be changed. These are SpeedLimitDownload, SpeedLimitUpload,
+// MaxPeerDial, MaxPeerAccept, ParallelMetadataDownloads and WebseedMaxDownloads.
+// New peer limits are applied when torrents connect to or accept the next peer, existing connections are not closed.
+// Other fields are read-only while the Session is running.
+// If a read-only field differs from the value returned from Config method, no changes are made and an InputError is returned.
+func (s *Session) SetConfig(cfg Config) error {
+	cur := s.Config()
+	v1, v2 := reflect.ValueOf(cur), reflect.ValueOf(cfg)
+	for i := 0; i < v1.NumField(); i++ {
+		f := v1.Type().Field(i)
+		switch f.Name {
+		case "SpeedLimitDownload", "SpeedLimitUpload", "MaxPeerDial", "MaxPeerAccept", "ParallelMetadataDownloads", "WebseedMaxDownloads":
+			continue
+		}
+		if f.Type.Kind() == reflect.Func {
+			// Functions can only be compared with nil.
+			if v1.Field(i).IsNil() != v2.Field(i).IsNil() {
+				return newInputError(fmt.Errorf("%s cannot be changed while session is running", f.Name))
+			}
+			continue
+		}
+		if !equalConfigValues(v1.Field(i), v2.Field(i)) {
+			return newInputError(fmt.Errorf("%s cannot be changed while session is running", f.Name))
+		}
+	}
+	if cfg.MaxPeerDial < 0 || cfg.MaxPeerAccept < 0 || cfg.ParallelMetadataDownloads < 0 || cfg.WebseedMaxDownloads < 0 {
+		return newInputError(errors.New("negative limit"))
+	}
+	err := s.SetSpeedLimit(cfg.SpeedLimitDownload, cfg.SpeedLimitUpload)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&s.maxPeerDial, int32(cfg.MaxPeerDial))
+	atomic.StoreInt32(&s.maxPeerAccept, int32(cfg.MaxPeerAccept))
+	atomic.StoreInt32(&s.parallelMetadataDownloads, int32(cfg.ParallelMetadataDownloads))
+	atomic.StoreInt32(&s.webseedMaxDownloads, int32(cfg.WebseedMaxDownloads))
+	return nil
+}
+
+func (s *Session) maxPeerDialLimit() int {
+	return int(atomic.LoadInt32(&s.maxPeerDial))
+}
+
+func (s *Session) maxPeerAcceptLimit() int {
+	return int(atomic.LoadInt32(&s.maxPeerAccept))
+}
+
+func (s *Session) parallelMetadataDownloadsLimit() int {
+	return int(atomic.LoadInt32(&s.parallelMetadataDownloads))
+}
+
+func (s *Session) webseedMaxDownloadsLimit() int {
+	return int(atomic.LoadInt32(&s.webseedMaxDownloads))
+}
+
+func equalConfigValues(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Slice, reflect.Map:
+		// Nil and empty values are the same in config.
+		if a.Len() == 0 && b.Len() == 0 {
+			return true
+		}
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// SetSpeedLimit changes the download and upload speed limits of the torrent in KB/s. Zero means no limit.
+// Global limits of the Session are applied in addition to these.
+func (t *Torrent) SetSpeedLimit(download, upload int64) error {
+	if download < 0 || upload < 0 {
+		return newInputError(errors.New("negative speed limit"))
+	}
+	t.torrent.limitDownload.SetLimit(download * 1024)
+	t.torrent.limitUpload.SetLimit(upload * 1024)
+	return t.torrent.session.resumer.WriteSpeedLimit(t.torrent.id, download, upload)
+}
+
+// SpeedLimit returns the download and upload speed limits of the torrent in KB/s. Zero means no limit.
+func (t *Torrent) SpeedLimit() (download, upload int64) {
+	return t.torrent.limitDownload.Limit() / 1024, t.torrent.limitUpload.Limit() / 1024
+}
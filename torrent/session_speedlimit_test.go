@@ -0,0 +1,82 @@
+package torrent
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSetConfig(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Database = filepath.Join(t.TempDir(), "session.db")
+	cfg.DataDir = t.TempDir()
+	cfg.DHTEnabled = false
+	cfg.RPCEnabled = false
+	s, err := NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// Config must be unchanged after a round trip through the config file format.
+	b, err := yaml.Marshal(s.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg2 := s.Config()
+	assert.NoError(t, yaml.Unmarshal(b, &cfg2))
+	assert.NoError(t, s.SetConfig(cfg2))
+
+	cfg2.SpeedLimitDownload = 100
+	assert.NoError(t, s.SetConfig(cfg2))
+	download, upload := s.SpeedLimit()
+	assert.Equal(t, int64(100), download)
+	assert.Equal(t, int64(0), upload)
+	assert.Equal(t, int64(100), s.Config().SpeedLimitDownload)
+
+	cfg2.MaxPeerDial++
+	cfg2.WebseedMaxDownloads = 1
+	assert.NoError(t, s.SetConfig(cfg2))
+	assert.Equal(t, cfg.MaxPeerDial+1, s.Config().MaxPeerDial)
+	assert.Equal(t, 1, s.webseedMaxDownloadsLimit())
+
+	var e *InputError
+	cfg3 := cfg2
+	cfg3.MaxPeerAccept = -1
+	assert.ErrorAs(t, s.SetConfig(cfg3), &e)
+	cfg3 = cfg2
+	cfg3.MaxRequestsIn++
+	assert.ErrorAs(t, s.SetConfig(cfg3), &e)
+	assert.Error(t, s.SetSpeedLimit(-1, 0))
+}
+
+func TestTorrentSpeedLimit(t *testing.T) {
+	cfg := DefaultConfig
+	cfg.Database = filepath.Join(t.TempDir(), "session.db")
+	cfg.DataDir = t.TempDir()
+	cfg.DHTEnabled = false
+	cfg.RPCEnabled = false
+	s, err := NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, err := s.AddURI("magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567", &AddTorrentOptions{Stopped: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, tor.SetSpeedLimit(10, 20))
+	assert.NoError(t, s.Close())
+
+	// Limits must be loaded from the database.
+	s, err = NewSession(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	tor = s.GetTorrent(tor.ID())
+	download, upload := tor.SpeedLimit()
+	assert.Equal(t, int64(10), download)
+	assert.Equal(t, int64(20), upload)
+}
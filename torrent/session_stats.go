@@ -1,11 +1,9 @@
 package torrent
 
 import (
-	"strconv"
 	"time"
 
-	"github.com/ganqierwu/rain/internal/resumer/boltdbresumer"
-	"go.etcd.io/bbolt"
+	"github.com/ganqierwu/rain/resumer"
 )
 
 // SessionStats contains statistics about Session.
@@ -22,7 +20,13 @@ type SessionStats struct {
 	// Number of rules in blocklist.
 	BlockListRules int
 	// Time elapsed after the last successful update of blocklist.
+	// If there are multiple sources, it is the time elapsed after the update of the oldest one.
 	BlockListRecency time.Duration
+	// Status of each blocklist source.
+	BlockListSources []BlocklistSourceStats
+
+	// Number of IPs that are banned in all torrents for sending corrupt data.
+	BannedIPs int
 
 	// Number of objects in piece read cache.
 	// Each object is a block whose size is defined in Config.ReadCacheBlockSize.
@@ -55,6 +59,15 @@ type SessionStats struct {
 	WritesActive int
 	// Number of pending write requests to disk.
 	WritesPending int
+	// Number of bytes in pieces waiting to be written to disk.
+	WriteQueueBytes int64
+	// Average duration of a write to disk.
+	// Adjacent pieces are merged into a single write if BufferedWrites is enabled.
+	WriteLatency time.Duration
+	// Number of fdatasync operations per second. Each operation flushes the files of pieces written since the last one.
+	SyncsPerSecond int
+	// Average duration of a sync operation.
+	SyncLatency time.Duration
 
 	// Download speed from peers in bytes/s.
 	SpeedDownload int
@@ -76,6 +89,9 @@ func (s *Session) Stats() SessionStats {
 
 		BlockListRules:   int(s.metrics.BlockListRules.Value()),
 		BlockListRecency: time.Duration(s.metrics.BlockListRecency.Value()) * time.Second,
+		BlockListSources: s.blocklistStats(),
+
+		BannedIPs: int(s.metrics.BannedIPs.Value()),
 
 		ReadCacheObjects:     int(s.metrics.ReadCacheObjects.Value()),
 		ReadCacheSize:        s.metrics.ReadCacheSize.Value(),
@@ -92,6 +108,10 @@ func (s *Session) Stats() SessionStats {
 		WritesPerSecond: int(s.metrics.WritesPerSecond.Rate1()),
 		WritesActive:    int(s.metrics.WritesActive.Value()),
 		WritesPending:   int(s.metrics.WritesPending.Value()),
+		WriteQueueBytes: s.metrics.WriteQueueBytes.Value(),
+		WriteLatency:    time.Duration(s.metrics.WriteLatency.Mean()),
+		SyncsPerSecond:  int(s.metrics.SyncLatency.Rate1()),
+		SyncLatency:     time.Duration(s.metrics.SyncLatency.Mean()),
 
 		SpeedDownload: int(s.metrics.SpeedDownload.Rate1()),
 		SpeedUpload:   int(s.metrics.SpeedUpload.Rate1()),
@@ -116,25 +136,32 @@ func (s *Session) updateStatsLoop() {
 func (s *Session) updateStats() {
 	s.mTorrents.RLock()
 	defer s.mTorrents.RUnlock()
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		mb := tx.Bucket(torrentsBucket)
-		for _, t := range s.torrents {
-			b := mb.Bucket([]byte(t.torrent.id))
-			_ = b.Put(boltdbresumer.Keys.BytesDownloaded, []byte(strconv.FormatInt(t.torrent.bytesDownloaded.Count(), 10)))
-			_ = b.Put(boltdbresumer.Keys.BytesUploaded, []byte(strconv.FormatInt(t.torrent.bytesUploaded.Count(), 10)))
-			_ = b.Put(boltdbresumer.Keys.BytesWasted, []byte(strconv.FormatInt(t.torrent.bytesWasted.Count(), 10)))
-			_ = b.Put(boltdbresumer.Keys.SeededFor, []byte(time.Duration(t.torrent.seededFor.Count()).String()))
-
-			t.torrent.mBitfield.RLock()
-			if t.torrent.bitfield != nil {
-				_ = b.Put(boltdbresumer.Keys.Bitfield, t.torrent.bitfield.Bytes())
-			}
-		}
-		return nil
-	})
+	states := make(map[string]resumer.State, len(s.torrents))
 	for _, t := range s.torrents {
+		state := resumer.State{
+			Stats: resumer.Stats{
+				BytesDownloaded: t.torrent.bytesDownloaded.Count(),
+				BytesUploaded:   t.torrent.bytesUploaded.Count(),
+				BytesWasted:     t.torrent.bytesWasted.Count(),
+				SeededFor:       t.torrent.seededFor.Count(),
+			},
+		}
+		t.torrent.mBitfield.RLock()
+		if t.torrent.bitfield != nil {
+			state.Bitfield = append([]byte(nil), t.torrent.bitfield.Bytes()...)
+		}
 		t.torrent.mBitfield.RUnlock()
+		if state.Bitfield != nil {
+			// Pieces in the bitfield are flushed to disk before the bitfield is saved.
+			err := t.torrent.syncFiles()
+			if err != nil {
+				s.log.Errorln("cannot sync files:", err.Error())
+				state.Bitfield = nil
+			}
+		}
+		states[t.torrent.id] = state
 	}
+	err := s.resumer.WriteStates(states)
 	if err != nil {
 		s.log.Errorln("cannot update stats:", err.Error())
 	}
@@ -1,20 +1,14 @@
 package torrent
 
 import (
-	"archive/tar"
 	"encoding/hex"
-	"encoding/json"
-	"fmt"
-	"io"
-	"mime/multipart"
-	"net/http"
-	"os"
+	"errors"
+	"net"
 	"path/filepath"
 	"time"
 
-	"github.com/ganqierwu/rain/internal/resumer/boltdbresumer"
+	"github.com/ganqierwu/rain/internal/peer"
 	"github.com/ganqierwu/rain/internal/tracker"
-	"go.etcd.io/bbolt"
 )
 
 // Torrent is created from a torrent file or a magnet link.
@@ -55,6 +49,12 @@ func (t *Torrent) AddedAt() time.Time {
 	return t.torrent.addedAt
 }
 
+// DataDir returns the directory that the files of the torrent are saved in.
+// Returns empty string if the storage of the torrent is not on disk.
+func (t *Torrent) DataDir() string {
+	return t.torrent.rootDir()
+}
+
 // Stats returns statistics about the torrent.
 func (t *Torrent) Stats() Stats {
 	return t.torrent.Stats()
@@ -87,6 +87,31 @@ func (t *Torrent) Webseeds() []Webseed {
 	return t.torrent.Webseeds()
 }
 
+// Files returns the list of files in the torrent with their download progress.
+// Returns nil if torrent has no metadata yet.
+func (t *Torrent) Files() []File {
+	return t.torrent.Files()
+}
+
+// Pieces returns the state of the pieces in the torrent.
+func (t *Torrent) Pieces() Pieces {
+	return t.torrent.Pieces()
+}
+
+// SetFilePriority changes the download priority of the file at index in the list returned from Files method.
+// Files with PrioritySkip are not downloaded and the torrent is completed when all the other files are downloaded.
+func (t *Torrent) SetFilePriority(index int, prio FilePriority) error {
+	return t.torrent.SetFilePriority(index, prio)
+}
+
+// SetSuperSeed enables or disables super-seeding mode (BEP 16).
+// While seeding in super-seeding mode, pieces are revealed to each peer one by one
+// and the next piece is revealed after the previous one is seen at other peers.
+// Peers that are already connected when the mode is enabled are not affected.
+func (t *Torrent) SetSuperSeed(enabled bool) error {
+	return t.torrent.SetSuperSeed(enabled)
+}
+
 // Port returns the TCP port number that the torrent is listening peers.
 func (t *Torrent) Port() int {
 	return t.torrent.port
@@ -118,6 +143,34 @@ func (t *Torrent) AddPeer(addr string) error {
 	return t.torrent.addPeerString(addr)
 }
 
+// DisconnectPeer closes the connection to the peer with the address in "ip:port" form.
+// The peer may connect again later. Use BanIP to prevent new connections.
+func (t *Torrent) DisconnectPeer(addr string) error {
+	taddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return newInputError(err)
+	}
+	n := t.torrent.disconnectPeers(func(pe *peer.Peer) bool {
+		a := pe.Addr()
+		return a.IP.Equal(taddr.IP) && a.Port == taddr.Port
+	})
+	if n == 0 {
+		return newInputError(errors.New("peer not found"))
+	}
+	return nil
+}
+
+// BanIP bans an IP address or a CIDR range in the torrent and disconnects the matching peers.
+// If d is not zero, the ban is removed after d.
+func (t *Torrent) BanIP(addr string, d time.Duration) error {
+	return t.torrent.session.addBan(addr, t.torrent.id, d)
+}
+
+// UnbanIP removes a ban that is added with Torrent.BanIP.
+func (t *Torrent) UnbanIP(addr string) error {
+	return t.torrent.session.removeBan(addr, t.torrent.id)
+}
+
 // AddTracker adds a new tracker to the torrent.
 func (t *Torrent) AddTracker(uri string) error {
 	var private bool
@@ -128,21 +181,11 @@ func (t *Torrent) AddTracker(uri string) error {
 	if err != nil {
 		return err
 	}
-	err = t.torrent.session.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(torrentsBucket).Bucket([]byte(t.torrent.id))
-		value := b.Get(boltdbresumer.Keys.Trackers)
-		var trackers [][]string
-		err = json.Unmarshal(value, &trackers)
-		if err != nil {
-			return err
-		}
-		trackers = append(trackers, []string{uri})
-		value, err = json.Marshal(trackers)
-		if err != nil {
-			return err
-		}
-		return b.Put(boltdbresumer.Keys.Trackers, value)
-	})
+	spec, err := t.torrent.session.resumer.Read(t.torrent.id)
+	if err != nil {
+		return err
+	}
+	err = t.torrent.session.resumer.WriteTrackers(t.torrent.id, append(spec.Trackers, []string{uri}))
 	if err != nil {
 		return err
 	}
@@ -181,10 +224,7 @@ func (t *Torrent) Announce() {
 // After Verify called, the torrent is stopped, then verification starts and the torrent switches into Verifying state.
 // The torrent stays stopped after verification finishes.
 func (t *Torrent) Verify() error {
-	err := t.torrent.session.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(torrentsBucket).Bucket([]byte(t.torrent.id))
-		return b.Delete([]byte("bitfield"))
-	})
+	err := t.torrent.session.resumer.WriteBitfield(t.torrent.id, nil)
 	if err != nil {
 		return err
 	}
@@ -192,126 +232,18 @@ func (t *Torrent) Verify() error {
 	return nil
 }
 
-// Move torrent to another Session.
-// target must be the RPC server address in host:port form.
-func (t *Torrent) Move(target string) error {
-	t.torrent.Stop()
-	spec, err := t.torrent.session.resumer.Read(t.torrent.id)
-	if err != nil {
-		return err
-	}
-
-	pr, pw := io.Pipe()
-	mw := multipart.NewWriter(pw)
-	go t.prepareBody(pw, mw, spec)
-
-	req, err := http.NewRequest(http.MethodPost, target+"/move-torrent?id="+t.torrent.id, pr) // nolint: noctx
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", mw.FormDataContentType())
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("http error: %d", resp.StatusCode)
-	}
-	defer resp.Body.Close()
-	return t.torrent.session.RemoveTorrent(t.torrent.id)
-}
-
-func (t *Torrent) prepareBody(pw *io.PipeWriter, mw *multipart.Writer, spec *boltdbresumer.Spec) {
-	var err error
-	defer func() { _ = pw.CloseWithError(err) }()
-
-	iw, err := mw.CreateFormField("id")
-	if err != nil {
-		t.torrent.log.Errorln("cannot create id form filed:", err)
-		return
-	}
-	_, err = iw.Write([]byte(t.torrent.id))
-	if err != nil {
-		t.torrent.log.Errorln("cannot write id:", err)
-		return
-	}
-	fw, err := mw.CreateFormField("metadata")
-	if err != nil {
-		t.torrent.log.Errorln("cannot create metadata form filed:", err)
-		return
-	}
-	err = json.NewEncoder(fw).Encode(spec)
-	if err != nil {
-		t.torrent.log.Errorln("cannot encode resumer spec:", err)
-		return
-	}
-	dw, err := mw.CreateFormField("data")
-	if err != nil {
-		t.torrent.log.Errorln("cannot create data form filed:", err)
-		return
-	}
-	tpr, tpw := io.Pipe()
-	go t.generateTar(tpw)
-	_, err = io.Copy(dw, tpr)
-	if err != nil {
-		t.torrent.log.Errorln("error copying pipe:", err)
-		return
-	}
-	err = mw.Close()
-	if err != nil {
-		t.torrent.log.Errorln("cannot close multipart writer:", err)
-		return
-	}
-}
-
-func (t *Torrent) generateTar(pw *io.PipeWriter) {
-	var err error
-	defer func() { _ = pw.CloseWithError(err) }()
-
-	tw := tar.NewWriter(pw)
-	root := t.torrent.session.getDataDir(t.torrent.id)
-	walkFunc := func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		hdr := &tar.Header{
-			Name: path[len(root)+1:],
-			Mode: 0600,
-			Size: info.Size(),
-		}
-		err = tw.WriteHeader(hdr)
-		if err != nil {
-			t.torrent.log.Errorln("cannot write tar header:", err)
-			return err
-		}
-		f, err := os.Open(path)
-		if err != nil {
-			t.torrent.log.Errorln("cannot open file:", err)
-			return err
-		}
-		_, err = io.Copy(tw, f)
-		f.Close()
-		if err != nil {
-			t.torrent.log.Errorln("cannot copy storage file to tar writer:", err)
-			return err
-		}
-		return nil
-	}
-	err = filepath.Walk(root, walkFunc)
-	if os.IsNotExist(err) {
-		err = nil
-		return
-	}
-	if err != nil {
-		t.torrent.log.Errorln("error walking files:", err)
-		return
+// MoveData moves the files of the torrent into dir on the same host and blocks until all files are moved.
+// The torrent is stopped during the move and it is started again if it was running before.
+// Files are renamed if dir is on the same filesystem, otherwise they are copied and the originals are deleted.
+// Progress of the copy can be followed in Stats while the torrent is in Moving state.
+// Since the files do not change, pieces are not verified again after the move.
+func (t *Torrent) MoveData(dir string) error {
+	if dir == "" {
+		return newInputError(errors.New("no directory specified"))
 	}
-	err = tw.Close()
+	dir, err := filepath.Abs(dir)
 	if err != nil {
-		t.torrent.log.Errorln("cannot close tar writer:", err)
-		return
+		return newInputError(err)
 	}
+	return t.torrent.MoveData(dir)
 }
@@ -0,0 +1,58 @@
+package torrent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoveData(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	src := filepath.Join(t.TempDir(), "content")
+	err := os.WriteFile(src, bytes.Repeat([]byte{1}, 50<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, _, err := s.CreateTorrent([]string{src}, &CreateTorrentOptions{PieceLength: 32 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-tor.NotifyComplete():
+	case <-time.After(timeout):
+		t.Fatal("torrent is not completed")
+	}
+
+	dir := filepath.Join(t.TempDir(), "moved")
+	err = tor.MoveData(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dir, tor.DataDir())
+	_, err = os.Stat(filepath.Join(dir, "content"))
+	assert.NoError(t, err)
+	_, err = os.Stat(src)
+	assert.True(t, os.IsNotExist(err))
+
+	spec, err := s.resumer.Read(tor.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dir, spec.Dest)
+
+	// Torrent is started again without verifying the pieces.
+	deadline := time.Now().Add(timeout)
+	for tor.Stats().Status != Seeding && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := tor.Stats()
+	assert.Equal(t, Seeding, stats.Status)
+	assert.Equal(t, uint32(0), stats.Pieces.Checked)
+	assert.Equal(t, stats.Pieces.Total, stats.Pieces.Have)
+}
@@ -15,7 +15,10 @@ import (
 	"github.com/ganqierwu/rain/internal/bitfield"
 	"github.com/ganqierwu/rain/internal/blocklist"
 	"github.com/ganqierwu/rain/internal/bufferpool"
+	"github.com/ganqierwu/rain/internal/datamover"
+	"github.com/ganqierwu/rain/internal/datareuse"
 	"github.com/ganqierwu/rain/internal/externalip"
+	"github.com/ganqierwu/rain/internal/filesection"
 	"github.com/ganqierwu/rain/internal/handshaker/incominghandshaker"
 	"github.com/ganqierwu/rain/internal/handshaker/outgoinghandshaker"
 	"github.com/ganqierwu/rain/internal/infodownloader"
@@ -28,14 +31,16 @@ import (
 	"github.com/ganqierwu/rain/internal/piecedownloader"
 	"github.com/ganqierwu/rain/internal/piecepicker"
 	"github.com/ganqierwu/rain/internal/piecewriter"
-	"github.com/ganqierwu/rain/internal/resumer"
-	"github.com/ganqierwu/rain/internal/storage"
+	"github.com/ganqierwu/rain/internal/smartban"
+	"github.com/ganqierwu/rain/internal/speedlimit"
 	"github.com/ganqierwu/rain/internal/suspendchan"
 	"github.com/ganqierwu/rain/internal/tracker"
 	"github.com/ganqierwu/rain/internal/unchoker"
 	"github.com/ganqierwu/rain/internal/urldownloader"
 	"github.com/ganqierwu/rain/internal/verifier"
 	"github.com/ganqierwu/rain/internal/webseedsource"
+	"github.com/ganqierwu/rain/resumer"
+	"github.com/ganqierwu/rain/storage"
 	"github.com/rcrowley/go-metrics"
 )
 
@@ -61,6 +66,35 @@ type torrent struct {
 	// Storage implementation to save the files in torrent.
 	storage storage.Storage
 
+	// Custom data directory of the torrent. Empty means the directory is chosen by session config.
+	dest string
+
+	// Download priorities of the files in torrent. Nil means all files have normal priority.
+	filePriorities []FilePriority
+
+	// Identity of the trusted signer of the torrent (BEP 35). Empty if the torrent is not signed.
+	signer string
+
+	// Magnet link of the mutable torrent (BEP 46) that this torrent is a version of. Empty for other torrents.
+	mutableLink string
+
+	// Files are seeded from their original location and not deleted when the torrent is removed.
+	keepData bool
+
+	// Data dir is created by the Session for the torrent and deleted as a whole when the torrent is removed.
+	destOwned bool
+
+	// Version of the mutable torrent that this torrent is being updated from. Nil after the update is completed.
+	previousVersion *resumer.PreviousVersion
+
+	// Super-seeding mode (BEP 16) is enabled.
+	superSeed bool
+	// Peers that are served in super-seeding mode and the index of the piece revealed to them.
+	superSeedPeers map[*peer.Peer]int
+
+	// Protects storage and dest writing from torrent loop and reading from other goroutines.
+	mStorage sync.RWMutex
+
 	// TCP Port to listen for peer connections.
 	port int
 
@@ -74,6 +108,9 @@ type torrent struct {
 	// Protects bitfield writing from torrent loop and reading from announcer loop.
 	mBitfield sync.RWMutex
 
+	// True if all wanted pieces are downloaded but some pieces are skipped. Protected by mBitfield.
+	partialSeed bool
+
 	// Unique peer ID is generated per downloader.
 	peerID [20]byte
 
@@ -109,6 +146,10 @@ type torrent struct {
 	pieceDownloadersSnubbed map[*peer.Peer]*piecedownloader.PieceDownloader
 	pieceDownloadersChoked  map[*peer.Peer]*piecedownloader.PieceDownloader
 
+	// Number of downloaders sharing the buffer of a piece that is downloaded from multiple peers.
+	// RAM is reserved once for each shared buffer and released when the last downloader of the piece is closed.
+	sharedPieceDownloaders map[uint32]int
+
 	// When a peer has snubbed us, a message sent to this channel.
 	peerSnubbedC chan *peer.Peer
 
@@ -118,6 +159,17 @@ type torrent struct {
 
 	pieceWriterResultC chan *piecewriter.PieceWriter
 
+	// Index of a piece that cannot be read from disk while uploading is sent to this channel.
+	pieceLostC chan uint32
+
+	// Number of bytes in pieces that are being written to disk.
+	bytesWriting int64
+
+	// File sections of written pieces that are not flushed to disk yet.
+	// Synced before the bitfield is saved to the resume db.
+	dirtySections filesection.Piece
+	mDirty        sync.Mutex
+
 	// This channel is closed once all torrent pieces are downloaded and verified.
 	completeC chan struct{}
 
@@ -143,18 +195,24 @@ type torrent struct {
 	doneC chan struct{}
 
 	// These are the channels for sending a message to run() loop.
-	statsCommandC        chan statsRequest        // Stats()
-	trackersCommandC     chan trackersRequest     // Trackers()
-	peersCommandC        chan peersRequest        // Peers()
-	webseedsCommandC     chan webseedsRequest     // Webseeds()
-	startCommandC        chan struct{}            // Start()
-	stopCommandC         chan struct{}            // Stop()
-	announceCommandC     chan struct{}            // Announce()
-	verifyCommandC       chan struct{}            // Verify()
-	notifyErrorCommandC  chan notifyErrorCommand  // NotifyError()
-	notifyListenCommandC chan notifyListenCommand // NotifyListen()
-	addPeersCommandC     chan []*net.TCPAddr      // AddPeers()
-	addTrackersCommandC  chan []tracker.Tracker   // AddTrackers()
+	statsCommandC           chan statsRequest    // Stats()
+	trackersCommandC        chan trackersRequest // Trackers()
+	peersCommandC           chan peersRequest    // Peers()
+	disconnectPeersCommandC chan disconnectPeersRequest
+	webseedsCommandC        chan webseedsRequest // Webseeds()
+	filesCommandC           chan filesRequest    // Files()
+	piecesCommandC          chan piecesRequest   // Pieces()
+	setFilePriorityCommandC chan setFilePriorityRequest
+	setSuperSeedCommandC    chan setSuperSeedRequest
+	startCommandC           chan struct{}            // Start()
+	stopCommandC            chan struct{}            // Stop()
+	announceCommandC        chan struct{}            // Announce()
+	verifyCommandC          chan struct{}            // Verify()
+	moveDataCommandC        chan moveDataRequest     // MoveData()
+	notifyErrorCommandC     chan notifyErrorCommand  // NotifyError()
+	notifyListenCommandC    chan notifyListenCommand // NotifyListen()
+	addPeersCommandC        chan []*net.TCPAddr      // AddPeers()
+	addTrackersCommandC     chan []tracker.Tracker   // AddTrackers()
 
 	// Trackers send announce responses to this channel.
 	addrsFromTrackers chan []*net.TCPAddr
@@ -205,12 +263,33 @@ type torrent struct {
 	allocatorResultC   chan *allocator.Allocator
 	bytesAllocated     int64
 
+	// A worker that copies files from other torrents in the session before allocation.
+	dataReuse        *datareuse.DataReuse
+	dataReuseResultC chan *datareuse.DataReuse
+	// Other torrents are searched for files only once.
+	dataReuseDone bool
+
+	// Other torrents in the session request data reuse sources from this channel.
+	dataReuseSourceCommandC chan dataReuseSourceRequest
+
 	// A worker that does hash check of files on the disk.
 	verifier          *verifier.Verifier
 	verifierProgressC chan verifier.Progress
 	verifierResultC   chan *verifier.Verifier
 	checkedPieces     uint32
 
+	// A worker that moves files to another data directory.
+	dataMover          *datamover.DataMover
+	dataMoverProgressC chan datamover.Progress
+	dataMoverResultC   chan *datamover.DataMover
+	bytesMoved         int64
+
+	// Pending MoveData() request. The data mover is started after the torrent is stopped.
+	moveDataRequest *moveDataRequest
+
+	// Torrent is started again after data is moved if it was running when MoveData() is called.
+	restartAfterMove bool
+
 	// Metrics
 	downloadSpeed   metrics.Meter
 	uploadSpeed     metrics.Meter
@@ -228,6 +307,13 @@ type torrent struct {
 	// Peers that are sending corrupt data are banned.
 	bannedPeerIPs map[string]struct{}
 
+	// Keeps blocks of corrupt pieces to find out the peers that have sent them.
+	smartBan *smartban.SmartBan
+
+	// Speed limits of the torrent. Session limits are the parents of these.
+	limitDownload *speedlimit.Limiter
+	limitUpload   *speedlimit.Limiter
+
 	// A signal sent to run() loop when announcers are stopped.
 	announcersStoppedC chan struct{}
 
@@ -244,6 +330,7 @@ type torrent struct {
 	webseedClient          *http.Client
 	webseedSources         []*webseedsource.WebseedSource
 	rawWebseedSources      []string
+	rawHTTPSeeds           []string
 	webseedPieceResultC    *suspendchan.Chan[*urldownloader.PieceResult]
 	webseedRetryC          chan *webseedsource.WebseedSource
 	webseedActiveDownloads int
@@ -311,10 +398,12 @@ func newTorrent2(
 		pieceDownloaders:          make(map[*peer.Peer]*piecedownloader.PieceDownloader),
 		pieceDownloadersSnubbed:   make(map[*peer.Peer]*piecedownloader.PieceDownloader),
 		pieceDownloadersChoked:    make(map[*peer.Peer]*piecedownloader.PieceDownloader),
+		sharedPieceDownloaders:    make(map[uint32]int),
 		peerSnubbedC:              make(chan *peer.Peer),
 		infoDownloaders:           make(map[*peer.Peer]*infodownloader.InfoDownloader),
 		infoDownloadersSnubbed:    make(map[*peer.Peer]*infodownloader.InfoDownloader),
 		pieceWriterResultC:        make(chan *piecewriter.PieceWriter),
+		pieceLostC:                make(chan uint32, 1),
 		completeC:                 make(chan struct{}),
 		completeMetadataC:         make(chan struct{}),
 		closeC:                    make(chan chan struct{}),
@@ -322,10 +411,17 @@ func newTorrent2(
 		stopCommandC:              make(chan struct{}),
 		announceCommandC:          make(chan struct{}),
 		verifyCommandC:            make(chan struct{}),
+		moveDataCommandC:          make(chan moveDataRequest),
 		statsCommandC:             make(chan statsRequest),
 		trackersCommandC:          make(chan trackersRequest),
 		peersCommandC:             make(chan peersRequest),
+		disconnectPeersCommandC:   make(chan disconnectPeersRequest),
 		webseedsCommandC:          make(chan webseedsRequest),
+		filesCommandC:             make(chan filesRequest),
+		piecesCommandC:            make(chan piecesRequest),
+		setFilePriorityCommandC:   make(chan setFilePriorityRequest),
+		setSuperSeedCommandC:      make(chan setSuperSeedRequest),
+		superSeedPeers:            make(map[*peer.Peer]int),
 		notifyErrorCommandC:       make(chan notifyErrorCommand),
 		notifyListenCommandC:      make(chan notifyListenCommand),
 		addPeersCommandC:          make(chan []*net.TCPAddr),
@@ -340,11 +436,18 @@ func newTorrent2(
 		incomingHandshakerResultC: make(chan *incominghandshaker.IncomingHandshaker),
 		outgoingHandshakerResultC: make(chan *outgoinghandshaker.OutgoingHandshaker),
 		allocatorProgressC:        make(chan allocator.Progress),
+		dataMoverProgressC:        make(chan datamover.Progress),
+		dataMoverResultC:          make(chan *datamover.DataMover),
 		allocatorResultC:          make(chan *allocator.Allocator),
+		dataReuseResultC:          make(chan *datareuse.DataReuse),
+		dataReuseSourceCommandC:   make(chan dataReuseSourceRequest),
 		verifierProgressC:         make(chan verifier.Progress),
 		verifierResultC:           make(chan *verifier.Verifier),
 		connectedPeerIPs:          make(map[string]struct{}),
 		bannedPeerIPs:             make(map[string]struct{}),
+		smartBan:                  smartban.New(),
+		limitDownload:             speedlimit.New(s.limitDownload),
+		limitUpload:               speedlimit.New(s.limitUpload),
 		announcersStoppedC:        make(chan struct{}),
 		dhtPeersC:                 make(chan []*net.TCPAddr, 1),
 		externalIP:                externalip.FirstExternalIP(),
@@ -399,6 +502,10 @@ func (t *torrent) copyPeerIDPrefix() int {
 func (t *torrent) getPeersForUnchoker() []unchoker.Peer {
 	peers := make([]unchoker.Peer, 0, len(t.peers))
 	for pe := range t.peers {
+		// Upload-only peers are not going to download from us.
+		if pe.UploadOnly {
+			continue
+		}
 		peers = append(peers, pe)
 	}
 	return peers
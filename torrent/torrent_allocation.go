@@ -34,6 +34,7 @@ func (t *torrent) handleAllocationDone(al *allocator.Allocator) {
 		return
 	}
 	t.pieces = pieces
+	t.setPiecePriorities()
 
 	for pe := range t.peers {
 		pe.GenerateAndSendAllowedFastMessages(t.session.config.AllowedFastSet, t.info.NumPieces, t.infoHash, t.pieces)
@@ -42,7 +43,7 @@ func (t *torrent) handleAllocationDone(al *allocator.Allocator) {
 	if t.piecePicker != nil {
 		panic("piece picker exists")
 	}
-	t.piecePicker = piecepicker.New(t.pieces, t.session.config.EndgameMaxDuplicateDownloads, t.webseedSources)
+	t.piecePicker = piecepicker.New(t.pieces, t.session.config.EndgameMaxDuplicateDownloads, uint32(t.session.config.MultiPeerPieceLength), t.webseedSources)
 
 	for pe := range t.peers {
 		pe.Bitfield = bitfield.New(t.info.NumPieces)
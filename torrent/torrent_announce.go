@@ -32,6 +32,7 @@ func (t *torrent) announcerFields() tracker.Torrent {
 	} else {
 		tr.BytesLeft = t.info.Length - t.bytesComplete()
 	}
+	tr.PartialSeed = t.partialSeed
 	t.mBitfield.RUnlock()
 	return tr
 }
@@ -20,6 +20,12 @@ func (t *torrent) close() {
 		t.stoppedEventAnnouncer.Close()
 	}
 
+	// Maybe we are in "Moving" state. Cancel the move.
+	t.stopDataMover()
+
+	// Storage is opened when the torrent is added, even if it is never started.
+	t.closeStorage()
+
 	t.downloadSpeed.Stop()
 	t.uploadSpeed.Stop()
 }
@@ -37,6 +43,7 @@ func (t *torrent) closePeer(pe *peer.Peer) {
 	delete(t.outgoingPeers, pe)
 	delete(t.peerIDs, pe.ID)
 	delete(t.connectedPeerIPs, pe.Conn.IP())
+	delete(t.superSeedPeers, pe)
 	if t.piecePicker != nil {
 		t.piecePicker.HandleDisconnect(pe)
 	}
@@ -63,6 +70,14 @@ func (t *torrent) closePieceDownloader(pd *piecedownloader.PieceDownloader) {
 		t.piecePicker.HandleCancelDownload(pe, pd.Piece.Index)
 	}
 	pe.Downloading = false
+	if pd.Blocks != nil {
+		t.sharedPieceDownloaders[pd.Piece.Index]--
+		if t.sharedPieceDownloaders[pd.Piece.Index] > 0 {
+			// Buffer is still used by other downloaders of the piece.
+			return
+		}
+		delete(t.sharedPieceDownloaders, pd.Piece.Index)
+	}
 	if t.session.ram != nil {
 		t.session.ram.Release(int64(t.info.PieceLength))
 	}
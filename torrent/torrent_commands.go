@@ -7,6 +7,7 @@ import (
 
 	"github.com/ganqierwu/rain/internal/magnet"
 	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/ganqierwu/rain/internal/peer"
 	"github.com/ganqierwu/rain/internal/tracker"
 )
 
@@ -106,11 +107,15 @@ func (t *torrent) Torrent() ([]byte, error) {
 	if t.info == nil {
 		return nil, errors.New("torrent metadata not ready")
 	}
-	webseeds := make([]string, len(t.webseedSources))
-	for i, ws := range t.webseedSources {
-		webseeds[i] = ws.URL
+	var webseeds, httpSeeds []string
+	for _, ws := range t.webseedSources {
+		if ws.HTTPSeed {
+			httpSeeds = append(httpSeeds, ws.URL)
+		} else {
+			webseeds = append(webseeds, ws.URL)
+		}
 	}
-	return metainfo.NewBytes(t.info.Bytes, t.getTieredTrackers(), webseeds, "")
+	return metainfo.NewBytes(t.info.Bytes, t.getTieredTrackers(), webseeds, httpSeeds, "")
 }
 
 func (t *torrent) getTieredTrackers() [][]string {
@@ -148,6 +153,27 @@ func (t *torrent) Stats() Stats {
 	return stats
 }
 
+type moveDataRequest struct {
+	Dir      string
+	Response chan error
+}
+
+// MoveData moves files to dir and blocks until the move is finished.
+func (t *torrent) MoveData(dir string) error {
+	req := moveDataRequest{Dir: dir, Response: make(chan error, 1)}
+	select {
+	case t.moveDataCommandC <- req:
+	case <-t.closeC:
+		return errClosed
+	}
+	select {
+	case err := <-req.Response:
+		return err
+	case <-t.closeC:
+		return errClosed
+	}
+}
+
 func (t *torrent) AddPeers(peers []*net.TCPAddr) {
 	select {
 	case t.addPeersCommandC <- peers:
@@ -234,6 +260,23 @@ type Peer struct {
 	EncryptedStream    bool
 	DownloadSpeed      int
 	UploadSpeed        int
+	// Number of piece bytes downloaded from and uploaded to the peer.
+	BytesDownloaded int64
+	BytesUploaded   int64
+	// Number of pieces that the peer has.
+	Pieces uint32
+	// Number of blocks requested from the peer but not received yet.
+	RequestsOut int
+	// Max number of blocks to be requested from the peer, adapted to the peer's speed and latency.
+	RequestQueue int
+	// Smoothed time between requesting a block and receiving it.
+	Latency time.Duration
+	// Number of blocks requested by the peer but not sent yet.
+	RequestsIn int
+	// Names of extensions that the peer supports in extension protocol (BEP 10).
+	Extensions []string
+	// Peer supports Fast Extension (BEP 6).
+	FastExtension bool
 }
 
 // PeerSource indicates that how the peer is found.
@@ -252,6 +295,26 @@ const (
 	SourceManual
 )
 
+type disconnectPeersRequest struct {
+	Match    func(pe *peer.Peer) bool
+	Response chan int
+}
+
+// disconnectPeers closes the connections to the peers that match and returns the number of closed connections.
+func (t *torrent) disconnectPeers(match func(pe *peer.Peer) bool) int {
+	var n int
+	req := disconnectPeersRequest{Match: match, Response: make(chan int, 1)}
+	select {
+	case t.disconnectPeersCommandC <- req:
+	case <-t.closeC:
+	}
+	select {
+	case n = <-req.Response:
+	case <-t.closeC:
+	}
+	return n
+}
+
 type peersRequest struct {
 	Response chan []Peer
 }
@@ -273,7 +336,9 @@ func (t *torrent) Peers() []Peer {
 // Webseed is a HTTP source defined in Torrent.
 // Client can download from these sources along with peers from the swarm.
 type Webseed struct {
-	URL           string
+	URL string
+	// HTTPSeed is true if the source is from "httpseeds" key (BEP 17) instead of "url-list" (BEP 19).
+	HTTPSeed      bool
 	Error         error
 	DownloadSpeed int
 }
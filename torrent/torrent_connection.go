@@ -7,7 +7,7 @@ import (
 )
 
 func (t *torrent) handleNewConnection(conn net.Conn) {
-	if len(t.incomingHandshakers)+len(t.incomingPeers) >= t.session.config.MaxPeerAccept {
+	if len(t.incomingHandshakers)+len(t.incomingPeers) >= t.session.maxPeerAcceptLimit() {
 		t.log.Debugln("peer limit reached, rejecting peer", conn.RemoteAddr().String())
 		conn.Close()
 		return
@@ -24,7 +24,7 @@ func (t *torrent) handleNewConnection(conn net.Conn) {
 		conn.Close()
 		return
 	}
-	if _, ok := t.bannedPeerIPs[ipstr]; ok {
+	if t.isBanned(ip) {
 		t.log.Debugln("connection attempt from banned IP: ", ipstr)
 		conn.Close()
 		return
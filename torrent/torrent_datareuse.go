@@ -0,0 +1,90 @@
+package torrent
+
+import (
+	"github.com/ganqierwu/rain/internal/datareuse"
+)
+
+type dataReuseSourceRequest struct {
+	Response chan datareuse.Source
+}
+
+// getDataReuseSource returns the data of the torrent that can be reused by other torrents in the session.
+func (t *torrent) getDataReuseSource() datareuse.Source {
+	var src datareuse.Source
+	req := dataReuseSourceRequest{Response: make(chan datareuse.Source, 1)}
+	select {
+	case t.dataReuseSourceCommandC <- req:
+	case <-t.closeC:
+	}
+	select {
+	case src = <-req.Response:
+	case <-t.closeC:
+	}
+	return src
+}
+
+func (t *torrent) dataReuseSource() datareuse.Source {
+	if t.info == nil || t.bitfield == nil {
+		return datareuse.Source{}
+	}
+	return datareuse.Source{
+		Info:     t.info,
+		Bitfield: t.bitfield.Copy(),
+		Dir:      t.storage.RootDir(),
+	}
+}
+
+// shouldReuseData returns true if files of a new torrent need to be searched in other torrents before allocation.
+// Data is always reused for the new versions of mutable torrents, because they are saved into a new directory.
+func (t *torrent) shouldReuseData() bool {
+	enabled := t.session.config.DataReuse || t.previousVersion != nil
+	return enabled && !t.dataReuseDone && t.bitfield == nil && t.storage.RootDir() != ""
+}
+
+func (t *torrent) startDataReuse() {
+	if t.dataReuse != nil {
+		panic("data reuse exists")
+	}
+	t.dataReuse = datareuse.New()
+	prev := t.previousVersionSources()
+	getSources := func() []datareuse.Source {
+		return append(t.getDataReuseSources(), prev...)
+	}
+	go t.dataReuse.Run(t.info, t.storage.RootDir(), getSources, t.session.config.DataReuseHardLink, t.dataReuseResultC)
+}
+
+// getDataReuseSources is called from the data reuse goroutine, not from the torrent loop,
+// because it waits for the loops of other torrents.
+func (t *torrent) getDataReuseSources() []datareuse.Source {
+	var sources []datareuse.Source
+	for _, other := range t.session.ListTorrents() {
+		if other.torrent == t {
+			continue
+		}
+		sources = append(sources, other.torrent.getDataReuseSource())
+	}
+	return sources
+}
+
+func (t *torrent) handleDataReuseDone(dr *datareuse.DataReuse) {
+	if t.dataReuse != dr {
+		panic("invalid data reuse")
+	}
+	t.dataReuse = nil
+	t.dataReuseDone = true
+	for _, err := range dr.Errors {
+		t.log.Warningln("cannot reuse file:", err)
+	}
+	if len(dr.Matches) > 0 {
+		t.log.Infof("reused %d files (%d bytes) from other torrents", len(dr.Matches), dr.Reused)
+	}
+	t.startAllocator()
+}
+
+func (t *torrent) stopDataReuse() {
+	t.log.Debugln("stopping data reuse")
+	if t.dataReuse != nil {
+		t.dataReuse.Close()
+		t.dataReuse = nil
+	}
+}
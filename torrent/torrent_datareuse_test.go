@@ -0,0 +1,76 @@
+package torrent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/logger"
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataReuse(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+	s.config.DataReuse = true
+
+	data := bytes.Repeat([]byte{1}, 100<<10)
+	src := filepath.Join(t.TempDir(), "content")
+	err := os.Mkdir(src, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(src, "a"), data, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor1, _, err := s.CreateTorrent([]string{src}, &CreateTorrentOptions{PieceLength: 32 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-tor1.NotifyComplete():
+	case <-time.After(timeout):
+		t.Fatal("torrent is not completed")
+	}
+
+	// A new version of the torrent with the same file under a different name.
+	src2 := filepath.Join(t.TempDir(), "content2")
+	err = os.Mkdir(src2, 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(src2, "a"), data, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := metainfo.NewInfoBytes("", []string{src2}, false, 32<<10, "", false, nil, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err := metainfo.NewBytes(info, nil, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	tor2, err := s.AddTorrent(bytes.NewReader(mi), &AddTorrentOptions{DataDir: dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// There are no peers, so the torrent can only be completed with the reused data.
+	select {
+	case <-tor2.NotifyComplete():
+	case err = <-tor2.NotifyStop():
+		t.Fatal(err)
+	case <-time.After(timeout):
+		t.Fatal("torrent is not completed")
+	}
+	b, err := os.ReadFile(filepath.Join(dest, "content2", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, data, b)
+}
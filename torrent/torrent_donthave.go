@@ -0,0 +1,98 @@
+package torrent
+
+import (
+	"github.com/ganqierwu/rain/internal/cachedpiece"
+	"github.com/ganqierwu/rain/internal/peer"
+	"github.com/ganqierwu/rain/internal/peerprotocol"
+	"github.com/ganqierwu/rain/internal/piece"
+	"github.com/ganqierwu/rain/internal/piecepicker"
+)
+
+// Retracting pieces with lt_donthave extension is described in BEP 54.
+
+func (t *torrent) handleDontHave(pe *peer.Peer, msg peerprotocol.ExtensionDontHaveMessage) {
+	// Save messages for processing later received while we don't have info yet.
+	if t.pieces == nil || t.bitfield == nil {
+		pe.Messages = append(pe.Messages, msg)
+		return
+	}
+	if msg.Index >= t.info.NumPieces {
+		pe.Logger().Errorln("unexpected piece index:", msg.Index)
+		t.closePeer(pe)
+		return
+	}
+	if t.piecePicker != nil {
+		t.piecePicker.HandleDontHave(pe, msg.Index)
+	} else {
+		pe.Bitfield.Clear(msg.Index)
+	}
+	t.updateInterestedState(pe)
+}
+
+// pieceReader reads the data of a piece for uploading to peers.
+// The piece is reported as lost if the data cannot be read, e.g. its file is truncated by another program.
+type pieceReader struct {
+	*cachedpiece.CachedPiece
+	index uint32
+	lostC chan uint32
+}
+
+func (t *torrent) newPieceReader(pi *piece.Piece) *pieceReader {
+	return &pieceReader{
+		CachedPiece: cachedpiece.New(pi, t.session.pieceCache, t.session.config.ReadCacheBlockSize, t.peerID),
+		index:       pi.Index,
+		lostC:       t.pieceLostC,
+	}
+}
+
+// ReadAt is called from the goroutine of the peer writer.
+func (r *pieceReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.CachedPiece.ReadAt(p, off)
+	if err != nil {
+		// Must not block because the torrent loop may be waiting for the peer to close.
+		// If the channel is full, the piece is reported again on the next failed read.
+		select {
+		case r.lostC <- r.index:
+		default:
+		}
+	}
+	return n, err
+}
+
+// handlePieceLost marks the piece as missing and tells connected peers that it is not available anymore.
+// The piece is downloaded again.
+func (t *torrent) handlePieceLost(i uint32) {
+	if t.pieces == nil || t.bitfield == nil || !t.bitfield.Test(i) {
+		return
+	}
+	t.log.Errorln("cannot read piece, it will be downloaded again:", i)
+	t.pieces[i].Done = false
+	t.mBitfield.Lock()
+	t.bitfield.Clear(i)
+	t.mBitfield.Unlock()
+	err := t.writeBitfield()
+	if err != nil {
+		t.stop(err)
+		return
+	}
+	for pe := range t.peers {
+		pe.SendDontHave(i)
+	}
+	if t.completed && !t.haveWantedPieces() {
+		t.completed = false
+		t.completeC = make(chan struct{})
+		t.updateUploadOnly()
+		t.piecePicker = piecepicker.New(t.pieces, t.session.config.EndgameMaxDuplicateDownloads, uint32(t.session.config.MultiPeerPieceLength), t.webseedSources)
+		for pe := range t.peers {
+			for j := uint32(0); j < pe.Bitfield.Len(); j++ {
+				if pe.Bitfield.Test(j) {
+					t.piecePicker.HandleHave(pe, j)
+				}
+			}
+		}
+	}
+	for pe := range t.peers {
+		t.updateInterestedState(pe)
+	}
+	t.startPieceDownloaders()
+}
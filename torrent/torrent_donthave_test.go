@@ -0,0 +1,94 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/peerprotocol"
+	"github.com/zeebo/bencode"
+)
+
+func sendTestMessage(t *testing.T, conn net.Conn, id peerprotocol.MessageID, payload []byte) {
+	b := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(1+len(payload)))
+	b[4] = byte(id)
+	_, err := conn.Write(append(b, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDontHaveUnreadablePiece(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	src := filepath.Join(t.TempDir(), "content")
+	err := os.WriteFile(src, bytes.Repeat([]byte{1}, 4*32<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, _, err := s.CreateTorrent([]string{src}, &CreateTorrentOptions{PieceLength: 32 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-tor.NotifyComplete():
+	case <-time.After(timeout):
+		t.Fatal("torrent is not completed")
+	}
+	// Wait for the acceptor to be started by the torrent loop.
+	tor.Stats()
+
+	// First peer supports lt_donthave extension.
+	var reserved [8]byte
+	reserved[5] = 0x10
+	conn1 := dialTestPeerReserved(t, tor, "127.0.0.1", reserved)
+	defer conn1.Close()
+	const donthaveID = 7
+	hs, err := bencode.EncodeBytes(peerprotocol.ExtensionHandshakeMessage{
+		M: map[string]uint8{peerprotocol.ExtensionKeyDontHave: donthaveID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTestMessage(t, conn1, peerprotocol.Extension, append([]byte{peerprotocol.ExtensionIDHandshake}, hs...))
+
+	// Second peer requests a piece after the file is truncated by another program.
+	conn2 := dialTestPeer(t, tor, "127.0.0.2")
+	defer conn2.Close()
+	sendTestMessage(t, conn2, peerprotocol.Interested, nil)
+	for {
+		id, _ := readTestMessage(t, conn2)
+		if id == peerprotocol.Unchoke {
+			break
+		}
+	}
+	err = os.Truncate(src, 32<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := make([]byte, 12)
+	binary.BigEndian.PutUint32(req[0:4], 2)
+	binary.BigEndian.PutUint32(req[8:12], 16<<10)
+	sendTestMessage(t, conn2, peerprotocol.Request, req)
+
+	// First peer is told that the piece is not available anymore.
+	for {
+		id, payload := readTestMessage(t, conn1)
+		if id != peerprotocol.Extension || payload[0] != donthaveID {
+			continue
+		}
+		if index := binary.BigEndian.Uint32(payload[1:]); index != 2 {
+			t.Fatalf("unexpected piece index: %d", index)
+		}
+		break
+	}
+	if tor.Stats().Pieces.Have != 3 {
+		t.Fatalf("unexpected number of pieces: %d", tor.Stats().Pieces.Have)
+	}
+}
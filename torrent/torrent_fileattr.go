@@ -0,0 +1,83 @@
+package torrent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// File attributes are described in BEP 47.
+// Attributes are applied after all wanted pieces are downloaded, so files are not changed while they are being written.
+
+// applyFileAttributes sets the executable bit of files and creates symbolic links in the data dir.
+func (t *torrent) applyFileAttributes() {
+	root := t.storage.RootDir()
+	if root == "" {
+		return
+	}
+	for i, f := range t.info.Files {
+		if t.filePriority(i) == PrioritySkip {
+			continue
+		}
+		name := filepath.Join(root, f.Path)
+		var err error
+		switch {
+		case f.SymlinkPath != "":
+			err = createSymlink(name, filepath.Join(root, f.SymlinkPath))
+		case f.Executable:
+			err = setExecutable(name)
+		}
+		if err != nil {
+			t.log.Errorf("cannot apply attributes of file %q: %s", f.Path, err)
+		}
+	}
+}
+
+func setExecutable(name string) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	mode := fi.Mode().Perm()
+	// Allow execute if read is allowed.
+	mode |= (mode & 0o444) >> 2
+	if mode == fi.Mode().Perm() {
+		return nil
+	}
+	return os.Chmod(name, mode)
+}
+
+// createSymlink creates a symbolic link at name pointing to target with a relative path.
+func createSymlink(name, target string) error {
+	rel, err := filepath.Rel(filepath.Dir(name), target)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Lstat(name)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case fi.Mode()&os.ModeSymlink != 0:
+		if current, _ := os.Readlink(name); current == rel {
+			return nil
+		}
+		err = os.Remove(name)
+		if err != nil {
+			return err
+		}
+	case fi.Mode().IsRegular() && fi.Size() == 0:
+		// An empty file may exist at the path if the torrent is allocated before symlinks are supported.
+		err = os.Remove(name)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("file exists: %s", name)
+	}
+	err = os.MkdirAll(filepath.Dir(name), os.ModeDir|0o750)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(rel, name)
+}
@@ -0,0 +1,354 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FilePriority determines how the pieces of a file are chosen for downloading.
+type FilePriority int
+
+const (
+	// PrioritySkip files are not downloaded.
+	// Pieces that are shared with other files are downloaded if any of the other files is not skipped.
+	PrioritySkip FilePriority = iota - 2
+	// PriorityLow files are downloaded after files with higher priority.
+	PriorityLow
+	// PriorityNormal is the default priority of files.
+	PriorityNormal
+	// PriorityHigh files are downloaded before files with lower priority.
+	PriorityHigh
+)
+
+var filePriorityNames = map[FilePriority]string{
+	PrioritySkip:   "Skip",
+	PriorityLow:    "Low",
+	PriorityNormal: "Normal",
+	PriorityHigh:   "High",
+}
+
+func (p FilePriority) String() string {
+	return filePriorityNames[p]
+}
+
+// ParseFilePriority returns the FilePriority for the name returned from FilePriority.String method.
+// Name is not case-sensitive.
+func ParseFilePriority(s string) (FilePriority, error) {
+	for p, name := range filePriorityNames {
+		if strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("invalid file priority: %q", s)
+}
+
+// File is a file in the torrent.
+type File struct {
+	// Path of the file relative to the data dir of the torrent.
+	Path string
+	// Length of the file in bytes.
+	Length int64
+	// Number of bytes of the file in the pieces that are downloaded and passed hash check.
+	Completed int64
+	Priority  FilePriority
+	// Padding files are not saved to disk (BEP 47).
+	Padding bool
+}
+
+// Pieces contains the state of each piece in the torrent.
+type Pieces struct {
+	// Number of total pieces in torrent.
+	Total uint32
+	// Pieces that are downloaded and passed hash check, in BitTorrent bitfield format.
+	// The high bit of the first byte corresponds to the piece at index 0.
+	Have []byte
+	// Number of connected peers that have the piece, for each piece.
+	// Nil if the files of the torrent are not open.
+	Availability []int
+	// Indexes of pieces that are being downloaded from peers or webseed sources.
+	Downloading []uint32
+	// Indexes of downloaded pieces that are being hash checked and written to disk.
+	Writing []uint32
+	// Indexes of pieces that are being verified when torrent is in "Verifying" state.
+	Verifying []uint32
+}
+
+type filesRequest struct {
+	Response chan []File
+}
+
+func (t *torrent) Files() []File {
+	var files []File
+	req := filesRequest{Response: make(chan []File, 1)}
+	select {
+	case t.filesCommandC <- req:
+	case <-t.closeC:
+	}
+	select {
+	case files = <-req.Response:
+	case <-t.closeC:
+	}
+	return files
+}
+
+type piecesRequest struct {
+	Response chan Pieces
+}
+
+func (t *torrent) Pieces() Pieces {
+	var pieces Pieces
+	req := piecesRequest{Response: make(chan Pieces, 1)}
+	select {
+	case t.piecesCommandC <- req:
+	case <-t.closeC:
+	}
+	select {
+	case pieces = <-req.Response:
+	case <-t.closeC:
+	}
+	return pieces
+}
+
+type setFilePriorityRequest struct {
+	Index    int
+	Priority FilePriority
+	Response chan error
+}
+
+// SetFilePriority changes the download priority of the file at index.
+func (t *torrent) SetFilePriority(index int, prio FilePriority) error {
+	var err error
+	req := setFilePriorityRequest{Index: index, Priority: prio, Response: make(chan error, 1)}
+	select {
+	case t.setFilePriorityCommandC <- req:
+	case <-t.closeC:
+	}
+	select {
+	case err = <-req.Response:
+	case <-t.closeC:
+	}
+	return err
+}
+
+func (t *torrent) handleSetFilePriority(index int, prio FilePriority) error {
+	if t.info == nil {
+		return newInputError(errors.New("torrent metadata is not downloaded yet"))
+	}
+	if index < 0 || index >= len(t.info.Files) {
+		return newInputError(fmt.Errorf("invalid file index: %d", index))
+	}
+	if _, ok := filePriorityNames[prio]; !ok {
+		return newInputError(fmt.Errorf("invalid file priority: %d", prio))
+	}
+	if t.filePriority(index) == prio {
+		return nil
+	}
+	if t.filePriorities == nil {
+		t.filePriorities = make([]FilePriority, len(t.info.Files))
+	}
+	t.filePriorities[index] = prio
+	err := t.session.resumer.WriteFilePriorities(t.id, t.rawFilePriorities())
+	if err != nil {
+		return err
+	}
+	t.setPiecePriorities()
+	if t.bitfield == nil {
+		return nil
+	}
+	if t.completed {
+		if t.haveWantedPieces() {
+			return nil
+		}
+		// Torrent needs to download more pieces. Restart it for setting up the download again.
+		t.completed = false
+		t.completeC = make(chan struct{})
+		t.updateUploadOnly()
+		if s := t.status(); s != Stopped && s != Stopping {
+			t.stop(nil)
+			t.start()
+		}
+		return nil
+	}
+	if t.pieces == nil || t.piecePicker == nil {
+		return nil
+	}
+	if t.checkCompletion() {
+		t.log.Info("download completed")
+		err = t.writeBitfield()
+		if err != nil {
+			t.stop(err)
+		} else if t.stopAfterDownload {
+			t.stopAndSetStoppedOnComplete()
+		}
+		return nil
+	}
+	for pe := range t.peers {
+		t.updateInterestedState(pe)
+	}
+	t.startPieceDownloaders()
+	return nil
+}
+
+// rawFilePriorities returns file priorities in the format saved in resume data.
+func (t *torrent) rawFilePriorities() []int {
+	if t.filePriorities == nil {
+		return nil
+	}
+	priorities := make([]int, len(t.filePriorities))
+	for i, p := range t.filePriorities {
+		priorities[i] = int(p)
+	}
+	return priorities
+}
+
+func (t *torrent) filePriority(index int) FilePriority {
+	if t.filePriorities == nil {
+		return PriorityNormal
+	}
+	return t.filePriorities[index]
+}
+
+// getPiecePriorities returns the priority of each piece that is the highest priority of the files in the piece.
+// Returns nil if all files have normal priority.
+func (t *torrent) getPiecePriorities() []FilePriority {
+	if t.info == nil || t.filePriorities == nil {
+		return nil
+	}
+	prios := make([]FilePriority, t.info.NumPieces)
+	for i := range prios {
+		prios[i] = PrioritySkip
+	}
+	pieceLength := int64(t.info.PieceLength)
+	var fileBegin int64
+	for i, f := range t.info.Files {
+		fileEnd := fileBegin + f.Length
+		if f.Length > 0 {
+			for j := fileBegin / pieceLength; j <= (fileEnd-1)/pieceLength; j++ {
+				if t.filePriorities[i] > prios[j] {
+					prios[j] = t.filePriorities[i]
+				}
+			}
+		}
+		fileBegin = fileEnd
+	}
+	return prios
+}
+
+// setPiecePriorities updates the piece priorities used by the piece picker after file priorities are changed.
+func (t *torrent) setPiecePriorities() {
+	if t.pieces == nil {
+		return
+	}
+	prios := t.getPiecePriorities()
+	for i := range t.pieces {
+		prio := PriorityNormal
+		if prios != nil {
+			prio = prios[i]
+		}
+		t.pieces[i].Priority = int(prio)
+		t.pieces[i].Skip = prio == PrioritySkip
+	}
+}
+
+// haveWantedPieces returns true if all pieces except the skipped ones are downloaded.
+func (t *torrent) haveWantedPieces() bool {
+	if t.bitfield.All() {
+		return true
+	}
+	prios := t.getPiecePriorities()
+	if prios == nil {
+		return false
+	}
+	for i, prio := range prios {
+		if prio != PrioritySkip && !t.bitfield.Test(uint32(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *torrent) getFiles() []File {
+	if t.info == nil {
+		return nil
+	}
+	files := make([]File, len(t.info.Files))
+	for i, f := range t.info.Files {
+		files[i] = File{
+			Path:     f.Path,
+			Length:   f.Length,
+			Priority: t.filePriority(i),
+			Padding:  f.Padding,
+		}
+	}
+	if t.bitfield == nil {
+		return files
+	}
+	pieceLength := int64(t.info.PieceLength)
+	// Files and pieces are both in increasing order of offset, so they are walked together.
+	var fileIndex int
+	var fileBegin int64
+	for i := uint32(0); i < t.info.NumPieces; i++ {
+		if !t.bitfield.Test(i) {
+			continue
+		}
+		begin := int64(i) * pieceLength
+		end := begin + pieceLength
+		if end > t.info.Length {
+			end = t.info.Length
+		}
+		for fileIndex < len(files) && fileBegin+files[fileIndex].Length <= begin {
+			fileBegin += files[fileIndex].Length
+			fileIndex++
+		}
+		for j, jBegin := fileIndex, fileBegin; j < len(files) && jBegin < end; j++ {
+			jEnd := jBegin + files[j].Length
+			files[j].Completed += min64(end, jEnd) - max64(begin, jBegin)
+			jBegin = jEnd
+		}
+	}
+	return files
+}
+
+func (t *torrent) getPieces() Pieces {
+	var p Pieces
+	if t.info == nil {
+		return p
+	}
+	p.Total = t.info.NumPieces
+	if t.bitfield != nil {
+		p.Have = t.bitfield.Bytes()
+	}
+	if t.piecePicker != nil {
+		p.Availability = make([]int, t.info.NumPieces)
+		for i := uint32(0); i < t.info.NumPieces; i++ {
+			p.Availability[i] = t.piecePicker.HavingPeers(i)
+			if len(t.piecePicker.RequestedPeers(i)) > 0 || t.piecePicker.RequestedWebseedSource(i) != nil {
+				p.Downloading = append(p.Downloading, i)
+			}
+		}
+	}
+	for i := range t.pieces {
+		if t.pieces[i].Writing {
+			p.Writing = append(p.Writing, t.pieces[i].Index)
+		}
+	}
+	if t.verifier != nil && t.checkedPieces < t.info.NumPieces {
+		p.Verifying = []uint32{t.checkedPieces}
+	}
+	return p
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,79 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/ganqierwu/rain/internal/bitfield"
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFiles(t *testing.T) {
+	// Piece length is 10, files span the pieces as: [0-15) [15-20) [20-45)
+	tor := &torrent{
+		info: &metainfo.Info{
+			PieceLength: 10,
+			Length:      45,
+			NumPieces:   5,
+			Files: []metainfo.File{
+				{Path: "a", Length: 15},
+				{Path: "b", Length: 5},
+				{Path: "c", Length: 25},
+			},
+		},
+		bitfield: bitfield.New(5),
+	}
+	tor.bitfield.Set(1)
+	tor.bitfield.Set(4)
+	files := tor.getFiles()
+	assert.Equal(t, []int64{5, 5, 5}, []int64{files[0].Completed, files[1].Completed, files[2].Completed})
+
+	tor.bitfield.Set(0)
+	tor.bitfield.Set(2)
+	tor.bitfield.Set(3)
+	files = tor.getFiles()
+	for _, f := range files {
+		assert.Equal(t, f.Length, f.Completed)
+	}
+}
+
+func TestPiecePriorities(t *testing.T) {
+	// Piece length is 10, files span the pieces as: [0-15) [15-20) [20-45)
+	tor := &torrent{
+		info: &metainfo.Info{
+			PieceLength: 10,
+			Length:      45,
+			NumPieces:   5,
+			Files: []metainfo.File{
+				{Path: "a", Length: 15},
+				{Path: "b", Length: 5},
+				{Path: "c", Length: 25},
+			},
+		},
+		bitfield: bitfield.New(5),
+	}
+	assert.Nil(t, tor.getPiecePriorities())
+
+	tor.filePriorities = []FilePriority{PrioritySkip, PriorityHigh, PrioritySkip}
+	assert.Equal(t, []FilePriority{PrioritySkip, PriorityHigh, PrioritySkip, PrioritySkip, PrioritySkip}, tor.getPiecePriorities())
+	assert.False(t, tor.haveWantedPieces())
+	tor.bitfield.Set(1)
+	assert.True(t, tor.haveWantedPieces())
+
+	tor.filePriorities = []FilePriority{PriorityLow, PrioritySkip, PriorityNormal}
+	assert.Equal(t, []FilePriority{PriorityLow, PriorityLow, PriorityNormal, PriorityNormal, PriorityNormal}, tor.getPiecePriorities())
+	assert.False(t, tor.haveWantedPieces())
+}
+
+func TestParseFilePriority(t *testing.T) {
+	for _, p := range []FilePriority{PrioritySkip, PriorityLow, PriorityNormal, PriorityHigh} {
+		p2, err := ParseFilePriority(p.String())
+		assert.NoError(t, err)
+		assert.Equal(t, p, p2)
+	}
+	p, err := ParseFilePriority("high")
+	assert.NoError(t, err)
+	assert.Equal(t, PriorityHigh, p)
+	_, err = ParseFilePriority("urgent")
+	assert.Error(t, err)
+}
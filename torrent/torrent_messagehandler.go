@@ -3,9 +3,9 @@ package torrent
 import (
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/ganqierwu/rain/internal/bitfield"
-	"github.com/ganqierwu/rain/internal/cachedpiece"
 	"github.com/ganqierwu/rain/internal/peer"
 	"github.com/ganqierwu/rain/internal/peerconn/peerwriter"
 	"github.com/ganqierwu/rain/internal/peerprotocol"
@@ -56,6 +56,10 @@ func (t *torrent) handlePieceMessage(pm peer.PieceMessage) {
 		msg.Buffer.Release()
 		return
 	}
+	if requestedAt, ok := pd.RequestedAt(block); ok {
+		now := time.Now()
+		pe.RequestQueue.Received(len(msg.Buffer.Data), now.Sub(requestedAt), now)
+	}
 	err := pd.GotBlock(block, msg.Buffer.Data)
 	switch err {
 	case piecedownloader.ErrBlockDuplicate:
@@ -92,9 +96,19 @@ func (t *torrent) handlePieceMessage(pm peer.PieceMessage) {
 			pd.RequestBlocks(t.maxAllowedRequests(pe))
 			pe.ResetSnubTimer()
 		}
+		if pd.Blocks != nil && pd.Pending() == 0 {
+			// Remaining blocks are requested from other peers. Start downloading another piece.
+			t.closePieceDownloader(pd)
+			pe.StopSnubTimer()
+			t.startPieceDownloaderFor(pe)
+		}
 		return
 	}
 	t.log.Debugf("piece #%d downloaded from %s", msg.Index, pe.IP())
+	var sources []string
+	if pd.Blocks != nil {
+		sources = t.blockSources(pd)
+	}
 	t.closePieceDownloader(pd)
 	pe.StopSnubTimer()
 
@@ -103,15 +117,15 @@ func (t *torrent) handlePieceMessage(pm peer.PieceMessage) {
 	}
 	piece.Writing = true
 
+	// Other peers must stop writing to the shared buffer before it is passed to the piece writer.
+	t.closePieceDownloadersOf(piece.Index)
+
 	// Request next piece while writing the completed piece, being optimistic about hash check.
 	t.startPieceDownloaderFor(pe)
 
-	// Prevent receiving piece messages to avoid more than 1 write per torrent.
-	t.pieceMessagesC.Suspend()
-	t.webseedPieceResultC.Suspend()
-
 	pw := piecewriter.New(piece, pe, pd.Buffer)
-	go pw.Run(t.pieceWriterResultC, t.doneC, t.session.metrics.WritesPerSecond, t.session.metrics.SpeedWrite, t.session.semWrite)
+	pw.BlockSources = sources
+	t.startPieceWriter(pw)
 }
 
 func (t *torrent) handlePeerMessage(pm peer.Message) {
@@ -131,7 +145,10 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 		// pe.Logger().Debug("Peer ", pe.String(), " has piece #", pi.Index)
 		if t.piecePicker != nil {
 			t.piecePicker.HandleHave(pe, msg.Index)
+		} else {
+			pe.Bitfield.Set(msg.Index)
 		}
+		t.handleSuperSeedHave(pe, msg.Index)
 		t.updateInterestedState(pe)
 		t.startPieceDownloaderFor(pe)
 	case peerprotocol.BitfieldMessage:
@@ -157,7 +174,10 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 					t.piecePicker.HandleHave(pe, i)
 				}
 			}
+		} else {
+			pe.Bitfield = bf
 		}
+		t.handleSuperSeedBitfield(pe)
 		t.updateInterestedState(pe)
 		t.startPieceDownloaderFor(pe)
 	case peerprotocol.HaveAllMessage:
@@ -169,7 +189,12 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 			for _, pi := range t.pieces {
 				t.piecePicker.HandleHave(pe, pi.Index)
 			}
+		} else {
+			for i := uint32(0); i < t.info.NumPieces; i++ {
+				pe.Bitfield.Set(i)
+			}
 		}
+		t.handleSuperSeedBitfield(pe)
 		t.updateInterestedState(pe)
 		t.startPieceDownloaderFor(pe)
 	case peerprotocol.HaveNoneMessage:
@@ -250,14 +275,14 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 		if pe.ClientChoking {
 			if pe.FastEnabled {
 				if pe.SentAllowedFast.Has(pi) {
-					pe.SendPiece(msg, cachedpiece.New(pi, t.session.pieceCache, t.session.config.ReadCacheBlockSize, t.peerID))
+					pe.SendPiece(msg, t.newPieceReader(pi))
 				} else {
 					m := peerprotocol.RejectMessage{RequestMessage: msg}
 					pe.SendMessage(m)
 				}
 			}
 		} else {
-			pe.SendPiece(msg, cachedpiece.New(pi, t.session.pieceCache, t.session.config.ReadCacheBlockSize, t.peerID))
+			pe.SendPiece(msg, t.newPieceReader(pi))
 		}
 	case peerprotocol.RejectMessage:
 		if t.pieces == nil || t.bitfield == nil {
@@ -334,8 +359,15 @@ func (t *torrent) handlePeerMessage(pm peer.Message) {
 				}
 			}
 		}
+		if msg.UploadOnly != 0 {
+			t.handleUploadOnly(pe, true)
+		}
 	case peerprotocol.ExtensionMetadataMessage:
 		t.handleMetadataMessage(pe, msg)
+	case peerprotocol.ExtensionUploadOnlyMessage:
+		t.handleUploadOnly(pe, msg.UploadOnly)
+	case peerprotocol.ExtensionDontHaveMessage:
+		t.handleDontHave(pe, msg)
 	case peerprotocol.ExtensionPEXMessage:
 		if !t.session.config.PEXEnabled {
 			break
@@ -366,7 +398,7 @@ func (t *torrent) updateInterestedState(pe *peer.Peer) {
 		for i := uint32(0); i < t.bitfield.Len(); i++ {
 			weHave := t.bitfield.Test(i)
 			peerHave := pe.Bitfield.Test(i)
-			if !weHave && peerHave {
+			if !weHave && peerHave && !t.pieces[i].Skip {
 				interested = true
 				break
 			}
@@ -97,6 +97,7 @@ func (t *torrent) handleMetadataMessage(pe *peer.Peer, msg peerprotocol.Extensio
 			t.stop(fmt.Errorf("cannot write resume info: %s", err))
 			break
 		}
+		t.setPreviousFilePriorities()
 		select {
 		case <-t.completeMetadataC:
 		default:
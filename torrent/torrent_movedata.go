@@ -0,0 +1,115 @@
+package torrent
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/ganqierwu/rain/internal/datamover"
+)
+
+// rootDir returns the root directory of the storage. It is safe to call from any goroutine.
+func (t *torrent) rootDir() string {
+	t.mStorage.RLock()
+	defer t.mStorage.RUnlock()
+	return t.storage.RootDir()
+}
+
+func (t *torrent) handleMoveDataCommand(req moveDataRequest) {
+	if t.moveDataRequest != nil {
+		req.Response <- errors.New("data is already being moved")
+		return
+	}
+	if t.storage.RootDir() == "" {
+		req.Response <- errors.New("torrent storage is not on disk")
+		return
+	}
+	t.moveDataRequest = &req
+	switch t.status() {
+	case Stopped:
+		t.startDataMover()
+	case Stopping:
+		// Data mover is started after stopped event is announced.
+	default:
+		t.restartAfterMove = true
+		t.stop(nil)
+	}
+}
+
+func (t *torrent) startDataMover() {
+	dir := t.moveDataRequest.Dir
+	if t.info == nil {
+		// Files are not created before metadata is downloaded.
+		t.handleDataMoveDone(nil)
+		return
+	}
+	src := filepath.Join(t.storage.RootDir(), t.info.Name)
+	dst := filepath.Join(dir, t.info.Name)
+	if src == dst {
+		t.handleDataMoveDone(nil)
+		return
+	}
+	t.log.Infof("moving data from %q to %q", src, dst)
+	t.bytesMoved = 0
+	t.dataMover = datamover.New()
+	go t.dataMover.Run(src, dst, t.dataMoverProgressC, t.dataMoverResultC)
+}
+
+func (t *torrent) handleDataMoverDone(dm *datamover.DataMover) {
+	if t.dataMover != dm {
+		panic("invalid data mover")
+	}
+	t.dataMover = nil
+	if dm.Error != nil {
+		t.log.Errorf("cannot move data: %s", dm.Error)
+	}
+	t.handleDataMoveDone(dm.Error)
+}
+
+// handleDataMoveDone switches the storage to the new directory if files are moved successfully,
+// replies the pending request and starts the torrent again if it was running before the move.
+func (t *torrent) handleDataMoveDone(err error) {
+	req := t.moveDataRequest
+	t.moveDataRequest = nil
+	t.bytesMoved = 0
+	if err == nil {
+		err = t.setDataDir(req.Dir)
+	}
+	req.Response <- err
+
+	restart := t.restartAfterMove
+	t.restartAfterMove = false
+	if t.doVerify {
+		t.bitfield = nil
+		t.start()
+	} else if restart {
+		// Bitfield is kept, so the files at the new location are not verified again.
+		t.start()
+	}
+}
+
+func (t *torrent) setDataDir(dir string) error {
+	sto, err := t.session.newStorage(t.id, dir)
+	if err != nil {
+		return err
+	}
+	t.closeStorage()
+	t.mStorage.Lock()
+	t.storage = sto
+	t.dest = dir
+	t.mStorage.Unlock()
+	// Files are moved to a directory chosen by the user.
+	t.destOwned = false
+	t.log.Infof("data dir is changed to %q", dir)
+	return t.session.resumer.WriteDest(t.id, dir)
+}
+
+func (t *torrent) stopDataMover() {
+	if t.dataMover != nil {
+		t.dataMover.Close()
+		t.dataMover = nil
+	}
+	if t.moveDataRequest != nil {
+		t.moveDataRequest.Response <- errClosed
+		t.moveDataRequest = nil
+	}
+}
@@ -0,0 +1,74 @@
+package torrent
+
+import (
+	"os"
+
+	"github.com/ganqierwu/rain/internal/bitfield"
+	"github.com/ganqierwu/rain/internal/datareuse"
+	"github.com/ganqierwu/rain/internal/metainfo"
+)
+
+// previousVersionSources returns the files of the previous version of the mutable torrent that can be reused by the new version.
+func (t *torrent) previousVersionSources() []datareuse.Source {
+	pv := t.previousVersion
+	if pv == nil || len(pv.Info) == 0 || len(pv.Bitfield) == 0 {
+		return nil
+	}
+	info, err := metainfo.NewInfo(pv.Info)
+	if err != nil {
+		t.log.Warningln("cannot parse info of previous version:", err)
+		return nil
+	}
+	bf, err := bitfield.NewBytes(pv.Bitfield, info.NumPieces)
+	if err != nil {
+		t.log.Warningln("cannot parse bitfield of previous version:", err)
+		return nil
+	}
+	return []datareuse.Source{{Info: info, Bitfield: bf, Dir: pv.Dir}}
+}
+
+// setPreviousFilePriorities copies the priorities of the files that have the same path in the previous version of the mutable torrent.
+// Called when the metadata of the new version is received.
+func (t *torrent) setPreviousFilePriorities() {
+	pv := t.previousVersion
+	if pv == nil || len(pv.FilePriorities) == 0 || t.filePriorities != nil {
+		return
+	}
+	var found bool
+	priorities := make([]FilePriority, len(t.info.Files))
+	for i, f := range t.info.Files {
+		if p, ok := pv.FilePriorities[f.Path]; ok {
+			priorities[i] = FilePriority(p)
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+	t.filePriorities = priorities
+	err := t.session.resumer.WriteFilePriorities(t.id, t.rawFilePriorities())
+	if err != nil {
+		t.log.Errorln("cannot write file priorities:", err)
+	}
+}
+
+// removePreviousVersion deletes the files of the previous version of the mutable torrent after the new version is completed.
+func (t *torrent) removePreviousVersion() {
+	pv := t.previousVersion
+	t.previousVersion = nil
+	err := t.session.resumer.WritePreviousVersion(t.id, nil)
+	if err != nil {
+		t.log.Errorln("cannot write resume data:", err)
+	}
+	if pv.RemovePath == "" {
+		t.log.Infof("new version is completed, keeping files of previous version %x in %s", pv.InfoHash, pv.Dir)
+		return
+	}
+	t.log.Infof("new version is completed, removing files of previous version %x in %s", pv.InfoHash, pv.RemovePath)
+	go func() {
+		err := os.RemoveAll(pv.RemovePath)
+		if err != nil {
+			t.log.Errorf("cannot remove files of previous version: %s", err)
+		}
+	}()
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/ganqierwu/rain/internal/peer"
 	"github.com/ganqierwu/rain/internal/peerprotocol"
 	"github.com/ganqierwu/rain/internal/peersource"
+	"github.com/ganqierwu/rain/internal/requestqueue"
 	"github.com/ganqierwu/rain/internal/resolver"
 )
 
@@ -80,13 +81,25 @@ func (t *torrent) handleNewPeers(addrs []*net.TCPAddr, source peersource.Source)
 func (t *torrent) filterBannedIPs(a []*net.TCPAddr) []*net.TCPAddr {
 	b := a[:0]
 	for _, x := range a {
-		if _, ok := t.bannedPeerIPs[x.IP.String()]; !ok {
+		if !t.isBanned(x.IP) {
 			b = append(b, x)
 		}
 	}
 	return b
 }
 
+func (t *torrent) handleDisconnectPeers(match func(pe *peer.Peer) bool) int {
+	var n int
+	for pe := range t.peers {
+		if match(pe) {
+			pe.Logger().Info("disconnecting peer")
+			t.closePeer(pe)
+			n++
+		}
+	}
+	return n
+}
+
 func (t *torrent) dialAddresses() {
 	if t.completed {
 		return
@@ -94,7 +107,7 @@ func (t *torrent) dialAddresses() {
 	peersConnected := func() int {
 		return len(t.outgoingPeers) + len(t.outgoingHandshakers)
 	}
-	for peersConnected() < t.session.config.MaxPeerDial {
+	for peersConnected() < t.session.maxPeerDialLimit() {
 		addr, src := t.addrList.Pop()
 		if addr == nil {
 			t.setNeedMorePeers(true)
@@ -104,6 +117,9 @@ func (t *torrent) dialAddresses() {
 		if _, ok := t.connectedPeerIPs[ip]; ok {
 			continue
 		}
+		if t.isBanned(addr.IP) {
+			continue
+		}
 		h := outgoinghandshaker.New(addr, src)
 		t.outgoingHandshakers[h] = struct{}{}
 		t.connectedPeerIPs[ip] = struct{}{}
@@ -140,7 +156,8 @@ func (t *torrent) startPeer(
 	}
 	t.peerIDs[peerID] = struct{}{}
 
-	pe := peer.New(conn, source, peerID, extensions, cipher, t.session.config.PieceReadTimeout, t.session.config.RequestTimeout, t.session.config.MaxRequestsIn, t.session.bucketDownload, t.session.bucketUpload)
+	rq := requestqueue.New(t.session.config.DefaultRequestsOut, t.session.config.MinRequestsOut, t.session.config.MaxRequestsOut, t.session.config.RequestTimeout)
+	pe := peer.New(conn, source, peerID, extensions, cipher, t.session.config.PieceReadTimeout, t.session.config.MaxRequestsIn, rq, t.limitDownload, t.limitUpload)
 	t.peers[pe] = struct{}{}
 	peers[pe] = struct{}{}
 	if t.info != nil {
@@ -154,7 +171,13 @@ func (t *torrent) startPeer(
 
 func (t *torrent) sendFirstMessage(p *peer.Peer) {
 	bf := t.bitfield
+	superSeeding := t.superSeeding()
 	switch {
+	case superSeeding:
+		// Pieces are revealed one by one after the first messages.
+		if p.FastEnabled {
+			p.SendMessage(peerprotocol.HaveNoneMessage{})
+		}
 	case p.FastEnabled && bf != nil && bf.All():
 		msg := peerprotocol.HaveAllMessage{}
 		p.SendMessage(msg)
@@ -172,7 +195,7 @@ func (t *torrent) sendFirstMessage(p *peer.Peer) {
 		metadataSize = uint32(len(t.info.Bytes))
 	}
 	if p.ExtensionsEnabled {
-		extHandshakeMsg := peerprotocol.NewExtensionHandshake(metadataSize, t.getClientVersion(), p.Addr().IP, t.session.config.MaxRequestsIn)
+		extHandshakeMsg := peerprotocol.NewExtensionHandshake(metadataSize, t.getClientVersion(), p.Addr().IP, t.session.config.MaxRequestsIn, t.completed)
 		msg := peerprotocol.ExtensionMessage{
 			ExtendedMessageID: peerprotocol.ExtensionIDHandshake,
 			Payload:           extHandshakeMsg,
@@ -186,6 +209,9 @@ func (t *torrent) sendFirstMessage(p *peer.Peer) {
 	if p.FastEnabled && t.pieces != nil {
 		p.GenerateAndSendAllowedFastMessages(t.session.config.AllowedFastSet, t.info.NumPieces, t.infoHash, t.pieces)
 	}
+	if superSeeding {
+		t.startSuperSeeding(p)
+	}
 }
 
 func (t *torrent) getClientVersion() string {
@@ -214,6 +240,7 @@ func (t *torrent) handlePeerSnubbed(pe *peer.Peer) {
 			return
 		}
 		pe.Snubbed = true
+		pe.RequestQueue.TimedOut()
 		t.pieceDownloadersSnubbed[pe] = pd
 		if t.piecePicker != nil {
 			t.piecePicker.HandleSnubbed(pe, pd.Piece.Index)
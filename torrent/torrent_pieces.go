@@ -7,18 +7,38 @@ import (
 )
 
 func (t *torrent) writeBitfield() error {
-	err := t.session.resumer.WriteBitfield(t.id, t.bitfield.Bytes())
+	err := t.syncFiles()
+	if err != nil {
+		t.log.Errorf("cannot sync files: %s", err)
+		return err
+	}
+	err = t.session.resumer.WriteBitfield(t.id, t.bitfield.Bytes())
 	if err != nil {
 		t.log.Errorf("cannot write bitfield to resume db: %s", err)
 	}
 	return err
 }
 
+// syncFiles flushes the written pieces to disk. Can be called from any goroutine.
+func (t *torrent) syncFiles() error {
+	t.mDirty.Lock()
+	defer t.mDirty.Unlock()
+	if len(t.dirtySections) == 0 {
+		return nil
+	}
+	err := t.session.writeQueue.Sync(t.dirtySections)
+	if err != nil {
+		return err
+	}
+	t.dirtySections = nil
+	return nil
+}
+
 func (t *torrent) checkCompletion() bool {
 	if t.completed {
 		return true
 	}
-	if !t.bitfield.All() {
+	if !t.haveWantedPieces() {
 		return false
 	}
 	t.completed = true
@@ -41,6 +61,11 @@ func (t *torrent) checkCompletion() bool {
 		pd.CancelPending()
 	}
 	t.piecePicker = nil
+	if t.previousVersion != nil {
+		t.removePreviousVersion()
+	}
+	t.applyFileAttributes()
+	t.updateUploadOnly()
 	t.updateSeedDuration(time.Now())
 	if !t.completeCmdRun && len(t.session.config.OnCompleteCmd) > 0 {
 		go t.session.runOnCompleteCmd(t)
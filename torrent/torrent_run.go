@@ -28,6 +28,8 @@ func (t *torrent) run() {
 			t.setNeedMorePeers(true)
 		case <-t.verifyCommandC:
 			t.handleVerifyCommand()
+		case req := <-t.moveDataCommandC:
+			t.handleMoveDataCommand(req)
 		case <-t.announcersStoppedC:
 			t.handleStopped()
 		case cmd := <-t.notifyErrorCommandC:
@@ -40,16 +42,34 @@ func (t *torrent) run() {
 			req.Response <- t.getTrackers()
 		case req := <-t.peersCommandC:
 			req.Response <- t.getPeers()
+		case req := <-t.disconnectPeersCommandC:
+			req.Response <- t.handleDisconnectPeers(req.Match)
 		case req := <-t.webseedsCommandC:
 			req.Response <- t.getWebseeds()
+		case req := <-t.filesCommandC:
+			req.Response <- t.getFiles()
+		case req := <-t.piecesCommandC:
+			req.Response <- t.getPieces()
+		case req := <-t.setFilePriorityCommandC:
+			req.Response <- t.handleSetFilePriority(req.Index, req.Priority)
+		case req := <-t.setSuperSeedCommandC:
+			req.Response <- t.handleSetSuperSeed(req.Enabled)
 		case p := <-t.allocatorProgressC:
 			t.bytesAllocated = p.AllocatedSize
 		case al := <-t.allocatorResultC:
 			t.handleAllocationDone(al)
+		case dr := <-t.dataReuseResultC:
+			t.handleDataReuseDone(dr)
+		case req := <-t.dataReuseSourceCommandC:
+			req.Response <- t.dataReuseSource()
 		case p := <-t.verifierProgressC:
 			t.checkedPieces = p.Checked
 		case ve := <-t.verifierResultC:
 			t.handleVerificationDone(ve)
+		case p := <-t.dataMoverProgressC:
+			t.bytesMoved = p.MovedSize
+		case dm := <-t.dataMoverResultC:
+			t.handleDataMoverDone(dm)
 		case data := <-t.ramNotifyC:
 			t.startSinglePieceDownloader(data)
 		case addrs := <-t.addrsFromTrackers:
@@ -68,6 +88,8 @@ func (t *torrent) run() {
 			t.startPieceDownloaderForWebseed(src)
 		case pw := <-t.pieceWriterResultC:
 			t.handlePieceWriteDone(pw)
+		case i := <-t.pieceLostC:
+			t.handlePieceLost(i)
 		case now := <-t.seedDurationTicker.C:
 			t.updateSeedDuration(now)
 		case pe := <-t.peerSnubbedC:
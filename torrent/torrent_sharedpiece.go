@@ -0,0 +1,48 @@
+package torrent
+
+import (
+	"github.com/ganqierwu/rain/internal/bufferpool"
+	"github.com/ganqierwu/rain/internal/peer"
+	"github.com/ganqierwu/rain/internal/piece"
+	"github.com/ganqierwu/rain/internal/piecedownloader"
+)
+
+// sharedPieceBuffer returns the buffer of the piece that is being downloaded from multiple peers.
+// A new buffer is returned if the peer is the first one downloading the piece.
+func (t *torrent) sharedPieceBuffer(pi *piece.Piece) bufferpool.Buffer {
+	for _, pe := range t.piecePicker.RequestedPeers(pi.Index) {
+		if pd, ok := t.pieceDownloaders[pe]; ok && pd.Blocks != nil {
+			return pd.Buffer
+		}
+	}
+	return t.piecePool.Get(int(pi.Length))
+}
+
+// blockSources returns the IP addresses of the peers that have sent the blocks of a piece downloaded from multiple peers.
+func (t *torrent) blockSources(pd *piecedownloader.PieceDownloader) []string {
+	peers := t.piecePicker.Blocks(pd.Piece.Index).Sources()
+	sources := make([]string, len(peers))
+	for i, pe := range peers {
+		if pe != nil {
+			sources[i] = pe.(*peer.Peer).IP()
+		}
+	}
+	return sources
+}
+
+// closePieceDownloadersOf stops the downloads of the piece from other peers after all blocks are received
+// and starts downloading other pieces from them.
+// Duplicate downloads in endgame are stopped too, so the piece is not completed again while it is being written.
+func (t *torrent) closePieceDownloadersOf(index uint32) {
+	peers := append([]*peer.Peer(nil), t.piecePicker.RequestedPeers(index)...)
+	for _, pe := range peers {
+		pd, ok := t.pieceDownloaders[pe]
+		if !ok {
+			continue
+		}
+		t.closePieceDownloader(pd)
+		pd.CancelPending()
+		pe.StopSnubTimer()
+		t.startPieceDownloaderFor(pe)
+	}
+}
@@ -0,0 +1,59 @@
+package torrent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/logger"
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/ganqierwu/rain/internal/peerprotocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedPieceReservesRAMOnce(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+	// Each peer can request only half of the blocks, so the second peer joins the piece of the first peer.
+	s.config.MultiPeerPieceLength = 64 << 10
+	s.config.DefaultRequestsOut = 2
+	s.config.MinRequestsOut = 2
+
+	src := filepath.Join(t.TempDir(), "content")
+	err := os.WriteFile(src, bytes.Repeat([]byte{1}, 2*64<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := metainfo.NewInfoBytes("", []string{src}, false, 64<<10, "", false, nil, logger.New("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := metainfo.NewBytes(info, nil, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, err := s.AddTorrent(bytes.NewReader(b), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Acceptor is started after files are allocated.
+	for tor.Stats().Status != Downloading {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, ip := range []string{"127.0.0.1", "127.0.0.2"} {
+		conn := dialTestPeer(t, tor, ip)
+		defer conn.Close()
+		sendTestMessage(t, conn, peerprotocol.Bitfield, []byte{0xc0})
+		sendTestMessage(t, conn, peerprotocol.Unchoke, nil)
+		for {
+			id, _ := readTestMessage(t, conn)
+			if id == peerprotocol.Request {
+				break
+			}
+		}
+	}
+	assert.Equal(t, int64(64<<10), s.ram.Stats().AllocatedSize)
+}
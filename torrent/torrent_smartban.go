@@ -0,0 +1,81 @@
+package torrent
+
+import (
+	"net"
+
+	"github.com/ganqierwu/rain/internal/peer"
+	"github.com/ganqierwu/rain/internal/piecewriter"
+)
+
+// handleCorruptPiece finds out the peers that have sent the corrupt blocks of a piece and bans them.
+func (t *torrent) handleCorruptPiece(pw *piecewriter.PieceWriter, pe *peer.Peer) {
+	sources := pw.BlockSources
+	if sources == nil {
+		// All blocks of a piece are downloaded from a single peer.
+		sources = make([]string, len(pw.BlockHashes))
+		for i := range sources {
+			sources[i] = pe.IP()
+		}
+	}
+	var suspects []string
+	seen := make(map[string]struct{})
+	for _, ip := range sources {
+		if _, ok := seen[ip]; !ok {
+			seen[ip] = struct{}{}
+			suspects = append(suspects, ip)
+		}
+	}
+	if len(suspects) == 1 && t.smartBan.FailedBy(pw.Piece.Index, suspects[0]) {
+		// Peer has sent corrupt blocks of the piece before and all blocks of this copy.
+		t.banPeerIP(suspects[0])
+	}
+	// Keep the blocks until the piece is downloaded again, then compare them to find out the culprit.
+	t.smartBan.Failed(pw.Piece.Index, sources, pw.BlockHashes)
+	if t.piecePicker == nil {
+		return
+	}
+	// Download the piece again from other peers.
+	t.piecePicker.HandleCorrupt(pw.Piece.Index, suspects)
+	if len(suspects) > 1 {
+		// Download pieces from single peers meanwhile, so the bad peer cannot corrupt more pieces shared with good peers.
+		t.piecePicker.SetMultiPeer(false)
+	}
+}
+
+// handleVerifiedPiece bans the peers that have sent corrupt blocks of a piece that failed the hash check before.
+func (t *torrent) handleVerifiedPiece(pw *piecewriter.PieceWriter) {
+	if t.piecePicker != nil {
+		t.piecePicker.HandleVerified(pw.Piece.Index)
+	}
+	if !t.smartBan.Waiting(pw.Piece.Index) {
+		return
+	}
+	for _, ip := range t.smartBan.Verified(pw.Piece.Index, pw.BlockHashes) {
+		t.banPeerIP(ip)
+	}
+	if t.smartBan.Len() == 0 && t.piecePicker != nil {
+		t.piecePicker.SetMultiPeer(true)
+	}
+}
+
+// banPeerIP closes the connections to the IP and prevents new connections to it in this torrent.
+func (t *torrent) banPeerIP(ip string) {
+	if _, ok := t.bannedPeerIPs[ip]; ok {
+		return
+	}
+	t.log.Infoln("banned peer for sending corrupt data:", ip)
+	t.bannedPeerIPs[ip] = struct{}{}
+	for pe := range t.peers {
+		if pe.IP() == ip {
+			t.closePeer(pe)
+		}
+	}
+	t.session.addSmartBanStrike(ip)
+}
+
+func (t *torrent) isBanned(ip net.IP) bool {
+	if _, ok := t.bannedPeerIPs[ip.String()]; ok {
+		return true
+	}
+	return t.session.isBanned(t.id, ip)
+}
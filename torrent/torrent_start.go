@@ -22,6 +22,12 @@ func (t *torrent) start() {
 		return
 	}
 
+	// Start after data is moved.
+	if t.moveDataRequest != nil {
+		t.restartAfterMove = true
+		return
+	}
+
 	// Stop announcing Stopped event if in "Stopping" state.
 	if t.stoppedEventAnnouncer != nil {
 		t.stoppedEventAnnouncer.Close()
@@ -71,6 +77,11 @@ func (t *torrent) startAllocator() {
 	if t.allocator != nil {
 		panic("allocator exists")
 	}
+	if t.shouldReuseData() {
+		// Allocator is started after data reuse is done.
+		t.startDataReuse()
+		return
+	}
 	t.allocator = allocator.New()
 	go t.allocator.Run(t.info, t.storage, t.allocatorProgressC, t.allocatorResultC)
 }
@@ -127,7 +138,7 @@ func (t *torrent) startInfoDownloaders() {
 	if t.info != nil {
 		return
 	}
-	for len(t.infoDownloaders)-len(t.infoDownloadersSnubbed) < t.session.config.ParallelMetadataDownloads {
+	for len(t.infoDownloaders)-len(t.infoDownloadersSnubbed) < t.session.parallelMetadataDownloadsLimit() {
 		id := t.nextInfoDownload()
 		if id == nil {
 			break
@@ -159,7 +170,7 @@ func (t *torrent) startPieceDownloaders() {
 }
 
 func (t *torrent) startPieceDownloaderForWebseed(src *webseedsource.WebseedSource) (started bool) {
-	if t.webseedActiveDownloads >= t.session.config.WebseedMaxDownloads {
+	if t.webseedActiveDownloads >= t.session.webseedMaxDownloadsLimit() {
 		return false
 	}
 	if t.status() != Downloading {
@@ -176,7 +187,12 @@ func (t *torrent) startPieceDownloaderForWebseed(src *webseedsource.WebseedSourc
 
 func (t *torrent) startWebseedDownloader(sp *piecepicker.WebseedDownloadSpec) {
 	t.log.Debugf("downloading pieces %d-%d from webseed %s", sp.Begin, sp.End, sp.Source.URL)
-	ud := urldownloader.New(sp.Source.URL, sp.Begin, sp.End, t.session.bucketDownload)
+	var ud *urldownloader.URLDownloader
+	if sp.Source.HTTPSeed {
+		ud = urldownloader.NewHTTPSeed(sp.Source.URL, t.infoHash, sp.Begin, sp.End, t.limitDownload)
+	} else {
+		ud = urldownloader.New(sp.Source.URL, sp.Begin, sp.End, t.limitDownload)
+	}
 	for _, src := range t.webseedSources {
 		if src != sp.Source {
 			continue
@@ -221,7 +237,17 @@ func (t *torrent) startSinglePieceDownloader(pe *peer.Peer) {
 	if pi == nil {
 		return
 	}
-	pd := piecedownloader.New(pi, pe, allowedFast, t.piecePool.Get(int(pi.Length)))
+	var pd *piecedownloader.PieceDownloader
+	if blocks := t.piecePicker.Blocks(pi.Index); blocks != nil {
+		pd = piecedownloader.NewShared(pi, pe, allowedFast, t.sharedPieceBuffer(pi), blocks)
+		t.sharedPieceDownloaders[pi.Index]++
+		if t.sharedPieceDownloaders[pi.Index] > 1 && t.session.ram != nil {
+			// RAM is already reserved for the shared buffer by the first downloader of the piece.
+			t.session.ram.Release(int64(t.info.PieceLength))
+		}
+	} else {
+		pd = piecedownloader.New(pi, pe, allowedFast, t.piecePool.Get(int(pi.Length)))
+	}
 	if _, ok := t.pieceDownloaders[pe]; ok {
 		panic("peer already has a piece downloader")
 	}
@@ -233,13 +259,12 @@ func (t *torrent) startSinglePieceDownloader(pe *peer.Peer) {
 	started = true
 }
 
+// maxAllowedRequests returns the number of outstanding requests to the peer.
+// The adaptive queue depth of the peer cannot exceed the `reqq` value in extension handshake.
 func (t *torrent) maxAllowedRequests(pe *peer.Peer) int {
-	ret := t.session.config.DefaultRequestsOut
-	if pe.ExtensionHandshake != nil && pe.ExtensionHandshake.RequestQueue > 0 {
+	ret := pe.RequestQueue.Depth()
+	if pe.ExtensionHandshake != nil && pe.ExtensionHandshake.RequestQueue > 0 && ret > pe.ExtensionHandshake.RequestQueue {
 		ret = pe.ExtensionHandshake.RequestQueue
 	}
-	if ret > t.session.config.MaxRequestsOut {
-		ret = t.session.config.MaxRequestsOut
-	}
 	return ret
 }
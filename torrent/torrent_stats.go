@@ -1,6 +1,7 @@
 package torrent
 
 import (
+	"sort"
 	"time"
 
 	"github.com/ganqierwu/rain/internal/mse"
@@ -48,6 +49,9 @@ type Stats struct {
 		Wasted int64
 		// Bytes allocated on storage.
 		Allocated int64
+		// Bytes copied to the new data directory when torrent is in "Moving" state.
+		// It stays zero if files are moved by renaming.
+		Moved int64
 	}
 	Peers struct {
 		// Number of peers that are connected, handshaked and ready to send and receive messages.
@@ -56,6 +60,8 @@ type Stats struct {
 		Incoming int
 		// Number of peers that we have connected to.
 		Outgoing int
+		// Number of IPs that are banned for sending corrupt data.
+		Banned int
 	}
 	Handshakes struct {
 		// Number of peers that are not handshaked yet.
@@ -101,6 +107,10 @@ type Stats struct {
 	PieceLength uint32
 	// Duration while the torrent is in Seeding status.
 	SeededFor time.Duration
+	// Is super-seeding mode enabled?
+	SuperSeeding bool
+	// Identity of the trusted signer of the torrent (BEP 35). Empty if the torrent is not signed.
+	Signer string
 	// Speed is calculated as 1-minute moving average.
 	Speed struct {
 		// Downloaded bytes per second.
@@ -130,6 +140,7 @@ func (t *torrent) stats() Stats {
 	s.Peers.Total = len(t.peers)
 	s.Peers.Incoming = len(t.incomingPeers)
 	s.Peers.Outgoing = len(t.outgoingPeers)
+	s.Peers.Banned = len(t.bannedPeerIPs)
 	s.MetadataDownloads.Total = len(t.infoDownloaders)
 	s.MetadataDownloads.Snubbed = len(t.infoDownloadersSnubbed)
 	s.MetadataDownloads.Running = len(t.infoDownloaders) - len(t.infoDownloadersSnubbed)
@@ -142,7 +153,10 @@ func (t *torrent) stats() Stats {
 	s.Bytes.Uploaded = t.bytesUploaded.Count()
 	s.Bytes.Wasted = t.bytesWasted.Count()
 	s.SeededFor = time.Duration(t.seededFor.Count())
+	s.SuperSeeding = t.superSeed
+	s.Signer = t.signer
 	s.Bytes.Allocated = t.bytesAllocated
+	s.Bytes.Moved = t.bytesMoved
 	s.Pieces.Checked = t.checkedPieces
 	s.Speed.Download = int(t.downloadSpeed.Rate1())
 	s.Speed.Upload = int(t.uploadSpeed.Rate1())
@@ -266,6 +280,24 @@ func (t *torrent) getPeers() []Peer {
 			Source:             source,
 			DownloadSpeed:      pe.DownloadSpeed(),
 			UploadSpeed:        pe.UploadSpeed(),
+			BytesDownloaded:    pe.BytesDownloaded(),
+			BytesUploaded:      pe.BytesUploaded(),
+			RequestsIn:         pe.QueuedRequests(),
+			RequestQueue:       t.maxAllowedRequests(pe),
+			Latency:            pe.RequestQueue.Latency(),
+			FastExtension:      pe.FastEnabled,
+		}
+		if pe.Bitfield != nil {
+			p.Pieces = pe.Bitfield.Count()
+		}
+		if pd, ok := t.pieceDownloaders[pe]; ok {
+			p.RequestsOut = pd.Pending()
+		}
+		if pe.ExtensionHandshake != nil {
+			for name := range pe.ExtensionHandshake.M {
+				p.Extensions = append(p.Extensions, name)
+			}
+			sort.Strings(p.Extensions)
 		}
 		peers = append(peers, p)
 	}
@@ -277,6 +309,7 @@ func (t *torrent) getWebseeds() []Webseed {
 	for _, src := range t.webseedSources {
 		ws := Webseed{
 			URL:           src.URL,
+			HTTPSeed:      src.HTTPSeed,
 			Error:         src.LastError,
 			DownloadSpeed: int(src.DownloadSpeed.Rate1()),
 		}
@@ -20,6 +20,8 @@ const (
 	Seeding
 	// Stopping the torrent. This is the status after Stop() is called. All peers are disconnected and files are closed. A stop event sent to all trackers. After trackers responded the torrent switches into Stopped state.
 	Stopping
+	// Moving files of the torrent to another directory. The torrent is stopped while the files are being moved.
+	Moving
 )
 
 func (s Status) String() string {
@@ -31,17 +33,20 @@ func (s Status) String() string {
 		Downloading:         "Downloading",
 		Seeding:             "Seeding",
 		Stopping:            "Stopping",
+		Moving:              "Moving",
 	}
 	return m[s]
 }
 
 func (t *torrent) status() Status {
 	switch {
+	case t.dataMover != nil:
+		return Moving
 	case t.errC == nil:
 		return Stopped
 	case t.stoppedEventAnnouncer != nil:
 		return Stopping
-	case t.allocator != nil:
+	case t.allocator != nil, t.dataReuse != nil:
 		return Allocating
 	case t.verifier != nil:
 		return Verifying
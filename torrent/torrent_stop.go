@@ -1,6 +1,8 @@
 package torrent
 
 import (
+	"io"
+
 	"github.com/ganqierwu/rain/internal/announcer"
 	"github.com/ganqierwu/rain/internal/handshaker/incominghandshaker"
 	"github.com/ganqierwu/rain/internal/handshaker/outgoinghandshaker"
@@ -13,7 +15,9 @@ func (t *torrent) handleStopped() {
 	t.errC <- t.lastError
 	t.errC = nil
 	t.portC = nil
-	if t.doVerify {
+	if t.moveDataRequest != nil {
+		t.startDataMover()
+	} else if t.doVerify {
 		t.bitfield = nil
 		t.start()
 	} else {
@@ -39,6 +43,11 @@ func (t *torrent) stopAndSetStoppedOnMetadata() {
 
 func (t *torrent) stop(err error) {
 	s := t.status()
+	if s == Moving {
+		// Do not start again after data is moved.
+		t.restartAfterMove = false
+		return
+	}
 	if s == Stopping || s == Stopped {
 		return
 	}
@@ -68,6 +77,7 @@ func (t *torrent) stop(err error) {
 
 	// Closing data is necessary to cancel ongoing IO operations on files.
 	t.closeData()
+	t.stopDataReuse()
 	// Data must be closed before closing Allocator.
 	t.stopAllocator()
 	// Data must be closed before closing Verifier.
@@ -144,6 +154,10 @@ func (t *torrent) stopIncomingHandshakers() {
 
 func (t *torrent) closeData() {
 	t.log.Debugln("closing open files")
+	// Files must not be synced by the stats loop after they are closed.
+	t.mDirty.Lock()
+	defer t.mDirty.Unlock()
+	t.dirtySections = nil
 	for _, f := range t.files {
 		err := f.Storage.Close()
 		if err != nil {
@@ -155,6 +169,20 @@ func (t *torrent) closeData() {
 	t.piecePicker = nil
 	t.bytesAllocated = 0
 	t.checkedPieces = 0
+	t.closeStorage()
+}
+
+// closeStorage releases the resources of the storage if it implements io.Closer.
+// Storage must open the resources again when a file is opened on the next start.
+func (t *torrent) closeStorage() {
+	c, ok := t.storage.(io.Closer)
+	if !ok {
+		return
+	}
+	err := c.Close()
+	if err != nil {
+		t.log.Error(err)
+	}
 }
 
 func (t *torrent) stopPeriodicalAnnouncers() {
@@ -0,0 +1,155 @@
+package torrent
+
+import (
+	"github.com/ganqierwu/rain/internal/peer"
+	"github.com/ganqierwu/rain/internal/peerprotocol"
+)
+
+// Super-seeding mode is described in BEP 16.
+// The seed pretends to have no pieces and reveals a single piece to each peer.
+// The next piece is revealed to the peer after the revealed piece is seen in Have messages of other peers.
+// This way the seed uploads each piece once and the peers distribute the pieces among themselves.
+
+// noSuperSeedPiece is the value in torrent.superSeedPeers for peers that do not have a revealed piece.
+const noSuperSeedPiece = -1
+
+type setSuperSeedRequest struct {
+	Enabled  bool
+	Response chan error
+}
+
+// SetSuperSeed enables or disables the super-seeding mode.
+func (t *torrent) SetSuperSeed(enabled bool) error {
+	var err error
+	req := setSuperSeedRequest{Enabled: enabled, Response: make(chan error, 1)}
+	select {
+	case t.setSuperSeedCommandC <- req:
+	case <-t.closeC:
+	}
+	select {
+	case err = <-req.Response:
+	case <-t.closeC:
+	}
+	return err
+}
+
+func (t *torrent) handleSetSuperSeed(enabled bool) error {
+	if enabled == t.superSeed {
+		return nil
+	}
+	err := t.session.resumer.WriteSuperSeed(t.id, enabled)
+	if err != nil {
+		return err
+	}
+	t.superSeed = enabled
+	if enabled {
+		// Peers that are already connected have received our bitfield.
+		// Super-seeding applies to the peers that connect from now on.
+		return nil
+	}
+	// Reveal the rest of the pieces to peers that are in super-seeding mode.
+	for pe := range t.superSeedPeers {
+		for i := range t.pieces {
+			pi := &t.pieces[i]
+			if pi.Done && !pe.Bitfield.Test(pi.Index) {
+				pe.SendMessage(peerprotocol.HaveMessage{Index: pi.Index})
+			}
+		}
+		delete(t.superSeedPeers, pe)
+	}
+	return nil
+}
+
+// superSeeding returns true if newly connected peers must be served in super-seeding mode.
+func (t *torrent) superSeeding() bool {
+	return t.superSeed && t.completed && t.pieces != nil
+}
+
+// startSuperSeeding must be called after the first messages are sent to a newly connected peer.
+func (t *torrent) startSuperSeeding(pe *peer.Peer) {
+	t.superSeedPeers[pe] = noSuperSeedPiece
+	t.revealPiece(pe)
+}
+
+// revealPiece sends a Have message to the peer for a piece that is the least revealed and the rarest in the swarm.
+func (t *torrent) revealPiece(pe *peer.Peer) {
+	revealed := make(map[int]int)
+	for _, index := range t.superSeedPeers {
+		if index != noSuperSeedPiece {
+			revealed[index]++
+		}
+	}
+	// Piece picker may not exist if the torrent is complete, so availability is counted here.
+	having := make([]int, len(t.pieces))
+	for other := range t.peers {
+		if other.Bitfield == nil {
+			continue
+		}
+		for i := range having {
+			if other.Bitfield.Test(uint32(i)) {
+				having[i]++
+			}
+		}
+	}
+	current := t.superSeedPeers[pe]
+	selected := noSuperSeedPiece
+	for i := range t.pieces {
+		pi := &t.pieces[i]
+		if !pi.Done || pe.Bitfield.Test(pi.Index) || i == current {
+			continue
+		}
+		if selected == noSuperSeedPiece {
+			selected = i
+			continue
+		}
+		if revealed[i] != revealed[selected] {
+			if revealed[i] < revealed[selected] {
+				selected = i
+			}
+			continue
+		}
+		if having[i] < having[selected] {
+			selected = i
+		}
+	}
+	t.superSeedPeers[pe] = selected
+	if selected != noSuperSeedPiece {
+		pe.Logger().Debugln("super-seeding piece:", selected)
+		pe.SendMessage(peerprotocol.HaveMessage{Index: uint32(selected)})
+	}
+}
+
+// handleSuperSeedHave must be called when a peer announces that it has the piece with the index.
+func (t *torrent) handleSuperSeedHave(pe *peer.Peer, index uint32) {
+	for other, revealed := range t.superSeedPeers {
+		if revealed != int(index) {
+			continue
+		}
+		if other != pe || !t.canPropagate(pe, index) {
+			// The piece is spread to another peer, or there is no other peer to spread it.
+			t.revealPiece(other)
+		}
+	}
+}
+
+// handleSuperSeedBitfield must be called after the bitfield of the peer is received.
+// If the peer already has the revealed piece, another piece is revealed.
+func (t *torrent) handleSuperSeedBitfield(pe *peer.Peer) {
+	revealed, ok := t.superSeedPeers[pe]
+	if !ok {
+		return
+	}
+	if revealed == noSuperSeedPiece || pe.Bitfield.Test(uint32(revealed)) {
+		t.revealPiece(pe)
+	}
+}
+
+// canPropagate returns true if a peer other than pe is connected and does not have the piece with the index.
+func (t *torrent) canPropagate(pe *peer.Peer, index uint32) bool {
+	for other := range t.peers {
+		if other != pe && other.Bitfield != nil && !other.Bitfield.Test(index) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package torrent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/peerprotocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// dialTestPeer connects to the torrent from the local IP address and completes the handshake without any extensions.
+func dialTestPeer(t *testing.T, tor *Torrent, localIP string) net.Conn {
+	return dialTestPeerReserved(t, tor, localIP, [8]byte{})
+}
+
+// dialTestPeerReserved is like dialTestPeer but sends the reserved bytes in handshake to enable extensions.
+func dialTestPeerReserved(t *testing.T, tor *Torrent, localIP string, reserved [8]byte) net.Conn {
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(localIP)}, Timeout: timeout}
+	conn, err := d.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tor.Port())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ih := tor.InfoHash()
+	var peerID [20]byte
+	copy(peerID[:], localIP)
+	hs := append([]byte("\x13BitTorrent protocol"), reserved[:]...)
+	hs = append(hs, ih[:]...)
+	hs = append(hs, peerID[:]...)
+	_, err = conn.Write(hs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err = io.ReadFull(conn, make([]byte, len(hs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// readTestMessage returns the next message that is not a keep-alive.
+func readTestMessage(t *testing.T, conn net.Conn) (peerprotocol.MessageID, []byte) {
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		var length uint32
+		err := binary.Read(conn, binary.BigEndian, &length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if length == 0 {
+			continue
+		}
+		buf := make([]byte, length)
+		_, err = io.ReadFull(conn, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return peerprotocol.MessageID(buf[0]), buf[1:]
+	}
+}
+
+func readRevealedPiece(t *testing.T, conn net.Conn) uint32 {
+	id, payload := readTestMessage(t, conn)
+	if id != peerprotocol.Have {
+		t.Fatalf("unexpected message: %s", id)
+	}
+	return binary.BigEndian.Uint32(payload)
+}
+
+func sendTestHave(t *testing.T, conn net.Conn, index uint32) {
+	b := []byte{0, 0, 0, 5, byte(peerprotocol.Have), 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[5:], index)
+	_, err := conn.Write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSuperSeed(t *testing.T) {
+	s, closeSession := newTestSession(t)
+	defer closeSession()
+
+	src := filepath.Join(t.TempDir(), "content")
+	err := os.WriteFile(src, bytes.Repeat([]byte{1}, 4*32<<10), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, _, err := s.CreateTorrent([]string{src}, &CreateTorrentOptions{PieceLength: 32 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-tor.NotifyComplete():
+	case <-time.After(timeout):
+		t.Fatal("torrent is not completed")
+	}
+	err = tor.SetSuperSeed(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, tor.Stats().SuperSeeding)
+
+	// Each peer gets a different piece instead of a bitfield.
+	conn1 := dialTestPeer(t, tor, "127.0.0.1")
+	defer conn1.Close()
+	piece1 := readRevealedPiece(t, conn1)
+	conn2 := dialTestPeer(t, tor, "127.0.0.2")
+	defer conn2.Close()
+	piece2 := readRevealedPiece(t, conn2)
+	assert.NotEqual(t, piece1, piece2)
+
+	// When the second peer gets the piece of the first peer, a new piece is revealed to the first peer.
+	sendTestHave(t, conn2, piece1)
+	piece3 := readRevealedPiece(t, conn1)
+	assert.NotEqual(t, piece1, piece3)
+	assert.NotEqual(t, piece2, piece3)
+}
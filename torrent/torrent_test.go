@@ -219,7 +219,7 @@ func TestDownloadWebseed(t *testing.T) {
 	tor.torrent.webseedSources = webseedsource.NewList([]string{
 		"http://127.0.0.1:" + strconv.Itoa(port1),
 		"http://127.0.0.1:" + strconv.Itoa(port2),
-	})
+	}, nil)
 	tor.torrent.webseedClient = http.DefaultClient
 	tor.Start()
 	tor.AddPeer(addr)
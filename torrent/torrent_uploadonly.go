@@ -0,0 +1,29 @@
+package torrent
+
+import (
+	"github.com/ganqierwu/rain/internal/peer"
+)
+
+// Upload-only state is described in BEP 21.
+// The torrent is upload-only after all wanted pieces are downloaded.
+// If some files are skipped, the torrent is a partial seed and it is announced to trackers with "paused" event.
+
+// updateUploadOnly must be called after t.completed is changed.
+func (t *torrent) updateUploadOnly() {
+	t.mBitfield.Lock()
+	t.partialSeed = t.completed && t.bitfield != nil && !t.bitfield.All()
+	t.mBitfield.Unlock()
+	for pe := range t.peers {
+		pe.SendUploadOnly(t.completed)
+	}
+}
+
+// handleUploadOnly must be called when the peer tells its upload-only state.
+func (t *torrent) handleUploadOnly(pe *peer.Peer, value bool) {
+	pe.UploadOnly = value
+	if value && t.completed {
+		// Neither side is going to download from the other.
+		pe.Logger().Debugln("closing upload-only peer")
+		t.closePeer(pe)
+	}
+}
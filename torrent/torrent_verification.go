@@ -52,9 +52,10 @@ func (t *torrent) handleVerificationDone(ve *verifier.Verifier) {
 	}
 
 	// We may detect missing pieces after verification. Then, status must be set from Seeding to Downloading.
-	if !t.bitfield.All() {
+	if !t.haveWantedPieces() {
 		t.completed = false
 		t.completeC = make(chan struct{})
+		t.updateUploadOnly()
 	}
 
 	if t.doVerify {
@@ -1,6 +1,7 @@
 package torrent
 
 import (
+	"errors"
 	"time"
 
 	"github.com/ganqierwu/rain/internal/piecewriter"
@@ -14,6 +15,7 @@ func (t *torrent) handleWebseedPieceResult(msg *urldownloader.PieceResult) {
 		// Possible causes:
 		// * Client.Do error
 		// * Unexpected status code
+		// * HTTP seed is busy
 		// * Response.Body.Read error
 		t.disableSource(msg.Downloader.URL, msg.Error, true)
 		t.webseedActiveDownloads--
@@ -39,12 +41,10 @@ func (t *torrent) handleWebseedPieceResult(msg *urldownloader.PieceResult) {
 	}
 	piece.Writing = true
 
-	// Prevent receiving piece messages to avoid more than 1 write per torrent.
-	t.pieceMessagesC.Suspend()
-	t.webseedPieceResultC.Suspend()
-
-	pw := piecewriter.New(piece, msg.Downloader, msg.Buffer)
-	go pw.Run(t.pieceWriterResultC, t.doneC, t.session.metrics.WritesPerSecond, t.session.metrics.SpeedWrite, t.session.semWrite)
+	if t.piecePicker != nil {
+		t.closePieceDownloadersOf(piece.Index)
+	}
+	t.startPieceWriter(piecewriter.New(piece, msg.Downloader, msg.Buffer))
 
 	if msg.Done {
 		for _, src := range t.webseedSources {
@@ -69,15 +69,24 @@ func (t *torrent) disableSource(srcurl string, err error, retry bool) {
 		src.LastError = err
 		t.closeWebseedDownloader(src)
 		if retry {
-			go t.notifyWebseedRetry(src)
+			go t.notifyWebseedRetry(src, webseedRetryDelay(err))
 		}
 		break
 	}
 }
 
-func (t *torrent) notifyWebseedRetry(src *webseedsource.WebseedSource) {
+// webseedRetryDelay returns the duration that the HTTP seed asked for if it is busy.
+func webseedRetryDelay(err error) time.Duration {
+	var busy *urldownloader.BusyError
+	if errors.As(err, &busy) && busy.RetryAfter > 0 {
+		return busy.RetryAfter
+	}
+	return time.Minute
+}
+
+func (t *torrent) notifyWebseedRetry(src *webseedsource.WebseedSource, d time.Duration) {
 	select {
-	case <-time.After(time.Minute):
+	case <-time.After(d):
 		select {
 		case t.webseedRetryC <- src:
 		case <-t.closeC:
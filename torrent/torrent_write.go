@@ -10,11 +10,38 @@ import (
 	"github.com/ganqierwu/rain/internal/urldownloader"
 )
 
+// startPieceWriter checks the hash of the downloaded piece and writes it to disk in a new goroutine.
+func (t *torrent) startPieceWriter(pw *piecewriter.PieceWriter) {
+	pw.HashBlocks = t.smartBan.Waiting(pw.Piece.Index)
+	pw.Sync = t.session.config.BufferedWrites && t.session.config.SyncEveryPiece
+	t.bytesWriting += int64(len(pw.Buffer.Data))
+
+	// Prevent receiving piece messages to limit the memory used by the pieces waiting to be written.
+	if t.writeQueueFull() {
+		t.pieceMessagesC.Suspend()
+		t.webseedPieceResultC.Suspend()
+	}
+
+	go pw.Run(t.pieceWriterResultC, t.doneC, t.session.metrics.WritesPerSecond, t.session.metrics.SpeedWrite, t.session.writeQueue, t)
+}
+
+// writeQueueFull returns true if no more pieces can be written until the pending writes are done.
+// Without buffered writes, only 1 piece is written at a time per torrent.
+func (t *torrent) writeQueueFull() bool {
+	if !t.session.config.BufferedWrites {
+		return t.bytesWriting > 0
+	}
+	return t.bytesWriting >= t.session.config.WriteQueueSize
+}
+
 func (t *torrent) handlePieceWriteDone(pw *piecewriter.PieceWriter) {
 	pw.Piece.Writing = false
+	t.bytesWriting -= int64(len(pw.Buffer.Data))
 
-	t.pieceMessagesC.Resume()
-	t.webseedPieceResultC.Resume()
+	if !t.writeQueueFull() {
+		t.pieceMessagesC.Resume()
+		t.webseedPieceResultC.Resume()
+	}
 
 	pw.Buffer.Release()
 
@@ -23,8 +50,7 @@ func (t *torrent) handlePieceWriteDone(pw *piecewriter.PieceWriter) {
 		switch src := pw.Source.(type) {
 		case *peer.Peer:
 			t.log.Debugln("received corrupt piece from peer", src.String())
-			t.closePeer(src)
-			t.bannedPeerIPs[src.IP()] = struct{}{}
+			t.handleCorruptPiece(pw, src)
 		case *urldownloader.URLDownloader:
 			t.log.Debugln("received corrupt piece from webseed", src.URL)
 			t.disableSource(src.URL, errors.New("corrupt piece"), false)
@@ -39,10 +65,18 @@ func (t *torrent) handlePieceWriteDone(pw *piecewriter.PieceWriter) {
 		return
 	}
 
+	t.handleVerifiedPiece(pw)
+
 	pw.Piece.Done = true
 	if t.bitfield.Test(pw.Piece.Index) {
 		panic(fmt.Sprintf("already have the piece #%d", pw.Piece.Index))
 	}
+	if t.session.config.BufferedWrites && !pw.Sync {
+		// Piece must be flushed to disk before the bitfield containing it is saved.
+		t.mDirty.Lock()
+		t.dirtySections = append(t.dirtySections, pw.Piece.Data...)
+		t.mDirty.Unlock()
+	}
 	t.mBitfield.Lock()
 	t.bitfield.Set(pw.Piece.Index)
 	t.mBitfield.Unlock()
@@ -0,0 +1,93 @@
+package torrentfile
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ganqierwu/rain/internal/magnet"
+)
+
+// Magnet link contains the information to download torrent metadata from network.
+type Magnet struct {
+	InfoHash [20]byte
+	// Display name. Optional.
+	Name string
+	// Tiers of tracker URLs.
+	Trackers [][]string
+	// Addresses of peers in "host:port" format.
+	Peers []string
+	// Parameters that are not known by this package. They are written back as is.
+	Extra url.Values
+}
+
+// ParseMagnet parses a magnet link.
+func ParseMagnet(s string) (*Magnet, error) {
+	ma, err := magnet.New(s)
+	if err != nil {
+		return nil, err
+	}
+	m := &Magnet{
+		InfoHash: ma.InfoHash,
+		Name:     ma.Name,
+		Trackers: ma.Trackers,
+		Peers:    ma.Peers,
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range u.Query() {
+		if isKnownMagnetParam(key) {
+			continue
+		}
+		if m.Extra == nil {
+			m.Extra = make(url.Values)
+		}
+		m.Extra[key] = values
+	}
+	return m, nil
+}
+
+func isKnownMagnetParam(key string) bool {
+	switch key {
+	case "xt", "dn", "tr", "x.pe":
+		return true
+	}
+	if strings.HasPrefix(key, "tr.") {
+		_, err := strconv.Atoi(key[3:])
+		return err == nil
+	}
+	return false
+}
+
+// String returns the magnet link in URI format.
+func (m *Magnet) String() string {
+	ma := magnet.Magnet{
+		InfoHash: m.InfoHash,
+		Name:     m.Name,
+		Trackers: m.Trackers,
+		Peers:    m.Peers,
+	}
+	s := ma.String()
+	if len(m.Extra) == 0 {
+		return s
+	}
+	keys := make([]string, 0, len(m.Extra))
+	for key := range m.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(s)
+	for _, key := range keys {
+		for _, v := range m.Extra[key] {
+			b.WriteString("&")
+			b.WriteString(url.QueryEscape(key))
+			b.WriteString("=")
+			b.WriteString(url.QueryEscape(v))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,346 @@
+// Package torrentfile provides support for reading, editing and writing .torrent files and magnet links.
+//
+// Keys that are not known by this package are kept as is,
+// so a torrent file that is read and written back without changes is not modified.
+package torrentfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/ganqierwu/rain/internal/metainfo"
+	"github.com/zeebo/bencode"
+)
+
+// Keys in the top level dictionary of a torrent file.
+const (
+	keyInfo         = "info"
+	keyAnnounce     = "announce"
+	keyAnnounceList = "announce-list"
+	keyURLList      = "url-list"
+	keyComment      = "comment"
+	keyCreatedBy    = "created by"
+	keyCreationDate = "creation date"
+)
+
+// Keys in the info dictionary of a torrent file.
+const (
+	keyPrivate = "private"
+	keySource  = "source"
+)
+
+// Torrent is the parsed content of a .torrent file.
+type Torrent struct {
+	dict map[string]bencode.RawMessage
+	info *metainfo.Info
+}
+
+// File is a file inside a torrent.
+type File struct {
+	// Path of the file, starting with the torrent name.
+	Path   string
+	Length int64
+}
+
+// New parses a torrent file from bencoded stream.
+func New(r io.Reader) (*Torrent, error) {
+	var dict map[string]bencode.RawMessage
+	err := bencode.NewDecoder(r).Decode(&dict)
+	if err != nil {
+		return nil, err
+	}
+	t := &Torrent{dict: dict}
+	err = t.parseInfo(dict[keyInfo])
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// NewBytes parses a torrent file from bencoded bytes in b.
+func NewBytes(b []byte) (*Torrent, error) {
+	return New(bytes.NewReader(b))
+}
+
+// Load reads the torrent file at path.
+func Load(path string) (*Torrent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return New(f)
+}
+
+func (t *Torrent) parseInfo(b []byte) error {
+	if len(b) == 0 {
+		return errors.New("no info dict in torrent file")
+	}
+	info, err := metainfo.NewInfo(b)
+	if err != nil {
+		return err
+	}
+	t.info = info
+	return nil
+}
+
+// Bytes returns the bencoded content of the torrent file.
+func (t *Torrent) Bytes() ([]byte, error) {
+	return bencode.EncodeBytes(t.dict)
+}
+
+// WriteTo writes the bencoded content of the torrent file to w.
+func (t *Torrent) WriteTo(w io.Writer) (int64, error) {
+	b, err := t.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// Save writes the torrent file to path.
+func (t *Torrent) Save(path string) error {
+	b, err := t.Bytes()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0o644)
+}
+
+// InfoHash returns the SHA-1 hash of the info dictionary.
+// Changing the private flag or the source tag changes the info hash.
+func (t *Torrent) InfoHash() [20]byte {
+	return t.info.Hash
+}
+
+// Info returns the bencoded info dictionary.
+func (t *Torrent) Info() []byte {
+	return t.info.Bytes
+}
+
+// Name of the torrent.
+func (t *Torrent) Name() string {
+	return t.info.Name
+}
+
+// Length is the total length of files in the torrent.
+func (t *Torrent) Length() int64 {
+	return t.info.Length
+}
+
+// PieceLength is the number of bytes in a piece.
+func (t *Torrent) PieceLength() uint32 {
+	return t.info.PieceLength
+}
+
+// NumPieces is the number of pieces in the torrent.
+func (t *Torrent) NumPieces() uint32 {
+	return t.info.NumPieces
+}
+
+// Files returns the list of files in the torrent.
+func (t *Torrent) Files() []File {
+	files := make([]File, len(t.info.Files))
+	for i, f := range t.info.Files {
+		files[i] = File{Path: f.Path, Length: f.Length}
+	}
+	return files
+}
+
+// Trackers returns the tiers of tracker URLs in the torrent.
+// "announce-list" is used if present, "announce" otherwise.
+func (t *Torrent) Trackers() [][]string {
+	var tiers [][]string
+	if b, ok := t.dict[keyAnnounceList]; ok {
+		err := bencode.DecodeBytes(b, &tiers)
+		if err == nil && len(tiers) > 0 {
+			return tiers
+		}
+	}
+	var s string
+	if b, ok := t.dict[keyAnnounce]; ok && bencode.DecodeBytes(b, &s) == nil && s != "" {
+		return [][]string{{s}}
+	}
+	return nil
+}
+
+// SetTrackers replaces the trackers in the torrent.
+// The first tracker is put into "announce" key for clients that do not support "announce-list".
+// Empty tiers are removed. Passing nil removes all trackers.
+func (t *Torrent) SetTrackers(tiers [][]string) {
+	var l [][]string
+	for _, tier := range tiers {
+		if len(tier) > 0 {
+			l = append(l, tier)
+		}
+	}
+	delete(t.dict, keyAnnounce)
+	delete(t.dict, keyAnnounceList)
+	if len(l) == 0 {
+		return
+	}
+	t.setString(keyAnnounce, l[0][0])
+	if len(l) > 1 || len(l[0]) > 1 {
+		t.dict[keyAnnounceList] = mustEncode(l)
+	}
+}
+
+// Webseeds returns the list of webseed URLs (BEP 19) in the torrent.
+func (t *Torrent) Webseeds() []string {
+	b, ok := t.dict[keyURLList]
+	if !ok || len(b) == 0 {
+		return nil
+	}
+	if b[0] == 'l' {
+		var l []string
+		if bencode.DecodeBytes(b, &l) != nil {
+			return nil
+		}
+		return l
+	}
+	var s string
+	if bencode.DecodeBytes(b, &s) != nil || s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+// SetWebseeds replaces the webseed URLs in the torrent. Passing nil removes all webseeds.
+func (t *Torrent) SetWebseeds(urls []string) {
+	switch len(urls) {
+	case 0:
+		delete(t.dict, keyURLList)
+	case 1:
+		t.setString(keyURLList, urls[0])
+	default:
+		t.dict[keyURLList] = mustEncode(urls)
+	}
+}
+
+// Comment returns the free-form comment of the torrent.
+func (t *Torrent) Comment() string {
+	return t.getString(keyComment)
+}
+
+// SetComment sets the comment of the torrent. Empty value removes the comment.
+func (t *Torrent) SetComment(s string) {
+	t.setString(keyComment, s)
+}
+
+// CreatedBy returns the name of the program that created the torrent.
+func (t *Torrent) CreatedBy() string {
+	return t.getString(keyCreatedBy)
+}
+
+// CreationDate returns the creation time of the torrent. Zero value is returned if it is not known.
+func (t *Torrent) CreationDate() time.Time {
+	var sec int64
+	if b, ok := t.dict[keyCreationDate]; ok && bencode.DecodeBytes(b, &sec) == nil && sec != 0 {
+		return time.Unix(sec, 0)
+	}
+	return time.Time{}
+}
+
+// Private returns the value of the private flag (BEP 27) in the info dictionary.
+func (t *Torrent) Private() bool {
+	return t.info.Private
+}
+
+// SetPrivate sets the private flag in the info dictionary.
+// Since the info dictionary changes, the torrent gets a new info hash.
+func (t *Torrent) SetPrivate(private bool) error {
+	if private == t.info.Private {
+		return nil
+	}
+	return t.updateInfo(func(info map[string]bencode.RawMessage) {
+		if private {
+			info[keyPrivate] = mustEncode(1)
+		} else {
+			delete(info, keyPrivate)
+		}
+	})
+}
+
+// Source returns the source tag in the info dictionary.
+// Private trackers use this tag for making the info hash of cross-seeded torrents unique.
+func (t *Torrent) Source() string {
+	var v struct {
+		Source string `bencode:"source"`
+	}
+	_ = bencode.DecodeBytes(t.info.Bytes, &v)
+	return v.Source
+}
+
+// SetSource sets the source tag in the info dictionary. Empty value removes the tag.
+// Since the info dictionary changes, the torrent gets a new info hash.
+func (t *Torrent) SetSource(source string) error {
+	if source == t.Source() {
+		return nil
+	}
+	return t.updateInfo(func(info map[string]bencode.RawMessage) {
+		if source == "" {
+			delete(info, keySource)
+		} else {
+			info[keySource] = mustEncode(source)
+		}
+	})
+}
+
+// updateInfo decodes the info dictionary, calls fn to modify it and encodes it back.
+// Unknown keys in the info dictionary are preserved.
+func (t *Torrent) updateInfo(fn func(info map[string]bencode.RawMessage)) error {
+	var info map[string]bencode.RawMessage
+	err := bencode.DecodeBytes(t.info.Bytes, &info)
+	if err != nil {
+		return err
+	}
+	fn(info)
+	b, err := bencode.EncodeBytes(info)
+	if err != nil {
+		return err
+	}
+	err = t.parseInfo(b)
+	if err != nil {
+		return err
+	}
+	t.dict[keyInfo] = b
+	return nil
+}
+
+// Magnet returns a magnet link for the torrent.
+func (t *Torrent) Magnet() *Magnet {
+	return &Magnet{
+		InfoHash: t.info.Hash,
+		Name:     t.info.Name,
+		Trackers: t.Trackers(),
+	}
+}
+
+func (t *Torrent) getString(key string) string {
+	var s string
+	if b, ok := t.dict[key]; ok {
+		_ = bencode.DecodeBytes(b, &s)
+	}
+	return s
+}
+
+func (t *Torrent) setString(key, value string) {
+	if value == "" {
+		delete(t.dict, key)
+		return
+	}
+	t.dict[key] = mustEncode(value)
+}
+
+func mustEncode(v interface{}) bencode.RawMessage {
+	b, err := bencode.EncodeBytes(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,118 @@
+package torrentfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+func newTestTorrent(t *testing.T) []byte {
+	info := map[string]interface{}{
+		"name":         "foo",
+		"piece length": 16 << 10,
+		"pieces":       string(make([]byte, sha1.Size)),
+		"length":       10,
+		"x-unknown":    "bar",
+	}
+	mi := map[string]interface{}{
+		"info":          info,
+		"announce":      "http://tracker.example.com/announce",
+		"comment":       "hello",
+		"x-unknown-top": []interface{}{int64(1), "baz"},
+	}
+	b, err := bencode.EncodeBytes(mi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRoundTrip(t *testing.T) {
+	b := newTestTorrent(t)
+	tor, err := NewBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := tor.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, b2) {
+		t.Fatalf("torrent changed after round trip:\n%q\n%q", b, b2)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	tor, err := NewBytes(newTestTorrent(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := tor.InfoHash()
+	tor.SetComment("world")
+	tor.SetTrackers([][]string{{"http://a/announce", "http://b/announce"}, {"udp://c:1337"}})
+	tor.SetWebseeds([]string{"http://w/"})
+	if tor.InfoHash() != hash {
+		t.Fatal("info hash changed")
+	}
+	if err = tor.SetPrivate(true); err != nil {
+		t.Fatal(err)
+	}
+	if err = tor.SetSource("SRC"); err != nil {
+		t.Fatal(err)
+	}
+	if tor.InfoHash() == hash {
+		t.Fatal("info hash not changed")
+	}
+
+	b, err := tor.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tor, err = NewBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tor.Comment() != "world" {
+		t.Errorf("comment: %q", tor.Comment())
+	}
+	if tr := tor.Trackers(); len(tr) != 2 || len(tr[0]) != 2 || tr[1][0] != "udp://c:1337" {
+		t.Errorf("trackers: %v", tr)
+	}
+	if ws := tor.Webseeds(); len(ws) != 1 || ws[0] != "http://w/" {
+		t.Errorf("webseeds: %v", ws)
+	}
+	if !tor.Private() {
+		t.Error("not private")
+	}
+	if tor.Source() != "SRC" {
+		t.Errorf("source: %q", tor.Source())
+	}
+	var dict struct {
+		Unknown []interface{} `bencode:"x-unknown-top"`
+		Info    struct {
+			Unknown string `bencode:"x-unknown"`
+		} `bencode:"info"`
+	}
+	if err = bencode.DecodeBytes(b, &dict); err != nil {
+		t.Fatal(err)
+	}
+	if len(dict.Unknown) != 2 || dict.Info.Unknown != "bar" {
+		t.Errorf("unknown keys are lost: %+v", dict)
+	}
+}
+
+func TestMagnetRoundTrip(t *testing.T) {
+	const link = "magnet:?xt=urn:btih:f60f2e2c5c4bed5a1a1e26e8b3c3a3d4b5c6d7e8&dn=foo&tr=http%3A%2F%2Ftracker%2Fannounce&ws=http%3A%2F%2Fw%2F"
+	m, err := ParseMagnet(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Extra.Get("ws") != "http://w/" {
+		t.Fatalf("extra params: %v", m.Extra)
+	}
+	if s := m.String(); s != link {
+		t.Fatalf("magnet changed after round trip:\n%s\n%s", link, s)
+	}
+}